@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuditEntry records an operation performed through the API
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Username  string          `json:"username"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Outcome   string          `json:"outcome"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// recordAudit stores an audit entry and returns its ID. Failures are logged
+// but never block the operation being audited.
+func recordAudit(username, action, target, outcome string, details interface{}) int64 {
+	log := logrus.WithFields(logrus.Fields{
+		"audit":   action,
+		"user":    username,
+		"target":  target,
+		"outcome": outcome,
+	})
+	log.Info("Audit event")
+
+	if db == nil {
+		return 0
+	}
+
+	payload, err := json.Marshal(details)
+	if err != nil {
+		payload = []byte("{}")
+	}
+
+	result, err := db.Exec(`INSERT INTO audit_log (username, action, target, outcome, details, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		username, action, target, outcome, string(payload), time.Now())
+	if err != nil {
+		log.WithError(err).Warn("Failed to store audit event")
+		return 0
+	}
+	id, _ := result.LastInsertId()
+	return id
+}
+
+// loadAuditLog returns the most recent audit entries, optionally filtered by action
+func loadAuditLog(action string, limit int) ([]AuditEntry, error) {
+	query := `SELECT id, username, action, target, outcome, details, created_at FROM audit_log`
+	args := []interface{}{}
+	if action != "" {
+		query += ` WHERE action = ?`
+		args = append(args, action)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var details string
+		if err := rows.Scan(&entry.ID, &entry.Username, &entry.Action, &entry.Target, &entry.Outcome, &details, &entry.CreatedAt); err != nil {
+			logrus.WithError(err).Error("Failed to scan audit row")
+			continue
+		}
+		entry.Details = json.RawMessage(details)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// listAuditLog returns recent audit entries
+func listAuditLog(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeError(w, http.StatusServiceUnavailable, "Audit log requires the database")
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+
+	entries, err := loadAuditLog(r.URL.Query().Get("action"), limit)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load audit log")
+		writeFailure(w, "Failed to load audit log", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
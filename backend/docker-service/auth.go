@@ -167,20 +167,20 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, "Authorization header required")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			http.Error(w, "Bearer token required", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, "Bearer token required")
 			return
 		}
 
 		claims, err := validateToken(tokenString)
 		if err != nil {
 			logrus.WithError(err).Warn("Invalid token")
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
@@ -192,11 +192,54 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// queryTokenMiddleware accepts the token as a query parameter, for the
+// event streams and downloads a browser opens without setting headers. It
+// runs outside authMiddleware and only on those routes, since query strings
+// end up in access logs and browser history.
+func queryTokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if token := query.Get("token"); token != "" {
+			if r.Header.Get("Authorization") == "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			query.Del("token")
+			r.URL.RawQuery = query.Encode()
+		}
+		next(w, r)
+	}
+}
+
+// adminMiddleware restricts a route to admins. It runs inside authMiddleware,
+// which sets the role from the token.
+func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Role") != "admin" {
+			writeError(w, http.StatusForbidden, "This action requires the admin role")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminUnlessDryRunMiddleware lets anyone preview with ?dry_run=true but
+// requires the admin role for the real action
+func adminUnlessDryRunMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	admin := adminMiddleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dry_run") == "true" {
+			next(w, r)
+			return
+		}
+		admin(w, r)
+	}
+}
+
 // Login handler
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
@@ -206,14 +249,14 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			"username": req.Username,
 			"error":    err.Error(),
 		}).Warn("Failed login attempt")
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
 
 	token, expiresAt, err := generateToken(user)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to generate token")
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
@@ -261,21 +304,21 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	
 	var req ChangePasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Validate current password
 	user, exists := users[username]
 	if !exists {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
 	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword))
 	if err != nil {
 		logrus.WithField("username", username).Warn("Invalid current password for password change")
-		http.Error(w, "Invalid current password", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "Invalid current password")
 		return
 	}
 
@@ -283,7 +326,7 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to hash new password")
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to hash password")
 		return
 	}
 
@@ -295,7 +338,7 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	if db != nil {
 		if err := updateUserPassword(username, string(hashedPassword)); err != nil {
 			logrus.WithError(err).Error("Failed to update password in database")
-			http.Error(w, "Failed to update password in database", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "Failed to update password in database")
 			return
 		}
 	}
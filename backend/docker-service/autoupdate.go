@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// autoUpdateLabel opts a container in to scheduled updates
+	autoUpdateLabel = "dockmaster.auto-update"
+	// autoUpdateWindowLabel overrides the health window of one container
+	autoUpdateWindowLabel = "dockmaster.auto-update.window"
+	// autoUpdatePollInterval is how often a new container's health is checked
+	autoUpdatePollInterval = 2 * time.Second
+	// autoUpdateBackupSuffix is appended to the name of the replaced container
+	// until the new one is healthy
+	autoUpdateBackupSuffix = "-dockmaster-previous"
+)
+
+// Container update statuses
+const (
+	ContainerUpdateRunning        = "running"
+	ContainerUpdateSucceeded      = "succeeded"
+	ContainerUpdateFailed         = "failed"
+	ContainerUpdateRolledBack     = "rolled_back"
+	ContainerUpdateRollbackFailed = "rollback_failed"
+)
+
+var errContainerUpdating = errors.New("container is already being updated")
+
+var (
+	updatingContainers   = make(map[string]bool)
+	updatingContainersMu sync.Mutex
+)
+
+// ContainerUpdate is one attempt to move a container to a newer image
+type ContainerUpdate struct {
+	ID             int64      `json:"id"`
+	ContainerName  string     `json:"container_name"`
+	ContainerID    string     `json:"container_id"`
+	NewContainerID string     `json:"new_container_id,omitempty"`
+	Image          string     `json:"image"`
+	OldImageID     string     `json:"old_image_id"`
+	NewImageID     string     `json:"new_image_id"`
+	Trigger        string     `json:"trigger"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error,omitempty"`
+	TriggeredBy    string     `json:"triggered_by"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// containerUpdateOptions controls a single container update
+type containerUpdateOptions struct {
+	trigger string
+	user    string
+	// pull forces a pull even when no newer registry digest is known
+	pull bool
+	// progress reports each phase; it may be nil
+	progress func(string)
+	// pullFn receives pull progress; it may be nil
+	pullFn func(engineMessage)
+}
+
+func (opts containerUpdateOptions) report(message string) {
+	if opts.progress != nil {
+		opts.progress(message)
+	}
+}
+
+// autoUpdateWindow is how long a new container has to become healthy, or
+// to keep running when it has no health check
+func autoUpdateWindow(labels map[string]string) time.Duration {
+	for _, value := range []string{labels[autoUpdateWindowLabel], getEnvOrDefault("AUTO_UPDATE_WINDOW", "")} {
+		if value == "" {
+			continue
+		}
+		if window, err := time.ParseDuration(value); err == nil && window > 0 {
+			return window
+		}
+		logrus.WithField("value", value).Warn("Invalid auto-update window, ignoring it")
+	}
+	return 2 * time.Minute
+}
+
+// saveContainerUpdate inserts or updates an update record
+func saveContainerUpdate(update *ContainerUpdate) {
+	if db == nil {
+		return
+	}
+	if update.ID == 0 {
+		result, err := db.Exec(`INSERT INTO container_updates (container_name, container_id, new_container_id, image, old_image_id, new_image_id, run_trigger, status, error, triggered_by, started_at, finished_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			update.ContainerName, update.ContainerID, update.NewContainerID, update.Image, update.OldImageID, update.NewImageID,
+			update.Trigger, update.Status, update.Error, update.TriggeredBy, update.StartedAt, update.FinishedAt)
+		if err != nil {
+			logrus.WithError(err).WithField("container", update.ContainerName).Error("Failed to record container update")
+			return
+		}
+		update.ID, _ = result.LastInsertId()
+		return
+	}
+	_, err := db.Exec(`UPDATE container_updates SET new_container_id = ?, new_image_id = ?, status = ?, error = ?, finished_at = ? WHERE id = ?`,
+		update.NewContainerID, update.NewImageID, update.Status, update.Error, update.FinishedAt, update.ID)
+	if err != nil {
+		logrus.WithError(err).WithField("container", update.ContainerName).Error("Failed to record container update")
+	}
+}
+
+// rolledBackImage returns the image of the last rolled back update of a
+// container, so a scheduled run does not retry a known bad image
+func rolledBackImage(name string) string {
+	if db == nil {
+		return ""
+	}
+	var status, imageID string
+	err := db.QueryRow(`SELECT status, new_image_id FROM container_updates WHERE container_name = ? ORDER BY id DESC LIMIT 1`, name).Scan(&status, &imageID)
+	if err != nil || (status != ContainerUpdateRolledBack && status != ContainerUpdateRollbackFailed) {
+		return ""
+	}
+	return imageID
+}
+
+// rawContainer is a container's inspect data kept as the engine returned it,
+// so a recreated container gets every setting, including ones DockMaster
+// does not model
+type rawContainer struct {
+	Config          map[string]interface{} `json:"Config"`
+	HostConfig      map[string]interface{} `json:"HostConfig"`
+	NetworkSettings struct {
+		Networks map[string]map[string]interface{} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// inspectRawContainer returns the untyped inspect data of a container
+func inspectRawContainer(ctx context.Context, id string) (*rawContainer, error) {
+	resp, err := engineRequest(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var raw rawContainer
+	decoder := json.NewDecoder(resp.Body)
+	// Numbers such as memory limits must round-trip exactly
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse container inspect: %v", err)
+	}
+	if raw.Config == nil || raw.HostConfig == nil {
+		return nil, fmt.Errorf("container %s has no configuration", id)
+	}
+	return &raw, nil
+}
+
+// dropImageDefaults removes the settings a container inherited from its
+// previous image, so the new image's defaults apply instead
+func dropImageDefaults(config map[string]interface{}, container *ContainerInspect, image *ImageInspect) {
+	cfg := container.Config
+
+	imageEnv := make(map[string]bool, len(image.Config.Env))
+	for _, env := range image.Config.Env {
+		imageEnv[env] = true
+	}
+	env := []string{}
+	for _, value := range cfg.Env {
+		if !imageEnv[value] {
+			env = append(env, value)
+		}
+	}
+	config["Env"] = env
+
+	labels := map[string]string{}
+	for key, value := range cfg.Labels {
+		if imageValue, ok := image.Config.Labels[key]; !ok || imageValue != value {
+			labels[key] = value
+		}
+	}
+	config["Labels"] = labels
+
+	declaredByImage := map[string]map[string]struct{}{"ExposedPorts": image.Config.ExposedPorts, "Volumes": image.Config.Volumes}
+	for key, declared := range declaredByImage {
+		if set, ok := config[key].(map[string]interface{}); ok {
+			for name := range declared {
+				delete(set, name)
+			}
+		}
+	}
+
+	if stringSlicesEqual(cfg.Entrypoint, image.Config.Entrypoint) {
+		delete(config, "Entrypoint")
+	}
+	if stringSlicesEqual(cfg.Cmd, image.Config.Cmd) {
+		delete(config, "Cmd")
+	}
+	if cfg.WorkingDir == image.Config.WorkingDir {
+		delete(config, "WorkingDir")
+	}
+	if cfg.User == image.Config.User {
+		delete(config, "User")
+	}
+	if cfg.StopSignal == image.Config.StopSignal {
+		delete(config, "StopSignal")
+	}
+	if hc := cfg.Healthcheck; hc == nil || (image.Config.Healthcheck != nil && stringSlicesEqual(hc.Test, image.Config.Healthcheck.Test)) {
+		delete(config, "Healthcheck")
+	}
+	// The engine defaults the hostname to the short container ID
+	if strings.HasPrefix(container.ID, cfg.Hostname) {
+		delete(config, "Hostname")
+	}
+}
+
+// keptVolumeBinds binds the volumes of a container that are neither in its
+// binds nor its mounts, which are the anonymous and image-declared ones, so
+// their data carries over to the container that replaces it
+func keptVolumeBinds(hostConfig map[string]interface{}, container *ContainerInspect) []string {
+	configured := make(map[string]bool)
+	for _, bind := range container.HostConfig.Binds {
+		if parts := strings.Split(bind, ":"); len(parts) >= 2 {
+			configured[parts[1]] = true
+		}
+	}
+	if mounts, ok := hostConfig["Mounts"].([]interface{}); ok {
+		for _, mount := range mounts {
+			if mount, ok := mount.(map[string]interface{}); ok {
+				if target, ok := mount["Target"].(string); ok {
+					configured[target] = true
+				}
+			}
+		}
+	}
+
+	binds := append([]string{}, container.HostConfig.Binds...)
+	for _, mount := range container.Mounts {
+		if mount.Type == "volume" && mount.Name != "" && !configured[mount.Destination] {
+			binds = append(binds, mount.Name+":"+mount.Destination)
+		}
+	}
+	return binds
+}
+
+// endpointConfig returns the settings of a network endpoint that can be given
+// when connecting a container. Aliases of the old container's ID are dropped.
+func endpointConfig(endpoint map[string]interface{}, containerID string) map[string]interface{} {
+	config := map[string]interface{}{}
+	for _, key := range []string{"IPAMConfig", "Links", "DriverOpts"} {
+		if value, ok := endpoint[key]; ok && value != nil {
+			config[key] = value
+		}
+	}
+	if aliases, ok := endpoint["Aliases"].([]interface{}); ok {
+		kept := []string{}
+		for _, alias := range aliases {
+			if alias, ok := alias.(string); ok && !strings.HasPrefix(containerID, alias) {
+				kept = append(kept, alias)
+			}
+		}
+		config["Aliases"] = kept
+	}
+	return config
+}
+
+// recreateContainer creates a container named name from reference with the
+// configuration of an existing one: its full Config and HostConfig with the
+// previous image's defaults removed, and every network it is attached to with
+// the same aliases. The new container is started and its ID returned, also
+// when a later step fails so the caller can remove it.
+func recreateContainer(ctx context.Context, name, reference string, container *ContainerInspect, previousImage *ImageInspect) (string, error) {
+	raw, err := inspectRawContainer(ctx, container.ID)
+	if err != nil {
+		return "", err
+	}
+
+	config := raw.Config
+	dropImageDefaults(config, container, previousImage)
+	config["Image"] = reference
+	hostConfig := raw.HostConfig
+	hostConfig["Binds"] = keptVolumeBinds(hostConfig, container)
+
+	// Only one network can be given at creation, the others are connected
+	// before the container starts
+	mode := container.HostConfig.NetworkMode
+	primary := mode
+	if mode == "" || mode == "default" {
+		primary = "bridge"
+	}
+	for network, endpoint := range raw.NetworkSettings.Networks {
+		if id, _ := endpoint["NetworkID"].(string); id != "" && id == mode {
+			primary = network
+		}
+	}
+	body := map[string]interface{}{}
+	for key, value := range config {
+		body[key] = value
+	}
+	body["HostConfig"] = hostConfig
+	if endpoint, ok := raw.NetworkSettings.Networks[primary]; ok && primary != "bridge" {
+		body["NetworkingConfig"] = map[string]interface{}{
+			"EndpointsConfig": map[string]interface{}{primary: endpointConfig(endpoint, container.ID)},
+		}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	resp, err := engineRequest(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}},
+		map[string]string{"Content-Type": "application/json"}, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse created container: %v", err)
+	}
+
+	// Containers sharing the host's or another container's network stack
+	// cannot join networks
+	if mode != "host" && mode != "none" && !strings.HasPrefix(mode, "container:") {
+		networks := make([]string, 0, len(raw.NetworkSettings.Networks))
+		for network := range raw.NetworkSettings.Networks {
+			if network != primary {
+				networks = append(networks, network)
+			}
+		}
+		sort.Strings(networks)
+		for _, network := range networks {
+			endpoint := map[string]interface{}{}
+			// The default bridge does not support aliases
+			if network != "bridge" {
+				endpoint = endpointConfig(raw.NetworkSettings.Networks[network], container.ID)
+			}
+			connect, err := json.Marshal(map[string]interface{}{"Container": created.ID, "EndpointConfig": endpoint})
+			if err != nil {
+				return created.ID, err
+			}
+			resp, err := engineRequest(ctx, http.MethodPost, "/networks/"+url.PathEscape(network)+"/connect", nil,
+				map[string]string{"Content-Type": "application/json"}, bytes.NewReader(connect))
+			if err != nil {
+				return created.ID, fmt.Errorf("failed to connect to network %s: %v", network, err)
+			}
+			resp.Body.Close()
+		}
+	}
+
+	return created.ID, dockerStart(created.ID)
+}
+
+// healthOutput returns the output of the last health check, if any
+func healthOutput(container *ContainerInspect) string {
+	if container.State.Health == nil || len(container.State.Health.Log) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(container.State.Health.Log[len(container.State.Health.Log)-1].Output)
+}
+
+// waitHealthy waits for a new container to report healthy within window.
+// Containers without a health check must keep running for the whole window.
+func waitHealthy(ctx context.Context, id string, window time.Duration) error {
+	deadline := time.Now().Add(window)
+	for {
+		container, err := dockerInspectContainer(id)
+		if err != nil {
+			return err
+		}
+		if !container.State.Running && !container.State.Restarting {
+			return fmt.Errorf("container exited with code %d", container.State.ExitCode)
+		}
+		if container.RestartCount > 0 || container.State.Restarting {
+			return fmt.Errorf("container restarted %d time(s)", max(container.RestartCount, 1))
+		}
+		if health := container.State.Health; health != nil {
+			switch health.Status {
+			case "healthy":
+				return nil
+			case "unhealthy":
+				if output := healthOutput(container); output != "" {
+					return fmt.Errorf("health check failed: %s", output)
+				}
+				return fmt.Errorf("health check failed")
+			}
+		}
+		if time.Now().After(deadline) {
+			if container.State.Health != nil {
+				return fmt.Errorf("container did not become healthy within %s", window)
+			}
+			return nil
+		}
+
+		select {
+		case <-time.After(autoUpdatePollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// restorePrevious removes a failed replacement and brings the previous
+// container back under its original name
+func restorePrevious(name, previousID, newID string) error {
+	if newID != "" {
+		if err := dockerRemove(newID, true); err != nil {
+			return fmt.Errorf("failed to remove new container: %v", err)
+		}
+	}
+	if _, err := executeDockerCommand("rename", previousID, name); err != nil {
+		return fmt.Errorf("failed to rename previous container back: %v", err)
+	}
+	if err := dockerStart(previousID); err != nil {
+		return fmt.Errorf("failed to start previous container: %v", err)
+	}
+	return nil
+}
+
+// updateContainer recreates a container from the newest image of its tag
+// with the same configuration. The previous container is only stopped and
+// renamed, and comes back if the new one is not healthy within the window.
+// It returns nil without error when the container is already up to date.
+func updateContainer(ctx context.Context, id string, opts containerUpdateOptions) (*ContainerUpdate, error) {
+	container, err := dockerInspectContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(container.Name, "/")
+
+	updatingContainersMu.Lock()
+	if updatingContainers[name] {
+		updatingContainersMu.Unlock()
+		return nil, errContainerUpdating
+	}
+	updatingContainers[name] = true
+	updatingContainersMu.Unlock()
+	defer func() {
+		updatingContainersMu.Lock()
+		delete(updatingContainers, name)
+		updatingContainersMu.Unlock()
+	}()
+
+	reference := tagReference(container.Config.Image)
+	if reference == "" {
+		return nil, fmt.Errorf("container %s was created from an image ID or digest and has no tag to update from", name)
+	}
+	if container.HostConfig.AutoRemove {
+		return nil, fmt.Errorf("container %s is removed when stopped and cannot be rolled back, so it is not updated", name)
+	}
+	log := logrus.WithFields(logrus.Fields{"container": name, "image": reference, "trigger": opts.trigger})
+
+	update := &ContainerUpdate{
+		ContainerName: name,
+		ContainerID:   container.ID,
+		Image:         reference,
+		OldImageID:    container.Image,
+		Trigger:       opts.trigger,
+		Status:        ContainerUpdateRunning,
+		TriggeredBy:   opts.user,
+		StartedAt:     time.Now(),
+	}
+	finish := func(status string, err error) (*ContainerUpdate, error) {
+		update.Status = status
+		if err != nil {
+			update.Error = err.Error()
+		}
+		finished := time.Now()
+		update.FinishedAt = &finished
+		saveContainerUpdate(update)
+
+		outcome := "success"
+		if status != ContainerUpdateSucceeded {
+			outcome = "failure"
+		}
+		recordAudit(opts.user, "containers.update", name, outcome, map[string]interface{}{
+			"image":        reference,
+			"old_image_id": update.OldImageID,
+			"new_image_id": update.NewImageID,
+			"trigger":      opts.trigger,
+			"status":       status,
+			"error":        update.Error,
+		})
+		log.WithFields(logrus.Fields{"status": status, "error": update.Error}).Info("Container update finished")
+		return update, nil
+	}
+
+	known := imageUpdateFor(reference)
+	if opts.pull || (known != nil && known.UpdateAvailable) {
+		if known == nil || known.Status != UpdateLocalOnly {
+			opts.report("Pulling " + reference)
+			pullFn := opts.pullFn
+			if pullFn == nil {
+				pullFn = func(engineMessage) {}
+			}
+			if err := enginePull(ctx, reference, "", registryAuthHeaders(reference), pullFn); err != nil {
+				return finish(ContainerUpdateFailed, fmt.Errorf("pull failed: %v", err))
+			}
+		}
+	}
+
+	image, err := dockerInspectImageTyped(reference)
+	if err != nil {
+		return finish(ContainerUpdateFailed, err)
+	}
+	recordPulledImage(reference, image)
+	if image.ID == container.Image {
+		log.Debug("Container is up to date")
+		return nil, nil
+	}
+	if opts.trigger == "schedule" && rolledBackImage(name) == image.ID {
+		log.WithField("image_id", image.ID).Info("Skipping image that was rolled back before")
+		return nil, nil
+	}
+	update.NewImageID = image.ID
+	saveContainerUpdate(update)
+
+	previousImage, err := dockerInspectImageTyped(container.Image)
+	if err != nil {
+		previousImage = &ImageInspect{}
+	}
+
+	// Keep the previous container until the new one proves itself
+	opts.report("Stopping " + name)
+	if _, err := executeDockerCommand("rename", container.ID, name+autoUpdateBackupSuffix); err != nil {
+		return finish(ContainerUpdateFailed, fmt.Errorf("failed to rename container: %v", err))
+	}
+	if err := dockerStop(container.ID); err != nil {
+		executeDockerCommand("rename", container.ID, name)
+		return finish(ContainerUpdateFailed, fmt.Errorf("failed to stop container: %v", err))
+	}
+
+	opts.report("Starting " + name + " from " + reference)
+	newID, err := recreateContainer(ctx, name, reference, container, previousImage)
+	if err == nil {
+		update.NewContainerID = newID
+		window := autoUpdateWindow(container.Config.Labels)
+		opts.report(fmt.Sprintf("Waiting up to %s for %s to become healthy", window, name))
+		err = waitHealthy(ctx, newID, window)
+	}
+	if err != nil {
+		opts.report("Rolling back " + name)
+		if restoreErr := restorePrevious(name, container.ID, newID); restoreErr != nil {
+			notify("container.update_rollback_failed", "Rollback of "+name+" failed",
+				fmt.Sprintf("Updating %s to a new %s image failed (%v) and the previous container could not be restored: %v", name, reference, err, restoreErr),
+				map[string]interface{}{"container": name, "image": reference, "previous_container": container.ID})
+			return finish(ContainerUpdateRollbackFailed, fmt.Errorf("%v; rollback failed: %v", err, restoreErr))
+		}
+		notify("container.update_rolled_back", "Update of "+name+" rolled back",
+			fmt.Sprintf("%s was rolled back to its previous image because the new %s image failed: %v", name, reference, err),
+			map[string]interface{}{"container": name, "image": reference, "image_id": image.ID})
+		return finish(ContainerUpdateRolledBack, err)
+	}
+
+	if err := dockerRemove(container.ID, false); err != nil {
+		log.WithError(err).Warn("Failed to remove previous container after update")
+	}
+	notify("container.updated", "Updated "+name,
+		fmt.Sprintf("%s now runs the latest %s image", name, reference),
+		map[string]interface{}{"container": name, "image": reference, "image_id": image.ID})
+	return finish(ContainerUpdateSucceeded, nil)
+}
+
+// runAutoUpdates updates every running container labelled for automatic
+// updates. Tags are compared with their registries first so only changed
+// images are pulled.
+func runAutoUpdates(ctx context.Context, trigger, user string, progress func(done, total int, name string)) ([]ContainerUpdate, error) {
+	containers, err := getRealContainers(false, "--filter", "label="+autoUpdateLabel+"=true")
+	if err != nil {
+		return nil, err
+	}
+
+	var references []string
+	seen := make(map[string]bool)
+	for _, container := range containers {
+		if reference := tagReference(container.Image); reference != "" && !seen[reference] {
+			seen[reference] = true
+			references = append(references, reference)
+		}
+	}
+	if len(references) > 0 {
+		if _, err := runUpdateCheck(ctx, references, 0, nil); err != nil {
+			logrus.WithError(err).Warn("Failed to check images before automatic updates")
+		}
+	}
+
+	results := []ContainerUpdate{}
+	for i, container := range containers {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		name := strings.TrimPrefix(firstName(container.Names), "/")
+		if progress != nil {
+			progress(i, len(containers), name)
+		}
+		update, err := updateContainer(ctx, container.ID, containerUpdateOptions{trigger: trigger, user: user})
+		if err != nil {
+			logrus.WithError(err).WithField("container", name).Warn("Skipped automatic update")
+			continue
+		}
+		if update != nil {
+			results = append(results, *update)
+		}
+	}
+	return results, nil
+}
+
+// startAutoUpdater runs automatic updates on AUTO_UPDATE_SCHEDULE
+func startAutoUpdater() {
+	spec := getEnvOrDefault("AUTO_UPDATE_SCHEDULE", "@daily")
+	if spec == "off" {
+		logrus.Info("Automatic container updates disabled")
+		return
+	}
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		logrus.WithError(err).WithField("schedule", spec).Error("Invalid AUTO_UPDATE_SCHEDULE, automatic container updates disabled")
+		return
+	}
+
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			time.Sleep(time.Until(next))
+
+			results, err := runAutoUpdates(context.Background(), "schedule", "scheduler", nil)
+			if err != nil {
+				logrus.WithError(err).Error("Automatic container updates failed")
+				continue
+			}
+			logrus.WithField("updates", len(results)).Info("Automatic container updates finished")
+		}
+	}()
+}
+
+// updateContainerNow pulls the newest image of a container's tag and
+// recreates it as a job, rolling back if it does not become healthy
+func updateContainerNow(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	container, err := dockerInspectContainer(id)
+	if err != nil {
+		writeFailure(w, "Failed to update container", err)
+		return
+	}
+	if tagReference(container.Config.Image) == "" {
+		writeError(w, http.StatusBadRequest, "Container was created from an image ID or digest and has no tag to update from")
+		return
+	}
+
+	name := strings.TrimPrefix(container.Name, "/")
+	job := startJob("container-update", name, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		tracker := newLayerTracker(job)
+		update, err := updateContainer(ctx, container.ID, containerUpdateOptions{
+			trigger:  "manual",
+			user:     job.CreatedBy,
+			pull:     true,
+			progress: func(message string) { job.SetProgress(0, 0, message) },
+			pullFn:   tracker.handle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if update == nil {
+			job.SetProgress(0, 0, name+" is up to date")
+			return map[string]interface{}{"updated": false, "container": name}, nil
+		}
+		if update.Status != ContainerUpdateSucceeded {
+			return update, errors.New(update.Error)
+		}
+		return map[string]interface{}{"updated": true, "container": name, "update": update}, nil
+	})
+	writeJobAccepted(w, job)
+}
+
+// runAutoUpdatesNow runs the scheduled automatic updates immediately as a job
+func runAutoUpdatesNow(w http.ResponseWriter, r *http.Request) {
+	job := startJob("auto-update", "all", r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		results, err := runAutoUpdates(ctx, "manual", job.CreatedBy, func(done, total int, name string) {
+			job.SetProgress(int64(done), int64(total), "Checking "+name)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"updates": results}, nil
+	})
+	writeJobAccepted(w, job)
+}
+
+// listContainerUpdates returns update attempts, newest first, optionally
+// for one ?container= name
+func listContainerUpdates(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+
+	query := `SELECT id, container_name, container_id, new_container_id, image, old_image_id, new_image_id, run_trigger, status, error, triggered_by, started_at, finished_at FROM container_updates`
+	args := []interface{}{}
+	if name := r.URL.Query().Get("container"); name != "" {
+		query += ` WHERE container_name = ?`
+		args = append(args, strings.TrimPrefix(name, "/"))
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		writeFailure(w, "Failed to load container updates", err)
+		return
+	}
+	defer rows.Close()
+
+	updates := []ContainerUpdate{}
+	for rows.Next() {
+		var update ContainerUpdate
+		var finished sql.NullTime
+		if err := rows.Scan(&update.ID, &update.ContainerName, &update.ContainerID, &update.NewContainerID, &update.Image, &update.OldImageID,
+			&update.NewImageID, &update.Trigger, &update.Status, &update.Error, &update.TriggeredBy, &update.StartedAt, &finished); err != nil {
+			logrus.WithError(err).Error("Failed to scan container update row")
+			continue
+		}
+		if finished.Valid {
+			update.FinishedAt = &finished.Time
+		}
+		updates = append(updates, update)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updates)
+}
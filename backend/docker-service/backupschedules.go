@@ -0,0 +1,719 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// backupTickInterval is how often the scheduler looks for due schedules
+	backupTickInterval = 30 * time.Second
+	// backupRunTimeout bounds a single scheduled run
+	backupRunTimeout = 12 * time.Hour
+	// backupRunHistory is how many runs are kept per schedule
+	backupRunHistory = 100
+)
+
+// Backup run statuses
+const (
+	BackupRunRunning   = "running"
+	BackupRunSucceeded = "succeeded"
+	BackupRunPartial   = "partial"
+	BackupRunFailed    = "failed"
+)
+
+var errScheduleRunning = errors.New("backup schedule is already running")
+
+var runningBackupSchedules = newRunGuard()
+
+// BackupRetention decides which backups of a schedule are kept: the newest
+// KeepLast, and the newest of each of the last KeepDaily days and KeepWeekly
+// ISO weeks that have backups. With all three zero every backup is kept.
+type BackupRetention struct {
+	KeepLast   int `json:"keep_last"`
+	KeepDaily  int `json:"keep_daily"`
+	KeepWeekly int `json:"keep_weekly"`
+}
+
+// BackupSchedule backs up volumes, named or selected by labels, on a schedule
+type BackupSchedule struct {
+	ID        int64           `json:"id"`
+	Name      string          `json:"name"`
+	Volumes   []string        `json:"volumes"`
+	Labels    []string        `json:"labels"`
+	Schedule  string          `json:"schedule"`
+	TargetID  int64           `json:"target_id"`
+	Stop      bool            `json:"stop"`
+	Retention BackupRetention `json:"retention"`
+	Enabled   bool            `json:"enabled"`
+	CreatedBy string          `json:"created_by"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	LastRunAt *time.Time      `json:"last_run_at,omitempty"`
+	NextRunAt *time.Time      `json:"next_run_at,omitempty"`
+}
+
+// BackupRunResult is the outcome of one volume in a run
+type BackupRunResult struct {
+	Volume   string   `json:"volume"`
+	BackupID int64    `json:"backup_id,omitempty"`
+	Filename string   `json:"filename,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	Verified bool     `json:"verified"`
+	Pruned   []string `json:"pruned,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// BackupRun is one run of a schedule
+type BackupRun struct {
+	ID          int64             `json:"id"`
+	ScheduleID  int64             `json:"schedule_id"`
+	Trigger     string            `json:"trigger"`
+	Status      string            `json:"status"`
+	Results     []BackupRunResult `json:"results"`
+	Pruned      int               `json:"pruned"`
+	Error       string            `json:"error,omitempty"`
+	TriggeredBy string            `json:"triggered_by"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  *time.Time        `json:"finished_at,omitempty"`
+}
+
+// BackupScheduleRequest creates or updates a schedule
+type BackupScheduleRequest struct {
+	Name      string          `json:"name"`
+	Volumes   []string        `json:"volumes"`
+	Labels    []string        `json:"labels"`
+	Schedule  string          `json:"schedule"`
+	TargetID  int64           `json:"target_id"`
+	Stop      bool            `json:"stop"`
+	Retention BackupRetention `json:"retention"`
+	Enabled   *bool           `json:"enabled"`
+}
+
+// validate checks a schedule request. The target is checked by the caller.
+func (req *BackupScheduleRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Schedule = strings.TrimSpace(req.Schedule)
+	if req.Name == "" {
+		return fmt.Errorf("field 'name' is required")
+	}
+	if req.Volumes == nil {
+		req.Volumes = []string{}
+	}
+	if req.Labels == nil {
+		req.Labels = []string{}
+	}
+	if len(req.Volumes) == 0 && len(req.Labels) == 0 {
+		return fmt.Errorf("give the volumes to back up or a label selector in 'labels'")
+	}
+	for _, volume := range req.Volumes {
+		if !volumeNamePattern.MatchString(volume) {
+			return fmt.Errorf("invalid volume name %q", volume)
+		}
+	}
+	for _, label := range req.Labels {
+		if strings.TrimSpace(label) == "" || strings.HasPrefix(label, "=") {
+			return fmt.Errorf("invalid label selector %q: use key or key=value", label)
+		}
+	}
+	if req.Retention.KeepLast < 0 || req.Retention.KeepDaily < 0 || req.Retention.KeepWeekly < 0 {
+		return fmt.Errorf("retention counts must not be negative")
+	}
+	if _, err := parseSchedule(req.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule: %v", err)
+	}
+	return nil
+}
+
+// retained returns the IDs of the backups to keep, given newest first
+func (p BackupRetention) retained(backups []VolumeBackup) map[int64]bool {
+	keep := make(map[int64]bool)
+	if p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 {
+		for _, backup := range backups {
+			keep[backup.ID] = true
+		}
+		return keep
+	}
+
+	for i := 0; i < p.KeepLast && i < len(backups); i++ {
+		keep[backups[i].ID] = true
+	}
+	// Keep the newest backup of each of the most recent periods
+	keepPeriods := func(count int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, backup := range backups {
+			key := period(backup.CreatedAt.Local())
+			if seen[key] {
+				continue
+			}
+			if len(seen) == count {
+				return
+			}
+			seen[key] = true
+			keep[backup.ID] = true
+		}
+	}
+	keepPeriods(p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(p.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	return keep
+}
+
+// scheduleVolumes resolves the volumes of a schedule: the named ones and
+// those carrying all of its labels
+func scheduleVolumes(schedule *BackupSchedule) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, volume := range schedule.Volumes {
+		selected[volume] = true
+	}
+	if len(schedule.Labels) > 0 {
+		args := []string{"volume", "ls", "--format", "{{.Name}}"}
+		for _, label := range schedule.Labels {
+			args = append(args, "--filter", "label="+label)
+		}
+		output, err := executeDockerCommand(args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range strings.Split(string(output), "\n") {
+			if name = strings.TrimSpace(name); name != "" {
+				selected[name] = true
+			}
+		}
+	}
+
+	volumes := make([]string, 0, len(selected))
+	for volume := range selected {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	return volumes, nil
+}
+
+// pruneScheduledBackups applies the retention of a schedule to its backups
+// of a volume and returns the removed files
+func pruneScheduledBackups(ctx context.Context, schedule *BackupSchedule, volume string) ([]string, error) {
+	rows, err := db.Query(`SELECT `+volumeBackupColumns+` FROM volume_backups WHERE schedule_id = ? AND volume = ? ORDER BY created_at DESC, id DESC`,
+		schedule.ID, volume)
+	if err != nil {
+		return nil, err
+	}
+	var backups []VolumeBackup
+	for rows.Next() {
+		backup, err := scanVolumeBackup(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		backups = append(backups, *backup)
+	}
+	rows.Close()
+
+	keep := schedule.Retention.retained(backups)
+	var pruned []string
+	for i := range backups {
+		backup := &backups[i]
+		if keep[backup.ID] {
+			continue
+		}
+		// The schedule's target may have changed since the backup was made
+		store, err := backup.store()
+		if err == nil {
+			err = removeVolumeBackup(ctx, backup, store)
+		}
+		if err != nil {
+			return pruned, fmt.Errorf("failed to remove %s: %v", backup.Filename, err)
+		}
+		pruned = append(pruned, backup.Filename)
+	}
+	return pruned, nil
+}
+
+// backupScheduleVolume stores, verifies and prunes the backups of one volume
+func backupScheduleVolume(ctx context.Context, schedule *BackupSchedule, target *BackupTarget, store backupStore, volume, user string) BackupRunResult {
+	result := BackupRunResult{Volume: volume}
+	backup, err := storeVolumeBackup(ctx, volume, volumeBackupOptions{stop: schedule.Stop, user: user, target: target, scheduleID: schedule.ID})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.BackupID, result.Filename, result.Size = backup.ID, backup.Filename, backup.Size
+
+	// A backup that does not read back intact is not kept
+	if err := backup.verify(ctx, store); err != nil {
+		if removeErr := removeVolumeBackup(ctx, backup, store); removeErr != nil {
+			logrus.WithError(removeErr).WithField("backup", backup.Filename).Warn("Failed to remove unverified backup")
+		}
+		result.BackupID, result.Error = 0, "verification failed: "+err.Error()
+		return result
+	}
+	result.Verified = true
+
+	pruned, err := pruneScheduledBackups(ctx, schedule, volume)
+	result.Pruned = pruned
+	if err != nil {
+		result.Error = "retention failed: " + err.Error()
+	}
+	return result
+}
+
+// runBackupSchedule backs up every volume of a schedule, verifies the new
+// backups, applies the retention policy and records the run. Failures are
+// notified. Only one run of a schedule happens at a time.
+func runBackupSchedule(ctx context.Context, schedule *BackupSchedule, trigger, user string, progress func(done, total int, message string)) (*BackupRun, error) {
+	if !runningBackupSchedules.acquire(schedule.ID) {
+		return nil, errScheduleRunning
+	}
+	defer runningBackupSchedules.release(schedule.ID)
+	if progress == nil {
+		progress = func(int, int, string) {}
+	}
+
+	run := &BackupRun{
+		ScheduleID:  schedule.ID,
+		Trigger:     trigger,
+		Status:      BackupRunRunning,
+		Results:     []BackupRunResult{},
+		TriggeredBy: user,
+		StartedAt:   time.Now(),
+	}
+	result, err := db.Exec(`INSERT INTO backup_runs (schedule_id, run_trigger, status, triggered_by, started_at) VALUES (?, ?, ?, ?, ?)`,
+		run.ScheduleID, run.Trigger, run.Status, run.TriggeredBy, run.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	run.ID, _ = result.LastInsertId()
+
+	log := logrus.WithFields(logrus.Fields{"schedule": schedule.Name, "run": run.ID, "trigger": trigger})
+	log.Info("Backup schedule started")
+
+	runErr := func() error {
+		target, err := loadBackupTarget(schedule.TargetID)
+		if err != nil {
+			return fmt.Errorf("failed to load backup target %d: %v", schedule.TargetID, err)
+		}
+		store, err := target.store()
+		if err != nil {
+			return err
+		}
+		volumes, err := scheduleVolumes(schedule)
+		if err != nil {
+			return err
+		}
+		if len(volumes) == 0 {
+			return fmt.Errorf("no volumes match the schedule")
+		}
+		for i, volume := range volumes {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			progress(i, len(volumes), "Backing up "+volume)
+			volumeResult := backupScheduleVolume(ctx, schedule, target, store, volume, user)
+			run.Results = append(run.Results, volumeResult)
+			run.Pruned += len(volumeResult.Pruned)
+		}
+		progress(len(volumes), len(volumes), "Backups complete")
+		return nil
+	}()
+
+	var failed []string
+	for _, volumeResult := range run.Results {
+		if volumeResult.Error != "" {
+			failed = append(failed, volumeResult.Volume+": "+volumeResult.Error)
+		}
+	}
+	switch {
+	case runErr != nil:
+		run.Status, run.Error = BackupRunFailed, runErr.Error()
+	case len(failed) == 0:
+		run.Status = BackupRunSucceeded
+	case len(failed) == len(run.Results):
+		run.Status, run.Error = BackupRunFailed, strings.Join(failed, "; ")
+	default:
+		run.Status, run.Error = BackupRunPartial, strings.Join(failed, "; ")
+	}
+	finished := time.Now()
+	run.FinishedAt = &finished
+
+	results, _ := json.Marshal(run.Results)
+	if _, err := db.Exec(`UPDATE backup_runs SET status = ?, results = ?, pruned = ?, error = ?, finished_at = ? WHERE id = ?`,
+		run.Status, string(results), run.Pruned, run.Error, finished, run.ID); err != nil {
+		log.WithError(err).Error("Failed to record backup run")
+	}
+	if _, err := db.Exec(`UPDATE backup_schedules SET last_run_at = ? WHERE id = ?`, run.StartedAt, schedule.ID); err != nil {
+		log.WithError(err).Error("Failed to update backup schedule")
+	}
+	if _, err := db.Exec(`DELETE FROM backup_runs WHERE schedule_id = ? AND id NOT IN (SELECT id FROM backup_runs WHERE schedule_id = ? ORDER BY id DESC LIMIT ?)`,
+		schedule.ID, schedule.ID, backupRunHistory); err != nil {
+		log.WithError(err).Warn("Failed to trim backup run history")
+	}
+
+	outcome := "success"
+	if run.Status != BackupRunSucceeded {
+		outcome = "failure"
+	}
+	recordAudit(user, "volumes.backup.schedule.run", schedule.Name, outcome, map[string]interface{}{
+		"schedule_id": schedule.ID,
+		"run_id":      run.ID,
+		"trigger":     trigger,
+		"status":      run.Status,
+		"volumes":     len(run.Results),
+		"pruned":      run.Pruned,
+		"error":       run.Error,
+	})
+	if run.Status != BackupRunSucceeded {
+		log.WithField("status", run.Status).Error("Backup schedule failed: " + run.Error)
+		notify("volume.backup_failed", "Backup schedule "+schedule.Name+" failed", run.Error, map[string]interface{}{
+			"schedule_id": schedule.ID,
+			"run_id":      run.ID,
+			"status":      run.Status,
+		})
+	} else {
+		log.WithFields(logrus.Fields{"volumes": len(run.Results), "pruned": run.Pruned}).Info("Backup schedule finished")
+	}
+	return run, nil
+}
+
+// backupScheduler runs enabled schedules when they are due
+var backupScheduler = &dueRunner{
+	kind:     "backup schedule",
+	table:    "backup_schedules",
+	interval: backupTickInterval,
+	timeout:  backupRunTimeout,
+	load: func() ([]scheduledItem, error) {
+		schedules, err := loadBackupSchedules()
+		if err != nil {
+			return nil, err
+		}
+		items := make([]scheduledItem, 0, len(schedules))
+		for i := range schedules {
+			schedule := &schedules[i]
+			items = append(items, scheduledItem{
+				ID: schedule.ID, Name: schedule.Name, Schedule: schedule.Schedule, Enabled: schedule.Enabled, NextRunAt: schedule.NextRunAt,
+				run: func(ctx context.Context) error {
+					_, err := runBackupSchedule(ctx, schedule, "schedule", "scheduler", nil)
+					return err
+				},
+			})
+		}
+		return items, nil
+	},
+}
+
+// startBackupScheduler runs due backup schedules in the background
+func startBackupScheduler() {
+	backupScheduler.start()
+}
+
+const backupScheduleColumns = `id, name, volumes, labels, schedule, target_id, stop, keep_last, keep_daily, keep_weekly, enabled, created_by, created_at, updated_at, last_run_at, next_run_at`
+
+func scanBackupSchedule(row interface{ Scan(...interface{}) error }) (*BackupSchedule, error) {
+	var schedule BackupSchedule
+	var volumes, labels string
+	var lastRun, nextRun sql.NullTime
+	if err := row.Scan(&schedule.ID, &schedule.Name, &volumes, &labels, &schedule.Schedule, &schedule.TargetID, &schedule.Stop,
+		&schedule.Retention.KeepLast, &schedule.Retention.KeepDaily, &schedule.Retention.KeepWeekly, &schedule.Enabled,
+		&schedule.CreatedBy, &schedule.CreatedAt, &schedule.UpdatedAt, &lastRun, &nextRun); err != nil {
+		return nil, err
+	}
+	schedule.Volumes, schedule.Labels = []string{}, []string{}
+	json.Unmarshal([]byte(volumes), &schedule.Volumes)
+	json.Unmarshal([]byte(labels), &schedule.Labels)
+	if lastRun.Valid {
+		schedule.LastRunAt = &lastRun.Time
+	}
+	if nextRun.Valid {
+		schedule.NextRunAt = &nextRun.Time
+	}
+	return &schedule, nil
+}
+
+// loadBackupSchedules returns all stored schedules
+func loadBackupSchedules() ([]BackupSchedule, error) {
+	rows, err := db.Query(`SELECT ` + backupScheduleColumns + ` FROM backup_schedules ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []BackupSchedule{}
+	for rows.Next() {
+		schedule, err := scanBackupSchedule(rows)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to scan backup schedule row")
+			continue
+		}
+		schedules = append(schedules, *schedule)
+	}
+	return schedules, rows.Err()
+}
+
+// loadBackupSchedule returns a stored schedule by ID
+func loadBackupSchedule(id int64) (*BackupSchedule, error) {
+	return scanBackupSchedule(db.QueryRow(`SELECT `+backupScheduleColumns+` FROM backup_schedules WHERE id = ?`, id))
+}
+
+// backupScheduleFromRequest loads the schedule named by the route, writing
+// an error response if there is none
+func backupScheduleFromRequest(w http.ResponseWriter, r *http.Request) (*BackupSchedule, bool) {
+	if !requireDatabase(w) {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid backup schedule ID")
+		return nil, false
+	}
+	schedule, err := loadBackupSchedule(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Backup schedule not found")
+		return nil, false
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load backup schedule", err)
+		return nil, false
+	}
+	return schedule, true
+}
+
+// decodeBackupScheduleRequest decodes and validates a schedule request into
+// req, which holds the current values on update
+func decodeBackupScheduleRequest(w http.ResponseWriter, r *http.Request, req *BackupScheduleRequest) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return false
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if _, err := loadBackupTarget(req.TargetID); err != nil {
+		writeError(w, http.StatusBadRequest, "Unknown backup target")
+		return false
+	}
+	return true
+}
+
+// listBackupSchedules returns the stored schedules
+func listBackupSchedules(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	schedules, err := loadBackupSchedules()
+	if err != nil {
+		writeFailure(w, "Failed to load backup schedules", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// getBackupSchedule returns one stored schedule
+func getBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := backupScheduleFromRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// createBackupSchedule stores a schedule and plans its first run
+func createBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	var req BackupScheduleRequest
+	if !decodeBackupScheduleRequest(w, r, &req) {
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	now := time.Now()
+	volumes, _ := json.Marshal(req.Volumes)
+	labels, _ := json.Marshal(req.Labels)
+	result, err := db.Exec(`INSERT INTO backup_schedules (name, volumes, labels, schedule, target_id, stop, keep_last, keep_daily, keep_weekly, enabled, created_by, created_at, updated_at, next_run_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, string(volumes), string(labels), req.Schedule, req.TargetID, req.Stop, req.Retention.KeepLast, req.Retention.KeepDaily,
+		req.Retention.KeepWeekly, enabled, r.Header.Get("X-User"), now, now, nextRunTime(req.Schedule, enabled, now))
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A backup schedule with this name already exists")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to store backup schedule", err)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	recordAudit(r.Header.Get("X-User"), "volumes.backup.schedule.create", req.Name, "success", map[string]interface{}{
+		"volumes":   req.Volumes,
+		"labels":    req.Labels,
+		"schedule":  req.Schedule,
+		"target_id": req.TargetID,
+		"retention": req.Retention,
+		"enabled":   enabled,
+	})
+	logrus.WithFields(logrus.Fields{"schedule": req.Name, "cron": req.Schedule}).Info("Backup schedule created")
+
+	schedule, err := loadBackupSchedule(id)
+	if err != nil {
+		writeFailure(w, "Failed to load backup schedule", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// updateBackupSchedule changes a schedule. Omitted fields keep their values
+// and the next run is recomputed from now.
+func updateBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	existing, ok := backupScheduleFromRequest(w, r)
+	if !ok {
+		return
+	}
+	req := BackupScheduleRequest{
+		Name:      existing.Name,
+		Volumes:   existing.Volumes,
+		Labels:    existing.Labels,
+		Schedule:  existing.Schedule,
+		TargetID:  existing.TargetID,
+		Stop:      existing.Stop,
+		Retention: existing.Retention,
+		Enabled:   &existing.Enabled,
+	}
+	if !decodeBackupScheduleRequest(w, r, &req) {
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	now := time.Now()
+	volumes, _ := json.Marshal(req.Volumes)
+	labels, _ := json.Marshal(req.Labels)
+	_, err := db.Exec(`UPDATE backup_schedules SET name = ?, volumes = ?, labels = ?, schedule = ?, target_id = ?, stop = ?, keep_last = ?, keep_daily = ?, keep_weekly = ?,
+		enabled = ?, updated_at = ?, next_run_at = ? WHERE id = ?`,
+		req.Name, string(volumes), string(labels), req.Schedule, req.TargetID, req.Stop, req.Retention.KeepLast, req.Retention.KeepDaily,
+		req.Retention.KeepWeekly, enabled, now, nextRunTime(req.Schedule, enabled, now), existing.ID)
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A backup schedule with this name already exists")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to update backup schedule", err)
+		return
+	}
+
+	recordAudit(r.Header.Get("X-User"), "volumes.backup.schedule.update", req.Name, "success", map[string]interface{}{
+		"volumes":   req.Volumes,
+		"labels":    req.Labels,
+		"schedule":  req.Schedule,
+		"target_id": req.TargetID,
+		"retention": req.Retention,
+		"enabled":   enabled,
+	})
+
+	schedule, err := loadBackupSchedule(existing.ID)
+	if err != nil {
+		writeFailure(w, "Failed to load backup schedule", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// deleteBackupSchedule removes a schedule and its run history. Its backups
+// stay in the catalog but are no longer pruned.
+func deleteBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := backupScheduleFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM backup_schedules WHERE id = ?`, schedule.ID); err != nil {
+		writeFailure(w, "Failed to delete backup schedule", err)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM backup_runs WHERE schedule_id = ?`, schedule.ID); err != nil {
+		logrus.WithError(err).WithField("schedule", schedule.Name).Warn("Failed to delete backup run history")
+	}
+
+	recordAudit(r.Header.Get("X-User"), "volumes.backup.schedule.delete", schedule.Name, "success", nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Backup schedule deleted successfully"})
+}
+
+// runBackupScheduleNow runs a schedule outside its cron expression as a
+// background job whose result is the run
+func runBackupScheduleNow(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := backupScheduleFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if runningBackupSchedules.busy(schedule.ID) {
+		writeError(w, http.StatusConflict, "Backup schedule is already running")
+		return
+	}
+
+	job := startJob("backup-schedule", schedule.Name, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		return runBackupSchedule(ctx, schedule, "manual", job.CreatedBy, func(done, total int, message string) {
+			job.SetProgress(int64(done), int64(total), message)
+		})
+	})
+	writeJobAccepted(w, job)
+}
+
+// listBackupRuns returns the run history of a schedule, newest first
+func listBackupRuns(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := backupScheduleFromRequest(w, r)
+	if !ok {
+		return
+	}
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= backupRunHistory {
+			limit = n
+		}
+	}
+
+	rows, err := db.Query(`SELECT id, schedule_id, run_trigger, status, results, pruned, error, triggered_by, started_at, finished_at
+		FROM backup_runs WHERE schedule_id = ? ORDER BY id DESC LIMIT ?`, schedule.ID, limit)
+	if err != nil {
+		writeFailure(w, "Failed to load backup runs", err)
+		return
+	}
+	defer rows.Close()
+
+	runs := []BackupRun{}
+	for rows.Next() {
+		var run BackupRun
+		var results string
+		var finished sql.NullTime
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.Trigger, &run.Status, &results, &run.Pruned, &run.Error,
+			&run.TriggeredBy, &run.StartedAt, &finished); err != nil {
+			logrus.WithError(err).Error("Failed to scan backup run row")
+			continue
+		}
+		run.Results = []BackupRunResult{}
+		json.Unmarshal([]byte(results), &run.Results)
+		if finished.Valid {
+			run.FinishedAt = &finished.Time
+		}
+		runs = append(runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
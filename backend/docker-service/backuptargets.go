@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	backupTargetLocal = "local"
+	backupTargetS3    = "s3"
+)
+
+// BackupTarget is where volume backups are stored: a local directory or an
+// S3-compatible bucket. Target 0 is the VOLUME_BACKUP_DIR directory. The
+// secret key never leaves the service.
+type BackupTarget struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Path         string    `json:"path,omitempty"`
+	Endpoint     string    `json:"endpoint,omitempty"`
+	Region       string    `json:"region,omitempty"`
+	Bucket       string    `json:"bucket,omitempty"`
+	Prefix       string    `json:"prefix,omitempty"`
+	AccessKey    string    `json:"access_key,omitempty"`
+	HasSecretKey bool      `json:"has_secret_key,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	secretKey string
+	// secretKeyErr is set when the stored secret key cannot be decrypted, for
+	// example after REGISTRY_SECRET_KEY changed
+	secretKeyErr error
+}
+
+// BackupTargetRequest creates or updates a target. An empty secret key keeps
+// the stored one on update.
+type BackupTargetRequest struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// backupStore reads and writes backup files on a target
+type backupStore interface {
+	// spoolDir is where archives are written before put, so a local target
+	// can move them into place; empty for the system temp directory
+	spoolDir() string
+	put(ctx context.Context, name string, file *os.File, size int64, sha256 string) error
+	open(ctx context.Context, name string) (io.ReadCloser, error)
+	remove(ctx context.Context, name string) error
+}
+
+// localBackupStore keeps backups as files in a directory
+type localBackupStore struct {
+	dir string
+}
+
+func (s *localBackupStore) spoolDir() string {
+	return s.dir
+}
+
+func (s *localBackupStore) put(ctx context.Context, name string, file *os.File, size int64, sha256 string) error {
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(s.dir, filepath.Base(name)))
+}
+
+func (s *localBackupStore) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, filepath.Base(name)))
+}
+
+func (s *localBackupStore) remove(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(s.dir, filepath.Base(name))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// s3BackupStore keeps backups as objects under a prefix of a bucket
+type s3BackupStore struct {
+	client *s3Client
+	bucket string
+	prefix string
+}
+
+func (s *s3BackupStore) spoolDir() string {
+	return ""
+}
+
+func (s *s3BackupStore) put(ctx context.Context, name string, file *os.File, size int64, sha256 string) error {
+	return s.client.putObject(ctx, s.bucket, s.prefix+name, file, size, sha256)
+}
+
+func (s *s3BackupStore) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, err := s.client.getObject(ctx, s.bucket, s.prefix+name)
+	var s3Err *s3Error
+	if errors.As(err, &s3Err) && s3Err.Status == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %v", os.ErrNotExist, err)
+	}
+	return reader, err
+}
+
+func (s *s3BackupStore) remove(ctx context.Context, name string) error {
+	return s.client.deleteObject(ctx, s.bucket, s.prefix+name)
+}
+
+// defaultBackupTarget is the backup directory of the service
+func defaultBackupTarget() *BackupTarget {
+	return &BackupTarget{ID: 0, Name: "default", Type: backupTargetLocal, Path: volumeBackupDir()}
+}
+
+// store returns the backup store of a target, creating a local directory
+// if needed
+func (t *BackupTarget) store() (backupStore, error) {
+	switch t.Type {
+	case backupTargetLocal:
+		if err := os.MkdirAll(t.Path, 0700); err != nil {
+			return nil, err
+		}
+		return &localBackupStore{dir: t.Path}, nil
+	case backupTargetS3:
+		client, err := newS3Client(t.Endpoint, t.Region, t.AccessKey, t.secretKey)
+		if err != nil {
+			return nil, err
+		}
+		return &s3BackupStore{client: client, bucket: t.Bucket, prefix: t.Prefix}, nil
+	}
+	return nil, fmt.Errorf("unknown backup target type %q", t.Type)
+}
+
+// validate checks a target request and normalizes it
+func (req *BackupTargetRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Path = strings.TrimSpace(req.Path)
+	req.Endpoint = strings.TrimSuffix(strings.TrimSpace(req.Endpoint), "/")
+	req.Bucket = strings.TrimSpace(req.Bucket)
+	req.Prefix = strings.Trim(strings.TrimSpace(req.Prefix), "/")
+	if req.Prefix != "" {
+		req.Prefix += "/"
+	}
+	if req.Name == "" {
+		return fmt.Errorf("field 'name' is required")
+	}
+	switch req.Type {
+	case backupTargetLocal:
+		if !filepath.IsAbs(req.Path) {
+			return fmt.Errorf("local targets need an absolute 'path'")
+		}
+	case backupTargetS3:
+		if req.Bucket == "" {
+			return fmt.Errorf("field 'bucket' is required for S3 targets")
+		}
+		if req.AccessKey == "" {
+			return fmt.Errorf("field 'access_key' is required for S3 targets")
+		}
+		if _, err := newS3Client(req.Endpoint, req.Region, req.AccessKey, req.SecretKey); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("field 'type' must be local or s3")
+	}
+	return nil
+}
+
+const backupTargetColumns = `id, name, type, path, endpoint, region, bucket, prefix, access_key, secret, created_at, updated_at`
+
+func scanBackupTarget(row interface{ Scan(...interface{}) error }) (*BackupTarget, error) {
+	var target BackupTarget
+	var secret string
+	if err := row.Scan(&target.ID, &target.Name, &target.Type, &target.Path, &target.Endpoint, &target.Region, &target.Bucket,
+		&target.Prefix, &target.AccessKey, &secret, &target.CreatedAt, &target.UpdatedAt); err != nil {
+		return nil, err
+	}
+	target.HasSecretKey = secret != ""
+	secretKey, err := decryptSecret(secret)
+	if err != nil {
+		logrus.WithError(err).WithField("target", target.Name).Warn("Failed to decrypt backup target secret key")
+	}
+	target.secretKey, target.secretKeyErr = secretKey, err
+	return &target, nil
+}
+
+// loadBackupTargets returns the default target followed by the stored ones
+func loadBackupTargets() ([]BackupTarget, error) {
+	rows, err := db.Query(`SELECT ` + backupTargetColumns + ` FROM backup_targets ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []BackupTarget{*defaultBackupTarget()}
+	for rows.Next() {
+		target, err := scanBackupTarget(rows)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to scan backup target row")
+			continue
+		}
+		targets = append(targets, *target)
+	}
+	return targets, rows.Err()
+}
+
+// loadBackupTarget returns a target by ID, returning sql.ErrNoRows if there is none
+func loadBackupTarget(id int64) (*BackupTarget, error) {
+	if id == 0 {
+		return defaultBackupTarget(), nil
+	}
+	return scanBackupTarget(db.QueryRow(`SELECT `+backupTargetColumns+` FROM backup_targets WHERE id = ?`, id))
+}
+
+// backupTargetFromRequest loads the target named by the route, writing an
+// error response if there is none
+func backupTargetFromRequest(w http.ResponseWriter, r *http.Request) (*BackupTarget, bool) {
+	if !requireDatabase(w) {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid backup target ID")
+		return nil, false
+	}
+	target, err := loadBackupTarget(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Backup target not found")
+		return nil, false
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load backup target", err)
+		return nil, false
+	}
+	return target, true
+}
+
+// listBackupTargets returns the backup targets, starting with the default one
+func listBackupTargets(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	targets, err := loadBackupTargets()
+	if err != nil {
+		writeFailure(w, "Failed to load backup targets", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targets)
+}
+
+// getBackupTarget returns one backup target
+func getBackupTarget(w http.ResponseWriter, r *http.Request) {
+	target, ok := backupTargetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target)
+}
+
+// createBackupTarget stores a backup target
+func createBackupTarget(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	var req BackupTargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Type == backupTargetS3 && req.SecretKey == "" {
+		writeError(w, http.StatusBadRequest, "field 'secret_key' is required for S3 targets")
+		return
+	}
+	secret, err := encryptSecret(req.SecretKey)
+	if err != nil {
+		writeFailure(w, "Failed to encrypt secret key", err)
+		return
+	}
+
+	now := time.Now()
+	result, err := db.Exec(`INSERT INTO backup_targets (name, type, path, endpoint, region, bucket, prefix, access_key, secret, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.Type, req.Path, req.Endpoint, req.Region, req.Bucket, req.Prefix, req.AccessKey, secret, now, now)
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A backup target with this name already exists")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to store backup target", err)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	recordAudit(r.Header.Get("X-User"), "backups.targets.create", req.Name, "success", map[string]interface{}{
+		"type": req.Type, "path": req.Path, "endpoint": req.Endpoint, "bucket": req.Bucket, "prefix": req.Prefix,
+	})
+	logrus.WithFields(logrus.Fields{"target": req.Name, "type": req.Type}).Info("Backup target created")
+
+	target, err := loadBackupTarget(id)
+	if err != nil {
+		writeFailure(w, "Failed to load backup target", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(target)
+}
+
+// updateBackupTarget changes a stored target. Omitted fields keep their values.
+func updateBackupTarget(w http.ResponseWriter, r *http.Request) {
+	existing, ok := backupTargetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if existing.ID == 0 {
+		writeError(w, http.StatusBadRequest, "The default backup target is configured with VOLUME_BACKUP_DIR")
+		return
+	}
+
+	req := BackupTargetRequest{
+		Name:      existing.Name,
+		Type:      existing.Type,
+		Path:      existing.Path,
+		Endpoint:  existing.Endpoint,
+		Region:    existing.Region,
+		Bucket:    existing.Bucket,
+		Prefix:    existing.Prefix,
+		AccessKey: existing.AccessKey,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	secretKey := existing.secretKey
+	if req.SecretKey != "" {
+		secretKey = req.SecretKey
+	} else if existing.secretKeyErr != nil {
+		// Keeping the secret key would store it as empty
+		writeError(w, http.StatusConflict, "The stored secret key cannot be decrypted, was REGISTRY_SECRET_KEY changed? Send the secret key again")
+		return
+	}
+	secret, err := encryptSecret(secretKey)
+	if err != nil {
+		writeFailure(w, "Failed to encrypt secret key", err)
+		return
+	}
+
+	_, err = db.Exec(`UPDATE backup_targets SET name = ?, type = ?, path = ?, endpoint = ?, region = ?, bucket = ?, prefix = ?, access_key = ?, secret = ?, updated_at = ? WHERE id = ?`,
+		req.Name, req.Type, req.Path, req.Endpoint, req.Region, req.Bucket, req.Prefix, req.AccessKey, secret, time.Now(), existing.ID)
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A backup target with this name already exists")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to update backup target", err)
+		return
+	}
+
+	recordAudit(r.Header.Get("X-User"), "backups.targets.update", req.Name, "success", map[string]interface{}{
+		"type": req.Type, "path": req.Path, "endpoint": req.Endpoint, "bucket": req.Bucket, "prefix": req.Prefix,
+		"secret_key_changed": req.SecretKey != "",
+	})
+
+	target, err := loadBackupTarget(existing.ID)
+	if err != nil {
+		writeFailure(w, "Failed to load backup target", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target)
+}
+
+// deleteBackupTarget removes a target that no schedule or stored backup uses
+func deleteBackupTarget(w http.ResponseWriter, r *http.Request) {
+	target, ok := backupTargetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if target.ID == 0 {
+		writeError(w, http.StatusBadRequest, "The default backup target cannot be deleted")
+		return
+	}
+
+	var schedules, backups int
+	db.QueryRow(`SELECT COUNT(*) FROM backup_schedules WHERE target_id = ?`, target.ID).Scan(&schedules)
+	db.QueryRow(`SELECT COUNT(*) FROM volume_backups WHERE target_id = ?`, target.ID).Scan(&backups)
+	if schedules > 0 || backups > 0 {
+		writeErrorDetails(w, http.StatusConflict, codeConflict, "Backup target is in use by schedules or stored backups",
+			map[string]interface{}{"schedules": schedules, "backups": backups})
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM backup_targets WHERE id = ?`, target.ID); err != nil {
+		writeFailure(w, "Failed to delete backup target", err)
+		return
+	}
+	recordAudit(r.Header.Get("X-User"), "backups.targets.delete", target.Name, "success", map[string]interface{}{"type": target.Type})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Backup target deleted successfully"})
+}
+
+// testBackupTarget writes, reads back and removes a small probe file. A
+// failing target is a result, not a failed request.
+func testBackupTarget(w http.ResponseWriter, r *http.Request) {
+	target, ok := backupTargetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result := map[string]interface{}{"success": true}
+	if err := probeBackupStore(ctx, target); err != nil {
+		result = map[string]interface{}{"success": false, "error": err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// probeBackupStore checks that a target accepts, returns and deletes a file
+func probeBackupStore(ctx context.Context, target *BackupTarget) error {
+	store, err := target.store()
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(store.spoolDir(), ".probe-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	content := []byte("dockmaster backup target probe " + time.Now().UTC().Format(time.RFC3339Nano))
+	if _, err := file.Write(content); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	name := ".dockmaster-probe-" + newJobID()
+	if err := store.put(ctx, name, file, int64(len(content)), hex.EncodeToString(sum[:])); err != nil {
+		return fmt.Errorf("write failed: %v", err)
+	}
+	defer store.remove(ctx, name)
+
+	reader, err := store.open(ctx, name)
+	if err != nil {
+		return fmt.Errorf("read failed: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, int64(len(content))+1))
+	if err != nil {
+		return fmt.Errorf("read failed: %v", err)
+	}
+	if string(data) != string(content) {
+		return fmt.Errorf("read back different content than was written")
+	}
+	if err := store.remove(ctx, name); err != nil {
+		return fmt.Errorf("delete failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// maxBuildContextSize bounds the size of an uploaded build context
+	maxBuildContextSize = envByteSize("BUILD_MAX_CONTEXT", 2*1024*1024*1024)
+	// maxBuildLogSize bounds the build output kept in the database
+	maxBuildLogSize = envByteSize("BUILD_MAX_LOG", 4*1024*1024)
+)
+
+// maxInlineDockerfileSize bounds an inline Dockerfile
+const maxInlineDockerfileSize = 1024 * 1024
+
+var buildStepPattern = regexp.MustCompile(`^Step (\d+)/(\d+) :`)
+
+// BuildOptions are the options of an image build
+type BuildOptions struct {
+	Tags       []string          `json:"tags"`
+	BuildArgs  map[string]string `json:"build_args,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Platform   string            `json:"platform,omitempty"`
+	NoCache    bool              `json:"no_cache"`
+	Pull       bool              `json:"pull"`
+}
+
+// BuildRecord is a build kept in the database with its output
+type BuildRecord struct {
+	ID         string       `json:"id"`
+	Status     string       `json:"status"`
+	Tags       []string     `json:"tags"`
+	Options    BuildOptions `json:"options"`
+	ImageID    string       `json:"image_id,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Log        string       `json:"log,omitempty"`
+	Truncated  bool         `json:"log_truncated,omitempty"`
+	CreatedBy  string       `json:"created_by"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// parseKeyValues parses repeated KEY=VALUE query parameters
+func parseKeyValues(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected KEY=VALUE, got %q", value)
+		}
+		result[key] = val
+	}
+	return result, nil
+}
+
+// buildOptionsFromQuery reads build options from query parameters. Tags,
+// build args and labels may be repeated.
+func buildOptionsFromQuery(query url.Values) (BuildOptions, error) {
+	opts := BuildOptions{
+		Tags:       query["tag"],
+		Target:     query.Get("target"),
+		Dockerfile: query.Get("dockerfile"),
+		Platform:   query.Get("platform"),
+		NoCache:    query.Get("no_cache") == "true",
+		Pull:       query.Get("pull") == "true",
+	}
+	var err error
+	if opts.BuildArgs, err = parseKeyValues(query["build_arg"]); err != nil {
+		return opts, fmt.Errorf("invalid build_arg: %v", err)
+	}
+	if opts.Labels, err = parseKeyValues(query["label"]); err != nil {
+		return opts, fmt.Errorf("invalid label: %v", err)
+	}
+	return opts, nil
+}
+
+// validate checks the build options and normalizes the tags
+func (opts *BuildOptions) validate() error {
+	for i, tag := range opts.Tags {
+		tag = strings.TrimSpace(tag)
+		if err := validateTagReference("tags", tag); err != nil {
+			return err
+		}
+		opts.Tags[i] = normalizeImageReference(tag)
+	}
+	if opts.Platform != "" && !platformPattern.MatchString(opts.Platform) {
+		return fmt.Errorf("invalid platform, use os/arch[/variant] such as linux/arm64")
+	}
+	if opts.Dockerfile != "" {
+		name, err := safeArchiveName(opts.Dockerfile)
+		if err != nil || name == "" {
+			return fmt.Errorf("invalid dockerfile path %q", opts.Dockerfile)
+		}
+		opts.Dockerfile = name
+	}
+	return nil
+}
+
+// partFileName returns the file name of a multipart part including its
+// directories, which mime/multipart strips from FileName()
+func partFileName(part *multipart.Part) string {
+	if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return strings.ReplaceAll(params["filename"], "\\", "/")
+	}
+	return part.FileName()
+}
+
+// writeBuildContext assembles a build context from a multipart request. The
+// parts are an optional "options" JSON object, an optional "context" tar
+// archive, an optional inline "Dockerfile" and any number of "files" whose
+// file names are their paths in the context.
+func writeBuildContext(r *http.Request, tw *tar.Writer, opts *BuildOptions) (int, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var dockerfile []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		switch part.FormName() {
+		case "options":
+			err = json.NewDecoder(part).Decode(opts)
+			if err != nil {
+				err = fmt.Errorf("invalid options: %v", err)
+			}
+		case "context":
+			var n int
+			n, err = sanitizeArchive(part, tw)
+			count += n
+		case "Dockerfile", "dockerfile":
+			dockerfile, err = io.ReadAll(io.LimitReader(part, maxInlineDockerfileSize+1))
+			if err == nil && len(dockerfile) > maxInlineDockerfileSize {
+				err = fmt.Errorf("inline Dockerfile exceeds %d bytes", maxInlineDockerfileSize)
+			}
+		case "files":
+			var name string
+			name, err = safeArchiveName(partFileName(part))
+			if err == nil && name == "" {
+				err = fmt.Errorf("file part without a file name")
+			}
+			if err == nil {
+				err = writeContextFile(tw, name, part)
+				count++
+			}
+		}
+		part.Close()
+		if err != nil {
+			return count, err
+		}
+	}
+
+	// The inline Dockerfile is written last so it wins over one in the context
+	if dockerfile != nil {
+		opts.Dockerfile = "Dockerfile"
+		if err := writeContextFile(tw, opts.Dockerfile, strings.NewReader(string(dockerfile))); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("the build context is empty; upload a context archive, a Dockerfile or files")
+	}
+	return count, tw.Close()
+}
+
+// writeContextFile adds a regular file to a build context
+func writeContextFile(tw *tar.Writer, name string, src io.Reader) error {
+	file, size, err := spoolUpload(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// registryConfigHeader encodes every stored credential for X-Registry-Config
+// so base images can be pulled from private registries during the build
+func registryConfigHeader() string {
+	if db == nil {
+		return ""
+	}
+	registries, err := loadRegistries()
+	if err != nil || len(registries) == 0 {
+		return ""
+	}
+	config := make(map[string]RegistryAuth, len(registries))
+	for i := range registries {
+		if registries[i].Username != "" {
+			auth := registries[i].auth()
+			config[auth.ServerAddress] = auth
+		}
+	}
+	data, _ := json.Marshal(config)
+	return base64.URLEncoding.EncodeToString(data)
+}
+
+// engineBuild runs a build through the Engine API, reporting every stream
+// message to fn
+func engineBuild(ctx context.Context, buildContext io.Reader, opts BuildOptions, fn func(engineMessage)) error {
+	query := url.Values{"t": opts.Tags, "rm": {"1"}, "forcerm": {"1"}}
+	if len(opts.BuildArgs) > 0 {
+		data, _ := json.Marshal(opts.BuildArgs)
+		query.Set("buildargs", string(data))
+	}
+	if len(opts.Labels) > 0 {
+		data, _ := json.Marshal(opts.Labels)
+		query.Set("labels", string(data))
+	}
+	if opts.Target != "" {
+		query.Set("target", opts.Target)
+	}
+	if opts.Dockerfile != "" {
+		query.Set("dockerfile", opts.Dockerfile)
+	}
+	if opts.Platform != "" {
+		query.Set("platform", opts.Platform)
+	}
+	if opts.NoCache {
+		query.Set("nocache", "1")
+	}
+	if opts.Pull {
+		query.Set("pull", "1")
+	}
+
+	headers := map[string]string{"Content-Type": "application/x-tar"}
+	if config := registryConfigHeader(); config != "" {
+		headers["X-Registry-Config"] = config
+	}
+
+	resp, err := engineRequest(ctx, http.MethodPost, "/build", query, headers, buildContext)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := readEngineStream(resp.Body, "docker build", fn); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// buildLog accumulates build output up to maxBuildLogSize
+type buildLog struct {
+	strings.Builder
+	truncated bool
+}
+
+func (l *buildLog) add(line string) {
+	if l.truncated {
+		return
+	}
+	if int64(l.Len()+len(line)) > maxBuildLogSize {
+		l.truncated = true
+		l.WriteString("... log truncated ...\n")
+		return
+	}
+	l.WriteString(line)
+}
+
+// saveBuildStart records a running build
+func saveBuildStart(job *Job, opts BuildOptions) {
+	if db == nil {
+		return
+	}
+	tags, _ := json.Marshal(opts.Tags)
+	options, _ := json.Marshal(opts)
+	_, err := db.Exec(`INSERT INTO builds (id, status, tags, options, created_by, started_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		job.ID, JobRunning, string(tags), string(options), job.CreatedBy, time.Now())
+	if err != nil {
+		logrus.WithError(err).WithField("build", job.ID).Warn("Failed to record build")
+	}
+}
+
+// saveBuildResult stores the outcome and output of a build
+func saveBuildResult(id, status, imageID string, buildErr error, log *buildLog) {
+	if db == nil {
+		return
+	}
+	message := ""
+	if buildErr != nil {
+		message = buildErr.Error()
+	}
+	_, err := db.Exec(`UPDATE builds SET status = ?, image_id = ?, error = ?, log = ?, log_truncated = ?, finished_at = ? WHERE id = ?`,
+		status, imageID, message, log.String(), log.truncated, time.Now(), id)
+	if err != nil {
+		logrus.WithError(err).WithField("build", id).Warn("Failed to store build log")
+	}
+}
+
+// startBuildJob starts a background job building the context in file. The
+// job owns the file and closes it when done.
+func startBuildJob(file *os.File, opts BuildOptions, user string) *Job {
+	target := strings.Join(opts.Tags, ", ")
+	if target == "" {
+		target = "untagged"
+	}
+	return startJob("build", target, user, func(ctx context.Context, job *Job) (interface{}, error) {
+		defer file.Close()
+		saveBuildStart(job, opts)
+		started := time.Now()
+		job.SetProgress(0, 0, "Sending build context")
+
+		log := &buildLog{}
+		imageID := ""
+		err := engineBuild(ctx, file, opts, func(msg engineMessage) {
+			if len(msg.Aux) > 0 {
+				var aux struct {
+					ID string `json:"ID"`
+				}
+				if json.Unmarshal(msg.Aux, &aux) == nil && aux.ID != "" {
+					imageID = aux.ID
+				}
+				return
+			}
+
+			line := msg.Stream
+			if line == "" && msg.Status != "" {
+				line = strings.TrimSpace(msg.ID+" "+msg.Status) + "\n"
+			}
+			if line == "" {
+				return
+			}
+			log.add(line)
+			job.Emit("log", map[string]string{"message": strings.TrimRight(line, "\n")})
+
+			if match := buildStepPattern.FindStringSubmatch(line); match != nil {
+				step, _ := strconv.ParseInt(match[1], 10, 64)
+				steps, _ := strconv.ParseInt(match[2], 10, 64)
+				job.SetProgress(step, steps, strings.TrimSpace(line))
+			}
+		})
+
+		switch {
+		case ctx.Err() == context.Canceled:
+			saveBuildResult(job.ID, JobCancelled, "", nil, log)
+			return nil, ctx.Err()
+		case err != nil:
+			log.add("ERROR: " + err.Error() + "\n")
+			saveBuildResult(job.ID, JobFailed, "", err, log)
+			recordAudit(user, "images.build", target, "failure", map[string]interface{}{"job": job.ID, "error": err.Error()})
+			return nil, err
+		}
+
+		saveBuildResult(job.ID, JobSucceeded, imageID, nil, log)
+		recordAudit(user, "images.build", target, "success", map[string]interface{}{"job": job.ID, "image": imageID})
+
+		result := map[string]interface{}{
+			"build_id": job.ID,
+			"image_id": imageID,
+			"tags":     opts.Tags,
+			"duration": time.Since(started).String(),
+		}
+		job.Emit("complete", result)
+		logrus.WithFields(logrus.Fields{"image": imageID, "tags": opts.Tags}).Info("Image built successfully")
+		return result, nil
+	})
+}
+
+// buildImage accepts a build context and starts a build job; output is
+// streamed through /jobs/{id}/events and kept under /images/builds/{id}.
+//
+// The context is either a tar archive body (optionally gzipped) with options
+// in query parameters, or a multipart form as read by writeBuildContext.
+func buildImage(w http.ResponseWriter, r *http.Request) {
+	// Contexts can be large; the upload must not be cut off by the server timeouts
+	disableTimeouts(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBuildContextSize)
+
+	opts, err := buildOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var file *os.File
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-tar", "application/gzip", "application/x-gzip":
+		file, _, err = spoolUpload(r.Body)
+	case "multipart/form-data":
+		file, err = os.CreateTemp("", "dockmaster-build-*")
+		if err == nil {
+			os.Remove(file.Name())
+			if _, err = writeBuildContext(r, tar.NewWriter(file), &opts); err == nil {
+				_, err = file.Seek(0, io.SeekStart)
+			}
+		}
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, "Send the build context as application/x-tar, application/gzip or multipart/form-data")
+		return
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, errTransferTooLarge.Error())
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Invalid build context: "+err.Error())
+		return
+	}
+
+	if err := opts.validate(); err != nil {
+		file.Close()
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job := startBuildJob(file, opts, r.Header.Get("X-User"))
+	writeJobAccepted(w, job)
+}
+
+const buildColumns = `id, status, tags, options, image_id, error, log_truncated, created_by, started_at, finished_at`
+
+func scanBuild(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*BuildRecord, error) {
+	var build BuildRecord
+	var tags, options string
+	var finished sql.NullTime
+	dest := append([]interface{}{&build.ID, &build.Status, &tags, &options, &build.ImageID, &build.Error, &build.Truncated, &build.CreatedBy, &build.StartedAt, &finished}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(tags), &build.Tags)
+	json.Unmarshal([]byte(options), &build.Options)
+	if finished.Valid {
+		build.FinishedAt = &finished.Time
+	}
+	return &build, nil
+}
+
+// listBuilds returns recent builds without their output
+func listBuilds(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeError(w, http.StatusServiceUnavailable, "Build history requires the database")
+		return
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+
+	rows, err := db.Query(`SELECT `+buildColumns+` FROM builds ORDER BY started_at DESC LIMIT ?`, limit)
+	if err != nil {
+		writeFailure(w, "Failed to load builds", err)
+		return
+	}
+	defer rows.Close()
+
+	builds := []BuildRecord{}
+	for rows.Next() {
+		build, err := scanBuild(rows)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to scan build row")
+			continue
+		}
+		builds = append(builds, *build)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(builds)
+}
+
+// getBuild returns a build with its output. With ?format=text the log is
+// returned as plain text.
+func getBuild(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		writeError(w, http.StatusServiceUnavailable, "Build history requires the database")
+		return
+	}
+
+	var log string
+	build, err := scanBuild(db.QueryRow(`SELECT `+buildColumns+`, log FROM builds WHERE id = ?`, mux.Vars(r)["id"]), &log)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load build", err)
+		return
+	}
+	build.Log = log
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, build.Log)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(build)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBulkConcurrency = 4
+	maxBulkConcurrency     = 16
+)
+
+// BulkContainerRequest applies one action to many containers
+type BulkContainerRequest struct {
+	Action      string   `json:"action"`
+	IDs         []string `json:"ids,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	DryRun      bool     `json:"dry_run,omitempty"`
+	Force       bool     `json:"force,omitempty"`
+	Signal      string   `json:"signal,omitempty"`
+	Concurrency int      `json:"concurrency,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk action for a single container
+type BulkResult struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	State    string `json:"state,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+// BulkReport summarizes a bulk action
+type BulkReport struct {
+	Action    string       `json:"action"`
+	DryRun    bool         `json:"dry_run"`
+	Total     int          `json:"total"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Skipped   int          `json:"skipped"`
+	Results   []BulkResult `json:"results"`
+	AuditID   int64        `json:"audit_id,omitempty"`
+}
+
+// bulkActions lists the supported actions and the states in which they are no-ops
+var bulkActions = map[string][]string{
+	"start":   {"running"},
+	"stop":    {"exited", "created", "dead"},
+	"restart": {},
+	"pause":   {"paused", "exited", "created", "dead"},
+	"unpause": {"running", "exited", "created", "dead"},
+	"kill":    {"exited", "created", "dead"},
+	"remove":  {},
+}
+
+// bulkTarget is a container resolved from IDs or a label selector
+type bulkTarget struct {
+	ID    string
+	Name  string
+	State string
+}
+
+// bulkAuditTarget names what a bulk request addressed: its label selector,
+// or the containers its IDs resolved to
+func bulkAuditTarget(req BulkContainerRequest, targets []bulkTarget) string {
+	if len(req.Labels) > 0 {
+		return strings.Join(req.Labels, ",")
+	}
+	if len(targets) == 0 {
+		return strings.Join(req.IDs, ",")
+	}
+	ids := make([]string, len(targets))
+	for i, target := range targets {
+		ids[i] = target.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+// resolveBulkTargets finds the containers addressed by a bulk request
+func resolveBulkTargets(req BulkContainerRequest) ([]bulkTarget, []BulkResult, error) {
+	args := []string{"ps", "-a", "--no-trunc", "--format", "json"}
+	for _, label := range req.Labels {
+		args = append(args, "--filter", "label="+label)
+	}
+	output, err := executeDockerCommand(args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var all []bulkTarget
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		var container DockerContainer
+		if err := json.Unmarshal([]byte(line), &container); err != nil {
+			logrus.WithError(err).WithField("line", line).Error("Failed to parse container JSON")
+			continue
+		}
+		all = append(all, bulkTarget{ID: container.ID, Name: container.Names, State: container.State})
+	}
+
+	// A label selector alone targets every matching container
+	if len(req.IDs) == 0 {
+		return all, nil, nil
+	}
+
+	var targets []bulkTarget
+	var missing []BulkResult
+	seen := make(map[string]bool)
+	for _, id := range req.IDs {
+		var match *bulkTarget
+		for i := range all {
+			if all[i].ID == id || all[i].Name == strings.TrimPrefix(id, "/") || (len(id) >= 12 && strings.HasPrefix(all[i].ID, id)) {
+				match = &all[i]
+				break
+			}
+		}
+		if match == nil {
+			status := "not_found"
+			if len(req.Labels) > 0 {
+				status = "not_matched"
+			}
+			missing = append(missing, BulkResult{ID: id, Status: status, Error: "container not found or not matching the selector"})
+			continue
+		}
+		if !seen[match.ID] {
+			seen[match.ID] = true
+			targets = append(targets, *match)
+		}
+	}
+
+	return targets, missing, nil
+}
+
+// applyBulkAction performs the action on a single container
+func applyBulkAction(req BulkContainerRequest, id string) error {
+	switch req.Action {
+	case "start":
+		return dockerStart(id)
+	case "stop":
+		return dockerStop(id)
+	case "restart":
+		return dockerRestart(id)
+	case "pause":
+		return dockerPause(id)
+	case "unpause":
+		return dockerUnpause(id)
+	case "kill":
+		return dockerKill(id, req.Signal)
+	case "remove":
+		return dockerRemove(id, req.Force)
+	}
+	return fmt.Errorf("unsupported action %s", req.Action)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// runBulkAction executes the action on all targets with bounded concurrency
+func runBulkAction(req BulkContainerRequest, targets []bulkTarget) []BulkResult {
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBulkConcurrency
+	}
+	if concurrency > maxBulkConcurrency {
+		concurrency = maxBulkConcurrency
+	}
+
+	results := make([]BulkResult, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		result := BulkResult{ID: target.ID, Name: target.Name, State: target.State}
+
+		if containsString(bulkActions[req.Action], target.State) {
+			result.Status = "skipped"
+			results[i] = result
+			continue
+		}
+		if req.DryRun {
+			result.Status = "would_" + req.Action
+			results[i] = result
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, result BulkResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			started := time.Now()
+			if err := applyBulkAction(req, result.ID); err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			} else {
+				result.Status = "ok"
+			}
+			result.Duration = time.Since(started).Milliseconds()
+			results[i] = result
+		}(i, result)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// bulkContainers applies an action to a list of containers or a label selector
+func bulkContainers(w http.ResponseWriter, r *http.Request) {
+	var req BulkContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if _, ok := bulkActions[req.Action]; !ok {
+		writeError(w, http.StatusBadRequest, "Unsupported action, use start, stop, restart, pause, unpause, kill or remove")
+		return
+	}
+	if len(req.IDs) == 0 && len(req.Labels) == 0 {
+		writeError(w, http.StatusBadRequest, "Either 'ids' or 'labels' is required")
+		return
+	}
+
+	targets, missing, err := resolveBulkTargets(req)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to resolve bulk targets")
+		writeFailure(w, "Failed to resolve containers", err)
+		return
+	}
+
+	// Stopping many containers can outlast the server write timeout
+	disableTimeouts(w)
+	report := BulkReport{Action: req.Action, DryRun: req.DryRun}
+	report.Results = append(runBulkAction(req, targets), missing...)
+	report.Total = len(report.Results)
+	for _, result := range report.Results {
+		switch result.Status {
+		case "ok":
+			report.Succeeded++
+		case "skipped":
+			report.Skipped++
+		case "error", "not_found", "not_matched":
+			report.Failed++
+		}
+	}
+
+	// Dry runs are audited too, like prune previews
+	outcome := "success"
+	if report.Failed > 0 {
+		outcome = "partial"
+		if report.Succeeded == 0 {
+			outcome = "failure"
+		}
+	}
+	report.AuditID = recordAudit(r.Header.Get("X-User"), "containers.bulk."+req.Action, bulkAuditTarget(req, targets), outcome, map[string]interface{}{
+		"dry_run":   req.DryRun,
+		"ids":       req.IDs,
+		"labels":    req.Labels,
+		"succeeded": report.Succeeded,
+		"failed":    report.Failed,
+		"skipped":   report.Skipped,
+		"results":   report.Results,
+	})
+
+	logrus.WithFields(logrus.Fields{
+		"action":    req.Action,
+		"dry_run":   req.DryRun,
+		"succeeded": report.Succeeded,
+		"failed":    report.Failed,
+	}).Info("Bulk container action finished")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
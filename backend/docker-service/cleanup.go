@@ -0,0 +1,791 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cleanupTickInterval is how often the scheduler looks for due policies
+	cleanupTickInterval = 30 * time.Second
+	// cleanupRunTimeout bounds a single scheduled run
+	cleanupRunTimeout = 2 * time.Hour
+	// cleanupRunHistory is how many runs are kept per policy
+	cleanupRunHistory = 100
+	// tagRetentionType keeps the newest tags of each repository and removes the rest
+	tagRetentionType = "image-tags"
+)
+
+// tagRetentionFilters lists the filters of image-tags policies
+var tagRetentionFilters = []string{"keep_last", "repository", "until", "label!"}
+
+var errPolicyRunning = errors.New("policy is already running")
+
+var runningPolicies = newRunGuard()
+
+// CleanupPolicy is a stored prune that runs on a schedule
+type CleanupPolicy struct {
+	ID          int64               `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Type        string              `json:"type"`
+	Filters     map[string][]string `json:"filters"`
+	Schedule    string              `json:"schedule"`
+	Enabled     bool                `json:"enabled"`
+	CreatedBy   string              `json:"created_by"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+	LastRunAt   *time.Time          `json:"last_run_at,omitempty"`
+	NextRunAt   *time.Time          `json:"next_run_at,omitempty"`
+}
+
+// CleanupRun is one run or dry-run preview of a policy
+type CleanupRun struct {
+	ID             int64       `json:"id"`
+	PolicyID       int64       `json:"policy_id"`
+	Trigger        string      `json:"trigger"`
+	DryRun         bool        `json:"dry_run"`
+	Status         string      `json:"status"`
+	Count          int         `json:"count"`
+	SpaceReclaimed int64       `json:"space_reclaimed"`
+	Items          []PruneItem `json:"items"`
+	Error          string      `json:"error,omitempty"`
+	TriggeredBy    string      `json:"triggered_by"`
+	StartedAt      time.Time   `json:"started_at"`
+	FinishedAt     *time.Time  `json:"finished_at,omitempty"`
+}
+
+// policyFilters are the filters of a policy. Values may be given as a
+// string, number or boolean as well as a list.
+type policyFilters map[string][]string
+
+func (f *policyFilters) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*f = make(policyFilters, len(raw))
+	for key, value := range raw {
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			switch v := v.(type) {
+			case string:
+				(*f)[key] = append((*f)[key], v)
+			case float64:
+				(*f)[key] = append((*f)[key], strconv.FormatFloat(v, 'f', -1, 64))
+			case bool:
+				(*f)[key] = append((*f)[key], strconv.FormatBool(v))
+			default:
+				return fmt.Errorf("filter '%s' must be a string, number, boolean or a list of them", key)
+			}
+		}
+	}
+	return nil
+}
+
+// CleanupPolicyRequest creates or updates a policy
+type CleanupPolicyRequest struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Type        string        `json:"type"`
+	Filters     policyFilters `json:"filters"`
+	Schedule    string        `json:"schedule"`
+	Enabled     *bool         `json:"enabled"`
+}
+
+// validate checks a policy request and parses its schedule
+func (req *CleanupPolicyRequest) validate() (Schedule, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	req.Schedule = strings.TrimSpace(req.Schedule)
+	if req.Name == "" {
+		return nil, fmt.Errorf("field 'name' is required")
+	}
+	if req.Filters == nil {
+		req.Filters = policyFilters{}
+	}
+	if err := validatePolicyFilters(req.Type, req.Filters); err != nil {
+		return nil, err
+	}
+	schedule, err := parseSchedule(req.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %v", err)
+	}
+	return schedule, nil
+}
+
+// validatePolicyFilters checks the type of a policy and that its filters
+// apply to it
+func validatePolicyFilters(policyType string, filters map[string][]string) error {
+	allowed, ok := pruneFilters[policyType]
+	if policyType == tagRetentionType {
+		allowed, ok = tagRetentionFilters, true
+	}
+	if !ok {
+		return fmt.Errorf("unknown policy type '%s', use containers, images, volumes, networks, build-cache or %s", policyType, tagRetentionType)
+	}
+	for name := range filters {
+		known := false
+		for _, filter := range allowed {
+			known = known || filter == name
+		}
+		if !known {
+			return fmt.Errorf("filter '%s' is not supported by %s policies, use %s", name, policyType, strings.Join(allowed, ", "))
+		}
+	}
+
+	if policyType == tagRetentionType {
+		_, err := parseTagRetention(filters, time.Now())
+		return err
+	}
+	_, err := parsePruneQuery(policyType, url.Values(filters))
+	return err
+}
+
+// tagRetention holds the parsed filters of an image-tags policy
+type tagRetention struct {
+	keepLast     int
+	repositories []string
+	until        time.Time
+	excluded     []string
+}
+
+// parseTagRetention reads the filters of an image-tags policy. keep_last is
+// required; repository takes glob patterns such as "registry.local/app/*".
+func parseTagRetention(filters map[string][]string, now time.Time) (*tagRetention, error) {
+	tr := &tagRetention{repositories: filters["repository"], excluded: filters["label!"]}
+
+	values := filters["keep_last"]
+	if len(values) == 0 {
+		return nil, fmt.Errorf("filter 'keep_last' is required for %s policies", tagRetentionType)
+	}
+	n, err := strconv.Atoi(values[0])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("filter 'keep_last' must be a positive number")
+	}
+	tr.keepLast = n
+
+	for _, pattern := range tr.repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid repository pattern %q", pattern)
+		}
+	}
+	if values := filters["until"]; len(values) > 0 {
+		if tr.until, err = parseUntil(values[0], now); err != nil {
+			return nil, err
+		}
+	}
+	return tr, nil
+}
+
+// matchesRepository reports whether a repository is covered by the policy
+func (tr *tagRetention) matchesRepository(repository string) bool {
+	if len(tr.repositories) == 0 {
+		return true
+	}
+	for _, pattern := range tr.repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tagCandidates lists the tags an image-tags policy would remove: all but
+// the keep_last newest tags of each repository, by image creation time.
+// Tags of images used by containers are never removed, but still count
+// towards the tags that are kept.
+func tagCandidates(ctx context.Context, tr *tagRetention) ([]PruneItem, error) {
+	resp, err := engineRequest(ctx, http.MethodGet, "/images/json", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var images []struct {
+		ID       string            `json:"Id"`
+		RepoTags []string          `json:"RepoTags"`
+		Created  int64             `json:"Created"`
+		Size     int64             `json:"Size"`
+		Labels   map[string]string `json:"Labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&images); err != nil {
+		return nil, err
+	}
+	usage, err := containersByImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type repoTag struct {
+		tag   string
+		image int
+	}
+	byRepository := make(map[string][]repoTag)
+	for i, image := range images {
+		for _, tag := range image.RepoTags {
+			if tag == "<none>:<none>" {
+				continue
+			}
+			repository, _ := splitImageReference(tag)
+			if tr.matchesRepository(repository) {
+				byRepository[repository] = append(byRepository[repository], repoTag{tag: tag, image: i})
+			}
+		}
+	}
+
+	repositories := make([]string, 0, len(byRepository))
+	for repository := range byRepository {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+
+	removed := make(map[int]int)
+	var candidates []repoTag
+	for _, repository := range repositories {
+		tags := byRepository[repository]
+		sort.Slice(tags, func(i, j int) bool {
+			a, b := images[tags[i].image], images[tags[j].image]
+			if a.Created != b.Created {
+				return a.Created > b.Created
+			}
+			return tags[i].tag > tags[j].tag
+		})
+		for _, tag := range tags[min(tr.keepLast, len(tags)):] {
+			image := images[tag.image]
+			if len(usage[image.ID]) > 0 || excludedByLabels(image.Labels, tr.excluded) {
+				continue
+			}
+			if !tr.until.IsZero() && !time.Unix(image.Created, 0).Before(tr.until) {
+				continue
+			}
+			candidates = append(candidates, tag)
+			removed[tag.image]++
+		}
+	}
+
+	// Removing a tag only frees space when it is the image's last one
+	items := []PruneItem{}
+	for _, tag := range candidates {
+		image := images[tag.image]
+		item := PruneItem{ID: image.ID, Name: tag.tag}
+		if removed[tag.image] == len(image.RepoTags) {
+			item.Size = image.Size
+			removed[tag.image] = -1
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// runTagRetention removes the tags an image-tags policy selects, or lists
+// them when dryRun is set. Tags that fail to be removed are skipped and
+// reported together.
+func runTagRetention(ctx context.Context, policy *CleanupPolicy, dryRun bool) (*PruneReport, error) {
+	tr, err := parseTagRetention(policy.Filters, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := tagCandidates(ctx, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PruneReport{Type: tagRetentionType, DryRun: dryRun, Filters: policy.Filters, Items: []PruneItem{}}
+	var failures []string
+	for _, item := range candidates {
+		if !dryRun {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err := dockerRemoveImage(item.Name, false); err != nil {
+				failures = append(failures, item.Name+": "+err.Error())
+				continue
+			}
+		}
+		report.Items = append(report.Items, item)
+		report.SpaceReclaimed += item.Size
+	}
+	report.Count = len(report.Items)
+
+	if len(failures) > 0 {
+		return report, fmt.Errorf("failed to remove %d tag(s): %s", len(failures), strings.Join(failures, "; "))
+	}
+	return report, nil
+}
+
+// executePolicy runs the prune a policy describes. A failed image-tags run
+// may still return the tags it removed.
+func executePolicy(ctx context.Context, policy *CleanupPolicy, dryRun bool, user string) (*PruneReport, error) {
+	if policy.Type == tagRetentionType {
+		return runTagRetention(ctx, policy, dryRun)
+	}
+	pq, err := parsePruneQuery(policy.Type, url.Values(policy.Filters))
+	if err != nil {
+		return nil, err
+	}
+	return runPrune(ctx, policy.Type, pq, dryRun, user)
+}
+
+// runPolicy runs a policy, or previews it when dryRun is set, and records
+// the run in its history. Only one run of a policy happens at a time.
+func runPolicy(ctx context.Context, policy *CleanupPolicy, trigger string, dryRun bool, user string) (*CleanupRun, error) {
+	if !runningPolicies.acquire(policy.ID) {
+		return nil, errPolicyRunning
+	}
+	defer runningPolicies.release(policy.ID)
+
+	run := &CleanupRun{
+		PolicyID:    policy.ID,
+		Trigger:     trigger,
+		DryRun:      dryRun,
+		Status:      "running",
+		Items:       []PruneItem{},
+		TriggeredBy: user,
+		StartedAt:   time.Now(),
+	}
+	result, err := db.Exec(`INSERT INTO cleanup_runs (policy_id, run_trigger, dry_run, status, triggered_by, started_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		run.PolicyID, run.Trigger, run.DryRun, run.Status, run.TriggeredBy, run.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	run.ID, _ = result.LastInsertId()
+
+	log := logrus.WithFields(logrus.Fields{"policy": policy.Name, "run": run.ID, "trigger": trigger, "dry_run": dryRun})
+	log.Info("Cleanup policy started")
+
+	report, runErr := executePolicy(ctx, policy, dryRun, user)
+	if report != nil {
+		run.Items = report.Items
+		run.Count = report.Count
+		run.SpaceReclaimed = report.SpaceReclaimed
+	}
+	run.Status = "succeeded"
+	outcome := "success"
+	if runErr != nil {
+		run.Status = "failed"
+		run.Error = runErr.Error()
+		outcome = "failure"
+	}
+	finished := time.Now()
+	run.FinishedAt = &finished
+
+	items, _ := json.Marshal(run.Items)
+	if _, err := db.Exec(`UPDATE cleanup_runs SET status = ?, count = ?, space_reclaimed = ?, items = ?, error = ?, finished_at = ? WHERE id = ?`,
+		run.Status, run.Count, run.SpaceReclaimed, string(items), run.Error, finished, run.ID); err != nil {
+		log.WithError(err).Error("Failed to record cleanup run")
+	}
+	if !dryRun {
+		if _, err := db.Exec(`UPDATE cleanup_policies SET last_run_at = ? WHERE id = ?`, run.StartedAt, policy.ID); err != nil {
+			log.WithError(err).Error("Failed to update cleanup policy")
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM cleanup_runs WHERE policy_id = ? AND id NOT IN (SELECT id FROM cleanup_runs WHERE policy_id = ? ORDER BY id DESC LIMIT ?)`,
+		policy.ID, policy.ID, cleanupRunHistory); err != nil {
+		log.WithError(err).Warn("Failed to trim cleanup run history")
+	}
+
+	recordAudit(user, "cleanup.run", policy.Name, outcome, map[string]interface{}{
+		"policy_id":       policy.ID,
+		"run_id":          run.ID,
+		"trigger":         trigger,
+		"dry_run":         dryRun,
+		"count":           run.Count,
+		"space_reclaimed": run.SpaceReclaimed,
+		"error":           run.Error,
+	})
+	if runErr != nil {
+		log.WithError(runErr).Error("Cleanup policy failed")
+	} else {
+		log.WithFields(logrus.Fields{"count": run.Count, "bytes": run.SpaceReclaimed}).Info("Cleanup policy finished")
+	}
+	return run, nil
+}
+
+// nextRunTime returns when an enabled policy next runs after now
+func nextRunTime(spec string, enabled bool, now time.Time) *time.Time {
+	if !enabled {
+		return nil
+	}
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		return nil
+	}
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// cleanupScheduler runs enabled policies when their schedule is due
+var cleanupScheduler = &dueRunner{
+	kind:     "cleanup policy",
+	table:    "cleanup_policies",
+	interval: cleanupTickInterval,
+	timeout:  cleanupRunTimeout,
+	load: func() ([]scheduledItem, error) {
+		policies, err := loadCleanupPolicies()
+		if err != nil {
+			return nil, err
+		}
+		items := make([]scheduledItem, 0, len(policies))
+		for i := range policies {
+			policy := &policies[i]
+			items = append(items, scheduledItem{
+				ID: policy.ID, Name: policy.Name, Schedule: policy.Schedule, Enabled: policy.Enabled, NextRunAt: policy.NextRunAt,
+				run: func(ctx context.Context) error {
+					_, err := runPolicy(ctx, policy, "schedule", false, "scheduler")
+					return err
+				},
+			})
+		}
+		return items, nil
+	},
+}
+
+// startCleanupScheduler runs due policies in the background
+func startCleanupScheduler() {
+	cleanupScheduler.start()
+}
+
+const cleanupPolicyColumns = `id, name, description, type, filters, schedule, enabled, created_by, created_at, updated_at, last_run_at, next_run_at`
+
+func scanCleanupPolicy(row interface{ Scan(...interface{}) error }) (*CleanupPolicy, error) {
+	var policy CleanupPolicy
+	var filters string
+	var lastRun, nextRun sql.NullTime
+	if err := row.Scan(&policy.ID, &policy.Name, &policy.Description, &policy.Type, &filters, &policy.Schedule,
+		&policy.Enabled, &policy.CreatedBy, &policy.CreatedAt, &policy.UpdatedAt, &lastRun, &nextRun); err != nil {
+		return nil, err
+	}
+	policy.Filters = map[string][]string{}
+	json.Unmarshal([]byte(filters), &policy.Filters)
+	if lastRun.Valid {
+		policy.LastRunAt = &lastRun.Time
+	}
+	if nextRun.Valid {
+		policy.NextRunAt = &nextRun.Time
+	}
+	return &policy, nil
+}
+
+// loadCleanupPolicies returns all stored policies
+func loadCleanupPolicies() ([]CleanupPolicy, error) {
+	rows, err := db.Query(`SELECT ` + cleanupPolicyColumns + ` FROM cleanup_policies ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []CleanupPolicy{}
+	for rows.Next() {
+		policy, err := scanCleanupPolicy(rows)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to scan cleanup policy row")
+			continue
+		}
+		policies = append(policies, *policy)
+	}
+	return policies, rows.Err()
+}
+
+// loadCleanupPolicy returns a stored policy by ID
+func loadCleanupPolicy(id int64) (*CleanupPolicy, error) {
+	return scanCleanupPolicy(db.QueryRow(`SELECT `+cleanupPolicyColumns+` FROM cleanup_policies WHERE id = ?`, id))
+}
+
+// cleanupPolicyFromRequest loads the policy named by the route, writing an
+// error response if there is none
+func cleanupPolicyFromRequest(w http.ResponseWriter, r *http.Request) (*CleanupPolicy, bool) {
+	if !requireDatabase(w) {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid policy ID")
+		return nil, false
+	}
+	policy, err := loadCleanupPolicy(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Cleanup policy not found")
+		return nil, false
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load cleanup policy", err)
+		return nil, false
+	}
+	return policy, true
+}
+
+// listCleanupPolicies returns the stored policies
+func listCleanupPolicies(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	policies, err := loadCleanupPolicies()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load cleanup policies")
+		writeFailure(w, "Failed to load cleanup policies", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policies)
+}
+
+// getCleanupPolicy returns one stored policy
+func getCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, ok := cleanupPolicyFromRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// createCleanupPolicy stores a policy and schedules its first run
+func createCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	var req CleanupPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if _, err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	now := time.Now()
+	filters, _ := json.Marshal(req.Filters)
+	result, err := db.Exec(`INSERT INTO cleanup_policies (name, description, type, filters, schedule, enabled, created_by, created_at, updated_at, next_run_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.Description, req.Type, string(filters), req.Schedule, enabled, r.Header.Get("X-User"), now, now, nextRunTime(req.Schedule, enabled, now))
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A cleanup policy with this name already exists")
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to store cleanup policy")
+		writeFailure(w, "Failed to store cleanup policy", err)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	recordAudit(r.Header.Get("X-User"), "cleanup.policies.create", req.Name, "success", map[string]interface{}{
+		"type":     req.Type,
+		"filters":  req.Filters,
+		"schedule": req.Schedule,
+		"enabled":  enabled,
+	})
+	logrus.WithFields(logrus.Fields{"policy": req.Name, "type": req.Type, "schedule": req.Schedule}).Info("Cleanup policy created")
+
+	policy, err := loadCleanupPolicy(id)
+	if err != nil {
+		writeFailure(w, "Failed to load cleanup policy", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(policy)
+}
+
+// updateCleanupPolicy changes a policy. Omitted fields keep their values and
+// the next run is recomputed from now.
+func updateCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	existing, ok := cleanupPolicyFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	req := CleanupPolicyRequest{
+		Name:        existing.Name,
+		Description: existing.Description,
+		Type:        existing.Type,
+		Filters:     existing.Filters,
+		Schedule:    existing.Schedule,
+		Enabled:     &existing.Enabled,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if _, err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	now := time.Now()
+	filters, _ := json.Marshal(req.Filters)
+	_, err := db.Exec(`UPDATE cleanup_policies SET name = ?, description = ?, type = ?, filters = ?, schedule = ?, enabled = ?, updated_at = ?, next_run_at = ? WHERE id = ?`,
+		req.Name, req.Description, req.Type, string(filters), req.Schedule, enabled, now, nextRunTime(req.Schedule, enabled, now), existing.ID)
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A cleanup policy with this name already exists")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to update cleanup policy", err)
+		return
+	}
+
+	recordAudit(r.Header.Get("X-User"), "cleanup.policies.update", req.Name, "success", map[string]interface{}{
+		"type":     req.Type,
+		"filters":  req.Filters,
+		"schedule": req.Schedule,
+		"enabled":  enabled,
+	})
+
+	policy, err := loadCleanupPolicy(existing.ID)
+	if err != nil {
+		writeFailure(w, "Failed to load cleanup policy", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// deleteCleanupPolicy removes a policy and its run history
+func deleteCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, ok := cleanupPolicyFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM cleanup_policies WHERE id = ?`, policy.ID); err != nil {
+		writeFailure(w, "Failed to delete cleanup policy", err)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM cleanup_runs WHERE policy_id = ?`, policy.ID); err != nil {
+		logrus.WithError(err).WithField("policy", policy.Name).Warn("Failed to delete cleanup run history")
+	}
+
+	recordAudit(r.Header.Get("X-User"), "cleanup.policies.delete", policy.Name, "success", map[string]interface{}{"type": policy.Type})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Cleanup policy deleted successfully"})
+}
+
+// writePolicyRun runs or previews a policy for a request
+func writePolicyRun(w http.ResponseWriter, r *http.Request, trigger string, dryRun bool) {
+	policy, ok := cleanupPolicyFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	disableTimeouts(w)
+	run, err := runPolicy(r.Context(), policy, trigger, dryRun, r.Header.Get("X-User"))
+	if errors.Is(err, errPolicyRunning) {
+		writeError(w, http.StatusConflict, "Cleanup policy is already running")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to run cleanup policy", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+// runCleanupPolicy runs a policy now, outside its schedule
+func runCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	writePolicyRun(w, r, "manual", false)
+}
+
+// previewCleanupPolicy lists what a policy would remove now. Previews are
+// kept in the run history.
+func previewCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	writePolicyRun(w, r, "preview", true)
+}
+
+// previewCleanupPolicyDraft lists what an unsaved policy would remove
+func previewCleanupPolicyDraft(w http.ResponseWriter, r *http.Request) {
+	var req CleanupPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.Name == "" {
+		req.Name = "preview"
+	}
+	if req.Schedule == "" {
+		req.Schedule = "@daily"
+	}
+	if _, err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	disableTimeouts(w)
+	policy := &CleanupPolicy{Name: req.Name, Type: req.Type, Filters: req.Filters}
+	report, err := executePolicy(r.Context(), policy, true, r.Header.Get("X-User"))
+	if err != nil {
+		writeFailure(w, "Failed to preview cleanup policy", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// listCleanupRuns returns the run history of a policy, newest first
+func listCleanupRuns(w http.ResponseWriter, r *http.Request) {
+	policy, ok := cleanupPolicyFromRequest(w, r)
+	if !ok {
+		return
+	}
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= cleanupRunHistory {
+			limit = n
+		}
+	}
+
+	rows, err := db.Query(`SELECT id, policy_id, run_trigger, dry_run, status, count, space_reclaimed, items, error, triggered_by, started_at, finished_at
+		FROM cleanup_runs WHERE policy_id = ? ORDER BY id DESC LIMIT ?`, policy.ID, limit)
+	if err != nil {
+		writeFailure(w, "Failed to load cleanup runs", err)
+		return
+	}
+	defer rows.Close()
+
+	runs := []CleanupRun{}
+	for rows.Next() {
+		var run CleanupRun
+		var items string
+		var finished sql.NullTime
+		if err := rows.Scan(&run.ID, &run.PolicyID, &run.Trigger, &run.DryRun, &run.Status, &run.Count, &run.SpaceReclaimed,
+			&items, &run.Error, &run.TriggeredBy, &run.StartedAt, &finished); err != nil {
+			logrus.WithError(err).Error("Failed to scan cleanup run row")
+			continue
+		}
+		run.Items = []PruneItem{}
+		json.Unmarshal([]byte(items), &run.Items)
+		if finished.Valid {
+			run.FinishedAt = &finished.Time
+		}
+		runs = append(runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// CloneContainerRequest describes how a container should be duplicated
+type CloneContainerRequest struct {
+	Name      string          `json:"name"`
+	Start     *bool           `json:"start,omitempty"`
+	Overrides json.RawMessage `json:"overrides,omitempty"`
+}
+
+// defaultShmSize is the size docker gives /dev/shm when --shm-size is not set
+const defaultShmSize = 64 * 1024 * 1024
+
+// containerRunSpec rebuilds the run request that produces a container's
+// effective configuration. Settings inherited from the image are left out so
+// the result only carries what was set explicitly at run time. It also
+// returns the settings a run request cannot reproduce.
+func containerRunSpec(containerID string) (*RunContainerRequest, []string, error) {
+	container, err := dockerInspectContainer(containerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	image, err := dockerInspectImageTyped(container.Image)
+	if err != nil {
+		// The image may have been removed; fall back to exporting everything
+		logrus.WithError(err).WithField("container", containerID).Warn("Failed to inspect container image")
+		image = &ImageInspect{}
+	}
+
+	spec, unsupported := runSpecFromInspect(container, image, daemonLogDriver())
+	return spec, unsupported, nil
+}
+
+// daemonLogDriver returns the log driver containers get by default
+func daemonLogDriver() string {
+	output, err := executeDockerCommand("system", "info", "--format", "{{.LoggingDriver}}")
+	if err != nil || strings.TrimSpace(string(output)) == "" {
+		return "json-file"
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// runSpecFromInspect converts inspect data into a run request. Settings that
+// have no run request equivalent are returned as descriptions.
+func runSpecFromInspect(container *ContainerInspect, image *ImageInspect, defaultLogDriver string) (*RunContainerRequest, []string) {
+	cfg := container.Config
+	host := container.HostConfig
+
+	spec := &RunContainerRequest{
+		Image:      cfg.Image,
+		Name:       strings.TrimPrefix(container.Name, "/"),
+		Privileged: host.Privileged,
+		ReadOnly:   host.ReadonlyRootfs,
+		AutoRemove: host.AutoRemove,
+		Tty:        cfg.Tty,
+		CapAdd:     host.CapAdd,
+		CapDrop:    host.CapDrop,
+		DNS:        host.DNS,
+		ExtraHosts: host.ExtraHosts,
+		Memory:     host.Memory,
+	}
+
+	if cfg.OpenStdin {
+		spec.Interactive = true
+	}
+	if host.NanoCPUs > 0 {
+		spec.CPUs = float64(host.NanoCPUs) / 1e9
+	}
+
+	// Ports
+	spec.Ports = mappingsFromBindings(host.PortBindings)
+
+	// Environment, dropping variables identical to the image defaults
+	imageEnv := make(map[string]bool, len(image.Config.Env))
+	for _, env := range image.Config.Env {
+		imageEnv[env] = true
+	}
+	for _, env := range cfg.Env {
+		if !imageEnv[env] {
+			spec.Environment = append(spec.Environment, env)
+		}
+	}
+
+	var unsupported []string
+
+	// Volumes: explicit binds and mounts, named volumes at paths the image
+	// declares, and anonymous volumes not declared by the image
+	spec.Volumes = append(spec.Volumes, host.Binds...)
+	bound := make(map[string]bool)
+	for _, bind := range host.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) >= 2 {
+			bound[parts[1]] = true
+		}
+	}
+	for _, mount := range host.Mounts {
+		bound[mount.Target] = true
+		if mount.Type == "tmpfs" {
+			spec.Tmpfs = append(spec.Tmpfs, tmpfsFromMount(mount))
+			continue
+		}
+		volume, err := volumeFromMount(mount)
+		if err != nil {
+			unsupported = append(unsupported, err.Error())
+			continue
+		}
+		spec.Volumes = append(spec.Volumes, volume)
+	}
+	for _, mount := range container.Mounts {
+		if mount.Type != "volume" || bound[mount.Destination] || hexVolumeName.MatchString(mount.Name) {
+			continue
+		}
+		volume := mount.Name + ":" + mount.Destination
+		if !mount.RW {
+			volume += ":ro"
+		}
+		spec.Volumes = append(spec.Volumes, volume)
+		bound[mount.Destination] = true
+	}
+	anonymous := []string{}
+	for destination := range cfg.Volumes {
+		if _, declared := image.Config.Volumes[destination]; declared || bound[destination] {
+			continue
+		}
+		anonymous = append(anonymous, destination)
+	}
+	sort.Strings(anonymous)
+	spec.Volumes = append(spec.Volumes, anonymous...)
+
+	tmpfsPaths := make([]string, 0, len(host.Tmpfs))
+	for destination := range host.Tmpfs {
+		tmpfsPaths = append(tmpfsPaths, destination)
+	}
+	sort.Strings(tmpfsPaths)
+	for _, destination := range tmpfsPaths {
+		if options := host.Tmpfs[destination]; options != "" {
+			destination += ":" + options
+		}
+		spec.Tmpfs = append(spec.Tmpfs, destination)
+	}
+
+	for _, device := range host.Devices {
+		mapping := device.PathOnHost + ":" + device.PathInContainer
+		if device.CgroupPermissions != "" && device.CgroupPermissions != "rwm" {
+			mapping += ":" + device.CgroupPermissions
+		}
+		spec.Devices = append(spec.Devices, mapping)
+	}
+	for _, ulimit := range host.Ulimits {
+		spec.Ulimits = append(spec.Ulimits, fmt.Sprintf("%s=%d:%d", ulimit.Name, ulimit.Soft, ulimit.Hard))
+	}
+	if host.ShmSize > 0 && host.ShmSize != defaultShmSize {
+		spec.ShmSize = host.ShmSize
+	}
+	if host.LogConfig.Type != "" && (host.LogConfig.Type != defaultLogDriver || len(host.LogConfig.Config) > 0) {
+		spec.LogDriver = host.LogConfig.Type
+		if len(host.LogConfig.Config) > 0 {
+			spec.LogOptions = host.LogConfig.Config
+		}
+	}
+
+	// Published ports are exposed implicitly
+	for port := range cfg.ExposedPorts {
+		if _, declared := image.Config.ExposedPorts[port]; declared {
+			continue
+		}
+		if _, published := host.PortBindings[port]; published {
+			continue
+		}
+		spec.Expose = append(spec.Expose, port)
+	}
+	sort.Strings(spec.Expose)
+
+	// Labels, dropping those inherited from the image
+	for key, value := range cfg.Labels {
+		if imageValue, ok := image.Config.Labels[key]; ok && imageValue == value {
+			continue
+		}
+		if strings.HasPrefix(key, "com.docker.compose.") {
+			continue
+		}
+		if spec.Labels == nil {
+			spec.Labels = map[string]string{}
+		}
+		spec.Labels[key] = value
+	}
+
+	if !stringSlicesEqual(cfg.Entrypoint, image.Config.Entrypoint) {
+		spec.Entrypoint = cfg.Entrypoint
+	}
+	if !stringSlicesEqual(cfg.Cmd, image.Config.Cmd) {
+		spec.Command = cfg.Cmd
+	}
+	if cfg.WorkingDir != image.Config.WorkingDir {
+		spec.WorkingDir = cfg.WorkingDir
+	}
+	if cfg.User != image.Config.User {
+		spec.User = cfg.User
+	}
+	if cfg.StopSignal != "" && cfg.StopSignal != image.Config.StopSignal {
+		spec.StopSignal = cfg.StopSignal
+	}
+
+	// The engine defaults the hostname to the short container ID
+	if cfg.Hostname != "" && !strings.HasPrefix(container.ID, cfg.Hostname) {
+		spec.Hostname = cfg.Hostname
+	}
+
+	switch host.NetworkMode {
+	case "", "default", "bridge":
+	default:
+		spec.Network = host.NetworkMode
+	}
+
+	switch host.RestartPolicy.Name {
+	case "", "no":
+	case "on-failure":
+		spec.RestartPolicy = "on-failure"
+		if host.RestartPolicy.MaximumRetryCount > 0 {
+			spec.RestartPolicy += ":" + strconv.Itoa(host.RestartPolicy.MaximumRetryCount)
+		}
+	default:
+		spec.RestartPolicy = host.RestartPolicy.Name
+	}
+
+	if hc := cfg.Healthcheck; hc != nil && len(hc.Test) > 0 {
+		imageHC := image.Config.Healthcheck
+		if imageHC == nil || !stringSlicesEqual(hc.Test, imageHC.Test) {
+			switch hc.Test[0] {
+			case "NONE":
+				spec.NoHealthcheck = true
+			case "CMD-SHELL":
+				spec.HealthCmd = strings.Join(hc.Test[1:], " ")
+			case "CMD":
+				spec.HealthCmd = joinShellArgs(hc.Test[1:])
+			}
+			if hc.Interval > 0 {
+				spec.HealthInterval = time.Duration(hc.Interval).String()
+			}
+			if hc.Timeout > 0 {
+				spec.HealthTimeout = time.Duration(hc.Timeout).String()
+			}
+			spec.HealthRetries = hc.Retries
+		}
+	}
+
+	return spec, unsupported
+}
+
+// volumeFromMount converts a bind or volume mount into a --volume value
+func volumeFromMount(mount HostMount) (string, error) {
+	if mount.Type != "bind" && mount.Type != "volume" {
+		return "", fmt.Errorf("%s mount at %s", mount.Type, mount.Target)
+	}
+	var options []string
+	if mount.ReadOnly {
+		options = append(options, "ro")
+	}
+	if opts := mount.BindOptions; opts != nil {
+		if opts.NonRecursive {
+			return "", fmt.Errorf("non-recursive bind mount at %s", mount.Target)
+		}
+		if opts.Propagation != "" {
+			options = append(options, opts.Propagation)
+		}
+	}
+	if opts := mount.VolumeOptions; opts != nil {
+		if opts.Subpath != "" {
+			return "", fmt.Errorf("volume subpath mount at %s", mount.Target)
+		}
+		if opts.DriverConfig != nil && opts.DriverConfig.Name != "" && mount.Source == "" {
+			return "", fmt.Errorf("anonymous %s volume at %s", opts.DriverConfig.Name, mount.Target)
+		}
+		if opts.NoCopy {
+			options = append(options, "nocopy")
+		}
+	}
+	if mount.Source == "" && len(options) > 0 {
+		return "", fmt.Errorf("anonymous volume with options at %s", mount.Target)
+	}
+
+	volume := mount.Target
+	if mount.Source != "" {
+		volume = mount.Source + ":" + mount.Target
+	}
+	if len(options) > 0 {
+		volume += ":" + strings.Join(options, ",")
+	}
+	return volume, nil
+}
+
+// tmpfsFromMount converts a tmpfs mount into a --tmpfs value
+func tmpfsFromMount(mount HostMount) string {
+	var options []string
+	if mount.ReadOnly {
+		options = append(options, "ro")
+	}
+	if opts := mount.TmpfsOptions; opts != nil {
+		if opts.SizeBytes > 0 {
+			options = append(options, "size="+strconv.FormatInt(opts.SizeBytes, 10))
+		}
+		if opts.Mode != 0 {
+			// The engine reports Go file modes, whose special bits differ from octal ones
+			mode := os.FileMode(opts.Mode)
+			octal := uint64(mode.Perm())
+			if mode&os.ModeSetuid != 0 {
+				octal |= 0o4000
+			}
+			if mode&os.ModeSetgid != 0 {
+				octal |= 0o2000
+			}
+			if mode&os.ModeSticky != 0 {
+				octal |= 0o1000
+			}
+			options = append(options, "mode="+strconv.FormatUint(octal, 8))
+		}
+	}
+	if len(options) == 0 {
+		return mount.Target
+	}
+	return mount.Target + ":" + strings.Join(options, ",")
+}
+
+// renderDockerRun renders a run request as an equivalent `docker run` command line
+func renderDockerRun(spec *RunContainerRequest) string {
+	args := append([]string{"docker", "run", "-d"}, buildRunArgs(*spec)...)
+	return joinShellArgs(args)
+}
+
+// renderComposeService renders a run request as a Compose file with a single service
+func renderComposeService(spec *RunContainerRequest) string {
+	name := spec.Name
+	if name == "" {
+		name = "app"
+	}
+
+	var b strings.Builder
+	b.WriteString("services:\n")
+	b.WriteString("  " + yamlQuote(name) + ":\n")
+	writeYAMLScalar(&b, "image", spec.Image)
+	if spec.Name != "" {
+		writeYAMLScalar(&b, "container_name", spec.Name)
+	}
+	if spec.Hostname != "" {
+		writeYAMLScalar(&b, "hostname", spec.Hostname)
+	}
+	if spec.User != "" {
+		writeYAMLScalar(&b, "user", spec.User)
+	}
+	if spec.WorkingDir != "" {
+		writeYAMLScalar(&b, "working_dir", spec.WorkingDir)
+	}
+	if len(spec.Entrypoint) > 0 {
+		writeYAMLList(&b, "entrypoint", spec.Entrypoint)
+	}
+	if len(spec.Command) > 0 {
+		writeYAMLList(&b, "command", spec.Command)
+	}
+	if spec.RestartPolicy != "" {
+		writeYAMLScalar(&b, "restart", spec.RestartPolicy)
+	}
+	if spec.Network != "" {
+		if spec.Network == "host" || spec.Network == "none" || strings.HasPrefix(spec.Network, "container:") {
+			writeYAMLScalar(&b, "network_mode", spec.Network)
+		} else {
+			writeYAMLList(&b, "networks", []string{spec.Network})
+		}
+	}
+	if len(spec.Ports) > 0 {
+		ports := make([]string, 0, len(spec.Ports))
+		for _, mapping := range spec.Ports {
+			ports = append(ports, mapping.String())
+		}
+		writeYAMLList(&b, "ports", ports)
+	}
+	if len(spec.Environment) > 0 {
+		writeYAMLList(&b, "environment", spec.Environment)
+	}
+	if len(spec.Volumes) > 0 {
+		writeYAMLList(&b, "volumes", spec.Volumes)
+	}
+	if len(spec.Labels) > 0 {
+		keys := make([]string, 0, len(spec.Labels))
+		for key := range spec.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteString("    labels:\n")
+		for _, key := range keys {
+			b.WriteString("      " + yamlQuote(key) + ": " + yamlQuote(spec.Labels[key]) + "\n")
+		}
+	}
+	if spec.Privileged {
+		b.WriteString("    privileged: true\n")
+	}
+	if spec.ReadOnly {
+		b.WriteString("    read_only: true\n")
+	}
+	if spec.Interactive {
+		b.WriteString("    stdin_open: true\n")
+	}
+	if spec.Tty {
+		b.WriteString("    tty: true\n")
+	}
+	if len(spec.CapAdd) > 0 {
+		writeYAMLList(&b, "cap_add", spec.CapAdd)
+	}
+	if len(spec.CapDrop) > 0 {
+		writeYAMLList(&b, "cap_drop", spec.CapDrop)
+	}
+	if len(spec.DNS) > 0 {
+		writeYAMLList(&b, "dns", spec.DNS)
+	}
+	if len(spec.ExtraHosts) > 0 {
+		writeYAMLList(&b, "extra_hosts", spec.ExtraHosts)
+	}
+	if spec.Memory > 0 {
+		writeYAMLScalar(&b, "mem_limit", strconv.FormatInt(spec.Memory, 10))
+	}
+	if spec.CPUs > 0 {
+		b.WriteString("    cpus: " + strconv.FormatFloat(spec.CPUs, 'f', -1, 64) + "\n")
+	}
+	if spec.StopSignal != "" {
+		writeYAMLScalar(&b, "stop_signal", spec.StopSignal)
+	}
+	if len(spec.Tmpfs) > 0 {
+		writeYAMLList(&b, "tmpfs", spec.Tmpfs)
+	}
+	if len(spec.Devices) > 0 {
+		writeYAMLList(&b, "devices", spec.Devices)
+	}
+	if len(spec.Expose) > 0 {
+		writeYAMLList(&b, "expose", spec.Expose)
+	}
+	if spec.ShmSize > 0 {
+		writeYAMLScalar(&b, "shm_size", strconv.FormatInt(spec.ShmSize, 10))
+	}
+	if len(spec.Ulimits) > 0 {
+		b.WriteString("    ulimits:\n")
+		for _, ulimit := range spec.Ulimits {
+			name, limits, _ := strings.Cut(ulimit, "=")
+			soft, hard, ok := strings.Cut(limits, ":")
+			if !ok {
+				hard = soft
+			}
+			b.WriteString("      " + yamlQuote(name) + ":\n")
+			b.WriteString("        soft: " + soft + "\n")
+			b.WriteString("        hard: " + hard + "\n")
+		}
+	}
+	if spec.LogDriver != "" || len(spec.LogOptions) > 0 {
+		b.WriteString("    logging:\n")
+		if spec.LogDriver != "" {
+			b.WriteString("      driver: " + yamlQuote(spec.LogDriver) + "\n")
+		}
+		if len(spec.LogOptions) > 0 {
+			keys := make([]string, 0, len(spec.LogOptions))
+			for key := range spec.LogOptions {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			b.WriteString("      options:\n")
+			for _, key := range keys {
+				b.WriteString("        " + yamlQuote(key) + ": " + yamlQuote(spec.LogOptions[key]) + "\n")
+			}
+		}
+	}
+	if spec.NoHealthcheck {
+		b.WriteString("    healthcheck:\n")
+		b.WriteString("      disable: true\n")
+	} else if spec.HealthCmd != "" {
+		// A string test would run through a shell as a whole, prefix included
+		b.WriteString("    healthcheck:\n")
+		b.WriteString("      test:\n")
+		b.WriteString("        - CMD-SHELL\n")
+		b.WriteString("        - " + yamlQuote(spec.HealthCmd) + "\n")
+		if spec.HealthInterval != "" {
+			b.WriteString("      interval: " + yamlQuote(spec.HealthInterval) + "\n")
+		}
+		if spec.HealthTimeout != "" {
+			b.WriteString("      timeout: " + yamlQuote(spec.HealthTimeout) + "\n")
+		}
+		if spec.HealthRetries > 0 {
+			b.WriteString("      retries: " + strconv.Itoa(spec.HealthRetries) + "\n")
+		}
+	}
+	if spec.Network != "" && spec.Network != "host" && spec.Network != "none" && !strings.HasPrefix(spec.Network, "container:") {
+		b.WriteString("networks:\n")
+		b.WriteString("  " + yamlQuote(spec.Network) + ":\n")
+		b.WriteString("    external: true\n")
+	}
+
+	return b.String()
+}
+
+func writeYAMLScalar(b *strings.Builder, key, value string) {
+	b.WriteString("    " + key + ": " + yamlQuote(value) + "\n")
+}
+
+func writeYAMLList(b *strings.Builder, key string, values []string) {
+	b.WriteString("    " + key + ":\n")
+	for _, value := range values {
+		b.WriteString("      - " + yamlQuote(value) + "\n")
+	}
+}
+
+// yamlQuote quotes a scalar when YAML would otherwise misread it
+func yamlQuote(value string) string {
+	if value == "" {
+		return `""`
+	}
+	needsQuote := strings.ContainsAny(value, ":#{}[],&*!|>'\"%@`\\\n\t") ||
+		strings.TrimSpace(value) != value ||
+		strings.HasPrefix(value, "-") || strings.HasPrefix(value, "?")
+	if !needsQuote {
+		switch strings.ToLower(value) {
+		case "true", "false", "yes", "no", "on", "off", "null", "~", "y", "n":
+			needsQuote = true
+		}
+	}
+	if !needsQuote {
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			needsQuote = true
+		}
+	}
+	if !needsQuote {
+		return value
+	}
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
+}
+
+// shellQuote quotes an argument for a POSIX shell
+func shellQuote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range arg {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func joinShellArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeUnreproducible refuses to clone or export a container whose
+// configuration would lose the listed settings
+func writeUnreproducible(w http.ResponseWriter, unsupported []string) {
+	writeErrorDetails(w, http.StatusConflict, codeConflict,
+		"The container has settings that cannot be reproduced: "+strings.Join(unsupported, ", "),
+		map[string]interface{}{"unsupported": unsupported})
+}
+
+// cloneContainer duplicates a container's configuration under a new name
+func cloneContainer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req CloneContainerRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	spec, unsupported, err := containerRunSpec(id)
+	if err != nil {
+		logrus.WithError(err).WithField("container", id).Error("Failed to read container configuration")
+		writeFailure(w, "Failed to read container configuration", err)
+		return
+	}
+	if len(unsupported) > 0 {
+		writeUnreproducible(w, unsupported)
+		return
+	}
+	source := spec.Name
+
+	// Overrides are applied on top of the source configuration; maps are merged
+	// and every other field present in the overrides replaces the original
+	if len(req.Overrides) > 0 {
+		if err := json.Unmarshal(req.Overrides, spec); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid overrides: "+err.Error())
+			return
+		}
+	}
+
+	spec.Name = req.Name
+	if spec.Name == "" {
+		spec.Name = fmt.Sprintf("%s-clone-%d", source, time.Now().Unix())
+	}
+	if spec.Name == source {
+		writeError(w, http.StatusBadRequest, "Clone name must differ from the source container")
+		return
+	}
+
+	start := req.Start == nil || *req.Start
+	var containerID string
+	if start {
+		if !preflightPorts(w, spec.Ports) {
+			return
+		}
+		containerID, err = dockerRun(*spec)
+	} else {
+		containerID, err = dockerCreate(*spec)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("container", id).Error("Failed to clone container")
+		writeFailure(w, "Failed to clone container", err)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"source":    id,
+		"container": containerID,
+		"name":      spec.Name,
+	}).Info("Container cloned")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Container cloned successfully",
+		"containerID": containerID,
+		"name":        spec.Name,
+		"started":     start,
+		"spec":        spec,
+	})
+}
+
+// exportContainerConfig renders a container's configuration as run, compose or json
+func exportContainerConfig(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	format := vars["format"]
+
+	spec, unsupported, err := containerRunSpec(id)
+	if err != nil {
+		logrus.WithError(err).WithField("container", id).Error("Failed to read container configuration")
+		writeFailure(w, "Failed to read container configuration", err)
+		return
+	}
+	if len(unsupported) > 0 {
+		writeUnreproducible(w, unsupported)
+		return
+	}
+
+	switch format {
+	case "run":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(renderDockerRun(spec) + "\n"))
+	case "compose":
+		w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", spec.Name+".compose.yaml"))
+		w.Write([]byte(renderComposeService(spec)))
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(spec)
+	default:
+		writeError(w, http.StatusBadRequest, "Unsupported export format, use run, compose or json")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// commitInstructions are the Dockerfile instructions `docker commit --change` accepts
+var commitInstructions = map[string]bool{
+	"CMD":         true,
+	"ENTRYPOINT":  true,
+	"ENV":         true,
+	"EXPOSE":      true,
+	"HEALTHCHECK": true,
+	"LABEL":       true,
+	"ONBUILD":     true,
+	"STOPSIGNAL":  true,
+	"USER":        true,
+	"VOLUME":      true,
+	"WORKDIR":     true,
+}
+
+// CommitContainerRequest describes the image created from a container
+type CommitContainerRequest struct {
+	Repository string            `json:"repository"`
+	Tag        string            `json:"tag,omitempty"`
+	Author     string            `json:"author,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Pause      *bool             `json:"pause,omitempty"`
+	Changes    []string          `json:"changes,omitempty"`
+	Cmd        []string          `json:"cmd,omitempty"`
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Env        []string          `json:"env,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Expose     []string          `json:"expose,omitempty"`
+	User       string            `json:"user,omitempty"`
+	WorkingDir string            `json:"working_dir,omitempty"`
+}
+
+// ExportContainerRequest controls the filesystem export of a container
+type ExportContainerRequest struct {
+	Gzip bool `json:"gzip,omitempty"`
+}
+
+// commitChanges converts the request into `--change` instructions
+func (req CommitContainerRequest) commitChanges() ([]string, error) {
+	changes := []string{}
+	for _, change := range req.Changes {
+		change = strings.TrimSpace(change)
+		instruction := strings.ToUpper(strings.Fields(change + " ")[0])
+		if !commitInstructions[instruction] {
+			return nil, fmt.Errorf("unsupported change instruction %q", instruction)
+		}
+		changes = append(changes, change)
+	}
+
+	if len(req.Cmd) > 0 {
+		cmd, _ := json.Marshal(req.Cmd)
+		changes = append(changes, "CMD "+string(cmd))
+	}
+	if len(req.Entrypoint) > 0 {
+		entrypoint, _ := json.Marshal(req.Entrypoint)
+		changes = append(changes, "ENTRYPOINT "+string(entrypoint))
+	}
+	for _, env := range req.Env {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid environment variable %q", env)
+		}
+		quoted, _ := json.Marshal(value)
+		changes = append(changes, "ENV "+key+"="+string(quoted))
+	}
+	labelKeys := make([]string, 0, len(req.Labels))
+	for key := range req.Labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	for _, key := range labelKeys {
+		quotedKey, _ := json.Marshal(key)
+		quotedValue, _ := json.Marshal(req.Labels[key])
+		changes = append(changes, "LABEL "+string(quotedKey)+"="+string(quotedValue))
+	}
+	for _, port := range req.Expose {
+		changes = append(changes, "EXPOSE "+port)
+	}
+	if req.User != "" {
+		changes = append(changes, "USER "+req.User)
+	}
+	if req.WorkingDir != "" {
+		changes = append(changes, "WORKDIR "+req.WorkingDir)
+	}
+
+	return changes, nil
+}
+
+// dockerCommit creates an image from a container's changes
+func dockerCommit(ctx context.Context, containerID, reference string, req CommitContainerRequest, changes []string) (string, error) {
+	args := []string{"commit"}
+	if req.Author != "" {
+		args = append(args, "--author", req.Author)
+	}
+	if req.Message != "" {
+		args = append(args, "--message", req.Message)
+	}
+	if req.Pause != nil && !*req.Pause {
+		args = append(args, "--pause=false")
+	}
+	for _, change := range changes {
+		args = append(args, "--change", change)
+	}
+	args = append(args, containerID)
+	if reference != "" {
+		args = append(args, reference)
+	}
+
+	proc, err := startDockerCommand(ctx, nil, args...)
+	if err != nil {
+		return "", err
+	}
+	output, _ := io.ReadAll(proc.Stdout)
+	if err := proc.Wait(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// commitContainer starts a job that commits a container to an image
+func commitContainer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req CommitContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	changes, err := req.commitChanges()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reference := req.Repository
+	if reference != "" && req.Tag != "" {
+		reference += ":" + req.Tag
+	}
+
+	job := startJob("commit", id, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		job.SetProgress(0, 0, "Committing container")
+		started := time.Now()
+		imageID, err := dockerCommit(ctx, id, reference, req, changes)
+		if err != nil {
+			return nil, err
+		}
+		job.SetProgress(1, 1, "Image created")
+		logrus.WithFields(logrus.Fields{"container": id, "image": imageID, "reference": reference}).Info("Container committed")
+		return map[string]interface{}{
+			"image_id":  imageID,
+			"reference": reference,
+			"changes":   changes,
+			"duration":  time.Since(started).String(),
+		}, nil
+	})
+
+	writeJobAccepted(w, job)
+}
+
+// exportContainerFilesystem starts a job that exports a container's
+// flattened filesystem as a tarball
+func exportContainerFilesystem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req ExportContainerRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	container, err := dockerInspectContainer(id)
+	if err != nil {
+		logrus.WithError(err).WithField("container", id).Error("Failed to inspect container")
+		writeFailure(w, "Failed to inspect container", err)
+		return
+	}
+	name := strings.TrimPrefix(container.Name, "/")
+
+	job := startJob("export", id, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		// The root filesystem size is the best estimate of the archive size
+		_, total, err := containerSizes(id)
+		if err != nil {
+			total = 0
+		}
+
+		filename := name + ".tar"
+		if req.Gzip {
+			filename += ".gz"
+		}
+		file, err := job.CreateArtifact(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		proc, err := startDockerCommand(ctx, nil, "export", id)
+		if err != nil {
+			return nil, err
+		}
+
+		var out io.Writer = file
+		var gz *gzip.Writer
+		if req.Gzip {
+			gz = gzip.NewWriter(file)
+			out = gz
+		}
+		progress := &progressWriter{job: job, total: total, message: "Exporting filesystem"}
+		written, copyErr := io.Copy(io.MultiWriter(out, progress), proc.Stdout)
+		if err := proc.Wait(); err != nil {
+			return nil, err
+		}
+		if copyErr != nil {
+			return nil, copyErr
+		}
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				return nil, err
+			}
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		job.SetProgress(written, written, "Export complete")
+		return map[string]interface{}{
+			"filename":      filename,
+			"size":          info.Size(),
+			"uncompressed":  written,
+			"download_path": "/jobs/" + job.ID + "/download",
+		}, nil
+	})
+
+	writeJobAccepted(w, job)
+}
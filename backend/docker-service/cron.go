@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes when something next runs
+type Schedule interface {
+	// Next returns the first activation strictly after t, or the zero time
+	// if there is none
+	Next(t time.Time) time.Time
+}
+
+// cronSchedule is a standard five-field cron expression. Each field is a
+// bit set of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, a restricted day of month and day of week match if either does
+	domRestricted, dowRestricted bool
+}
+
+// everySchedule runs at a fixed interval
+type everySchedule struct {
+	interval time.Duration
+}
+
+// minEveryInterval keeps @every schedules from spinning the scheduler
+const minEveryInterval = time.Minute
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@nightly":  "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// cronField describes the range and names of one field
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	{name: "day of week", min: 0, max: 7, names: dayNames},
+}
+
+// parseSchedule parses a cron expression ("0 3 * * *"), a macro such as
+// @daily or @nightly, or "@every <duration>"
+func parseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("schedule is empty")
+	}
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid @every interval: %v", err)
+		}
+		if interval < minEveryInterval {
+			return nil, fmt.Errorf("@every interval must be at least %s", minEveryInterval)
+		}
+		return everySchedule{interval: interval}, nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := cronMacros[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule macro %s", spec)
+		}
+		spec = expanded
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression must have 5 fields (minute hour day-of-month month day-of-week), got %d", len(parts))
+	}
+	bits := make([]uint64, len(parts))
+	for i, part := range parts {
+		set, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = set
+	}
+
+	// Sunday may be written as 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &cronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dom:           bits[2],
+		month:         bits[3],
+		dow:           bits[4],
+		domRestricted: parts[2] != "*" && parts[2] != "?",
+		dowRestricted: parts[4] != "*" && parts[4] != "?",
+	}, nil
+}
+
+// parseCronField parses a comma-separated list of *, values, ranges and
+// steps such as "*/15", "1-5" or "mon-fri"
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, spec.name)
+			}
+			step = n
+		}
+
+		low, high := spec.min, spec.max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = cronValue(from, spec); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(to, spec); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangePart, spec.name)
+			}
+		default:
+			value, err := cronValue(rangePart, spec)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			if !hasStep {
+				high = value
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// cronValue parses one number or name of a field
+func cronValue(value string, spec cronField) (int, error) {
+	if n, ok := spec.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < spec.min || n > spec.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, expected %d-%d", value, spec.name, spec.min, spec.max)
+	}
+	return n, nil
+}
+
+// Next finds the next matching minute after t in t's location. It gives up
+// after five years, which only happens for dates like February 30th.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns t plus the interval
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval).Truncate(time.Second)
+}
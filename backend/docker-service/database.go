@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
@@ -13,16 +14,18 @@ import (
 
 var db *sql.DB
 
+// dataDirectory holds the database and other persistent files
+const dataDirectory = "./data"
+
 // initDatabase initializes the SQLite database
 func initDatabase() error {
 	// Create data directory if it doesn't exist
-	dataDir := "./data"
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(dataDirectory, 0755); err != nil {
 		return err
 	}
 
 	// Open database
-	dbPath := filepath.Join(dataDir, "dockmaster.db")
+	dbPath := filepath.Join(dataDirectory, "dockmaster.db")
 	var err error
 	db, err = sql.Open("sqlite3", dbPath)
 	if err != nil {
@@ -68,15 +71,266 @@ func createTables() error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	// Audit log of operations performed through the API
+	auditTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		outcome TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '{}',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Registry endpoints; passwords are encrypted with the registry secret key
+	registriesTable := `
+	CREATE TABLE IF NOT EXISTS registries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		host TEXT NOT NULL UNIQUE,
+		username TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Image builds with their output
+	buildsTable := `
+	CREATE TABLE IF NOT EXISTS builds (
+		id TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		tags TEXT NOT NULL DEFAULT '[]',
+		options TEXT NOT NULL DEFAULT '{}',
+		image_id TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		log TEXT NOT NULL DEFAULT '',
+		log_truncated INTEGER NOT NULL DEFAULT 0,
+		created_by TEXT NOT NULL DEFAULT '',
+		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME
+	);`
+
+	// Cleanup policies run on a schedule by the cleanup scheduler
+	cleanupPoliciesTable := `
+	CREATE TABLE IF NOT EXISTS cleanup_policies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL,
+		filters TEXT NOT NULL DEFAULT '{}',
+		schedule TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_run_at DATETIME,
+		next_run_at DATETIME
+	);`
+
+	// Runs and dry-run previews of cleanup policies
+	cleanupRunsTable := `
+	CREATE TABLE IF NOT EXISTS cleanup_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		policy_id INTEGER NOT NULL,
+		run_trigger TEXT NOT NULL,
+		dry_run INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		space_reclaimed INTEGER NOT NULL DEFAULT 0,
+		items TEXT NOT NULL DEFAULT '[]',
+		error TEXT NOT NULL DEFAULT '',
+		triggered_by TEXT NOT NULL DEFAULT '',
+		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS cleanup_runs_policy ON cleanup_runs (policy_id, id);`
+
+	// Notifications shown in the UI and sent to the notification webhook
+	notificationsTable := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		title TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		data TEXT NOT NULL DEFAULT '{}',
+		read INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Last update check of every local image tag
+	imageUpdatesTable := `
+	CREATE TABLE IF NOT EXISTS image_updates (
+		reference TEXT PRIMARY KEY,
+		image_id TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		local_digest TEXT NOT NULL DEFAULT '',
+		remote_digest TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		notified_digest TEXT NOT NULL DEFAULT '',
+		checked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Automatic and manual container update attempts
+	containerUpdatesTable := `
+	CREATE TABLE IF NOT EXISTS container_updates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		container_name TEXT NOT NULL,
+		container_id TEXT NOT NULL DEFAULT '',
+		new_container_id TEXT NOT NULL DEFAULT '',
+		image TEXT NOT NULL,
+		old_image_id TEXT NOT NULL DEFAULT '',
+		new_image_id TEXT NOT NULL DEFAULT '',
+		run_trigger TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		triggered_by TEXT NOT NULL DEFAULT '',
+		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME
+	);`
+
+	// Vulnerabilities imported from OSV files, one row per affected package
+	vulnerabilitiesTable := `
+	CREATE TABLE IF NOT EXISTS vulnerabilities (
+		vuln_id TEXT NOT NULL,
+		ecosystem TEXT NOT NULL,
+		package TEXT NOT NULL,
+		aliases TEXT NOT NULL DEFAULT '[]',
+		summary TEXT NOT NULL DEFAULT '',
+		severity TEXT NOT NULL DEFAULT 'unknown',
+		score REAL NOT NULL DEFAULT 0,
+		affected TEXT NOT NULL DEFAULT '{}',
+		modified TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (vuln_id, ecosystem, package)
+	);
+	CREATE INDEX IF NOT EXISTS vulnerabilities_package ON vulnerabilities (package, ecosystem);`
+
+	// Vulnerability database imports
+	vulnerabilityImportsTable := `
+	CREATE TABLE IF NOT EXISTS vulnerability_imports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		entries INTEGER NOT NULL DEFAULT 0,
+		records INTEGER NOT NULL DEFAULT 0,
+		replaced INTEGER NOT NULL DEFAULT 0,
+		imported_by TEXT NOT NULL DEFAULT '',
+		imported_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Volume backups stored on a backup target, 0 being the backup directory
+	volumeBackupsTable := `
+	CREATE TABLE IF NOT EXISTS volume_backups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		volume TEXT NOT NULL,
+		target_id INTEGER NOT NULL DEFAULT 0,
+		schedule_id INTEGER NOT NULL DEFAULT 0,
+		filename TEXT NOT NULL,
+		size INTEGER NOT NULL DEFAULT 0,
+		sha256 TEXT NOT NULL DEFAULT '',
+		files INTEGER NOT NULL DEFAULT 0,
+		stopped_containers TEXT NOT NULL DEFAULT '[]',
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		verified_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS volume_backups_volume ON volume_backups (volume);`
+
+	// Local directories and S3 buckets that hold volume backups
+	backupTargetsTable := `
+	CREATE TABLE IF NOT EXISTS backup_targets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		type TEXT NOT NULL,
+		path TEXT NOT NULL DEFAULT '',
+		endpoint TEXT NOT NULL DEFAULT '',
+		region TEXT NOT NULL DEFAULT '',
+		bucket TEXT NOT NULL DEFAULT '',
+		prefix TEXT NOT NULL DEFAULT '',
+		access_key TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Scheduled volume backups with their retention policy
+	backupSchedulesTable := `
+	CREATE TABLE IF NOT EXISTS backup_schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		volumes TEXT NOT NULL DEFAULT '[]',
+		labels TEXT NOT NULL DEFAULT '[]',
+		schedule TEXT NOT NULL,
+		target_id INTEGER NOT NULL DEFAULT 0,
+		stop INTEGER NOT NULL DEFAULT 0,
+		keep_last INTEGER NOT NULL DEFAULT 0,
+		keep_daily INTEGER NOT NULL DEFAULT 0,
+		keep_weekly INTEGER NOT NULL DEFAULT 0,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_run_at DATETIME,
+		next_run_at DATETIME
+	);`
+
+	// History of backup schedule runs
+	backupRunsTable := `
+	CREATE TABLE IF NOT EXISTS backup_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		schedule_id INTEGER NOT NULL,
+		run_trigger TEXT NOT NULL,
+		status TEXT NOT NULL,
+		results TEXT NOT NULL DEFAULT '[]',
+		pruned INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		triggered_by TEXT NOT NULL DEFAULT '',
+		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME
+	);`
+
 	// Execute table creation
-	if _, err := db.Exec(usersTable); err != nil {
-		return err
+	for _, table := range []string{usersTable, settingsTable, auditTable, registriesTable, buildsTable, cleanupPoliciesTable, cleanupRunsTable,
+		notificationsTable, imageUpdatesTable, containerUpdatesTable, vulnerabilitiesTable, vulnerabilityImportsTable, volumeBackupsTable,
+		backupTargetsTable, backupSchedulesTable, backupRunsTable} {
+		if _, err := db.Exec(table); err != nil {
+			return err
+		}
 	}
 
-	if _, err := db.Exec(settingsTable); err != nil {
+	// Columns added to tables that may exist from an earlier version
+	return addMissingColumns("volume_backups", [][2]string{
+		{"target_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"schedule_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"verified_at", "DATETIME"},
+	})
+}
+
+// addMissingColumns adds the given name and definition pairs to a table
+// that lacks them
+func addMissingColumns(table string, columns [][2]string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
 		return err
 	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
 
+	for _, column := range columns {
+		if existing[column[0]] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column[0], column[1])); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// maxSizedChanges bounds how many changed files are sized per request
+const maxSizedChanges = 500
+
+var psArgsPattern = regexp.MustCompile(`^[A-Za-z0-9,=%_\- ]*$`)
+
+// ProcessList is the process table of a container
+type ProcessList struct {
+	Titles    []string            `json:"titles"`
+	Processes []map[string]string `json:"processes"`
+}
+
+// FileChange is a single entry of a container's filesystem diff
+type FileChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	Size *int64 `json:"size,omitempty"`
+}
+
+// ChangeNode is a directory tree node built from a filesystem diff
+type ChangeNode struct {
+	Name     string        `json:"name"`
+	Path     string        `json:"path"`
+	Kind     string        `json:"kind"`
+	Size     int64         `json:"size"`
+	Children []*ChangeNode `json:"children,omitempty"`
+}
+
+// ChangeTotals summarizes a filesystem diff
+type ChangeTotals struct {
+	Added         int   `json:"added"`
+	Modified      int   `json:"modified"`
+	Deleted       int   `json:"deleted"`
+	AddedBytes    int64 `json:"added_bytes"`
+	ModifiedBytes int64 `json:"modified_bytes"`
+	SizeRw        int64 `json:"size_rw"`
+	SizeRootFs    int64 `json:"size_root_fs"`
+	Sized         bool  `json:"sized"`
+	Truncated     bool  `json:"truncated"`
+}
+
+// ContainerChanges is the filesystem diff of a container against its image
+type ContainerChanges struct {
+	Changes []FileChange `json:"changes"`
+	Tree    *ChangeNode  `json:"tree"`
+	Totals  ChangeTotals `json:"totals"`
+}
+
+// dockerTop lists the processes running in a container
+func dockerTop(containerID string, psArgs string) (*ProcessList, error) {
+	args := []string{"top", containerID}
+	args = append(args, strings.Fields(psArgs)...)
+
+	output, err := executeDockerCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &ProcessList{Processes: []map[string]string{}}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if list.Titles == nil {
+			list.Titles = strings.Fields(line)
+			continue
+		}
+
+		// The last column (usually the command) may contain spaces
+		fields := strings.Fields(line)
+		if len(fields) > len(list.Titles) {
+			last := len(list.Titles) - 1
+			fields = append(fields[:last], strings.Join(fields[last:], " "))
+		}
+		process := make(map[string]string, len(list.Titles))
+		for i, title := range list.Titles {
+			if i < len(fields) {
+				process[title] = fields[i]
+			}
+		}
+		list.Processes = append(list.Processes, process)
+	}
+
+	return list, nil
+}
+
+// dockerDiff lists the filesystem changes of a container
+func dockerDiff(containerID string) ([]FileChange, error) {
+	output, err := executeDockerCommand("diff", containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []FileChange{}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 3 {
+			continue
+		}
+		var kind string
+		switch line[0] {
+		case 'A':
+			kind = "added"
+		case 'C':
+			kind = "modified"
+		case 'D':
+			kind = "deleted"
+		default:
+			continue
+		}
+		changes = append(changes, FileChange{Path: line[2:], Kind: kind})
+	}
+
+	return changes, nil
+}
+
+// containerSizes returns the writable layer and total root filesystem sizes
+func containerSizes(containerID string) (int64, int64, error) {
+	output, err := executeDockerCommand("container", "inspect", "--size", "--format", "{{json .}}", containerID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var sizes struct {
+		SizeRw     int64 `json:"SizeRw"`
+		SizeRootFs int64 `json:"SizeRootFs"`
+	}
+	if err := json.Unmarshal(output, &sizes); err != nil {
+		return 0, 0, err
+	}
+	return sizes.SizeRw, sizes.SizeRootFs, nil
+}
+
+// sizeChangedFiles measures added and modified leaf entries of a diff.
+// Running containers are measured with a single stat call; stopped ones by
+// reading the archive headers of each path.
+func sizeChangedFiles(ctx context.Context, containerID string, running bool, paths []string) map[string]int64 {
+	sizes := make(map[string]int64, len(paths))
+
+	if running {
+		for start := 0; start < len(paths); start += 100 {
+			end := start + 100
+			if end > len(paths) {
+				end = len(paths)
+			}
+			args := append([]string{"exec", containerID, "stat", "-c", "%s|%n", "--"}, paths[start:end]...)
+			proc, err := startDockerCommand(ctx, nil, args...)
+			if err != nil {
+				break
+			}
+			scanner := bufio.NewScanner(proc.Stdout)
+			for scanner.Scan() {
+				size, name, ok := strings.Cut(scanner.Text(), "|")
+				if !ok {
+					continue
+				}
+				if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+					sizes[name] = n
+				}
+			}
+			// stat exits non-zero when any path has vanished; partial output is still useful
+			proc.Wait()
+		}
+		if len(sizes) > 0 {
+			return sizes
+		}
+	}
+
+	for _, p := range paths {
+		proc, err := startDockerCommand(ctx, nil, "cp", containerID+":"+p, "-")
+		if err != nil {
+			continue
+		}
+		var total int64
+		reader := tar.NewReader(proc.Stdout)
+		for {
+			header, err := reader.Next()
+			if err != nil {
+				break
+			}
+			if header.Typeflag == tar.TypeReg {
+				total += header.Size
+			}
+		}
+		io.Copy(io.Discard, proc.Stdout)
+		if proc.Wait() == nil {
+			sizes[p] = total
+		}
+	}
+
+	return sizes
+}
+
+// buildChangeTree arranges diff entries into a directory tree with
+// aggregated sizes
+func buildChangeTree(changes []FileChange) *ChangeNode {
+	root := &ChangeNode{Name: "/", Path: "/", Kind: "unchanged"}
+	nodes := map[string]*ChangeNode{"/": root}
+
+	var ensure func(p string) *ChangeNode
+	ensure = func(p string) *ChangeNode {
+		if node, ok := nodes[p]; ok {
+			return node
+		}
+		parent := ensure(path.Dir(p))
+		node := &ChangeNode{Name: path.Base(p), Path: p, Kind: "unchanged"}
+		parent.Children = append(parent.Children, node)
+		nodes[p] = node
+		return node
+	}
+
+	for _, change := range changes {
+		node := ensure(path.Clean(change.Path))
+		node.Kind = change.Kind
+		if change.Size != nil {
+			node.Size = *change.Size
+		}
+	}
+
+	var aggregate func(node *ChangeNode) int64
+	aggregate = func(node *ChangeNode) int64 {
+		if len(node.Children) == 0 {
+			return node.Size
+		}
+		sort.Slice(node.Children, func(i, j int) bool {
+			return node.Children[i].Name < node.Children[j].Name
+		})
+		var total int64
+		for _, child := range node.Children {
+			total += aggregate(child)
+		}
+		node.Size = total
+		return total
+	}
+	aggregate(root)
+
+	return root
+}
+
+// getContainerTop returns the process table of a container
+func getContainerTop(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	psArgs := r.URL.Query().Get("ps_args")
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		psArgs = "-o " + columns
+	}
+	if !psArgsPattern.MatchString(psArgs) {
+		writeError(w, http.StatusBadRequest, "Invalid ps arguments")
+		return
+	}
+
+	processes, err := dockerTop(id, psArgs)
+	if err != nil {
+		logrus.WithError(err).WithField("container", id).Error("Failed to list container processes")
+		writeFailure(w, "Failed to list container processes", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(processes)
+}
+
+// getContainerChanges returns the filesystem diff of a container against its image
+func getContainerChanges(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	withSizes := r.URL.Query().Get("sizes") != "false"
+
+	changes, err := dockerDiff(id)
+	if err != nil {
+		logrus.WithError(err).WithField("container", id).Error("Failed to get container changes")
+		writeFailure(w, "Failed to get container changes", err)
+		return
+	}
+
+	result := ContainerChanges{Changes: changes}
+	if sizeRw, sizeRootFs, err := containerSizes(id); err == nil {
+		result.Totals.SizeRw = sizeRw
+		result.Totals.SizeRootFs = sizeRootFs
+	} else {
+		logrus.WithError(err).WithField("container", id).Warn("Failed to get container sizes")
+	}
+
+	if withSizes {
+		// Only leaves are sized; directories are summed from their children
+		parents := make(map[string]bool, len(changes))
+		for _, change := range changes {
+			parents[path.Dir(path.Clean(change.Path))] = true
+		}
+		leaves := []string{}
+		for _, change := range changes {
+			if change.Kind != "deleted" && !parents[path.Clean(change.Path)] {
+				leaves = append(leaves, change.Path)
+			}
+		}
+		if len(leaves) > maxSizedChanges {
+			leaves = leaves[:maxSizedChanges]
+			result.Totals.Truncated = true
+		}
+
+		running := false
+		if container, err := dockerInspectContainer(id); err == nil {
+			running = container.State.Running
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		sizes := sizeChangedFiles(ctx, id, running, leaves)
+		cancel()
+		for i := range result.Changes {
+			if size, ok := sizes[result.Changes[i].Path]; ok {
+				size := size
+				result.Changes[i].Size = &size
+			}
+		}
+		result.Totals.Sized = true
+	}
+
+	for _, change := range result.Changes {
+		var size int64
+		if change.Size != nil {
+			size = *change.Size
+		}
+		switch change.Kind {
+		case "added":
+			result.Totals.Added++
+			result.Totals.AddedBytes += size
+		case "modified":
+			result.Totals.Modified++
+			result.Totals.ModifiedBytes += size
+		case "deleted":
+			result.Totals.Deleted++
+		}
+	}
+	result.Tree = buildChangeTree(result.Changes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -28,68 +34,32 @@ type DockerContainer struct {
 }
 
 // convertToFrontendFormat converts raw Docker container data to frontend format
-func convertToFrontendFormat(raw DockerContainer) map[string]interface{} {
+func convertToFrontendFormat(raw DockerContainer) ContainerSummary {
 	// Parse names
 	names := []string{}
 	if raw.Names != "" {
-		names = []string{raw.Names}
-	}
-
-	// Parse ports
-	ports := []map[string]interface{}{}
-	if raw.Ports != "" {
-		// Simple port parsing - in production you'd want more robust parsing
-		portParts := strings.Split(raw.Ports, ",")
-		for _, portStr := range portParts {
-			portStr = strings.TrimSpace(portStr)
-			if strings.Contains(portStr, "->") {
-				// Format: "0.0.0.0:8080->8080/tcp"
-				parts := strings.Split(portStr, "->")
-				if len(parts) == 2 {
-					publicPart := strings.TrimSpace(parts[0])
-					privatePart := strings.TrimSpace(parts[1])
-					
-					// Extract public port
-					if colonIdx := strings.LastIndex(publicPart, ":"); colonIdx != -1 {
-						publicPortStr := publicPart[colonIdx+1:]
-						if publicPort, err := strconv.Atoi(publicPortStr); err == nil {
-							// Extract private port and type
-							if slashIdx := strings.Index(privatePart, "/"); slashIdx != -1 {
-								privatePortStr := privatePart[:slashIdx]
-								portType := privatePart[slashIdx+1:]
-								if privatePort, err := strconv.Atoi(privatePortStr); err == nil {
-									ports = append(ports, map[string]interface{}{
-										"PublicPort":  publicPort,
-										"PrivatePort": privatePort,
-										"Type":        portType,
-									})
-								}
-							}
-						}
-					}
-				}
-			} else if strings.Contains(portStr, "/") {
-				// Format: "80/tcp"
-				parts := strings.Split(portStr, "/")
-				if len(parts) == 2 {
-					if privatePort, err := strconv.Atoi(parts[0]); err == nil {
-						ports = append(ports, map[string]interface{}{
-							"PrivatePort": privatePort,
-							"Type":        parts[1],
-						})
-					}
-				}
-			}
-		}
+		names = strings.Split(raw.Names, ",")
+	}
+
+	mounts := []string{}
+	if raw.Mounts != "" {
+		mounts = strings.Split(raw.Mounts, ",")
 	}
 
-	return map[string]interface{}{
-		"Id":     raw.ID,
-		"Names":  names,
-		"Image":  raw.Image,
-		"State":  raw.State,
-		"Status": raw.Status,
-		"Ports":  ports,
+	// Ports are filled in from inspect data by attachContainerPorts, since
+	// the Ports column of docker ps is only meant for display
+	return ContainerSummary{
+		ID:         raw.ID,
+		Names:      names,
+		Image:      raw.Image,
+		Command:    strings.Trim(raw.Command, `"`),
+		Created:    parseDockerTime(raw.Created),
+		State:      raw.State,
+		Status:     raw.Status,
+		Ports:      []PortSummary{},
+		PortGroups: []PortGroup{},
+		Labels:     parseLabelString(raw.Labels),
+		Mounts:     mounts,
 	}
 }
 
@@ -102,21 +72,16 @@ type DockerImage struct {
 	Size       string `json:"Size"`
 }
 
-// DockerVolume represents a Docker volume
-type DockerVolume struct {
-	Driver     string `json:"Driver"`
-	Name       string `json:"Name"`
-	Size       string `json:"Size"`
-	CreatedAt  string `json:"CreatedAt"`
-}
-
 // DockerNetwork represents a Docker network
 type DockerNetwork struct {
-	ID      string `json:"ID"`
-	Name    string `json:"Name"`
-	Driver  string `json:"Driver"`
-	Scope   string `json:"Scope"`
-	Created string `json:"CreatedAt"`
+	ID       string `json:"ID"`
+	Name     string `json:"Name"`
+	Driver   string `json:"Driver"`
+	Scope    string `json:"Scope"`
+	Created  string `json:"CreatedAt"`
+	IPv6     string `json:"IPv6"`
+	Internal string `json:"Internal"`
+	Labels   string `json:"Labels"`
 }
 
 // ContainerStats represents container statistics
@@ -145,166 +110,232 @@ type SystemInfo struct {
 // executeDockerCommand executes a docker command and returns the output
 func executeDockerCommand(args ...string) ([]byte, error) {
 	cmd := exec.Command("docker", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	output, err := cmd.Output()
 	if err != nil {
-		logrus.WithError(err).WithField("command", "docker "+strings.Join(args, " ")).Error("Docker command failed")
-		return nil, fmt.Errorf("docker command failed: %v", err)
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"command": "docker " + strings.Join(args, " "),
+			"stderr":  strings.TrimSpace(stderr.String()),
+		}).Error("Docker command failed")
+		return nil, newDockerError(args, err, stderr.String())
 	}
 	return output, nil
 }
 
-// getRealContainers gets actual containers from Docker
-func getRealContainers(all bool) ([]map[string]interface{}, error) {
+// newDockerError wraps a failed docker invocation with its stderr output
+func newDockerError(args []string, err error, stderr string) *DockerError {
+	dockerErr := &DockerError{
+		Command:  "docker " + strings.Join(args, " "),
+		ExitCode: -1,
+		Stderr:   strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(stderr), "Error response from daemon: ")),
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		dockerErr.ExitCode = exitErr.ExitCode()
+	} else if dockerErr.Stderr == "" {
+		dockerErr.Stderr = err.Error()
+	}
+	return dockerErr
+}
+
+// dockerProcess is a running docker command whose output is streamed
+type dockerProcess struct {
+	cmd      *exec.Cmd
+	args     []string
+	stderr   bytes.Buffer
+	waitOnce sync.Once
+	waitErr  error
+	Stdout   io.ReadCloser
+}
+
+// startDockerCommand starts a docker command and returns its stdout as a stream.
+// The command is killed when ctx is cancelled; Wait must be called once the
+// output has been consumed.
+func startDockerCommand(ctx context.Context, stdin io.Reader, args ...string) (*dockerProcess, error) {
+	proc := &dockerProcess{
+		cmd:  exec.CommandContext(ctx, "docker", args...),
+		args: args,
+	}
+	proc.cmd.Stdin = stdin
+	proc.cmd.Stderr = &proc.stderr
+
+	stdout, err := proc.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	proc.Stdout = stdout
+
+	if err := proc.cmd.Start(); err != nil {
+		return nil, newDockerError(args, err, "")
+	}
+	return proc, nil
+}
+
+// Wait waits for the command to exit and reports its stderr on failure.
+// It is safe to call more than once.
+func (p *dockerProcess) Wait() error {
+	p.waitOnce.Do(func() {
+		if err := p.cmd.Wait(); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"command": "docker " + strings.Join(p.args, " "),
+				"stderr":  strings.TrimSpace(p.stderr.String()),
+			}).Error("Docker command failed")
+			p.waitErr = newDockerError(p.args, err, p.stderr.String())
+		}
+	})
+	return p.waitErr
+}
+
+// getRealContainers gets actual containers from Docker. Filters are passed
+// to docker as --filter arguments.
+func getRealContainers(all bool, filters ...string) ([]ContainerSummary, error) {
 	args := []string{"ps", "--format", "json", "--no-trunc"}
 	if all {
 		args = append(args, "-a")
 	}
+	args = append(args, filters...)
 
 	output, err := executeDockerCommand(args...)
 	if err != nil {
 		return nil, err
 	}
 
-	var containers []map[string]interface{}
+	containers := []ContainerSummary{}
 	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	
+
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
 			continue
 		}
-		
+
 		var container DockerContainer
 		if err := json.Unmarshal([]byte(line), &container); err != nil {
 			logrus.WithError(err).WithField("line", line).Error("Failed to parse container JSON")
 			continue
 		}
-		
-		// Convert to frontend format
-		frontendContainer := convertToFrontendFormat(container)
-		containers = append(containers, frontendContainer)
+
+		containers = append(containers, convertToFrontendFormat(container))
 	}
+	attachContainerPorts(containers)
 
 	return containers, nil
 }
 
-// getRealImages gets actual images from Docker
-func getRealImages() ([]map[string]interface{}, error) {
-	output, err := executeDockerCommand("images", "--format", "json", "--no-trunc")
+// getRealImages gets actual images from Docker. docker images prints one row
+// per tag, so rows are grouped by image ID.
+func getRealImages(filters ...string) ([]ImageSummary, error) {
+	args := append([]string{"images", "--format", "json", "--no-trunc"}, filters...)
+	output, err := executeDockerCommand(args...)
 	if err != nil {
 		return nil, err
 	}
 
-	var images []map[string]interface{}
+	images := []ImageSummary{}
+	byID := make(map[string]int)
 	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	
+
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
 			continue
 		}
-		
+
 		var image DockerImage
 		if err := json.Unmarshal([]byte(line), &image); err != nil {
 			logrus.WithError(err).WithField("line", line).Error("Failed to parse image JSON")
 			continue
 		}
-		
-		// Convert to frontend format
+
 		repoTag := image.Repository + ":" + image.Tag
+		dangling := image.Repository == "<none>" && image.Tag == "<none>"
 		if image.Tag == "<none>" {
 			repoTag = "<none>:<none>"
 		}
-		
-		frontendImage := map[string]interface{}{
-			"Id":       image.ID,
-			"RepoTags": []string{repoTag},
-			"Created":  parseDockerTime(image.Created),
-			"Size":     parseDockerSize(image.Size),
+
+		if i, ok := byID[image.ID]; ok {
+			if !dangling && !containsString(images[i].RepoTags, repoTag) {
+				images[i].RepoTags = append(images[i].RepoTags, repoTag)
+			}
+			continue
 		}
-		images = append(images, frontendImage)
+
+		byID[image.ID] = len(images)
+		images = append(images, ImageSummary{
+			ID:       image.ID,
+			RepoTags: []string{repoTag},
+			Created:  parseDockerTime(image.Created),
+			Size:     parseDockerSize(image.Size),
+			Dangling: dangling,
+		})
 	}
 
 	return images, nil
 }
 
-// getRealVolumes gets actual volumes from Docker
-func getRealVolumes() ([]map[string]interface{}, error) {
-	output, err := executeDockerCommand("volume", "ls", "--format", "json")
+// getRealVolumes gets actual volumes from Docker. docker volume ls only
+// names them, so their details come from docker volume inspect.
+func getRealVolumes(filters ...string) ([]VolumeSummary, error) {
+	args := append([]string{"volume", "ls", "--format", "{{.Name}}"}, filters...)
+	output, err := executeDockerCommand(args...)
 	if err != nil {
 		return nil, err
 	}
 
-	var volumes []map[string]interface{}
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-		
-		var volume DockerVolume
-		if err := json.Unmarshal([]byte(line), &volume); err != nil {
-			logrus.WithError(err).WithField("line", line).Error("Failed to parse volume JSON")
-			continue
-		}
-		
-		// Convert to frontend format
-		frontendVolume := map[string]interface{}{
-			"Name":       volume.Name,
-			"Driver":     volume.Driver,
-			"Mountpoint": "/var/lib/docker/volumes/" + volume.Name + "/_data",
-			"CreatedAt":  volume.CreatedAt,
-			"Scope":      "local",
-			"Labels":     map[string]string{},
-			"Options":    map[string]string{},
+	var names []string
+	for _, name := range strings.Split(string(output), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
 		}
-		volumes = append(volumes, frontendVolume)
+	}
+	inspections, err := dockerInspectVolumes(names...)
+	if err != nil {
+		return nil, err
 	}
 
+	volumes := []VolumeSummary{}
+	for _, volume := range inspections {
+		volumes = append(volumes, volumeSummaryFromInspect(volume))
+	}
 	return volumes, nil
 }
 
 // getRealNetworks gets actual networks from Docker
-func getRealNetworks() ([]map[string]interface{}, error) {
-	output, err := executeDockerCommand("network", "ls", "--format", "json")
+func getRealNetworks(filters ...string) ([]NetworkSummary, error) {
+	args := append([]string{"network", "ls", "--format", "json", "--no-trunc"}, filters...)
+	output, err := executeDockerCommand(args...)
 	if err != nil {
 		return nil, err
 	}
 
-	var networks []map[string]interface{}
+	networks := []NetworkSummary{}
 	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	
+
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
 			continue
 		}
-		
+
 		var network DockerNetwork
 		if err := json.Unmarshal([]byte(line), &network); err != nil {
 			logrus.WithError(err).WithField("line", line).Error("Failed to parse network JSON")
 			continue
 		}
-		
-		// Convert to frontend format
-		frontendNetwork := map[string]interface{}{
-			"Id":         network.ID,
-			"Name":       network.Name,
-			"Created":    network.Created,
-			"Scope":      network.Scope,
-			"Driver":     network.Driver,
-			"EnableIPv6": false,
-			"Internal":   false,
-			"Attachable": false,
-			"Ingress":    false,
-			"ConfigOnly": false,
-			"Containers": map[string]interface{}{},
-			"Options":    map[string]string{},
-			"Labels":     map[string]string{},
-		}
-		networks = append(networks, frontendNetwork)
+
+		networks = append(networks, NetworkSummary{
+			ID:         network.ID,
+			Name:       network.Name,
+			Created:    network.Created,
+			Scope:      network.Scope,
+			Driver:     network.Driver,
+			EnableIPv6: network.IPv6 == "true",
+			Internal:   network.Internal == "true",
+			Containers: map[string]interface{}{},
+			Options:    map[string]string{},
+			Labels:     parseLabelString(network.Labels),
+		})
 	}
 
 	return networks, nil
@@ -550,6 +581,25 @@ func dockerRestart(containerID string) error {
 	return err
 }
 
+func dockerPause(containerID string) error {
+	_, err := executeDockerCommand("pause", containerID)
+	return err
+}
+
+func dockerUnpause(containerID string) error {
+	_, err := executeDockerCommand("unpause", containerID)
+	return err
+}
+
+func dockerKill(containerID string, signal string) error {
+	args := []string{"kill", containerID}
+	if signal != "" {
+		args = []string{"kill", "--signal", signal, containerID}
+	}
+	_, err := executeDockerCommand(args...)
+	return err
+}
+
 func dockerRemove(containerID string, force bool) error {
 	args := []string{"rm", containerID}
 	if force {
@@ -636,7 +686,40 @@ func dockerLogs(containerID string, tail string) ([]map[string]interface{}, erro
 
 // dockerRun creates and starts a new container
 func dockerRun(req RunContainerRequest) (string, error) {
-	args := []string{"run", "-d"}
+	if err := ensureImage(context.Background(), req.Image); err != nil {
+		return "", err
+	}
+
+	args := append([]string{"run", "-d"}, buildRunArgs(req)...)
+
+	output, err := executeDockerCommand(args...)
+	if err != nil {
+		return "", err
+	}
+	
+	// Return container ID
+	return strings.TrimSpace(string(output)), nil
+}
+
+// dockerCreate creates a new container without starting it
+func dockerCreate(req RunContainerRequest) (string, error) {
+	if err := ensureImage(context.Background(), req.Image); err != nil {
+		return "", err
+	}
+
+	args := append([]string{"create"}, buildRunArgs(req)...)
+
+	output, err := executeDockerCommand(args...)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// buildRunArgs converts a run request into `docker run`/`docker create` arguments
+func buildRunArgs(req RunContainerRequest) []string {
+	args := []string{}
 	
 	// Add name if provided
 	if req.Name != "" {
@@ -644,8 +727,8 @@ func dockerRun(req RunContainerRequest) (string, error) {
 	}
 	
 	// Add port mappings
-	for hostPort, containerPort := range req.Ports {
-		args = append(args, "-p", hostPort+":"+containerPort)
+	for _, mapping := range req.Ports {
+		args = append(args, "-p", mapping.String())
 	}
 	
 	// Add environment variables
@@ -657,6 +740,16 @@ func dockerRun(req RunContainerRequest) (string, error) {
 	for _, volume := range req.Volumes {
 		args = append(args, "-v", volume)
 	}
+
+	// Add labels
+	labelKeys := make([]string, 0, len(req.Labels))
+	for key := range req.Labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	for _, key := range labelKeys {
+		args = append(args, "--label", key+"="+req.Labels[key])
+	}
 	
 	// Add working directory
 	if req.WorkingDir != "" {
@@ -667,22 +760,111 @@ func dockerRun(req RunContainerRequest) (string, error) {
 	if req.RestartPolicy != "" {
 		args = append(args, "--restart", req.RestartPolicy)
 	}
+
+	// Add runtime options
+	if req.User != "" {
+		args = append(args, "--user", req.User)
+	}
+	if req.Hostname != "" {
+		args = append(args, "--hostname", req.Hostname)
+	}
+	if req.Network != "" {
+		args = append(args, "--network", req.Network)
+	}
+	if req.Privileged {
+		args = append(args, "--privileged")
+	}
+	if req.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	if req.AutoRemove {
+		args = append(args, "--rm")
+	}
+	if req.Interactive {
+		args = append(args, "-i")
+	}
+	if req.Tty {
+		args = append(args, "-t")
+	}
+	for _, capability := range req.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	for _, capability := range req.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	for _, dns := range req.DNS {
+		args = append(args, "--dns", dns)
+	}
+	for _, host := range req.ExtraHosts {
+		args = append(args, "--add-host", host)
+	}
+	if req.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(req.Memory, 10))
+	}
+	if req.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(req.CPUs, 'f', -1, 64))
+	}
+	if req.HealthCmd != "" {
+		args = append(args, "--health-cmd", req.HealthCmd)
+	}
+	if req.HealthInterval != "" {
+		args = append(args, "--health-interval", req.HealthInterval)
+	}
+	if req.HealthTimeout != "" {
+		args = append(args, "--health-timeout", req.HealthTimeout)
+	}
+	if req.HealthRetries > 0 {
+		args = append(args, "--health-retries", strconv.Itoa(req.HealthRetries))
+	}
+	if req.StopSignal != "" {
+		args = append(args, "--stop-signal", req.StopSignal)
+	}
+	if req.NoHealthcheck {
+		args = append(args, "--no-healthcheck")
+	}
+	if req.ShmSize > 0 {
+		args = append(args, "--shm-size", strconv.FormatInt(req.ShmSize, 10))
+	}
+	for _, tmpfs := range req.Tmpfs {
+		args = append(args, "--tmpfs", tmpfs)
+	}
+	for _, device := range req.Devices {
+		args = append(args, "--device", device)
+	}
+	for _, ulimit := range req.Ulimits {
+		args = append(args, "--ulimit", ulimit)
+	}
+	for _, port := range req.Expose {
+		args = append(args, "--expose", port)
+	}
+	if req.LogDriver != "" {
+		args = append(args, "--log-driver", req.LogDriver)
+	}
+	logOptionKeys := make([]string, 0, len(req.LogOptions))
+	for key := range req.LogOptions {
+		logOptionKeys = append(logOptionKeys, key)
+	}
+	sort.Strings(logOptionKeys)
+	for _, key := range logOptionKeys {
+		args = append(args, "--log-opt", key+"="+req.LogOptions[key])
+	}
+	if len(req.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", req.Entrypoint[0])
+	}
 	
 	// Add image
 	args = append(args, req.Image)
 	
-	// Add command if provided
+	// Add command if provided. Extra entrypoint elements are passed first,
+	// since --entrypoint only accepts the executable.
+	if len(req.Entrypoint) > 1 {
+		args = append(args, req.Entrypoint[1:]...)
+	}
 	if len(req.Command) > 0 {
 		args = append(args, req.Command...)
 	}
-	
-	output, err := executeDockerCommand(args...)
-	if err != nil {
-		return "", err
-	}
-	
-	// Return container ID
-	return strings.TrimSpace(string(output)), nil
+
+	return args
 }
 
 // searchLocalImages searches for images locally
@@ -696,18 +878,15 @@ func searchLocalImages(query string) ([]LocalImageResult, error) {
 	query = strings.ToLower(query)
 	
 	for _, img := range images {
-		if repoTags, ok := img["RepoTags"].([]string); ok {
-			for _, tag := range repoTags {
-				if strings.Contains(strings.ToLower(tag), query) {
-					result := LocalImageResult{
-						ID:       img["Id"].(string),
-						RepoTags: repoTags,
-						Size:     img["Size"].(int64),
-						Created:  img["Created"].(int64),
-					}
-					results = append(results, result)
-					break
-				}
+		for _, tag := range img.RepoTags {
+			if strings.Contains(strings.ToLower(tag), query) {
+				results = append(results, LocalImageResult{
+					ID:       img.ID,
+					RepoTags: img.RepoTags,
+					Size:     img.Size,
+					Created:  img.Created,
+				})
+				break
 			}
 		}
 	}
@@ -750,12 +929,6 @@ func searchDockerHub(query string) ([]HubImageResult, error) {
 	return results, nil
 }
 
-// dockerPull pulls an image from registry
-func dockerPull(image string) error {
-	_, err := executeDockerCommand("pull", image)
-	return err
-}
-
 // dockerInspectImage inspects an image
 func dockerInspectImage(imageID string) (map[string]interface{}, error) {
 	output, err := executeDockerCommand("inspect", imageID)
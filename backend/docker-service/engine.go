@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The docker CLI does not report byte-level progress when its output is not a
+// terminal, so streaming operations talk to the Engine API directly. The
+// endpoint follows DOCKER_HOST like the CLI does.
+
+var (
+	engineOnce   sync.Once
+	engineClient *http.Client
+	engineBase   string
+)
+
+// dockerEngine returns an HTTP client for the Engine API and its base URL
+func dockerEngine() (*http.Client, string) {
+	engineOnce.Do(func() {
+		host := os.Getenv("DOCKER_HOST")
+		if host == "" {
+			host = "unix:///var/run/docker.sock"
+		}
+
+		transport := &http.Transport{}
+		if strings.HasPrefix(host, "unix://") {
+			socket := strings.TrimPrefix(host, "unix://")
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			}
+			engineBase = "http://docker"
+		} else {
+			engineBase = "http://" + strings.TrimPrefix(host, "tcp://")
+		}
+		engineClient = &http.Client{Transport: transport}
+	})
+	return engineClient, engineBase
+}
+
+// engineRequest performs an Engine API request. Non-2xx responses are
+// returned as DockerError so they are classified like CLI failures.
+func engineRequest(ctx context.Context, method, path string, query url.Values, headers map[string]string, body io.Reader) (*http.Response, error) {
+	client, base := dockerEngine()
+	target := base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &DockerError{Command: method + " " + path, ExitCode: -1, Stderr: "Cannot connect to the Docker daemon: " + err.Error()}
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		if apiErr.Message == "" {
+			apiErr.Message = fmt.Sprintf("engine returned %s", resp.Status)
+		}
+		return nil, &DockerError{Command: method + " " + path, ExitCode: resp.StatusCode, Stderr: apiErr.Message}
+	}
+	return resp, nil
+}
+
+// engineMessage is one object of an Engine API JSON progress stream
+type engineMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Stream         string `json:"stream"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux json.RawMessage `json:"aux,omitempty"`
+}
+
+// readEngineStream decodes a JSON progress stream, calling fn for every
+// message. An error message in the stream ends it with a DockerError.
+func readEngineStream(body io.Reader, command string, fn func(engineMessage)) error {
+	decoder := json.NewDecoder(body)
+	for {
+		var msg engineMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			message := msg.ErrorDetail.Message
+			if message == "" {
+				message = msg.Error
+			}
+			return &DockerError{Command: command, ExitCode: -1, Stderr: message}
+		}
+		fn(msg)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error codes returned in the error envelope
+const (
+	codeBadRequest        = "bad_request"
+	codeUnauthorized      = "unauthorized"
+	codeForbidden         = "forbidden"
+	codeNotFound          = "not_found"
+	codeMethodNotAllowed  = "method_not_allowed"
+	codeConflict          = "conflict"
+	codePayloadTooLarge   = "payload_too_large"
+	codeUnsupportedMedia  = "unsupported_media_type"
+	codeInternal          = "internal_error"
+	codeDaemonUnavailable = "daemon_unavailable"
+	codeTimeout           = "timeout"
+	codeRegistryDenied    = "registry_denied"
+	codeCanceled          = "canceled"
+	// codeImageInUse is a conflict the client can resolve with override=true
+	codeImageInUse = "image_in_use"
+)
+
+// statusClientClosedRequest is the non-standard status, known from nginx,
+// of a request the client abandoned. It keeps cancellations apart from
+// daemon outages in logs and metrics.
+const statusClientClosedRequest = 499
+
+// ErrorResponse is the JSON envelope returned for every failed request
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// DockerError is a failed docker CLI invocation. It keeps the daemon's
+// message so callers can classify it and clients can see it.
+type DockerError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *DockerError) Error() string {
+	if e.Stderr != "" {
+		return e.Stderr
+	}
+	return fmt.Sprintf("docker command failed with exit status %d", e.ExitCode)
+}
+
+// dockerErrorClasses maps fragments of daemon messages to HTTP statuses.
+// They are checked in order, so the more specific classes come first.
+var dockerErrorClasses = []struct {
+	status    int
+	code      string
+	fragments []string
+}{
+	{http.StatusServiceUnavailable, codeDaemonUnavailable, []string{
+		"cannot connect to the docker daemon",
+		"is the docker daemon running",
+		"error during connect",
+		// The docker CLI itself is missing; exec of a missing program in a
+		// container is a bad request
+		`exec: "docker": executable file not found`,
+	}},
+	{http.StatusBadRequest, codeBadRequest, []string{
+		"executable file not found",
+	}},
+	{http.StatusGatewayTimeout, codeTimeout, []string{
+		"context deadline exceeded",
+		"i/o timeout",
+		"tls handshake timeout",
+	}},
+	{http.StatusForbidden, codeRegistryDenied, []string{
+		"pull access denied",
+		"unauthorized",
+		"authentication required",
+		"denied: requested access",
+	}},
+	{http.StatusNotFound, codeNotFound, []string{
+		"no such container",
+		"no such image",
+		"no such volume",
+		"no such network",
+		"no such object",
+		"no such file or directory",
+		"could not find the file",
+		"manifest unknown",
+		"not found",
+	}},
+	{http.StatusConflict, codeConflict, []string{
+		"conflict",
+		"already in use",
+		"already exists",
+		"is in use",
+		"is being used",
+		"has active endpoints",
+		"port is already allocated",
+		"address already in use",
+		"cannot remove a running container",
+		"is not running",
+		"is already",
+		"is paused",
+		"is restarting",
+	}},
+	{http.StatusBadRequest, codeBadRequest, []string{
+		"invalid",
+		"bad parameter",
+		"unknown flag",
+		"unknown shorthand flag",
+		"requires at least",
+		"requires exactly",
+		"must be",
+	}},
+}
+
+// classifyError maps an error to an HTTP status and error code
+func classifyError(err error) (int, string) {
+	var dockerErr *DockerError
+	switch {
+	case errors.As(err, &dockerErr):
+		message := strings.ToLower(dockerErr.Stderr)
+		for _, class := range dockerErrorClasses {
+			for _, fragment := range class.fragments {
+				if strings.Contains(message, fragment) {
+					return class.status, class.code
+				}
+			}
+		}
+	case errors.Is(err, errTransferTooLarge):
+		return http.StatusRequestEntityTooLarge, codePayloadTooLarge
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, codeTimeout
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest, codeCanceled
+	}
+	return http.StatusInternalServerError, codeInternal
+}
+
+// codeForStatus returns the default error code of an HTTP status
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeBadRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusRequestEntityTooLarge:
+		return codePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return codeUnsupportedMedia
+	case http.StatusServiceUnavailable:
+		return codeDaemonUnavailable
+	case http.StatusGatewayTimeout:
+		return codeTimeout
+	}
+	return codeInternal
+}
+
+// writeErrorResponse writes the error envelope
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	resp.RequestID = w.Header().Get("X-Request-ID")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeError writes an error with the default code of its status
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorResponse(w, status, ErrorResponse{Code: codeForStatus(status), Message: message})
+}
+
+// writeErrorDetails writes an error with an explicit code and details
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	writeErrorResponse(w, status, ErrorResponse{Code: code, Message: message, Details: details})
+}
+
+// writeFailure writes an error for a failed operation. Docker errors are
+// classified by the daemon's message, which is kept in the response.
+func writeFailure(w http.ResponseWriter, message string, err error) {
+	status, code := classifyError(err)
+	resp := ErrorResponse{Code: code, Message: message + ": " + err.Error()}
+
+	var dockerErr *DockerError
+	if errors.As(err, &dockerErr) {
+		resp.Details = map[string]interface{}{
+			"command":   dockerErr.Command,
+			"exit_code": dockerErr.ExitCode,
+		}
+	}
+	writeErrorResponse(w, status, resp)
+}
+
+// newRequestID returns a random request identifier
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts caller-supplied IDs that are safe to echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware tags every request and response with an X-Request-ID,
+// reusing the caller's ID when one is sent
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		r.Header.Set("X-Request-ID", id)
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notFoundHandler answers unknown routes with the error envelope
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "No route for "+r.Method+" "+r.URL.Path)
+}
+
+// methodNotAllowedHandler answers unsupported methods with the error envelope
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, "Method "+r.Method+" is not allowed on "+r.URL.Path)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// injectedToolPath is where a static busybox is copied into running
+// containers whose image has no rm or mv
+const injectedToolPath = "/.dockmaster-busybox"
+
+// errStoppedRewrite is returned when a path of a stopped container's root
+// filesystem would have to be removed, which the archive API cannot do
+var errStoppedRewrite = errors.New("is on the root filesystem and cannot be removed while the container is stopped")
+
+// containerMountAt returns the volume or bind mount holding p, if any
+func containerMountAt(container *ContainerInspect, p string) *MountPoint {
+	var found *MountPoint
+	for i := range container.Mounts {
+		mount := &container.Mounts[i]
+		if mount.Type != "volume" && mount.Type != "bind" {
+			continue
+		}
+		if p != mount.Destination && !strings.HasPrefix(p, strings.TrimSuffix(mount.Destination, "/")+"/") {
+			continue
+		}
+		if found == nil || len(mount.Destination) > len(found.Destination) {
+			found = mount
+		}
+	}
+	return found
+}
+
+// isMountPoint reports whether p is where a mount of the container is attached
+func isMountPoint(container *ContainerInspect, p string) bool {
+	for _, mount := range container.Mounts {
+		if mount.Destination == p {
+			return true
+		}
+	}
+	return false
+}
+
+// isMissingExecutable reports whether a docker exec failed because the
+// container has no such program
+func isMissingExecutable(err error) bool {
+	var dockerErr *DockerError
+	return errors.As(err, &dockerErr) && strings.Contains(strings.ToLower(dockerErr.Stderr), "executable file not found")
+}
+
+// execContainerTool runs a file tool such as rm or mv in a running container.
+// Images without it, such as distroless ones, get a static busybox from the
+// helper image for the duration of the command.
+func execContainerTool(ctx context.Context, containerID string, args ...string) error {
+	_, err := executeDockerCommand(append([]string{"exec", containerID}, args...)...)
+	if err == nil || !isMissingExecutable(err) {
+		return err
+	}
+
+	if err := injectBusybox(ctx, containerID); err != nil {
+		return fmt.Errorf("the container has no %s and busybox could not be copied in: %v", args[0], err)
+	}
+	defer func() {
+		if _, err := executeDockerCommand("exec", containerID, injectedToolPath, "rm", "-f", injectedToolPath); err != nil {
+			logrus.WithError(err).WithField("container", containerID).Warn("Failed to remove busybox from container")
+		}
+	}()
+	_, err = executeDockerCommand(append([]string{"exec", containerID, injectedToolPath}, args...)...)
+	return err
+}
+
+// injectBusybox copies the busybox binary of the helper image to
+// injectedToolPath in a container
+func injectBusybox(ctx context.Context, containerID string) error {
+	image := volumeHelperImage()
+	if err := ensureImage(ctx, image); err != nil {
+		return err
+	}
+	output, err := executeDockerCommand("create", "--network", "none", "--label", volumeHelperLabel+"=true", image)
+	if err != nil {
+		return err
+	}
+	helperID := strings.TrimSpace(string(output))
+	defer removeVolumeHelper(helperID)
+
+	archive, err := executeDockerCommand("cp", "-L", helperID+":/bin/busybox", "-")
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(bytes.NewReader(archive))
+	header, err := reader.Next()
+	if err != nil {
+		return fmt.Errorf("failed to read busybox from %s: %v", image, err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     strings.TrimPrefix(injectedToolPath, "/"),
+		Typeflag: tar.TypeReg,
+		Mode:     0755,
+		Size:     header.Size,
+		ModTime:  header.ModTime,
+	}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, reader); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return copyIntoContainer(ctx, containerID, "/", &buf)
+}
+
+// runWithContainerMounts runs a command in a helper container that has the
+// volumes and bind mounts of a container at the same paths. It works while
+// the container is stopped.
+func runWithContainerMounts(ctx context.Context, containerID string, args ...string) error {
+	image := volumeHelperImage()
+	if err := ensureImage(ctx, image); err != nil {
+		return err
+	}
+	_, err := executeDockerCommand(append([]string{"run", "--rm", "--network", "none", "--label", volumeHelperLabel + "=true",
+		"--volumes-from", containerID, image}, args...)...)
+	return err
+}
+
+// deleteContainerPath removes a file or directory in a running container, or
+// on a volume or bind mount of a stopped one
+func deleteContainerPath(ctx context.Context, container *ContainerInspect, p string) error {
+	switch {
+	case container.State.Running:
+		return execContainerTool(ctx, container.ID, "rm", "-rf", "--", p)
+	case containerMountAt(container, p) != nil:
+		return runWithContainerMounts(ctx, container.ID, "rm", "-rf", "--", p)
+	}
+	return fmt.Errorf("%s %w", p, errStoppedRewrite)
+}
+
+// renameContainerPath moves a file or directory in a running or stopped
+// container. In a stopped container the source must be on a volume or bind
+// mount; a destination on the root filesystem is copied there through the
+// archive API before the source is deleted.
+func renameContainerPath(ctx context.Context, container *ContainerInspect, from, to string) error {
+	if container.State.Running {
+		return execContainerTool(ctx, container.ID, "mv", "--", from, to)
+	}
+	if containerMountAt(container, from) == nil {
+		return fmt.Errorf("%s %w", from, errStoppedRewrite)
+	}
+	if containerMountAt(container, to) != nil {
+		return runWithContainerMounts(ctx, container.ID, "mv", "--", from, to)
+	}
+
+	if _, err := statContainerPath(ctx, container.ID, to); err == nil {
+		return fmt.Errorf("%s already exists: %w", to, os.ErrExist)
+	}
+	if err := copyContainerPath(ctx, container.ID, from, to); err != nil {
+		return err
+	}
+	return runWithContainerMounts(ctx, container.ID, "rm", "-rf", "--", from)
+}
+
+// copyContainerPath copies a path of a container to a new name through the
+// archive API
+func copyContainerPath(ctx context.Context, containerID, from, to string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	proc, err := startDockerCommand(ctx, nil, "cp", containerID+":"+from, "-")
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(renameArchiveRoot(proc.Stdout, pw, path.Base(from), path.Base(to)))
+	}()
+	err = copyIntoContainer(ctx, containerID, path.Dir(to), pr)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if waitErr := proc.Wait(); waitErr != nil {
+		return waitErr
+	}
+	return err
+}
+
+// renameArchiveRoot copies a tar stream whose entries are below oldRoot,
+// moving them below newRoot
+func renameArchiveRoot(src io.Reader, dst io.Writer, oldRoot, newRoot string) error {
+	reader := tar.NewReader(src)
+	tw := tar.NewWriter(dst)
+	rebase := func(name string) string {
+		if rest := strings.TrimPrefix(name, oldRoot); rest != name && (rest == "" || rest[0] == '/') {
+			return newRoot + rest
+		}
+		return name
+	}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		header.Name = rebase(header.Name)
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = rebase(header.Linkname)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, reader); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,947 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// maxUploadSize bounds the size of a single upload request
+	maxUploadSize = envByteSize("FILE_BROWSER_MAX_UPLOAD", 1024*1024*1024)
+	// maxDownloadSize bounds the size of a single download
+	maxDownloadSize = envByteSize("FILE_BROWSER_MAX_DOWNLOAD", 4*1024*1024*1024)
+	// maxListingArchiveSize bounds how much archive data is read to list a
+	// directory of a stopped container
+	maxListingArchiveSize = envByteSize("FILE_BROWSER_MAX_LISTING", 256*1024*1024)
+
+	errTransferTooLarge = errors.New("transfer exceeds the configured size limit")
+)
+
+// protectedPaths are never written to or deleted through the file browser
+var protectedPaths = []string{"/proc", "/sys", "/dev"}
+
+// listDirectoryScript prints one stat line per directory entry, followed by
+// an "L|target" line for symlinks
+const listDirectoryScript = `cd -- "$1" || exit 1
+for f in .* *; do
+	[ "$f" = . ] || [ "$f" = .. ] && continue
+	[ -e "$f" ] || [ -L "$f" ] || continue
+	stat -c '%s|%f|%Y|%a|%U|%G|%n' -- "$f"
+	[ -L "$f" ] && printf 'L|%s\n' "$(readlink -- "$f")"
+done
+exit 0`
+
+// FileEntry describes a file or directory inside a container
+type FileEntry struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Type       string    `json:"type"`
+	Size       int64     `json:"size"`
+	Mode       string    `json:"mode"`
+	ModTime    time.Time `json:"mod_time"`
+	Owner      string    `json:"owner,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	LinkTarget string    `json:"link_target,omitempty"`
+}
+
+// DirectoryListing is the content of a container directory
+type DirectoryListing struct {
+	Path      string      `json:"path"`
+	Entries   []FileEntry `json:"entries"`
+	Truncated bool        `json:"truncated"`
+}
+
+// RenameFileRequest moves a file inside a container
+type RenameFileRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// envByteSize reads a byte size such as "512m" from the environment
+func envByteSize(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	size, err := parseByteSize(value)
+	if err != nil || size <= 0 {
+		logrus.WithField(key, value).Warn("Invalid size, using default")
+		return defaultValue
+	}
+	return size
+}
+
+// cleanContainerPath validates and normalizes a path inside a container
+func cleanContainerPath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("path contains invalid characters")
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("path must be absolute")
+	}
+	return path.Clean(p), nil
+}
+
+// isProtectedPath reports whether a path may not be modified
+func isProtectedPath(p string) bool {
+	if p == "/" {
+		return true
+	}
+	for _, protected := range protectedPaths {
+		if p == protected || strings.HasPrefix(p, protected+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// safeArchiveName validates an archive entry name and returns it cleaned
+func safeArchiveName(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(name, "./"))
+	if cleaned == "." {
+		return "", nil
+	}
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") || strings.ContainsRune(cleaned, 0) {
+		return "", fmt.Errorf("archive entry %q escapes the destination", name)
+	}
+	return cleaned, nil
+}
+
+func fileTypeFromMode(mode int64) string {
+	switch mode & 0xF000 {
+	case 0x4000:
+		return "dir"
+	case 0x8000:
+		return "file"
+	case 0xA000:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+func fileTypeFromHeader(header *tar.Header) string {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeSymlink:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+func entryFromHeader(header *tar.Header, fullPath string) FileEntry {
+	return FileEntry{
+		Name:       path.Base(fullPath),
+		Path:       fullPath,
+		Type:       fileTypeFromHeader(header),
+		Size:       header.Size,
+		Mode:       fmt.Sprintf("%04o", header.Mode&07777),
+		ModTime:    header.ModTime,
+		Owner:      header.Uname,
+		Group:      header.Gname,
+		LinkTarget: header.Linkname,
+	}
+}
+
+// limitedReader fails once more than limit bytes have been read
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, errTransferTooLarge
+	}
+	return n, err
+}
+
+// listContainerDirectory lists a directory, using exec for running
+// containers and the archive API for stopped ones
+func listContainerDirectory(ctx context.Context, containerID, dir string, running bool) (*DirectoryListing, error) {
+	if running {
+		listing, err := listDirectoryWithExec(containerID, dir)
+		if err == nil {
+			return listing, nil
+		}
+		logrus.WithError(err).WithField("container", containerID).Debug("Exec listing failed, falling back to archive")
+	}
+	return listDirectoryWithArchive(ctx, containerID, dir)
+}
+
+func listDirectoryWithExec(containerID, dir string) (*DirectoryListing, error) {
+	output, err := executeDockerCommand("exec", containerID, "sh", "-c", listDirectoryScript, "sh", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &DirectoryListing{Path: dir, Entries: []FileEntry{}}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "L|") {
+			if n := len(listing.Entries); n > 0 {
+				listing.Entries[n-1].LinkTarget = line[2:]
+			}
+			continue
+		}
+		fields := strings.SplitN(line, "|", 7)
+		if len(fields) != 7 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[0], 10, 64)
+		rawMode, _ := strconv.ParseInt(fields[1], 16, 64)
+		mtime, _ := strconv.ParseInt(fields[2], 10, 64)
+		perm, _ := strconv.ParseInt(fields[3], 8, 64)
+		listing.Entries = append(listing.Entries, FileEntry{
+			Name:    fields[6],
+			Path:    path.Join(dir, fields[6]),
+			Type:    fileTypeFromMode(rawMode),
+			Size:    size,
+			Mode:    fmt.Sprintf("%04o", perm),
+			ModTime: time.Unix(mtime, 0).UTC(),
+			Owner:   fields[4],
+			Group:   fields[5],
+		})
+	}
+
+	return listing, nil
+}
+
+func listDirectoryWithArchive(ctx context.Context, containerID, dir string) (*DirectoryListing, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	proc, err := startDockerCommand(ctx, nil, "cp", containerID+":"+dir, "-")
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &DirectoryListing{Path: dir, Entries: []FileEntry{}}
+	reader := tar.NewReader(&limitedReader{r: proc.Stdout, limit: maxListingArchiveSize})
+	root := ""
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, errTransferTooLarge) {
+				listing.Truncated = true
+				cancel()
+				proc.Wait()
+				return listing, nil
+			}
+			cancel()
+			proc.Wait()
+			return nil, err
+		}
+
+		name := path.Clean(header.Name)
+		if root == "" {
+			root = name
+			if header.Typeflag != tar.TypeDir {
+				cancel()
+				proc.Wait()
+				return nil, fmt.Errorf("%s is not a directory", dir)
+			}
+			continue
+		}
+		if path.Dir(name) != root {
+			continue
+		}
+		listing.Entries = append(listing.Entries, entryFromHeader(header, path.Join(dir, path.Base(name))))
+	}
+
+	if err := proc.Wait(); err != nil {
+		if root == "" {
+			return nil, err
+		}
+	}
+	return listing, nil
+}
+
+// statContainerPath reads the metadata of a single path
+func statContainerPath(ctx context.Context, containerID, p string) (*FileEntry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	proc, err := startDockerCommand(ctx, nil, "cp", containerID+":"+p, "-")
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := tar.NewReader(proc.Stdout).Next()
+	// Only the first header is needed; stop the copy early
+	cancel()
+	waitErr := proc.Wait()
+	if err != nil {
+		if waitErr != nil {
+			return nil, waitErr
+		}
+		return nil, fmt.Errorf("failed to read %s: %v", p, err)
+	}
+
+	entry := entryFromHeader(header, p)
+	return &entry, nil
+}
+
+// copyIntoContainer streams a tar archive into a container directory
+func copyIntoContainer(ctx context.Context, containerID, dir string, archive io.Reader) error {
+	proc, err := startDockerCommand(ctx, archive, "cp", "-", containerID+":"+dir)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, proc.Stdout)
+	return proc.Wait()
+}
+
+// sanitizeArchive re-encodes a tar (optionally gzip-compressed) archive,
+// rejecting entries, hard links and symlinks that would escape the
+// destination directory
+func sanitizeArchive(src io.Reader, tw *tar.Writer) (int, error) {
+	buffered := bufio.NewReader(src)
+	var input io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		input = gz
+	}
+
+	count := 0
+	reader := tar.NewReader(input)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		name, err := safeArchiveName(header.Name)
+		if err != nil {
+			return count, err
+		}
+		if name == "" {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		case tar.TypeLink:
+			if _, err := safeArchiveName(header.Linkname); err != nil {
+				return count, err
+			}
+		case tar.TypeSymlink:
+			// A later write through the link must stay in the destination
+			if path.IsAbs(header.Linkname) {
+				return count, fmt.Errorf("archive entry %q links to absolute path %q", header.Name, header.Linkname)
+			}
+			if _, err := safeArchiveName(path.Join(path.Dir(name), header.Linkname)); err != nil {
+				return count, fmt.Errorf("archive entry %q links outside the destination", header.Name)
+			}
+		default:
+			// Devices, fifos and other special files are skipped
+			continue
+		}
+
+		header.Name = name
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return count, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tw, reader); err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+}
+
+// zipToTar converts a zip archive into tar entries
+func zipToTar(file *os.File, size int64, tw *tar.Writer) (int, error) {
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range archive.File {
+		name, err := safeArchiveName(entry.Name)
+		if err != nil {
+			return count, err
+		}
+		if name == "" {
+			continue
+		}
+
+		info := entry.FileInfo()
+		header := &tar.Header{
+			Name:    name,
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: entry.Modified,
+		}
+		if info.IsDir() {
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			if header.Mode == 0 {
+				header.Mode = 0755
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return count, err
+			}
+			count++
+			continue
+		}
+
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(entry.UncompressedSize64)
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return count, err
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return count, err
+		}
+		_, err = io.Copy(tw, rc)
+		rc.Close()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// spoolUpload stores an uploaded stream in a temporary file so its size is known
+func spoolUpload(src io.Reader) (*os.File, int64, error) {
+	file, err := os.CreateTemp("", "dockmaster-upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	os.Remove(file.Name())
+
+	size, err := io.Copy(file, src)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+// uploadSource is a single uploaded file
+type uploadSource struct {
+	name    string
+	reader  io.Reader
+	extract bool
+}
+
+// writeUploadArchive writes one uploaded file to the tar stream, either
+// unpacked (archives) or as a single entry
+func writeUploadArchive(tw *tar.Writer, src uploadSource) (int, error) {
+	lower := strings.ToLower(src.name)
+	isTar := strings.HasSuffix(lower, ".tar") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+	isZip := strings.HasSuffix(lower, ".zip")
+
+	if src.extract && isTar {
+		return sanitizeArchive(src.reader, tw)
+	}
+
+	file, size, err := spoolUpload(src.reader)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if src.extract && isZip {
+		return zipToTar(file, size, tw)
+	}
+
+	name := path.Base(strings.ReplaceAll(src.name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" || name == "" {
+		return 0, fmt.Errorf("invalid file name %q", src.name)
+	}
+	header := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(tw, file); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// writeUploadStream writes the files of an upload request to a tar stream.
+// Multipart parts are written one by one; a raw body is either a tar archive
+// or a single file named by the filename query parameter.
+func writeUploadStream(r *http.Request, tw *tar.Writer, extract bool, count *int) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "multipart/form-data":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if part.FileName() == "" {
+				part.Close()
+				continue
+			}
+			n, err := writeUploadArchive(tw, uploadSource{name: part.FileName(), reader: part, extract: extract})
+			part.Close()
+			*count += n
+			if err != nil {
+				return err
+			}
+		}
+	case "application/x-tar", "application/gzip", "application/x-gzip":
+		n, err := sanitizeArchive(r.Body, tw)
+		*count += n
+		if err != nil {
+			return err
+		}
+	default:
+		name := r.URL.Query().Get("filename")
+		if name == "" {
+			return fmt.Errorf("query parameter 'filename' is required for raw uploads")
+		}
+		n, err := writeUploadArchive(tw, uploadSource{name: name, reader: r.Body, extract: extract})
+		*count += n
+		if err != nil {
+			return err
+		}
+	}
+
+	if *count == 0 {
+		return fmt.Errorf("no files were uploaded")
+	}
+	return tw.Close()
+}
+
+// listContainerFiles lists a directory inside a container
+func listContainerFiles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	dir, err := cleanContainerPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+
+	container, err := dockerInspectContainer(id)
+	if err != nil {
+		logrus.WithError(err).WithField("container", id).Error("Failed to inspect container")
+		writeFailure(w, "Failed to inspect container", err)
+		return
+	}
+
+	listing, err := listContainerDirectory(r.Context(), id, dir, container.State.Running)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"container": id, "path": dir}).Error("Failed to list directory")
+		writeFailure(w, "Failed to list directory", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// statContainerFile returns the metadata of a path inside a container
+func statContainerFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	p, err := cleanContainerPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+
+	entry, err := statContainerPath(r.Context(), id, p)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"container": id, "path": p}).Error("Failed to stat path")
+		writeFailure(w, "Failed to stat path", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// downloadContainerFile streams a file, or a directory as tar or zip
+func downloadContainerFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	format := r.URL.Query().Get("format")
+
+	p, err := cleanContainerPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	if format != "" && format != "tar" && format != "zip" {
+		writeError(w, http.StatusBadRequest, "Unsupported format, use tar or zip")
+		return
+	}
+
+	disableTimeouts(w)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	proc, err := startDockerCommand(ctx, nil, "cp", id+":"+p, "-")
+	if err != nil {
+		writeFailure(w, "Failed to download", err)
+		return
+	}
+	defer proc.Wait()
+
+	limited := &limitedReader{r: proc.Stdout, limit: maxDownloadSize + 64*1024}
+	buffered := bufio.NewReaderSize(limited, 64*1024)
+	reader := tar.NewReader(buffered)
+
+	// Read the first header before responding so errors can still be reported
+	header, err := reader.Next()
+	if err != nil {
+		cancel()
+		if waitErr := proc.Wait(); waitErr != nil {
+			err = waitErr
+		}
+		logrus.WithError(err).WithFields(logrus.Fields{"container": id, "path": p}).Error("Failed to download path")
+		writeFailure(w, "Failed to download", err)
+		return
+	}
+
+	name := path.Base(p)
+	if name == "/" {
+		name = "root"
+	}
+	log := logrus.WithFields(logrus.Fields{"container": id, "path": p, "format": format})
+
+	// Plain files are sent as-is unless an archive was requested
+	if header.Typeflag == tar.TypeReg && format == "" {
+		if header.Size > maxDownloadSize {
+			writeError(w, http.StatusRequestEntityTooLarge, errTransferTooLarge.Error())
+			return
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(header.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		if _, err := io.Copy(w, reader); err != nil {
+			log.WithError(err).Warn("File download interrupted")
+		}
+		return
+	}
+
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+		zw := zip.NewWriter(w)
+		for header != nil {
+			if err := writeZipEntry(zw, header, reader); err != nil {
+				log.WithError(err).Warn("Zip download interrupted")
+				return
+			}
+			header, err = reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.WithError(err).Warn("Zip download interrupted")
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			log.WithError(err).Warn("Failed to finish zip download")
+		}
+		return
+	}
+
+	// Tar downloads are passed through; re-encode the header already consumed
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".tar"}))
+	tw := tar.NewWriter(w)
+	for header != nil {
+		if err := tw.WriteHeader(header); err != nil {
+			log.WithError(err).Warn("Tar download interrupted")
+			return
+		}
+		if _, err := io.Copy(tw, reader); err != nil {
+			log.WithError(err).Warn("Tar download interrupted")
+			return
+		}
+		header, err = reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.WithError(err).Warn("Tar download interrupted")
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		log.WithError(err).Warn("Failed to finish tar download")
+	}
+}
+
+// writeZipEntry converts a tar entry into a zip entry
+func writeZipEntry(zw *zip.Writer, header *tar.Header, content io.Reader) error {
+	info := header.FileInfo()
+	zipHeader, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	zipHeader.Name = strings.TrimPrefix(path.Clean(header.Name), "/")
+	switch header.Typeflag {
+	case tar.TypeDir:
+		zipHeader.Name += "/"
+		_, err = zw.CreateHeader(zipHeader)
+		return err
+	case tar.TypeSymlink:
+		writer, err := zw.CreateHeader(zipHeader)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte(header.Linkname))
+		return err
+	case tar.TypeReg:
+		zipHeader.Method = zip.Deflate
+		writer, err := zw.CreateHeader(zipHeader)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, content)
+		return err
+	default:
+		return nil
+	}
+}
+
+// uploadContainerFiles uploads files or archives into a container directory
+func uploadContainerFiles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	extract := r.URL.Query().Get("extract") == "true"
+
+	dir, err := cleanContainerPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	if isProtectedPath(dir) && dir != "/" {
+		writeError(w, http.StatusForbidden, "Uploads to "+dir+" are not allowed")
+		return
+	}
+
+	disableTimeouts(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	// Uploaded files are converted into a single tar stream for `docker cp`
+	pr, pw := io.Pipe()
+	count := 0
+	produced := make(chan error, 1)
+	go func() {
+		err := writeUploadStream(r, tar.NewWriter(pw), extract, &count)
+		pw.CloseWithError(err)
+		produced <- err
+	}()
+
+	err = copyIntoContainer(r.Context(), id, dir, pr)
+	// Unblock the producer in case docker exited before reading everything
+	pr.CloseWithError(io.ErrClosedPipe)
+	if produceErr := <-produced; produceErr != nil && !errors.Is(produceErr, io.ErrClosedPipe) {
+		err = produceErr
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, errTransferTooLarge.Error())
+			return
+		}
+		logrus.WithError(err).WithFields(logrus.Fields{"container": id, "path": dir}).Error("Failed to upload files")
+		var dockerErr *DockerError
+		if errors.As(err, &dockerErr) {
+			writeFailure(w, "Failed to upload files", err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Failed to upload files: "+err.Error())
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"container": id, "path": dir, "entries": count}).Info("Files uploaded to container")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Files uploaded successfully",
+		"path":    dir,
+		"entries": count,
+	})
+}
+
+// deleteContainerFile removes a file or directory inside a running or stopped container
+func deleteContainerFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	p, err := cleanContainerPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	if isProtectedPath(p) {
+		writeError(w, http.StatusForbidden, "Deleting "+p+" is not allowed")
+		return
+	}
+	container, ok := fileOperationContainer(w, id)
+	if !ok {
+		return
+	}
+	if isMountPoint(container, p) {
+		writeError(w, http.StatusConflict, p+" is a mount point and cannot be deleted")
+		return
+	}
+
+	if err := deleteContainerPath(r.Context(), container, p); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"container": id, "path": p}).Error("Failed to delete path")
+		writeFileOperationError(w, "Failed to delete path", err)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"container": id, "path": p}).Info("Container path deleted")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Path deleted successfully"})
+}
+
+// renameContainerFile moves a file or directory inside a running or stopped container
+func renameContainerFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req RenameFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	from, err := cleanContainerPath(req.From)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid source path: "+err.Error())
+		return
+	}
+	to, err := cleanContainerPath(req.To)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid destination path: "+err.Error())
+		return
+	}
+	if isProtectedPath(from) || isProtectedPath(to) {
+		writeError(w, http.StatusForbidden, "Renaming protected paths is not allowed")
+		return
+	}
+	if from == to || strings.HasPrefix(to, from+"/") {
+		writeError(w, http.StatusBadRequest, "Cannot move "+from+" to "+to)
+		return
+	}
+	container, ok := fileOperationContainer(w, id)
+	if !ok {
+		return
+	}
+	if isMountPoint(container, from) {
+		writeError(w, http.StatusConflict, from+" is a mount point and cannot be renamed")
+		return
+	}
+
+	if err := renameContainerPath(r.Context(), container, from, to); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"container": id, "from": from, "to": to}).Error("Failed to rename path")
+		writeFileOperationError(w, "Failed to rename path", err)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"container": id, "from": from, "to": to}).Info("Container path renamed")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Path renamed successfully"})
+}
+
+// fileOperationContainer inspects the container of a file operation, which
+// may be running or stopped but not paused
+func fileOperationContainer(w http.ResponseWriter, id string) (*ContainerInspect, bool) {
+	container, err := dockerInspectContainer(id)
+	if err != nil {
+		writeFailure(w, "Failed to inspect container", err)
+		return nil, false
+	}
+	if container.State.Paused || container.State.Restarting {
+		writeError(w, http.StatusConflict, "Container must be running or stopped for this operation")
+		return nil, false
+	}
+	return container, true
+}
+
+// writeFileOperationError maps the errors of delete and rename to responses
+func writeFileOperationError(w http.ResponseWriter, message string, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		writeError(w, http.StatusNotFound, message+": "+err.Error())
+	case errors.Is(err, os.ErrExist), errors.Is(err, errStoppedRewrite):
+		writeError(w, http.StatusConflict, message+": "+err.Error())
+	default:
+		writeFailure(w, message, err)
+	}
+}
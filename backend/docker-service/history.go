@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// historyConcurrency bounds parallel history requests when every image is read
+const historyConcurrency = 4
+
+// engineHistoryEntry is one entry of the Engine API image history
+type engineHistoryEntry struct {
+	ID        string   `json:"Id"`
+	Created   int64    `json:"Created"`
+	CreatedBy string   `json:"CreatedBy"`
+	Tags      []string `json:"Tags"`
+	Size      int64    `json:"Size"`
+	Comment   string   `json:"Comment"`
+}
+
+// ImageLayer is one history entry of an image. Entries that only change the
+// image config have no layer and therefore no diff or chain ID.
+type ImageLayer struct {
+	ID         string    `json:"id,omitempty"`
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Comment    string    `json:"comment,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Size       int64     `json:"size"`
+	EmptyLayer bool      `json:"empty_layer"`
+	DiffID     string    `json:"diff_id,omitempty"`
+	ChainID    string    `json:"chain_id,omitempty"`
+	Shared     bool      `json:"shared"`
+	SharedWith []string  `json:"shared_with,omitempty"`
+}
+
+// ImageSizeBreakdown splits the size of an image into bytes only it uses and
+// bytes shared with other local images
+type ImageSizeBreakdown struct {
+	ID           string   `json:"id"`
+	RepoTags     []string `json:"repo_tags"`
+	Size         int64    `json:"size"`
+	UniqueSize   int64    `json:"unique_size"`
+	SharedSize   int64    `json:"shared_size"`
+	Layers       int      `json:"layers"`
+	SharedLayers int      `json:"shared_layers"`
+}
+
+// ImageHistory is the layer history of an image, newest entry first
+type ImageHistory struct {
+	ImageSizeBreakdown
+	History []ImageLayer `json:"history"`
+}
+
+// imageLayerInfo is what is known about the layers of one local image
+type imageLayerInfo struct {
+	image   ImageInspect
+	history []ImageLayer
+	chains  []string
+}
+
+// engineImageHistory reads the history of an image through the Engine API
+func engineImageHistory(ctx context.Context, id string) ([]engineHistoryEntry, error) {
+	resp, err := engineRequest(ctx, http.MethodGet, "/images/"+id+"/history", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var entries []engineHistoryEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// chainIDs computes the chain IDs of a list of diff IDs. A layer is only
+// stored once per chain ID, so two images share a layer exactly when their
+// chain IDs match, not merely their diff IDs.
+func chainIDs(diffIDs []string) []string {
+	chains := make([]string, len(diffIDs))
+	for i, diffID := range diffIDs {
+		if i == 0 {
+			chains[i] = diffID
+			continue
+		}
+		sum := sha256.Sum256([]byte(chains[i-1] + " " + diffID))
+		chains[i] = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return chains
+}
+
+// metadataInstructions only change the image config and never add a layer
+var metadataInstructions = map[string]bool{
+	"ENV": true, "LABEL": true, "CMD": true, "ENTRYPOINT": true, "EXPOSE": true,
+	"USER": true, "VOLUME": true, "ARG": true, "ONBUILD": true, "STOPSIGNAL": true,
+	"HEALTHCHECK": true, "SHELL": true, "MAINTAINER": true,
+}
+
+// createsLayer guesses from its instruction whether a history entry added a
+// layer. The Engine API does not expose the empty_layer flag of the config.
+func createsLayer(entry engineHistoryEntry) bool {
+	if entry.Size > 0 {
+		return true
+	}
+	instruction := strings.TrimSpace(strings.TrimPrefix(entry.CreatedBy, "/bin/sh -c "))
+	if rest, ok := strings.CutPrefix(instruction, "#(nop)"); ok {
+		keyword, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		return keyword == "ADD" || keyword == "COPY"
+	}
+	keyword, _, _ := strings.Cut(instruction, " ")
+	return !metadataInstructions[keyword]
+}
+
+// assignLayers matches history entries to the image's diff IDs. Entries are
+// newest first while diff IDs are base first. When the entries that created
+// layers cannot be told apart, layers are left unassigned rather than guessed.
+func assignLayers(entries []engineHistoryEntry, diffIDs []string) []ImageLayer {
+	history := make([]ImageLayer, len(entries))
+	for i, entry := range entries {
+		history[i] = ImageLayer{
+			Created:   time.Unix(entry.Created, 0).UTC(),
+			CreatedBy: entry.CreatedBy,
+			Comment:   entry.Comment,
+			Tags:      entry.Tags,
+			Size:      entry.Size,
+		}
+		if entry.ID != "<missing>" {
+			history[i].ID = entry.ID
+		}
+	}
+
+	chains := chainIDs(diffIDs)
+	for _, classify := range []func(engineHistoryEntry) bool{
+		createsLayer,
+		func(entry engineHistoryEntry) bool { return entry.Size > 0 },
+	} {
+		var layerEntries []int
+		for i := len(entries) - 1; i >= 0; i-- {
+			if classify(entries[i]) {
+				layerEntries = append(layerEntries, i)
+			}
+		}
+		if len(layerEntries) != len(diffIDs) {
+			continue
+		}
+		for i := range history {
+			history[i].EmptyLayer = true
+		}
+		for layer, i := range layerEntries {
+			history[i].EmptyLayer = false
+			history[i].DiffID = diffIDs[layer]
+			history[i].ChainID = chains[layer]
+		}
+		break
+	}
+	return history
+}
+
+// loadImageLayers reads the layers and history of every local image, since
+// sharing can only be judged against all of them
+func loadImageLayers(ctx context.Context) (map[string]*imageLayerInfo, error) {
+	images, err := getRealImages()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	inspections, err := dockerInspectImages(ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make(map[string]*imageLayerInfo, len(inspections))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, historyConcurrency)
+	for _, inspection := range inspections {
+		wg.Add(1)
+		go func(image ImageInspect) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			info := &imageLayerInfo{image: image, chains: chainIDs(image.RootFS.Layers)}
+			entries, err := engineImageHistory(ctx, image.ID)
+			if err != nil {
+				logrus.WithError(err).WithField("image", image.ID).Warn("Failed to read image history")
+			} else {
+				info.history = assignLayers(entries, image.RootFS.Layers)
+			}
+			mu.Lock()
+			infos[image.ID] = info
+			mu.Unlock()
+		}(inspection)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return infos, nil
+}
+
+// chainUsers maps every chain ID to the images using it
+func chainUsers(infos map[string]*imageLayerInfo) map[string][]string {
+	users := make(map[string][]string)
+	for id, info := range infos {
+		for _, chain := range info.chains {
+			users[chain] = append(users[chain], id)
+		}
+	}
+	return users
+}
+
+// imageLabel names an image by its first tag, or its short ID
+func imageLabel(image ImageInspect) string {
+	if len(image.RepoTags) > 0 {
+		return image.RepoTags[0]
+	}
+	id := strings.TrimPrefix(image.ID, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+// breakdownImage marks the shared layers of an image and sums its sizes
+func breakdownImage(info *imageLayerInfo, infos map[string]*imageLayerInfo, users map[string][]string) ImageHistory {
+	result := ImageHistory{
+		ImageSizeBreakdown: ImageSizeBreakdown{
+			ID:       info.image.ID,
+			RepoTags: info.image.RepoTags,
+			Size:     info.image.Size,
+			Layers:   len(info.chains),
+		},
+		History: info.history,
+	}
+	if result.RepoTags == nil {
+		result.RepoTags = []string{}
+	}
+
+	for i := range result.History {
+		layer := &result.History[i]
+		for _, other := range users[layer.ChainID] {
+			if other != info.image.ID {
+				layer.SharedWith = append(layer.SharedWith, imageLabel(infos[other].image))
+			}
+		}
+		sort.Strings(layer.SharedWith)
+		layer.Shared = len(layer.SharedWith) > 0
+		if layer.Shared {
+			result.SharedSize += layer.Size
+			result.SharedLayers++
+		}
+	}
+	result.UniqueSize = result.Size - result.SharedSize
+	if result.UniqueSize < 0 {
+		result.UniqueSize = 0
+	}
+	return result
+}
+
+// getImageHistory returns the layer history of an image with the layers it
+// shares with other local images
+func getImageHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	image, err := dockerInspectImageTyped(id)
+	if err != nil {
+		logrus.WithError(err).WithField("image", id).Error("Failed to inspect image")
+		writeFailure(w, "Failed to inspect image", err)
+		return
+	}
+
+	infos, err := loadImageLayers(r.Context())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to read image layers")
+		writeFailure(w, "Failed to read image layers", err)
+		return
+	}
+	info, ok := infos[image.ID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Image not found: "+id)
+		return
+	}
+	if info.history == nil {
+		entries, err := engineImageHistory(r.Context(), image.ID)
+		if err != nil {
+			writeFailure(w, "Failed to read image history", err)
+			return
+		}
+		info.history = assignLayers(entries, image.RootFS.Layers)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(breakdownImage(info, infos, chainUsers(infos)))
+}
+
+// getImageUsage returns the unique and shared bytes of every local image,
+// largest unique size first, with the disk space all images take together
+func getImageUsage(w http.ResponseWriter, r *http.Request) {
+	infos, err := loadImageLayers(r.Context())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to read image layers")
+		writeFailure(w, "Failed to read image layers", err)
+		return
+	}
+	users := chainUsers(infos)
+
+	images := make([]ImageSizeBreakdown, 0, len(infos))
+	layerSizes := make(map[string]int64)
+	var unlayered int64
+	for _, info := range infos {
+		breakdown := breakdownImage(info, infos, users)
+		images = append(images, breakdown.ImageSizeBreakdown)
+		for _, layer := range breakdown.History {
+			if layer.ChainID != "" {
+				layerSizes[layer.ChainID] = layer.Size
+			} else {
+				unlayered += layer.Size
+			}
+		}
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].UniqueSize != images[j].UniqueSize {
+			return images[i].UniqueSize > images[j].UniqueSize
+		}
+		return images[i].ID < images[j].ID
+	})
+
+	var totalSize, sharedSize int64 = unlayered, 0
+	for chain, size := range layerSizes {
+		totalSize += size
+		if len(users[chain]) > 1 {
+			sharedSize += size
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"images":      images,
+		"total_size":  totalSize,
+		"shared_size": sharedSize,
+		"layers":      len(layerSizes),
+	})
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PortConflict describes a requested host port that is already taken
+type PortConflict struct {
+	HostIP        string `json:"host_ip,omitempty"`
+	HostPort      int    `json:"host_port"`
+	Protocol      string `json:"protocol"`
+	OwnerType     string `json:"owner_type"`
+	Owner         string `json:"owner"`
+	OwnerID       string `json:"owner_id,omitempty"`
+	SuggestedPort int    `json:"suggested_port,omitempty"`
+}
+
+// hostPortUse is a host port held by a container or a listening socket
+type hostPortUse struct {
+	IP        string
+	Port      int
+	Protocol  string
+	OwnerType string
+	Owner     string
+	OwnerID   string
+	inode     string
+}
+
+// procRoot returns the proc filesystem to read sockets from. When the service
+// runs in a container, mount the host's /proc and set HOST_PROC so the host
+// network namespace is inspected instead of the container's own.
+func procRoot() (string, string) {
+	if root := os.Getenv("HOST_PROC"); root != "" {
+		return root, filepath.Join(root, "1", "net")
+	}
+	return "/proc", "/proc/net"
+}
+
+// parseProcNetAddress decodes an address such as 0100007F:1F90 from /proc/net
+func parseProcNetAddress(value string) (string, int, error) {
+	hexIP, hexPort, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid address %q", value)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return "", 0, fmt.Errorf("invalid address %q", value)
+	}
+	// The kernel prints each 32-bit word in host (little endian) order
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip.String(), int(port), nil
+}
+
+// listeningSockets reads bound TCP listeners and UDP sockets from /proc/net
+func listeningSockets() []hostPortUse {
+	_, netDir := procRoot()
+	files := []struct {
+		name     string
+		protocol string
+		state    string
+	}{
+		{"tcp", "tcp", "0A"},
+		{"tcp6", "tcp", "0A"},
+		{"udp", "udp", "07"},
+		{"udp6", "udp", "07"},
+	}
+
+	var uses []hostPortUse
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(netDir, file.name))
+		if err != nil {
+			logrus.WithError(err).Debug("Failed to read socket table")
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != file.state {
+				continue
+			}
+			ip, port, err := parseProcNetAddress(fields[1])
+			if err != nil {
+				continue
+			}
+			uses = append(uses, hostPortUse{
+				IP:        ip,
+				Port:      port,
+				Protocol:  file.protocol,
+				OwnerType: "socket",
+				Owner:     "unknown process",
+				inode:     fields[9],
+			})
+		}
+		f.Close()
+	}
+	return uses
+}
+
+// resolveSocketOwners names the processes holding the given sockets by
+// scanning /proc/<pid>/fd. This needs privileges to see other users'
+// processes; sockets that cannot be attributed keep an anonymous owner.
+func resolveSocketOwners(uses []hostPortUse) {
+	wanted := make(map[string][]int)
+	for i, use := range uses {
+		if use.inode != "" && use.inode != "0" {
+			wanted["socket:["+use.inode+"]"] = append(wanted["socket:["+use.inode+"]"], i)
+		}
+	}
+	if len(wanted) == 0 {
+		return
+	}
+
+	root, _ := procRoot()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		pid := entry.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		fds, err := os.ReadDir(filepath.Join(root, pid, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(root, pid, "fd", fd.Name()))
+			if err != nil {
+				continue
+			}
+			indexes, ok := wanted[link]
+			if !ok {
+				continue
+			}
+			comm, _ := os.ReadFile(filepath.Join(root, pid, "comm"))
+			for _, i := range indexes {
+				uses[i].OwnerType = "process"
+				uses[i].Owner = strings.TrimSpace(string(comm))
+				uses[i].OwnerID = pid
+			}
+			delete(wanted, link)
+			if len(wanted) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// containerPortUses lists host ports published by running containers
+func containerPortUses() ([]hostPortUse, error) {
+	containers, err := getRealContainers(false)
+	if err != nil {
+		return nil, err
+	}
+
+	var uses []hostPortUse
+	for _, container := range containers {
+		name := strings.TrimPrefix(firstName(container.Names), "/")
+		for _, port := range container.Ports {
+			if !port.Published || port.PublicPort == 0 {
+				continue
+			}
+			uses = append(uses, hostPortUse{
+				IP:        port.IP,
+				Port:      port.PublicPort,
+				Protocol:  port.Type,
+				OwnerType: "container",
+				Owner:     name,
+				OwnerID:   container.ID,
+			})
+		}
+	}
+	return uses, nil
+}
+
+// usedHostPorts combines container bindings with listening sockets
+func usedHostPorts() ([]hostPortUse, error) {
+	uses, err := containerPortUses()
+	if err != nil {
+		return nil, err
+	}
+	return append(uses, listeningSockets()...), nil
+}
+
+// hostIPsOverlap reports whether binding both addresses would collide.
+// Unspecified addresses bind every interface.
+func hostIPsOverlap(a, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a == b
+	}
+	return ipA.IsUnspecified() || ipB.IsUnspecified() || ipA.Equal(ipB)
+}
+
+// findPortUse returns who holds a host port, preferring container owners
+// over the docker-proxy sockets that back them
+func findPortUse(uses []hostPortUse, hostIP string, port int, protocol string) *hostPortUse {
+	var socket *hostPortUse
+	for i := range uses {
+		use := &uses[i]
+		if use.Port != port || use.Protocol != protocol || !hostIPsOverlap(hostIP, use.IP) {
+			continue
+		}
+		if use.OwnerType == "container" {
+			return use
+		}
+		if socket == nil {
+			socket = use
+		}
+	}
+	return socket
+}
+
+// nextFreePort returns the first port from start on that is not in use
+func nextFreePort(uses []hostPortUse, hostIP string, start int, protocol string) int {
+	if start < 1 {
+		start = 1
+	}
+	for port := start; port <= 65535; port++ {
+		if findPortUse(uses, hostIP, port, protocol) == nil {
+			return port
+		}
+	}
+	return 0
+}
+
+// checkPortConflicts validates the requested host ports of a run request
+// against running containers and listening sockets
+func checkPortConflicts(mappings PortMappings) ([]PortConflict, error) {
+	var requested []PortMapping
+	for _, mapping := range mappings {
+		if mapping.HostPort != "" && mapping.Protocol != "sctp" {
+			requested = append(requested, mapping)
+		}
+	}
+	if len(requested) == 0 {
+		return nil, nil
+	}
+
+	uses, err := usedHostPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []PortConflict
+	for _, mapping := range requested {
+		hostStart, hostEnd, _ := parsePortRange(mapping.HostPort)
+		containerStart, containerEnd, _ := parsePortRange(mapping.ContainerPort)
+
+		// A host range for a single container port only needs one free port
+		if hostEnd != hostStart && containerStart == containerEnd {
+			if nextFreePort(uses, mapping.HostIP, hostStart, mapping.Protocol) <= hostEnd {
+				continue
+			}
+		}
+
+		for port := hostStart; port <= hostEnd; port++ {
+			use := findPortUse(uses, mapping.HostIP, port, mapping.Protocol)
+			if use == nil {
+				continue
+			}
+			conflicts = append(conflicts, PortConflict{
+				HostIP:        use.IP,
+				HostPort:      port,
+				Protocol:      mapping.Protocol,
+				OwnerType:     use.OwnerType,
+				Owner:         use.Owner,
+				OwnerID:       use.OwnerID,
+				SuggestedPort: nextFreePort(uses, mapping.HostIP, port+1, mapping.Protocol),
+			})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		// Only name processes when there is something to report
+		var sockets []hostPortUse
+		var indexes []int
+		for i, conflict := range conflicts {
+			if conflict.OwnerType == "socket" {
+				for _, use := range uses {
+					if use.OwnerType == "socket" && use.Port == conflict.HostPort && use.Protocol == conflict.Protocol {
+						sockets = append(sockets, use)
+						indexes = append(indexes, i)
+						break
+					}
+				}
+			}
+		}
+		resolveSocketOwners(sockets)
+		for j, i := range indexes {
+			conflicts[i].OwnerType = sockets[j].OwnerType
+			conflicts[i].Owner = sockets[j].Owner
+			conflicts[i].OwnerID = sockets[j].OwnerID
+		}
+	}
+
+	return conflicts, nil
+}
+
+// preflightPorts checks a run request for host port conflicts and writes a
+// 409 response naming the owners. It returns false if the run must not proceed.
+func preflightPorts(w http.ResponseWriter, mappings PortMappings) bool {
+	conflicts, err := checkPortConflicts(mappings)
+	if err != nil {
+		// The engine still rejects real conflicts, so a failed check is not fatal
+		logrus.WithError(err).Warn("Failed to check host port conflicts")
+		return true
+	}
+	if len(conflicts) == 0 {
+		return true
+	}
+
+	messages := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		messages[i] = fmt.Sprintf("%d/%s is used by %s %s", conflict.HostPort, conflict.Protocol, conflict.OwnerType, conflict.Owner)
+	}
+	logrus.WithField("conflicts", messages).Warn("Host port conflict")
+
+	writeErrorDetails(w, http.StatusConflict, "port_conflict", "Host port conflict: "+strings.Join(messages, "; "), map[string]interface{}{
+		"conflicts": conflicts,
+	})
+	return false
+}
+
+// suggestHostPort returns the next free host port starting at ?from=
+func suggestHostPort(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	from := 8000
+	if value := query.Get("from"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 65535 {
+			writeError(w, http.StatusBadRequest, "Invalid 'from' port")
+			return
+		}
+		from = n
+	}
+	protocol := strings.ToLower(query.Get("protocol"))
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	if protocol != "tcp" && protocol != "udp" {
+		writeError(w, http.StatusBadRequest, "Protocol must be tcp or udp")
+		return
+	}
+	hostIP := query.Get("host_ip")
+	if hostIP != "" && net.ParseIP(hostIP) == nil {
+		writeError(w, http.StatusBadRequest, "Invalid host IP")
+		return
+	}
+
+	uses, err := usedHostPorts()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list used host ports")
+		writeFailure(w, "Failed to list used host ports", err)
+		return
+	}
+
+	port := nextFreePort(uses, hostIP, from, protocol)
+	if port == 0 {
+		writeError(w, http.StatusConflict, "No free port available")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"port":     port,
+		"protocol": protocol,
+		"host_ip":  hostIP,
+	})
+}
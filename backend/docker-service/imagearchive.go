@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxImageLoadSize bounds the size of an uploaded image tarball
+var maxImageLoadSize = envByteSize("IMAGE_LOAD_MAX", 32*1024*1024*1024)
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// LoadedImage is an image reported by docker load
+type LoadedImage struct {
+	Reference string `json:"reference,omitempty"`
+	ID        string `json:"id"`
+	Size      int64  `json:"size,omitempty"`
+}
+
+// errorReader remembers the first read error, which docker load would
+// otherwise only report as a truncated archive
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// saveArchiveName derives a download name from the saved images
+func saveArchiveName(images []string, gzipped bool) string {
+	name := "images"
+	if len(images) == 1 {
+		name = strings.Trim(unsafeFilenameChars.ReplaceAllString(images[0], "_"), "_")
+	}
+	if gzipped {
+		return name + ".tar.gz"
+	}
+	return name + ".tar"
+}
+
+// saveImages streams a docker save tarball of one or more images given as
+// repeated ?image= parameters, gzip-compressed with ?gzip=true
+func saveImages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var images []string
+	for _, image := range query["image"] {
+		if image = strings.TrimSpace(image); image != "" {
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		writeError(w, http.StatusBadRequest, "Query parameter 'image' is required")
+		return
+	}
+	gzipped := query.Get("gzip") == "true"
+
+	// Check every image up front so a missing one is a 404, not a broken download
+	if _, err := executeDockerCommand(append([]string{"image", "inspect", "--format", "{{.Id}}"}, images...)...); err != nil {
+		writeFailure(w, "Failed to save images", err)
+		return
+	}
+
+	disableTimeouts(w)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	proc, err := startDockerCommand(ctx, nil, append([]string{"save"}, images...)...)
+	if err != nil {
+		writeFailure(w, "Failed to save images", err)
+		return
+	}
+	defer proc.Wait()
+
+	// Wait for the first bytes before responding so errors can still be reported
+	buffered := bufio.NewReaderSize(proc.Stdout, 64*1024)
+	if _, err := buffered.Peek(1); err != nil {
+		cancel()
+		if waitErr := proc.Wait(); waitErr != nil {
+			err = waitErr
+		}
+		logrus.WithError(err).WithField("images", images).Error("Failed to save images")
+		writeFailure(w, "Failed to save images", err)
+		return
+	}
+
+	contentType := "application/x-tar"
+	if gzipped {
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": saveArchiveName(images, gzipped)}))
+
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if gzipped {
+		// Speed matters more than ratio for multi-gigabyte layers
+		gz, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+		out = gz
+	}
+
+	log := logrus.WithFields(logrus.Fields{"images": images, "gzip": gzipped})
+	written, err := io.Copy(out, buffered)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if waitErr := proc.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		log.WithError(err).Warn("Image save interrupted")
+		recordAudit(r.Header.Get("X-User"), "images.save", strings.Join(images, ","), "failure", map[string]interface{}{"error": err.Error()})
+		// Abort the response so the client sees a failed transfer rather
+		// than a complete-looking but truncated archive
+		panic(http.ErrAbortHandler)
+	}
+
+	recordAudit(r.Header.Get("X-User"), "images.save", strings.Join(images, ","), "success", map[string]interface{}{"bytes": written, "gzip": gzipped})
+	log.WithField("bytes", written).Info("Images saved")
+}
+
+// parseLoadOutput reads the images reported by docker load
+func parseLoadOutput(output string) []LoadedImage {
+	var loaded []LoadedImage
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if id, ok := strings.CutPrefix(line, "Loaded image ID: "); ok {
+			loaded = append(loaded, LoadedImage{ID: id})
+		} else if reference, ok := strings.CutPrefix(line, "Loaded image: "); ok {
+			loaded = append(loaded, LoadedImage{Reference: reference})
+		}
+	}
+	return loaded
+}
+
+// imageLoadBody returns the tarball of a load request: the raw body, or the
+// first file of a multipart form
+func imageLoadBody(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("no file was uploaded")
+			}
+			return nil, err
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// loadImages loads an uploaded image tarball, plain or compressed, and
+// reports the images it contained. The upload is streamed into docker load.
+func loadImages(w http.ResponseWriter, r *http.Request) {
+	disableTimeouts(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageLoadSize)
+
+	body, err := imageLoadBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+	upload := &errorReader{r: body}
+
+	proc, err := startDockerCommand(r.Context(), upload, "load")
+	if err != nil {
+		writeFailure(w, "Failed to load images", err)
+		return
+	}
+	output, _ := io.ReadAll(proc.Stdout)
+	err = proc.Wait()
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(upload.err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, errTransferTooLarge.Error())
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load images")
+		recordAudit(r.Header.Get("X-User"), "images.load", "", "failure", map[string]interface{}{"error": err.Error()})
+		writeFailure(w, "Failed to load images", err)
+		return
+	}
+
+	loaded := parseLoadOutput(string(output))
+	for i := range loaded {
+		key := loaded[i].Reference
+		if key == "" {
+			key = loaded[i].ID
+		}
+		if image, err := dockerInspectImageTyped(key); err == nil {
+			loaded[i].ID = image.ID
+			loaded[i].Size = image.Size
+		}
+	}
+
+	names := make([]string, len(loaded))
+	for i, image := range loaded {
+		names[i] = image.Reference
+		if names[i] == "" {
+			names[i] = image.ID
+		}
+	}
+	recordAudit(r.Header.Get("X-User"), "images.load", strings.Join(names, ","), "success", map[string]interface{}{"images": names})
+	logrus.WithField("images", names).Info("Images loaded")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Images loaded successfully",
+		"images":  loaded,
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ImageContainer is a container created from an image
+type ImageContainer struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State string `json:"State"`
+}
+
+// ImageDependents is what keeps an image from being removed safely
+type ImageDependents struct {
+	Containers []ImageContainer `json:"containers"`
+	Children   []string         `json:"children"`
+}
+
+// containersByImage maps image IDs to the containers using them, running or
+// not. The CLI only prints the reference a container was created with, so
+// the Engine API is asked for the resolved image IDs.
+func containersByImage(ctx context.Context) (map[string][]ImageContainer, error) {
+	resp, err := engineRequest(ctx, http.MethodGet, "/containers/json", url.Values{"all": {"1"}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var containers []struct {
+		ID      string   `json:"Id"`
+		Names   []string `json:"Names"`
+		ImageID string   `json:"ImageID"`
+		State   string   `json:"State"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+
+	byImage := make(map[string][]ImageContainer)
+	for _, container := range containers {
+		byImage[container.ImageID] = append(byImage[container.ImageID], ImageContainer{
+			ID:    container.ID,
+			Name:  strings.TrimPrefix(firstName(container.Names), "/"),
+			State: container.State,
+		})
+	}
+	return byImage, nil
+}
+
+// imageParents maps every local image ID to its parent. Parents are only
+// recorded for images built locally with the classic builder.
+func imageParents() (map[string]string, error) {
+	images, err := getRealImages()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	inspections, err := dockerInspectImages(ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[string]string, len(inspections))
+	for _, inspection := range inspections {
+		parents[inspection.ID] = inspection.Parent
+	}
+	return parents, nil
+}
+
+// annotateImages adds the containers using each image and its parent and
+// child images to a listing
+func annotateImages(ctx context.Context, images []ImageSummary) error {
+	usage, err := containersByImage(ctx)
+	if err != nil {
+		return err
+	}
+	parents, err := imageParents()
+	if err != nil {
+		return err
+	}
+	children := make(map[string][]string)
+	for id, parent := range parents {
+		if parent != "" {
+			children[parent] = append(children[parent], id)
+		}
+	}
+
+	for i := range images {
+		image := &images[i]
+		image.UsedBy = usage[image.ID]
+		if image.UsedBy == nil {
+			image.UsedBy = []ImageContainer{}
+		}
+		image.ParentID = parents[image.ID]
+		image.Children = children[image.ID]
+		if image.Children == nil {
+			image.Children = []string{}
+		}
+	}
+	return nil
+}
+
+// imageDependents returns the containers and child images depending on an image
+func imageDependents(ctx context.Context, imageID string) (*ImageDependents, error) {
+	usage, err := containersByImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := imageParents()
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := &ImageDependents{Containers: usage[imageID], Children: []string{}}
+	if dependents.Containers == nil {
+		dependents.Containers = []ImageContainer{}
+	}
+	for id, parent := range parents {
+		if parent == imageID {
+			dependents.Children = append(dependents.Children, id)
+		}
+	}
+	return dependents, nil
+}
+
+// removesImage reports whether removing a reference removes the image
+// itself rather than just one of several tags
+func removesImage(reference string, image *ImageInspect, force bool) bool {
+	if force || len(image.RepoTags) <= 1 {
+		return true
+	}
+	id := strings.TrimPrefix(image.ID, "sha256:")
+	ref := strings.TrimPrefix(reference, "sha256:")
+	return strings.HasPrefix(id, ref)
+}
+
+// checkImageDependents writes a 409 listing the dependents of an image that
+// a delete would remove. It returns false if the delete must not proceed.
+func checkImageDependents(w http.ResponseWriter, r *http.Request, reference string, force bool) bool {
+	image, err := dockerInspectImageTyped(reference)
+	if err != nil {
+		writeFailure(w, "Failed to delete image", err)
+		return false
+	}
+	if !removesImage(reference, image, force) {
+		return true
+	}
+
+	dependents, err := imageDependents(r.Context(), image.ID)
+	if err != nil {
+		writeFailure(w, "Failed to check image dependents", err)
+		return false
+	}
+	if len(dependents.Containers) == 0 && len(dependents.Children) == 0 {
+		return true
+	}
+
+	var parts []string
+	if n := len(dependents.Containers); n > 0 {
+		names := make([]string, n)
+		for i, container := range dependents.Containers {
+			names[i] = container.Name + " (" + container.State + ")"
+		}
+		parts = append(parts, fmt.Sprintf("%d container(s): %s", n, strings.Join(names, ", ")))
+	}
+	if n := len(dependents.Children); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d child image(s)", n))
+	}
+	writeErrorDetails(w, http.StatusConflict, codeImageInUse,
+		"Image is used by "+strings.Join(parts, " and ")+"; pass override=true to delete it anyway", dependents)
+	return false
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PortBinding is a single host binding for a container port
+type PortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// HealthcheckConfig mirrors the engine's healthcheck settings
+type HealthcheckConfig struct {
+	Test        []string `json:"Test,omitempty"`
+	Interval    int64    `json:"Interval,omitempty"`
+	Timeout     int64    `json:"Timeout,omitempty"`
+	StartPeriod int64    `json:"StartPeriod,omitempty"`
+	Retries     int      `json:"Retries,omitempty"`
+}
+
+// ContainerConfig is the portable part of a container's configuration
+type ContainerConfig struct {
+	Hostname     string              `json:"Hostname"`
+	User         string              `json:"User"`
+	Env          []string            `json:"Env"`
+	Cmd          []string            `json:"Cmd"`
+	Entrypoint   []string            `json:"Entrypoint"`
+	Image        string              `json:"Image"`
+	WorkingDir   string              `json:"WorkingDir"`
+	Labels       map[string]string   `json:"Labels"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	Volumes      map[string]struct{} `json:"Volumes"`
+	Tty          bool                `json:"Tty"`
+	OpenStdin    bool                `json:"OpenStdin"`
+	StopSignal   string              `json:"StopSignal"`
+	Healthcheck  *HealthcheckConfig  `json:"Healthcheck"`
+}
+
+// HostConfig is the host-dependent part of a container's configuration
+type HostConfig struct {
+	Binds         []string                 `json:"Binds"`
+	PortBindings  map[string][]PortBinding `json:"PortBindings"`
+	NetworkMode   string                   `json:"NetworkMode"`
+	RestartPolicy struct {
+		Name              string `json:"Name"`
+		MaximumRetryCount int    `json:"MaximumRetryCount"`
+	} `json:"RestartPolicy"`
+	AutoRemove     bool     `json:"AutoRemove"`
+	Privileged     bool     `json:"Privileged"`
+	ReadonlyRootfs bool     `json:"ReadonlyRootfs"`
+	CapAdd         []string `json:"CapAdd"`
+	CapDrop        []string `json:"CapDrop"`
+	DNS            []string `json:"Dns"`
+	ExtraHosts     []string `json:"ExtraHosts"`
+	Memory         int64    `json:"Memory"`
+	NanoCPUs       int64    `json:"NanoCpus"`
+	ShmSize        int64    `json:"ShmSize"`
+	// Mounts are the mounts given with --mount, which Compose v2 uses for
+	// every volume
+	Mounts    []HostMount       `json:"Mounts"`
+	Tmpfs     map[string]string `json:"Tmpfs"`
+	Devices   []DeviceMapping   `json:"Devices"`
+	Ulimits   []Ulimit          `json:"Ulimits"`
+	LogConfig struct {
+		Type   string            `json:"Type"`
+		Config map[string]string `json:"Config"`
+	} `json:"LogConfig"`
+}
+
+// HostMount is a mount as requested at creation
+type HostMount struct {
+	Type        string `json:"Type"`
+	Source      string `json:"Source"`
+	Target      string `json:"Target"`
+	ReadOnly    bool   `json:"ReadOnly"`
+	BindOptions *struct {
+		Propagation  string `json:"Propagation"`
+		NonRecursive bool   `json:"NonRecursive"`
+	} `json:"BindOptions,omitempty"`
+	VolumeOptions *struct {
+		NoCopy       bool   `json:"NoCopy"`
+		Subpath      string `json:"Subpath"`
+		DriverConfig *struct {
+			Name string `json:"Name"`
+		} `json:"DriverConfig"`
+	} `json:"VolumeOptions,omitempty"`
+	TmpfsOptions *struct {
+		SizeBytes int64  `json:"SizeBytes"`
+		Mode      uint32 `json:"Mode"`
+	} `json:"TmpfsOptions,omitempty"`
+}
+
+// DeviceMapping is a host device made available to a container
+type DeviceMapping struct {
+	PathOnHost        string `json:"PathOnHost"`
+	PathInContainer   string `json:"PathInContainer"`
+	CgroupPermissions string `json:"CgroupPermissions"`
+}
+
+// Ulimit is a resource limit of a container's processes
+type Ulimit struct {
+	Name string `json:"Name"`
+	Soft int64  `json:"Soft"`
+	Hard int64  `json:"Hard"`
+}
+
+// MountPoint describes a mount attached to a container
+type MountPoint struct {
+	Type        string `json:"Type"`
+	Name        string `json:"Name,omitempty"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+	Driver      string `json:"Driver,omitempty"`
+	Mode        string `json:"Mode"`
+	RW          bool   `json:"RW"`
+}
+
+// ContainerState is the runtime state of a container
+type ContainerState struct {
+	Status     string `json:"Status"`
+	Running    bool   `json:"Running"`
+	Paused     bool   `json:"Paused"`
+	Restarting bool   `json:"Restarting"`
+	ExitCode   int    `json:"ExitCode"`
+	StartedAt  string `json:"StartedAt"`
+	FinishedAt string `json:"FinishedAt"`
+	Health     *struct {
+		Status string `json:"Status"`
+		Log    []struct {
+			ExitCode int    `json:"ExitCode"`
+			Output   string `json:"Output"`
+		} `json:"Log"`
+	} `json:"Health,omitempty"`
+}
+
+// ContainerInspect is the subset of `docker inspect` output used by DockMaster
+type ContainerInspect struct {
+	ID              string          `json:"Id"`
+	Name            string          `json:"Name"`
+	Created         string          `json:"Created"`
+	Image           string          `json:"Image"`
+	RestartCount    int             `json:"RestartCount"`
+	State           ContainerState  `json:"State"`
+	Config          ContainerConfig `json:"Config"`
+	HostConfig      HostConfig      `json:"HostConfig"`
+	Mounts          []MountPoint    `json:"Mounts"`
+	NetworkSettings struct {
+		Ports    map[string][]PortBinding `json:"Ports"`
+		Networks map[string]struct {
+			IPAddress string   `json:"IPAddress"`
+			Aliases   []string `json:"Aliases"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// VolumeInspect is the output of `docker volume inspect`
+type VolumeInspect struct {
+	Name       string                 `json:"Name"`
+	Driver     string                 `json:"Driver"`
+	Mountpoint string                 `json:"Mountpoint"`
+	CreatedAt  string                 `json:"CreatedAt"`
+	Status     map[string]interface{} `json:"Status,omitempty"`
+	Labels     map[string]string      `json:"Labels"`
+	Scope      string                 `json:"Scope"`
+	Options    map[string]string      `json:"Options"`
+}
+
+// ImageInspect is the subset of `docker image inspect` output used by DockMaster
+type ImageInspect struct {
+	ID           string          `json:"Id"`
+	RepoTags     []string        `json:"RepoTags"`
+	RepoDigests  []string        `json:"RepoDigests"`
+	Parent       string          `json:"Parent"`
+	Created      string          `json:"Created"`
+	Size         int64           `json:"Size"`
+	Architecture string          `json:"Architecture"`
+	Os           string          `json:"Os"`
+	Config       ContainerConfig `json:"Config"`
+	RootFS       struct {
+		Type   string   `json:"Type"`
+		Layers []string `json:"Layers"`
+	} `json:"RootFS"`
+}
+
+// dockerInspectContainer returns the typed inspect data of a container
+func dockerInspectContainer(containerID string) (*ContainerInspect, error) {
+	output, err := executeDockerCommand("container", "inspect", containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var inspection []ContainerInspect
+	if err := json.Unmarshal(output, &inspection); err != nil {
+		return nil, fmt.Errorf("failed to parse container inspect: %v", err)
+	}
+	if len(inspection) == 0 {
+		return nil, fmt.Errorf("container %s not found", containerID)
+	}
+
+	return &inspection[0], nil
+}
+
+// dockerInspectContainers inspects several containers in one call. If one of
+// them disappeared in the meantime the rest are inspected individually.
+func dockerInspectContainers(containerIDs ...string) ([]ContainerInspect, error) {
+	args := append([]string{"container", "inspect"}, containerIDs...)
+	output, err := executeDockerCommand(args...)
+	if err != nil {
+		var inspections []ContainerInspect
+		for _, id := range containerIDs {
+			if info, err := dockerInspectContainer(id); err == nil {
+				inspections = append(inspections, *info)
+			}
+		}
+		return inspections, nil
+	}
+
+	var inspections []ContainerInspect
+	if err := json.Unmarshal(output, &inspections); err != nil {
+		return nil, fmt.Errorf("failed to parse container inspect: %v", err)
+	}
+	return inspections, nil
+}
+
+// dockerInspectImages inspects several images in one docker call, falling
+// back to one call per image if any of them has disappeared meanwhile
+func dockerInspectImages(imageIDs ...string) ([]ImageInspect, error) {
+	if len(imageIDs) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"image", "inspect"}, imageIDs...)
+	output, err := executeDockerCommand(args...)
+	if err != nil {
+		var inspections []ImageInspect
+		for _, id := range imageIDs {
+			if info, err := dockerInspectImageTyped(id); err == nil {
+				inspections = append(inspections, *info)
+			}
+		}
+		return inspections, nil
+	}
+
+	var inspections []ImageInspect
+	if err := json.Unmarshal(output, &inspections); err != nil {
+		return nil, fmt.Errorf("failed to parse image inspect: %v", err)
+	}
+	return inspections, nil
+}
+
+// dockerInspectImageTyped returns the typed inspect data of an image
+func dockerInspectImageTyped(imageID string) (*ImageInspect, error) {
+	output, err := executeDockerCommand("image", "inspect", imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	var inspection []ImageInspect
+	if err := json.Unmarshal(output, &inspection); err != nil {
+		return nil, fmt.Errorf("failed to parse image inspect: %v", err)
+	}
+	if len(inspection) == 0 {
+		return nil, fmt.Errorf("image %s not found", imageID)
+	}
+
+	return &inspection[0], nil
+}
+
+// dockerInspectVolumes inspects several volumes in one docker call, falling
+// back to one call per volume if any of them has disappeared meanwhile
+func dockerInspectVolumes(names ...string) ([]VolumeInspect, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	output, err := executeDockerCommand(append([]string{"volume", "inspect"}, names...)...)
+	if err != nil {
+		if len(names) == 1 {
+			return nil, err
+		}
+		var inspections []VolumeInspect
+		for _, name := range names {
+			if info, err := dockerInspectVolumes(name); err == nil {
+				inspections = append(inspections, info...)
+			}
+		}
+		return inspections, nil
+	}
+
+	var inspections []VolumeInspect
+	if err := json.Unmarshal(output, &inspections); err != nil {
+		return nil, fmt.Errorf("failed to parse volume inspect: %v", err)
+	}
+	return inspections, nil
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+
+	// jobRetention is how long finished jobs and their artifacts are kept
+	jobRetention = 2 * time.Hour
+	// jobEventHistory is how many events are replayed to late subscribers
+	jobEventHistory = 500
+)
+
+// JobProgress reports how far a job has come. Total is zero when the amount
+// of work is not known in advance.
+type JobProgress struct {
+	Current int64   `json:"current"`
+	Total   int64   `json:"total"`
+	Percent float64 `json:"percent"`
+	Message string  `json:"message,omitempty"`
+}
+
+// JobEvent is emitted whenever a job makes progress or changes state
+type JobEvent struct {
+	JobID string      `json:"job_id"`
+	Type  string      `json:"type"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Job is a long-running operation executed in the background
+type Job struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Target     string      `json:"target"`
+	Status     string      `json:"status"`
+	Progress   JobProgress `json:"progress"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	CreatedBy  string      `json:"created_by,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+
+	mu          sync.Mutex
+	cancel      context.CancelFunc
+	events      []JobEvent
+	subscribers map[chan JobEvent]struct{}
+	artifact    string
+	artifactAs  string
+}
+
+// JobFunc performs the work of a job and returns its result
+type JobFunc func(ctx context.Context, job *Job) (interface{}, error)
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*Job)
+)
+
+// artifactDir is where jobs store files for later download
+func artifactDir() string {
+	return filepath.Join(dataDirectory, "artifacts")
+}
+
+func newJobID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// startJob registers a job and runs fn in the background
+func startJob(jobType, target, user string, fn JobFunc) *Job {
+	pruneJobs()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:          newJobID(),
+		Type:        jobType,
+		Target:      target,
+		Status:      JobPending,
+		CreatedBy:   user,
+		CreatedAt:   time.Now(),
+		cancel:      cancel,
+		subscribers: make(map[chan JobEvent]struct{}),
+	}
+
+	jobsMu.Lock()
+	jobs[job.ID] = job
+	jobsMu.Unlock()
+
+	go func() {
+		defer cancel()
+		job.setStatus(JobRunning, "")
+		log := logrus.WithFields(logrus.Fields{"job": job.ID, "type": jobType, "target": target})
+		log.Info("Job started")
+
+		result, err := fn(ctx, job)
+		switch {
+		case ctx.Err() == context.Canceled:
+			job.removeArtifact()
+			job.setStatus(JobCancelled, "cancelled by user")
+			log.Info("Job cancelled")
+		case err != nil:
+			job.removeArtifact()
+			job.setStatus(JobFailed, err.Error())
+			log.WithError(err).Error("Job failed")
+		default:
+			job.mu.Lock()
+			job.Result = result
+			job.mu.Unlock()
+			job.setStatus(JobSucceeded, "")
+			log.Info("Job finished")
+		}
+	}()
+
+	return job
+}
+
+// getJob looks up a job by ID
+func getJob(id string) (*Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[id]
+	return job, ok
+}
+
+// pruneJobs forgets finished jobs older than the retention period
+func pruneJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for id, job := range jobs {
+		job.mu.Lock()
+		expired := job.FinishedAt != nil && time.Since(*job.FinishedAt) > jobRetention
+		job.mu.Unlock()
+		if expired {
+			job.removeArtifact()
+			delete(jobs, id)
+		}
+	}
+}
+
+// Cancel stops a running job
+func (j *Job) Cancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Status != JobPending && j.Status != JobRunning {
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+// snapshot returns a copy of the job that is safe to serialize
+func (j *Job) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Job{
+		ID:         j.ID,
+		Type:       j.Type,
+		Target:     j.Target,
+		Status:     j.Status,
+		Progress:   j.Progress,
+		Result:     j.Result,
+		Error:      j.Error,
+		CreatedBy:  j.CreatedBy,
+		CreatedAt:  j.CreatedAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+	}
+}
+
+func (j *Job) setStatus(status, message string) {
+	j.mu.Lock()
+	now := time.Now()
+	j.Status = status
+	switch status {
+	case JobRunning:
+		j.StartedAt = &now
+	case JobSucceeded:
+		j.FinishedAt = &now
+		if j.Progress.Total > 0 {
+			j.Progress.Current = j.Progress.Total
+		}
+		j.Progress.Percent = 100
+	case JobFailed, JobCancelled:
+		j.FinishedAt = &now
+		j.Error = message
+	}
+	data := map[string]interface{}{"status": status}
+	if message != "" {
+		data["error"] = message
+	}
+	if status == JobSucceeded && j.Result != nil {
+		data["result"] = j.Result
+	}
+	j.mu.Unlock()
+
+	j.Emit("status", data)
+}
+
+// SetProgress records progress and notifies subscribers
+func (j *Job) SetProgress(current, total int64, message string) {
+	j.mu.Lock()
+	j.Progress.Current = current
+	j.Progress.Total = total
+	if total > 0 {
+		percent := float64(current) / float64(total) * 100
+		if percent > 100 {
+			percent = 100
+		}
+		j.Progress.Percent = percent
+	}
+	if message != "" {
+		j.Progress.Message = message
+	}
+	progress := j.Progress
+	j.mu.Unlock()
+
+	j.Emit("progress", progress)
+}
+
+// Emit sends an event to all subscribers of the job
+func (j *Job) Emit(eventType string, data interface{}) {
+	event := JobEvent{JobID: j.ID, Type: eventType, Time: time.Now(), Data: data}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events = append(j.events, event)
+	if len(j.events) > jobEventHistory {
+		j.events = j.events[len(j.events)-jobEventHistory:]
+	}
+	for ch := range j.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Slow subscribers miss intermediate events rather than stalling the job
+		}
+	}
+	if j.FinishedAt != nil {
+		for ch := range j.subscribers {
+			close(ch)
+		}
+		j.subscribers = make(map[chan JobEvent]struct{})
+	}
+}
+
+// Subscribe returns past events and a channel for new ones. The channel is
+// closed when the job finishes.
+func (j *Job) Subscribe() ([]JobEvent, chan JobEvent, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	history := append([]JobEvent(nil), j.events...)
+	ch := make(chan JobEvent, 64)
+	if j.FinishedAt != nil {
+		close(ch)
+		return history, ch, func() {}
+	}
+	j.subscribers[ch] = struct{}{}
+
+	return history, ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.subscribers[ch]; ok {
+			delete(j.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// CreateArtifact creates the file a job writes its downloadable output to
+func (j *Job) CreateArtifact(downloadName string) (*os.File, error) {
+	if err := os.MkdirAll(artifactDir(), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(artifactDir(), j.ID))
+	if err != nil {
+		return nil, err
+	}
+	j.mu.Lock()
+	j.artifact = file.Name()
+	j.artifactAs = downloadName
+	j.mu.Unlock()
+	return file, nil
+}
+
+func (j *Job) removeArtifact() {
+	j.mu.Lock()
+	artifact := j.artifact
+	j.artifact = ""
+	j.mu.Unlock()
+	if artifact != "" {
+		os.Remove(artifact)
+	}
+}
+
+// progressWriter reports bytes written to a job as progress
+type progressWriter struct {
+	job     *Job
+	total   int64
+	written int64
+	message string
+	last    time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if time.Since(p.last) > 500*time.Millisecond {
+		p.last = time.Now()
+		p.job.SetProgress(p.written, p.total, p.message)
+	}
+	return len(b), nil
+}
+
+// writeJobAccepted responds with the job that was started for a request
+func writeJobAccepted(w http.ResponseWriter, job *Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	snapshot := job.snapshot()
+	json.NewEncoder(w).Encode(&snapshot)
+}
+
+// writeSSE writes one server-sent event and flushes it
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// listJobs returns all known jobs, newest first
+func listJobs(w http.ResponseWriter, r *http.Request) {
+	jobType := r.URL.Query().Get("type")
+
+	jobsMu.Lock()
+	list := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if jobType != "" && job.Type != jobType {
+			continue
+		}
+		list = append(list, job.snapshot())
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// getJobHandler returns the current state of a job
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJob(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	snapshot := job.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&snapshot)
+}
+
+// cancelJob cancels a running job
+func cancelJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	job, ok := getJob(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	if !job.Cancel() {
+		writeError(w, http.StatusConflict, "Job has already finished")
+		return
+	}
+
+	logrus.WithField("job", id).Info("Job cancellation requested")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Job cancellation requested"})
+}
+
+// streamJobEvents streams a job's events as server-sent events until it finishes
+func streamJobEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJob(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	disableTimeouts(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	history, events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	snapshot := job.snapshot()
+	if err := writeSSE(w, flusher, "snapshot", &snapshot); err != nil {
+		return
+	}
+	for _, event := range history {
+		if err := writeSSE(w, flusher, event.Type, event); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				snapshot := job.snapshot()
+				writeSSE(w, flusher, "done", &snapshot)
+				return
+			}
+			if err := writeSSE(w, flusher, event.Type, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// downloadJobArtifact streams the file produced by a finished job
+func downloadJobArtifact(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJob(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	job.mu.Lock()
+	status, artifact, name := job.Status, job.artifact, job.artifactAs
+	job.mu.Unlock()
+
+	if status != JobSucceeded {
+		writeError(w, http.StatusConflict, "Job has not finished successfully")
+		return
+	}
+	if artifact == "" {
+		writeError(w, http.StatusNotFound, "Job has no downloadable artifact")
+		return
+	}
+
+	file, err := os.Open(artifact)
+	if err != nil {
+		writeError(w, http.StatusGone, "Artifact is no longer available")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeFailure(w, "Failed to read artifact", err)
+		return
+	}
+
+	disableTimeouts(w)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// ContainerSummary is a container as returned by GET /containers
+type ContainerSummary struct {
+	ID         string            `json:"Id"`
+	Names      []string          `json:"Names"`
+	Image      string            `json:"Image"`
+	Command    string            `json:"Command"`
+	Created    int64             `json:"Created"`
+	State      string            `json:"State"`
+	Status     string            `json:"Status"`
+	Ports      []PortSummary     `json:"Ports"`
+	PortGroups []PortGroup       `json:"PortGroups"`
+	Labels     map[string]string `json:"Labels"`
+	Mounts     []string          `json:"Mounts"`
+	// UpdateAvailable is set when the container's image tag has a newer image
+	UpdateAvailable bool `json:"update_available"`
+}
+
+// ImageSummary is an image as returned by GET /images
+type ImageSummary struct {
+	ID       string           `json:"Id"`
+	RepoTags []string         `json:"RepoTags"`
+	Created  int64            `json:"Created"`
+	Size     int64            `json:"Size"`
+	Dangling bool             `json:"Dangling"`
+	UsedBy   []ImageContainer `json:"UsedBy"`
+	ParentID string           `json:"ParentId"`
+	Children []string         `json:"Children"`
+	// UpdateAvailable is set when the registry has a newer image for a tag
+	UpdateAvailable bool `json:"update_available"`
+}
+
+// VolumeSummary is a volume as returned by GET /volumes
+type VolumeSummary struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver"`
+	Mountpoint string            `json:"Mountpoint"`
+	CreatedAt  string            `json:"CreatedAt"`
+	Scope      string            `json:"Scope"`
+	Labels     map[string]string `json:"Labels"`
+	Options    map[string]string `json:"Options"`
+	// Size and RefCount are only measured with ?size=true
+	Size     *int64 `json:"Size,omitempty"`
+	RefCount *int64 `json:"RefCount,omitempty"`
+}
+
+// NetworkSummary is a network as returned by GET /networks
+type NetworkSummary struct {
+	ID         string                 `json:"Id"`
+	Name       string                 `json:"Name"`
+	Created    string                 `json:"Created"`
+	Scope      string                 `json:"Scope"`
+	Driver     string                 `json:"Driver"`
+	EnableIPv6 bool                   `json:"EnableIPv6"`
+	Internal   bool                   `json:"Internal"`
+	Attachable bool                   `json:"Attachable"`
+	Ingress    bool                   `json:"Ingress"`
+	ConfigOnly bool                   `json:"ConfigOnly"`
+	Containers map[string]interface{} `json:"Containers"`
+	Options    map[string]string      `json:"Options"`
+	Labels     map[string]string      `json:"Labels"`
+}
+
+// ListParams are the sorting and pagination parameters of a list request
+type ListParams struct {
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+// ListResponse is the envelope returned by all list endpoints
+type ListResponse[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	Count      int    `json:"count"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listCursor is the decoded form of an opaque pagination cursor
+type listCursor struct {
+	ID     string `json:"id"`
+	Offset int    `json:"offset"`
+}
+
+// sortFunc orders two items of a list by one field
+type sortFunc[T any] func(a, b T) int
+
+// parseListParams reads sort, order, limit, offset and cursor query parameters
+func parseListParams(query url.Values, sortable []string, defaultSort string) (ListParams, error) {
+	params := ListParams{Sort: defaultSort, Limit: defaultPageLimit}
+
+	if sortBy := query.Get("sort"); sortBy != "" {
+		if strings.HasPrefix(sortBy, "-") {
+			params.Desc = true
+			sortBy = sortBy[1:]
+		}
+		if !containsString(sortable, sortBy) {
+			return params, fmt.Errorf("unsupported sort key %q, use one of %s", sortBy, strings.Join(sortable, ", "))
+		}
+		params.Sort = sortBy
+	}
+	switch strings.ToLower(query.Get("order")) {
+	case "", "asc":
+	case "desc":
+		params.Desc = true
+	default:
+		return params, fmt.Errorf("order must be asc or desc")
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return params, fmt.Errorf("invalid limit")
+		}
+		// limit=0 returns every item; other limits are capped
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		params.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return params, fmt.Errorf("invalid offset")
+		}
+		params.Offset = offset
+	}
+	params.Cursor = query.Get("cursor")
+
+	return params, nil
+}
+
+// paginate sorts items and returns the requested page in a list envelope.
+// Cursors point just after the last item of the previous page, so pages stay
+// stable when items before the cursor disappear.
+func paginate[T any](items []T, params ListParams, sorters map[string]sortFunc[T], idOf func(T) string) (ListResponse[T], error) {
+	if less, ok := sorters[params.Sort]; ok {
+		sort.SliceStable(items, func(i, j int) bool {
+			cmp := less(items[i], items[j])
+			if cmp == 0 {
+				cmp = strings.Compare(idOf(items[i]), idOf(items[j]))
+			}
+			if params.Desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	start := params.Offset
+	if params.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(params.Cursor)
+		if err != nil {
+			return ListResponse[T]{}, fmt.Errorf("invalid cursor")
+		}
+		var cursor listCursor
+		if err := json.Unmarshal(raw, &cursor); err != nil {
+			return ListResponse[T]{}, fmt.Errorf("invalid cursor")
+		}
+		start = cursor.Offset
+		for i, item := range items {
+			if idOf(item) == cursor.ID {
+				start = i + 1
+				break
+			}
+		}
+	}
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + params.Limit
+	if params.Limit == 0 || end > len(items) {
+		end = len(items)
+	}
+
+	page := ListResponse[T]{
+		Items:  append([]T{}, items[start:end]...),
+		Total:  len(items),
+		Offset: start,
+		Limit:  params.Limit,
+	}
+	page.Count = len(page.Items)
+	if end < len(items) && end > start {
+		raw, _ := json.Marshal(listCursor{ID: idOf(items[end-1]), Offset: end})
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	return page, nil
+}
+
+// dockerFilterArgs turns query parameters into docker --filter arguments
+func dockerFilterArgs(query url.Values, keys ...string) []string {
+	args := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			if value != "" {
+				args = append(args, "--filter", key+"="+value)
+			}
+		}
+	}
+	return args
+}
+
+// parseLabelString parses the "k=v,k2=v2" label format of the docker CLI
+func parseLabelString(raw string) map[string]string {
+	labels := map[string]string{}
+	if raw == "" {
+		return labels
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		if key != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func firstName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+func firstTag(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return tags[0]
+}
+
+var containerSorters = map[string]sortFunc[ContainerSummary]{
+	"name":    func(a, b ContainerSummary) int { return strings.Compare(firstName(a.Names), firstName(b.Names)) },
+	"image":   func(a, b ContainerSummary) int { return strings.Compare(a.Image, b.Image) },
+	"state":   func(a, b ContainerSummary) int { return strings.Compare(a.State, b.State) },
+	"created": func(a, b ContainerSummary) int { return compareInt64(a.Created, b.Created) },
+}
+
+var imageSorters = map[string]sortFunc[ImageSummary]{
+	"tag":     func(a, b ImageSummary) int { return strings.Compare(firstTag(a.RepoTags), firstTag(b.RepoTags)) },
+	"size":    func(a, b ImageSummary) int { return compareInt64(a.Size, b.Size) },
+	"created": func(a, b ImageSummary) int { return compareInt64(a.Created, b.Created) },
+}
+
+var volumeSorters = map[string]sortFunc[VolumeSummary]{
+	"name":    func(a, b VolumeSummary) int { return strings.Compare(a.Name, b.Name) },
+	"driver":  func(a, b VolumeSummary) int { return strings.Compare(a.Driver, b.Driver) },
+	"scope":   func(a, b VolumeSummary) int { return strings.Compare(a.Scope, b.Scope) },
+	"created": func(a, b VolumeSummary) int { return strings.Compare(a.CreatedAt, b.CreatedAt) },
+	"size":    func(a, b VolumeSummary) int { return compareInt64(volumeSize(a), volumeSize(b)) },
+}
+
+// volumeSize orders unmeasured volumes before empty ones
+func volumeSize(v VolumeSummary) int64 {
+	if v.Size == nil {
+		return -1
+	}
+	return *v.Size
+}
+
+var networkSorters = map[string]sortFunc[NetworkSummary]{
+	"name":    func(a, b NetworkSummary) int { return strings.Compare(a.Name, b.Name) },
+	"driver":  func(a, b NetworkSummary) int { return strings.Compare(a.Driver, b.Driver) },
+	"scope":   func(a, b NetworkSummary) int { return strings.Compare(a.Scope, b.Scope) },
+	"created": func(a, b NetworkSummary) int { return strings.Compare(a.Created, b.Created) },
+}
+
+func sortKeys[T any](sorters map[string]sortFunc[T]) []string {
+	keys := make([]string, 0, len(sorters))
+	for key := range sorters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeListResponse sorts, paginates and writes a list envelope
+func writeListResponse[T any](w http.ResponseWriter, r *http.Request, items []T, sorters map[string]sortFunc[T], defaultSort string, idOf func(T) string) {
+	params, err := parseListParams(r.URL.Query(), sortKeys(sorters), defaultSort)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := paginate(items, params, sorters, idOf)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	json.NewEncoder(w).Encode(page)
+}
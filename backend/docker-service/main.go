@@ -17,18 +17,48 @@ import (
 )
 
 type RunContainerRequest struct {
-	Image        string            `json:"image"`
-	Name         string            `json:"name,omitempty"`
-	Ports        map[string]string `json:"ports,omitempty"`
-	Environment  []string          `json:"environment,omitempty"`
-	Volumes      []string          `json:"volumes,omitempty"`
-	Command      []string          `json:"command,omitempty"`
-	WorkingDir   string            `json:"working_dir,omitempty"`
-	RestartPolicy string           `json:"restart_policy,omitempty"`
+	Image          string            `json:"image"`
+	Name           string            `json:"name,omitempty"`
+	Ports          PortMappings      `json:"ports,omitempty"`
+	Environment    []string          `json:"environment,omitempty"`
+	Volumes        []string          `json:"volumes,omitempty"`
+	Command        []string          `json:"command,omitempty"`
+	Entrypoint     []string          `json:"entrypoint,omitempty"`
+	WorkingDir     string            `json:"working_dir,omitempty"`
+	RestartPolicy  string            `json:"restart_policy,omitempty"`
+	User           string            `json:"user,omitempty"`
+	Hostname       string            `json:"hostname,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Network        string            `json:"network,omitempty"`
+	Privileged     bool              `json:"privileged,omitempty"`
+	ReadOnly       bool              `json:"read_only,omitempty"`
+	AutoRemove     bool              `json:"auto_remove,omitempty"`
+	Tty            bool              `json:"tty,omitempty"`
+	Interactive    bool              `json:"interactive,omitempty"`
+	CapAdd         []string          `json:"cap_add,omitempty"`
+	CapDrop        []string          `json:"cap_drop,omitempty"`
+	DNS            []string          `json:"dns,omitempty"`
+	ExtraHosts     []string          `json:"extra_hosts,omitempty"`
+	Memory         int64             `json:"memory,omitempty"`
+	CPUs           float64           `json:"cpus,omitempty"`
+	HealthCmd      string            `json:"health_cmd,omitempty"`
+	HealthInterval string            `json:"health_interval,omitempty"`
+	HealthTimeout  string            `json:"health_timeout,omitempty"`
+	HealthRetries  int               `json:"health_retries,omitempty"`
+	StopSignal     string            `json:"stop_signal,omitempty"`
+	NoHealthcheck  bool              `json:"no_healthcheck,omitempty"`
+	ShmSize        int64             `json:"shm_size,omitempty"`
+	Tmpfs          []string          `json:"tmpfs,omitempty"`
+	Devices        []string          `json:"devices,omitempty"`
+	Ulimits        []string          `json:"ulimits,omitempty"`
+	Expose         []string          `json:"expose,omitempty"`
+	LogDriver      string            `json:"log_driver,omitempty"`
+	LogOptions     map[string]string `json:"log_options,omitempty"`
 }
 
 type PullImageRequest struct {
-	Image string `json:"image"`
+	Image    string `json:"image"`
+	Platform string `json:"platform,omitempty"`
 }
 
 type SearchResponse struct {
@@ -70,10 +100,24 @@ func main() {
 	// Initialize authentication
 	initAuth()
 
+	// Run cleanup policies on their schedules
+	startCleanupScheduler()
+
+	// Check local images for newer registry digests
+	startUpdateChecker()
+
+	// Recreate opted-in containers when their images change
+	startAutoUpdater()
+
+	// Back up volumes on their schedules
+	startBackupScheduler()
+
 	logrus.Info("Docker service starting...")
 
 	// Setup router
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
 	setupRoutes(router)
 
 	// Get port from environment variable or use default
@@ -93,7 +137,7 @@ func main() {
 	// Create server
 	srv := &http.Server{
 		Addr:         ":" + port,
-		Handler:      c.Handler(router),
+		Handler:      c.Handler(requestIDMiddleware(router)),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -161,6 +205,18 @@ func loadEnvFile() {
 	}
 }
 
+// disableTimeouts lifts the server read and write timeouts for a single
+// request, for transfers that legitimately outlive them
+func disableTimeouts(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		logrus.WithError(err).Debug("Failed to clear read deadline")
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logrus.WithError(err).Debug("Failed to clear write deadline")
+	}
+}
+
 func setupRoutes(router *mux.Router) {
 	// Public routes (no auth required)
 	router.HandleFunc("/health", healthCheck).Methods("GET")
@@ -174,31 +230,128 @@ func setupRoutes(router *mux.Router) {
 	// Container routes
 	router.HandleFunc("/containers", authMiddleware(listContainers)).Methods("GET")
 	router.HandleFunc("/containers/run", authMiddleware(runContainer)).Methods("POST")
+	router.HandleFunc("/containers/bulk", authMiddleware(bulkContainers)).Methods("POST")
+	router.HandleFunc("/containers/import-run", authMiddleware(importRunCommand)).Methods("POST")
+	router.HandleFunc("/containers/updates", authMiddleware(listContainerUpdates)).Methods("GET")
+	router.HandleFunc("/containers/updates/run", authMiddleware(adminMiddleware(runAutoUpdatesNow))).Methods("POST")
 	router.HandleFunc("/containers/{id}/start", authMiddleware(startContainer)).Methods("POST")
 	router.HandleFunc("/containers/{id}/stop", authMiddleware(stopContainer)).Methods("POST")
 	router.HandleFunc("/containers/{id}/restart", authMiddleware(restartContainer)).Methods("POST")
 	router.HandleFunc("/containers/{id}", authMiddleware(deleteContainer)).Methods("DELETE")
 	router.HandleFunc("/containers/{id}/stats", authMiddleware(getContainerStats)).Methods("GET")
 	router.HandleFunc("/containers/{id}/logs", authMiddleware(getContainerLogs)).Methods("GET")
+	router.HandleFunc("/containers/{id}/top", authMiddleware(getContainerTop)).Methods("GET")
+	router.HandleFunc("/containers/{id}/changes", authMiddleware(getContainerChanges)).Methods("GET")
+	router.HandleFunc("/containers/{id}/files", authMiddleware(listContainerFiles)).Methods("GET")
+	router.HandleFunc("/containers/{id}/files", authMiddleware(deleteContainerFile)).Methods("DELETE")
+	router.HandleFunc("/containers/{id}/files/stat", authMiddleware(statContainerFile)).Methods("GET")
+	router.HandleFunc("/containers/{id}/files/download", authMiddleware(downloadContainerFile)).Methods("GET")
+	router.HandleFunc("/containers/{id}/files/upload", authMiddleware(uploadContainerFiles)).Methods("POST")
+	router.HandleFunc("/containers/{id}/files/rename", authMiddleware(renameContainerFile)).Methods("POST")
+	router.HandleFunc("/containers/{id}/commit", authMiddleware(commitContainer)).Methods("POST")
+	router.HandleFunc("/containers/{id}/export", authMiddleware(exportContainerFilesystem)).Methods("POST")
+	router.HandleFunc("/containers/{id}/clone", authMiddleware(cloneContainer)).Methods("POST")
+	router.HandleFunc("/containers/{id}/update", authMiddleware(updateContainerNow)).Methods("POST")
+	router.HandleFunc("/containers/{id}/export/{format}", authMiddleware(exportContainerConfig)).Methods("GET")
 
 	// Image routes
 	router.HandleFunc("/images", authMiddleware(listImages)).Methods("GET")
 	router.HandleFunc("/images/search", authMiddleware(searchImages)).Methods("GET")
+	router.HandleFunc("/images/usage", authMiddleware(getImageUsage)).Methods("GET")
+	router.HandleFunc("/images/updates", authMiddleware(listImageUpdates)).Methods("GET")
+	router.HandleFunc("/images/updates/check", authMiddleware(checkImageUpdates)).Methods("POST")
 	router.HandleFunc("/images/pull", authMiddleware(pullImage)).Methods("POST")
+	router.HandleFunc("/images/build", authMiddleware(buildImage)).Methods("POST")
+	router.HandleFunc("/images/builds", authMiddleware(listBuilds)).Methods("GET")
+	router.HandleFunc("/images/builds/{id}", authMiddleware(getBuild)).Methods("GET")
+	router.HandleFunc("/images/push", authMiddleware(pushImage)).Methods("POST")
+	router.HandleFunc("/images/promote", authMiddleware(promoteImage)).Methods("POST")
+	router.HandleFunc("/images/tag", authMiddleware(tagImage)).Methods("POST")
+	router.HandleFunc("/images/save", queryTokenMiddleware(authMiddleware(saveImages))).Methods("GET")
+	router.HandleFunc("/images/load", authMiddleware(loadImages)).Methods("POST")
+	router.HandleFunc("/images/untag", authMiddleware(untagImage)).Methods("POST")
 	router.HandleFunc("/images/{id}", authMiddleware(deleteImage)).Methods("DELETE")
 	router.HandleFunc("/images/{id}/inspect", authMiddleware(inspectImage)).Methods("GET")
+	router.HandleFunc("/images/{id}/history", authMiddleware(getImageHistory)).Methods("GET")
+	router.HandleFunc("/images/{id}/sbom", authMiddleware(getImageSBOM)).Methods("GET")
+	router.HandleFunc("/images/{id}/vulnerabilities", authMiddleware(getImageVulnerabilities)).Methods("GET")
+
+	// Vulnerability database routes
+	router.HandleFunc("/vulnerabilities", authMiddleware(getVulnerabilityDatabase)).Methods("GET")
+	router.HandleFunc("/vulnerabilities", authMiddleware(adminMiddleware(clearVulnerabilityDatabase))).Methods("DELETE")
+	router.HandleFunc("/vulnerabilities/import", authMiddleware(adminMiddleware(importVulnerabilities))).Methods("POST")
 
 	// Volume routes
 	router.HandleFunc("/volumes", authMiddleware(listVolumes)).Methods("GET")
+	router.HandleFunc("/volumes", authMiddleware(createVolume)).Methods("POST")
+	router.HandleFunc("/volume-backups", authMiddleware(listVolumeBackups)).Methods("GET")
+	router.HandleFunc("/volume-backups/{id}", authMiddleware(adminMiddleware(deleteVolumeBackup))).Methods("DELETE")
+	router.HandleFunc("/volume-backups/{id}/download", queryTokenMiddleware(authMiddleware(downloadVolumeBackup))).Methods("GET")
+	router.HandleFunc("/volume-backups/{id}/restore", authMiddleware(restoreVolumeBackup)).Methods("POST")
+	router.HandleFunc("/volume-backups/{id}/verify", authMiddleware(verifyVolumeBackup)).Methods("POST")
+	router.HandleFunc("/volume-backup-targets", authMiddleware(listBackupTargets)).Methods("GET")
+	router.HandleFunc("/volume-backup-targets", authMiddleware(adminMiddleware(createBackupTarget))).Methods("POST")
+	router.HandleFunc("/volume-backup-targets/{id}", authMiddleware(getBackupTarget)).Methods("GET")
+	router.HandleFunc("/volume-backup-targets/{id}", authMiddleware(adminMiddleware(updateBackupTarget))).Methods("PUT")
+	router.HandleFunc("/volume-backup-targets/{id}", authMiddleware(adminMiddleware(deleteBackupTarget))).Methods("DELETE")
+	router.HandleFunc("/volume-backup-targets/{id}/test", authMiddleware(adminMiddleware(testBackupTarget))).Methods("POST")
+	router.HandleFunc("/volume-backup-schedules", authMiddleware(listBackupSchedules)).Methods("GET")
+	router.HandleFunc("/volume-backup-schedules", authMiddleware(adminMiddleware(createBackupSchedule))).Methods("POST")
+	router.HandleFunc("/volume-backup-schedules/{id}", authMiddleware(getBackupSchedule)).Methods("GET")
+	router.HandleFunc("/volume-backup-schedules/{id}", authMiddleware(adminMiddleware(updateBackupSchedule))).Methods("PUT")
+	router.HandleFunc("/volume-backup-schedules/{id}", authMiddleware(adminMiddleware(deleteBackupSchedule))).Methods("DELETE")
+	router.HandleFunc("/volume-backup-schedules/{id}/run", authMiddleware(adminMiddleware(runBackupScheduleNow))).Methods("POST")
+	router.HandleFunc("/volume-backup-schedules/{id}/runs", authMiddleware(listBackupRuns)).Methods("GET")
+	router.HandleFunc("/volumes/{name}", authMiddleware(getVolume)).Methods("GET")
 	router.HandleFunc("/volumes/{name}", authMiddleware(deleteVolume)).Methods("DELETE")
+	router.HandleFunc("/volumes/{name}/backup", queryTokenMiddleware(authMiddleware(backupVolume))).Methods("GET")
+	router.HandleFunc("/volumes/{name}/backups", authMiddleware(createVolumeBackup)).Methods("POST")
+	router.HandleFunc("/volumes/{name}/restore", authMiddleware(restoreVolumeUpload)).Methods("POST")
 
 	// Network routes
 	router.HandleFunc("/networks", authMiddleware(listNetworks)).Methods("GET")
 	router.HandleFunc("/networks/{id}", authMiddleware(deleteNetwork)).Methods("DELETE")
 
+	// Registry routes
+	router.HandleFunc("/registries", authMiddleware(listRegistries)).Methods("GET")
+	router.HandleFunc("/registries", authMiddleware(adminMiddleware(createRegistry))).Methods("POST")
+	router.HandleFunc("/registries/test", authMiddleware(testRegistryCredentials)).Methods("POST")
+	router.HandleFunc("/registries/{id}", authMiddleware(getRegistry)).Methods("GET")
+	router.HandleFunc("/registries/{id}", authMiddleware(adminMiddleware(updateRegistry))).Methods("PUT")
+	router.HandleFunc("/registries/{id}", authMiddleware(adminMiddleware(deleteRegistry))).Methods("DELETE")
+	router.HandleFunc("/registries/{id}/test", authMiddleware(testRegistry)).Methods("POST")
+
+	// Background job routes
+	router.HandleFunc("/jobs", authMiddleware(listJobs)).Methods("GET")
+	router.HandleFunc("/jobs/{id}", authMiddleware(getJobHandler)).Methods("GET")
+	router.HandleFunc("/jobs/{id}/events", queryTokenMiddleware(authMiddleware(streamJobEvents))).Methods("GET")
+	router.HandleFunc("/jobs/{id}/cancel", authMiddleware(cancelJob)).Methods("POST")
+	router.HandleFunc("/jobs/{id}/download", queryTokenMiddleware(authMiddleware(downloadJobArtifact))).Methods("GET")
+
+	// Audit log
+	router.HandleFunc("/audit", authMiddleware(listAuditLog)).Methods("GET")
+
+	// Notifications
+	router.HandleFunc("/notifications", authMiddleware(listNotifications)).Methods("GET")
+	router.HandleFunc("/notifications/read", authMiddleware(markNotificationsRead)).Methods("POST")
+
 	// System info and metrics
 	router.HandleFunc("/system/info", authMiddleware(getSystemInfo)).Methods("GET")
 	router.HandleFunc("/system/metrics", authMiddleware(getSystemMetrics)).Methods("GET")
+	router.HandleFunc("/system/ports/next", authMiddleware(suggestHostPort)).Methods("GET")
+	router.HandleFunc("/system/df", authMiddleware(getDiskUsage)).Methods("GET")
+	router.HandleFunc("/system/prune/{type}", authMiddleware(adminUnlessDryRunMiddleware(pruneResources))).Methods("POST")
+
+	// Cleanup policies; changing and running them is restricted to admins
+	router.HandleFunc("/cleanup/policies", authMiddleware(listCleanupPolicies)).Methods("GET")
+	router.HandleFunc("/cleanup/policies", authMiddleware(adminMiddleware(createCleanupPolicy))).Methods("POST")
+	router.HandleFunc("/cleanup/policies/preview", authMiddleware(adminMiddleware(previewCleanupPolicyDraft))).Methods("POST")
+	router.HandleFunc("/cleanup/policies/{id}", authMiddleware(getCleanupPolicy)).Methods("GET")
+	router.HandleFunc("/cleanup/policies/{id}", authMiddleware(adminMiddleware(updateCleanupPolicy))).Methods("PUT")
+	router.HandleFunc("/cleanup/policies/{id}", authMiddleware(adminMiddleware(deleteCleanupPolicy))).Methods("DELETE")
+	router.HandleFunc("/cleanup/policies/{id}/preview", authMiddleware(adminMiddleware(previewCleanupPolicy))).Methods("POST")
+	router.HandleFunc("/cleanup/policies/{id}/run", authMiddleware(adminMiddleware(runCleanupPolicy))).Methods("POST")
+	router.HandleFunc("/cleanup/policies/{id}/runs", authMiddleware(listCleanupRuns)).Methods("GET")
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
@@ -211,18 +364,23 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 
 // Real Docker API implementations
 func listContainers(w http.ResponseWriter, r *http.Request) {
-	all := r.URL.Query().Get("all") == "true"
-	
-	containers, err := getRealContainers(all)
+	query := r.URL.Query()
+	all := query.Get("all") == "true"
+	filters := dockerFilterArgs(query, "name", "status", "label", "ancestor", "id", "network", "health")
+
+	containers, err := getRealContainers(all, filters...)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get containers")
-		http.Error(w, "Failed to get containers: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get containers", err)
 		return
 	}
 
+	if err := annotateContainerUpdates(r.Context(), containers); err != nil {
+		logrus.WithError(err).Warn("Failed to annotate containers with image updates")
+	}
+
 	logrus.WithField("count", len(containers)).Info("Listed containers")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(containers)
+	writeListResponse(w, r, containers, containerSorters, "name", func(c ContainerSummary) string { return c.ID })
 }
 
 func startContainer(w http.ResponseWriter, r *http.Request) {
@@ -231,7 +389,7 @@ func startContainer(w http.ResponseWriter, r *http.Request) {
 
 	if err := dockerStart(id); err != nil {
 		logrus.WithError(err).WithField("container", id).Error("Failed to start container")
-		http.Error(w, "Failed to start container: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to start container", err)
 		return
 	}
 
@@ -246,7 +404,7 @@ func stopContainer(w http.ResponseWriter, r *http.Request) {
 
 	if err := dockerStop(id); err != nil {
 		logrus.WithError(err).WithField("container", id).Error("Failed to stop container")
-		http.Error(w, "Failed to stop container: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to stop container", err)
 		return
 	}
 
@@ -261,7 +419,7 @@ func restartContainer(w http.ResponseWriter, r *http.Request) {
 
 	if err := dockerRestart(id); err != nil {
 		logrus.WithError(err).WithField("container", id).Error("Failed to restart container")
-		http.Error(w, "Failed to restart container: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to restart container", err)
 		return
 	}
 
@@ -277,7 +435,7 @@ func deleteContainer(w http.ResponseWriter, r *http.Request) {
 
 	if err := dockerRemove(id, force); err != nil {
 		logrus.WithError(err).WithField("container", id).Error("Failed to delete container")
-		http.Error(w, "Failed to delete container: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to delete container", err)
 		return
 	}
 
@@ -293,7 +451,7 @@ func getContainerStats(w http.ResponseWriter, r *http.Request) {
 	stats, err := getRealContainerStats(id)
 	if err != nil {
 		logrus.WithError(err).WithField("container", id).Error("Failed to get container stats")
-		http.Error(w, "Failed to get container stats: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get container stats", err)
 		return
 	}
 
@@ -312,7 +470,7 @@ func getContainerLogs(w http.ResponseWriter, r *http.Request) {
 	logs, err := dockerLogs(id, tail)
 	if err != nil {
 		logrus.WithError(err).WithField("container", id).Error("Failed to get container logs")
-		http.Error(w, "Failed to get container logs: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get container logs", err)
 		return
 	}
 
@@ -321,16 +479,22 @@ func getContainerLogs(w http.ResponseWriter, r *http.Request) {
 }
 
 func listImages(w http.ResponseWriter, r *http.Request) {
-	images, err := getRealImages()
+	filters := dockerFilterArgs(r.URL.Query(), "reference", "label", "dangling", "before", "since")
+
+	images, err := getRealImages(filters...)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get images")
-		http.Error(w, "Failed to get images: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get images", err)
 		return
 	}
 
+	if err := annotateImages(r.Context(), images); err != nil {
+		logrus.WithError(err).Warn("Failed to annotate images with their dependents")
+	}
+	annotateImageUpdates(images)
+
 	logrus.WithField("count", len(images)).Info("Listed images")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(images)
+	writeListResponse(w, r, images, imageSorters, "tag", func(i ImageSummary) string { return i.ID })
 }
 
 func deleteImage(w http.ResponseWriter, r *http.Request) {
@@ -338,28 +502,45 @@ func deleteImage(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	force := r.URL.Query().Get("force") == "true"
 
+	// Containers and child images are protected unless explicitly overridden
+	if r.URL.Query().Get("override") != "true" && !checkImageDependents(w, r, id, force) {
+		return
+	}
+
 	if err := dockerRemoveImage(id, force); err != nil {
 		logrus.WithError(err).WithField("image", id).Error("Failed to delete image")
-		http.Error(w, "Failed to delete image: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to delete image", err)
 		return
 	}
 
+	recordAudit(r.Header.Get("X-User"), "images.delete", id, "success", map[string]interface{}{
+		"force":    force,
+		"override": r.URL.Query().Get("override") == "true",
+	})
 	logrus.WithField("image", id).Info("Image deleted")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Image deleted successfully"})
 }
 
 func listVolumes(w http.ResponseWriter, r *http.Request) {
-	volumes, err := getRealVolumes()
+	filters := dockerFilterArgs(r.URL.Query(), "name", "label", "driver", "dangling")
+
+	volumes, err := getRealVolumes(filters...)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get volumes")
-		http.Error(w, "Failed to get volumes: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get volumes", err)
 		return
 	}
+	if r.URL.Query().Get("size") == "true" {
+		if err := addVolumeSizes(r.Context(), volumes); err != nil {
+			logrus.WithError(err).Error("Failed to measure volume sizes")
+			writeFailure(w, "Failed to measure volume sizes", err)
+			return
+		}
+	}
 
 	logrus.WithField("count", len(volumes)).Info("Listed volumes")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(volumes)
+	writeListResponse(w, r, volumes, volumeSorters, "name", func(v VolumeSummary) string { return v.Name })
 }
 
 func deleteVolume(w http.ResponseWriter, r *http.Request) {
@@ -369,7 +550,7 @@ func deleteVolume(w http.ResponseWriter, r *http.Request) {
 
 	if err := dockerRemoveVolume(name, force); err != nil {
 		logrus.WithError(err).WithField("volume", name).Error("Failed to delete volume")
-		http.Error(w, "Failed to delete volume: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to delete volume", err)
 		return
 	}
 
@@ -379,16 +560,17 @@ func deleteVolume(w http.ResponseWriter, r *http.Request) {
 }
 
 func listNetworks(w http.ResponseWriter, r *http.Request) {
-	networks, err := getRealNetworks()
+	filters := dockerFilterArgs(r.URL.Query(), "name", "label", "driver", "scope", "type", "id")
+
+	networks, err := getRealNetworks(filters...)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get networks")
-		http.Error(w, "Failed to get networks: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get networks", err)
 		return
 	}
 
 	logrus.WithField("count", len(networks)).Info("Listed networks")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(networks)
+	writeListResponse(w, r, networks, networkSorters, "name", func(n NetworkSummary) string { return n.ID })
 }
 
 func deleteNetwork(w http.ResponseWriter, r *http.Request) {
@@ -397,7 +579,7 @@ func deleteNetwork(w http.ResponseWriter, r *http.Request) {
 
 	if err := dockerRemoveNetwork(id); err != nil {
 		logrus.WithError(err).WithField("network", id).Error("Failed to delete network")
-		http.Error(w, "Failed to delete network: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to delete network", err)
 		return
 	}
 
@@ -410,7 +592,7 @@ func getSystemInfo(w http.ResponseWriter, r *http.Request) {
 	info, err := getRealSystemInfo()
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get system info")
-		http.Error(w, "Failed to get system info: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get system info", err)
 		return
 	}
 
@@ -421,14 +603,18 @@ func getSystemInfo(w http.ResponseWriter, r *http.Request) {
 func runContainer(w http.ResponseWriter, r *http.Request) {
 	var req RunContainerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if !preflightPorts(w, req.Ports) {
 		return
 	}
 
 	containerID, err := dockerRun(req)
 	if err != nil {
 		logrus.WithError(err).WithField("image", req.Image).Error("Failed to run container")
-		http.Error(w, "Failed to run container: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to run container", err)
 		return
 	}
 
@@ -447,7 +633,7 @@ func runContainer(w http.ResponseWriter, r *http.Request) {
 func searchImages(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Query parameter 'q' is required")
 		return
 	}
 
@@ -472,24 +658,6 @@ func searchImages(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func pullImage(w http.ResponseWriter, r *http.Request) {
-	var req PullImageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if err := dockerPull(req.Image); err != nil {
-		logrus.WithError(err).WithField("image", req.Image).Error("Failed to pull image")
-		http.Error(w, "Failed to pull image: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	logrus.WithField("image", req.Image).Info("Image pulled successfully")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Image pulled successfully"})
-}
-
 func inspectImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -497,7 +665,7 @@ func inspectImage(w http.ResponseWriter, r *http.Request) {
 	inspection, err := dockerInspectImage(id)
 	if err != nil {
 		logrus.WithError(err).WithField("image", id).Error("Failed to inspect image")
-		http.Error(w, "Failed to inspect image: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to inspect image", err)
 		return
 	}
 
@@ -509,7 +677,7 @@ func getSystemMetrics(w http.ResponseWriter, r *http.Request) {
 	metrics, err := getRealSystemMetrics()
 	if err != nil {
 		logrus.WithError(err).Error("Failed to get system metrics")
-		http.Error(w, "Failed to get system metrics: "+err.Error(), http.StatusInternalServerError)
+		writeFailure(w, "Failed to get system metrics", err)
 		return
 	}
 
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// notificationTimeout bounds a webhook delivery
+const notificationTimeout = 10 * time.Second
+
+// Notification is an event worth a user's attention
+type Notification struct {
+	ID        int64                  `json:"id"`
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data"`
+	Read      bool                   `json:"read"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// notify stores a notification and posts it to NOTIFICATION_WEBHOOK_URL
+// when one is configured. Delivery happens in the background.
+func notify(kind, title, message string, data map[string]interface{}) {
+	notification := Notification{Type: kind, Title: title, Message: message, Data: data, CreatedAt: time.Now()}
+	if notification.Data == nil {
+		notification.Data = map[string]interface{}{}
+	}
+	logrus.WithFields(logrus.Fields{"type": kind, "title": title}).Info("Notification")
+
+	if db != nil {
+		encoded, _ := json.Marshal(notification.Data)
+		result, err := db.Exec(`INSERT INTO notifications (type, title, message, data, created_at) VALUES (?, ?, ?, ?, ?)`,
+			kind, title, message, string(encoded), notification.CreatedAt)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to store notification")
+		} else {
+			notification.ID, _ = result.LastInsertId()
+		}
+	}
+
+	if webhook := getEnvOrDefault("NOTIFICATION_WEBHOOK_URL", ""); webhook != "" {
+		go deliverWebhook(webhook, notification)
+	}
+}
+
+// deliverWebhook posts a notification as JSON
+func deliverWebhook(webhook string, notification Notification) {
+	body, _ := json.Marshal(notification)
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		logrus.WithError(err).Error("Invalid notification webhook URL")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to deliver notification")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logrus.WithField("status", resp.StatusCode).Warn("Notification webhook rejected notification")
+	}
+}
+
+// listNotifications returns recent notifications, newest first. With
+// ?unread=true only unread ones are returned.
+func listNotifications(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+	where := ""
+	if r.URL.Query().Get("unread") == "true" {
+		where = "WHERE read = 0"
+	}
+
+	rows, err := db.Query(`SELECT id, type, title, message, data, read, created_at FROM notifications `+where+` ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		writeFailure(w, "Failed to load notifications", err)
+		return
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var data string
+		if err := rows.Scan(&notification.ID, &notification.Type, &notification.Title, &notification.Message, &data, &notification.Read, &notification.CreatedAt); err != nil {
+			logrus.WithError(err).Error("Failed to scan notification row")
+			continue
+		}
+		json.Unmarshal([]byte(data), &notification.Data)
+		notifications = append(notifications, notification)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// markNotificationsRead marks the notifications listed in ids as read, or
+// all of them when no ids are given
+func markNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	var result sql.Result
+	var err error
+	if len(req.IDs) == 0 {
+		result, err = db.Exec(`UPDATE notifications SET read = 1 WHERE read = 0`)
+	} else {
+		args := make([]interface{}, len(req.IDs))
+		for i, id := range req.IDs {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+		result, err = db.Exec(`UPDATE notifications SET read = 1 WHERE id IN (`+placeholders+`)`, args...)
+	}
+	if err != nil {
+		writeFailure(w, "Failed to update notifications", err)
+		return
+	}
+	updated, _ := result.RowsAffected()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"updated": updated})
+}
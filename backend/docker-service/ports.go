@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PortSummary is a single container port as returned by GET /containers.
+// Exposed ports that are not published have no PublicPort.
+type PortSummary struct {
+	IP          string `json:"IP,omitempty"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort,omitempty"`
+	Type        string `json:"Type"`
+	Published   bool   `json:"Published"`
+}
+
+// PortGroup is a contiguous range of ports sharing protocol and host addresses,
+// e.g. 0.0.0.0 and :: 8000-8010->8000-8010/tcp
+type PortGroup struct {
+	HostIPs       []string `json:"HostIPs,omitempty"`
+	HostPort      string   `json:"HostPort,omitempty"`
+	ContainerPort string   `json:"ContainerPort"`
+	Protocol      string   `json:"Protocol"`
+	Published     bool     `json:"Published"`
+}
+
+// PortMapping publishes a container port, or a range of ports, on the host.
+// An empty HostPort lets the engine pick a free port.
+type PortMapping struct {
+	HostIP        string `json:"host_ip,omitempty"`
+	HostPort      string `json:"host_port,omitempty"`
+	ContainerPort string `json:"container_port"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+// PortMappings accepts a list of mappings, a list of `docker run -p` strings,
+// or the legacy object mapping host ports to container ports
+type PortMappings []PortMapping
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *PortMappings) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*p = nil
+		return nil
+	}
+
+	var mappings PortMappings
+	if len(data) > 0 && data[0] == '{' {
+		var legacy map[string]string
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		hostPorts := make([]string, 0, len(legacy))
+		for hostPort := range legacy {
+			hostPorts = append(hostPorts, hostPort)
+		}
+		sort.Strings(hostPorts)
+		for _, hostPort := range hostPorts {
+			mapping, err := parsePortSpec(hostPort + ":" + legacy[hostPort])
+			if err != nil {
+				return err
+			}
+			mappings = append(mappings, mapping)
+		}
+		*p = mappings
+		return nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if len(item) > 0 && item[0] == '"' {
+			var spec string
+			if err := json.Unmarshal(item, &spec); err != nil {
+				return err
+			}
+			mapping, err := parsePortSpec(spec)
+			if err != nil {
+				return err
+			}
+			mappings = append(mappings, mapping)
+			continue
+		}
+
+		// Ports may be given as numbers or strings
+		var raw struct {
+			HostIP        string          `json:"host_ip"`
+			HostPort      json.RawMessage `json:"host_port"`
+			ContainerPort json.RawMessage `json:"container_port"`
+			Protocol      string          `json:"protocol"`
+		}
+		if err := json.Unmarshal(item, &raw); err != nil {
+			return err
+		}
+		mapping, err := PortMapping{
+			HostIP:        raw.HostIP,
+			HostPort:      rawPortString(raw.HostPort),
+			ContainerPort: rawPortString(raw.ContainerPort),
+			Protocol:      raw.Protocol,
+		}.normalized()
+		if err != nil {
+			return err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	*p = mappings
+	return nil
+}
+
+// rawPortString returns a JSON number or string as a string
+func rawPortString(raw json.RawMessage) string {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// parsePortSpec parses the `docker run -p` syntax:
+// [ip:][hostPort:]containerPort[/protocol], with IPv6 addresses in brackets
+func parsePortSpec(spec string) (PortMapping, error) {
+	var mapping PortMapping
+	rest := strings.TrimSpace(spec)
+
+	if idx := strings.LastIndex(rest, "/"); idx != -1 {
+		mapping.Protocol = rest[idx+1:]
+		rest = rest[:idx]
+	}
+
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end == -1 || !strings.HasPrefix(rest[end+1:], ":") {
+			return mapping, fmt.Errorf("invalid port mapping %q", spec)
+		}
+		mapping.HostIP = rest[1:end]
+		rest = rest[end+2:]
+		host, container, ok := strings.Cut(rest, ":")
+		if !ok {
+			return mapping, fmt.Errorf("invalid port mapping %q", spec)
+		}
+		mapping.HostPort, mapping.ContainerPort = host, container
+	} else {
+		parts := strings.Split(rest, ":")
+		switch len(parts) {
+		case 1:
+			mapping.ContainerPort = parts[0]
+		case 2:
+			mapping.HostPort, mapping.ContainerPort = parts[0], parts[1]
+		case 3:
+			mapping.HostIP, mapping.HostPort, mapping.ContainerPort = parts[0], parts[1], parts[2]
+		default:
+			return mapping, fmt.Errorf("invalid port mapping %q, IPv6 addresses must be in brackets", spec)
+		}
+	}
+
+	return mapping.normalized()
+}
+
+// normalized validates a mapping and fills in defaults
+func (m PortMapping) normalized() (PortMapping, error) {
+	m.Protocol = strings.ToLower(strings.TrimSpace(m.Protocol))
+	if m.Protocol == "" {
+		m.Protocol = "tcp"
+	}
+	if m.Protocol != "tcp" && m.Protocol != "udp" && m.Protocol != "sctp" {
+		return m, fmt.Errorf("unsupported protocol %q", m.Protocol)
+	}
+
+	m.HostIP = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(m.HostIP), "["), "]")
+	if m.HostIP != "" && net.ParseIP(m.HostIP) == nil {
+		return m, fmt.Errorf("invalid host IP %q", m.HostIP)
+	}
+
+	m.ContainerPort = strings.TrimSpace(m.ContainerPort)
+	containerStart, containerEnd, err := parsePortRange(m.ContainerPort)
+	if err != nil {
+		return m, fmt.Errorf("invalid container port: %v", err)
+	}
+
+	m.HostPort = strings.TrimSpace(m.HostPort)
+	if m.HostPort != "" {
+		hostStart, hostEnd, err := parsePortRange(m.HostPort)
+		if err != nil {
+			return m, fmt.Errorf("invalid host port: %v", err)
+		}
+		// A host range with a single container port lets the engine pick
+		// one port of the range
+		if containerEnd != containerStart && hostEnd-hostStart != containerEnd-containerStart {
+			return m, fmt.Errorf("host port range %s does not match container port range %s", m.HostPort, m.ContainerPort)
+		}
+	}
+
+	return m, nil
+}
+
+// String renders the mapping in `docker run -p` syntax
+func (m PortMapping) String() string {
+	s := m.ContainerPort
+	switch {
+	case m.HostIP != "":
+		ip := m.HostIP
+		if strings.Contains(ip, ":") {
+			ip = "[" + ip + "]"
+		}
+		s = ip + ":" + m.HostPort + ":" + s
+	case m.HostPort != "":
+		s = m.HostPort + ":" + s
+	}
+	if m.Protocol != "" && m.Protocol != "tcp" {
+		s += "/" + m.Protocol
+	}
+	return s
+}
+
+// parsePortRange parses "80" or "8000-8010"
+func parsePortRange(value string) (int, int, error) {
+	if value == "" {
+		return 0, 0, fmt.Errorf("port is required")
+	}
+	startText, endText, isRange := strings.Cut(value, "-")
+	start, err := strconv.Atoi(startText)
+	if err != nil || start < 1 || start > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", value)
+	}
+	end := start
+	if isRange {
+		end, err = strconv.Atoi(endText)
+		if err != nil || end < start || end > 65535 {
+			return 0, 0, fmt.Errorf("invalid port range %q", value)
+		}
+	}
+	return start, end, nil
+}
+
+func formatPortRange(start, end int) string {
+	if start == end {
+		return strconv.Itoa(start)
+	}
+	return strconv.Itoa(start) + "-" + strconv.Itoa(end)
+}
+
+// splitPortKey splits an engine port key such as "80/tcp"
+func splitPortKey(key string) (int, string) {
+	portText, protocol, ok := strings.Cut(key, "/")
+	if !ok {
+		protocol = "tcp"
+	}
+	port, _ := strconv.Atoi(portText)
+	return port, protocol
+}
+
+// containerPorts lists the published and exposed ports of a container. The
+// runtime bindings are used for running containers and the configured ones
+// otherwise, so stopped containers still show their mappings.
+func containerPorts(info *ContainerInspect) []PortSummary {
+	bindings := info.NetworkSettings.Ports
+	if len(bindings) == 0 {
+		bindings = info.HostConfig.PortBindings
+	}
+
+	ports := []PortSummary{}
+	seen := make(map[PortSummary]bool)
+	add := func(port PortSummary) {
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+
+	for key, list := range bindings {
+		privatePort, protocol := splitPortKey(key)
+		if len(list) == 0 {
+			add(PortSummary{PrivatePort: privatePort, Type: protocol})
+			continue
+		}
+		for _, binding := range list {
+			publicPort, _ := strconv.Atoi(binding.HostPort)
+			add(PortSummary{IP: binding.HostIP, PrivatePort: privatePort, PublicPort: publicPort, Type: protocol, Published: true})
+		}
+	}
+	for key := range info.Config.ExposedPorts {
+		if _, ok := bindings[key]; !ok {
+			privatePort, protocol := splitPortKey(key)
+			add(PortSummary{PrivatePort: privatePort, Type: protocol})
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].PrivatePort != ports[j].PrivatePort {
+			return ports[i].PrivatePort < ports[j].PrivatePort
+		}
+		if ports[i].Type != ports[j].Type {
+			return ports[i].Type < ports[j].Type
+		}
+		return ports[i].IP < ports[j].IP
+	})
+	return ports
+}
+
+// groupPorts merges IPv4/IPv6 duplicates and contiguous ports into ranges
+func groupPorts(ports []PortSummary) []PortGroup {
+	type portKey struct {
+		Type        string
+		Published   bool
+		PrivatePort int
+		PublicPort  int
+	}
+	type merged struct {
+		portKey
+		IPs []string
+	}
+
+	var entries []*merged
+	byKey := make(map[portKey]*merged)
+	for _, port := range ports {
+		key := portKey{port.Type, port.Published, port.PrivatePort, port.PublicPort}
+		entry, ok := byKey[key]
+		if !ok {
+			entry = &merged{portKey: key}
+			byKey[key] = entry
+			entries = append(entries, entry)
+		}
+		if port.IP != "" && !containsString(entry.IPs, port.IP) {
+			entry.IPs = append(entry.IPs, port.IP)
+		}
+	}
+	for _, entry := range entries {
+		sort.Strings(entry.IPs)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Published != b.Published {
+			return a.Published
+		}
+		if ipsA, ipsB := strings.Join(a.IPs, ","), strings.Join(b.IPs, ","); ipsA != ipsB {
+			return ipsA < ipsB
+		}
+		return a.PrivatePort < b.PrivatePort
+	})
+
+	groups := []PortGroup{}
+	var current *merged
+	privateEnd, publicEnd := 0, 0
+	flush := func() {
+		if current == nil {
+			return
+		}
+		group := PortGroup{
+			HostIPs:       current.IPs,
+			ContainerPort: formatPortRange(current.PrivatePort, privateEnd),
+			Protocol:      current.Type,
+			Published:     current.Published,
+		}
+		if current.PublicPort != 0 {
+			group.HostPort = formatPortRange(current.PublicPort, publicEnd)
+		}
+		groups = append(groups, group)
+	}
+	for _, entry := range entries {
+		if current != nil &&
+			entry.Type == current.Type &&
+			entry.Published == current.Published &&
+			stringSlicesEqual(entry.IPs, current.IPs) &&
+			entry.PrivatePort == privateEnd+1 &&
+			((entry.PublicPort == 0 && current.PublicPort == 0) || (current.PublicPort != 0 && entry.PublicPort == publicEnd+1)) {
+			privateEnd = entry.PrivatePort
+			publicEnd = entry.PublicPort
+			continue
+		}
+		flush()
+		current = entry
+		privateEnd, publicEnd = entry.PrivatePort, entry.PublicPort
+	}
+	flush()
+
+	return groups
+}
+
+// mappingsFromBindings converts configured port bindings into run request
+// mappings, collapsing contiguous ports into ranges
+func mappingsFromBindings(bindings map[string][]PortBinding) PortMappings {
+	var ports []PortSummary
+	for key, list := range bindings {
+		privatePort, protocol := splitPortKey(key)
+		for _, binding := range list {
+			publicPort, _ := strconv.Atoi(binding.HostPort)
+			ports = append(ports, PortSummary{IP: binding.HostIP, PrivatePort: privatePort, PublicPort: publicPort, Type: protocol, Published: true})
+		}
+	}
+
+	var mappings PortMappings
+	for _, group := range groupPorts(ports) {
+		hostIPs := group.HostIPs
+		if len(hostIPs) == 0 {
+			hostIPs = []string{""}
+		}
+		for _, hostIP := range hostIPs {
+			mappings = append(mappings, PortMapping{
+				HostIP:        hostIP,
+				HostPort:      group.HostPort,
+				ContainerPort: group.ContainerPort,
+				Protocol:      group.Protocol,
+			})
+		}
+	}
+	return mappings
+}
+
+// attachContainerPorts replaces the ports of listed containers with the
+// structured bindings reported by the engine
+func attachContainerPorts(containers []ContainerSummary) {
+	if len(containers) == 0 {
+		return
+	}
+	ids := make([]string, len(containers))
+	for i, container := range containers {
+		ids[i] = container.ID
+	}
+
+	inspections, err := dockerInspectContainers(ids...)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to inspect containers for port bindings")
+		return
+	}
+	byID := make(map[string]*ContainerInspect, len(inspections))
+	for i := range inspections {
+		byID[inspections[i].ID] = &inspections[i]
+	}
+
+	for i := range containers {
+		if info, ok := byID[containers[i].ID]; ok {
+			containers[i].Ports = containerPorts(info)
+			containers[i].PortGroups = groupPorts(containers[i].Ports)
+		}
+	}
+}
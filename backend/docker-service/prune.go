@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var hexVolumeName = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// engineDiskUsage is the Engine API /system/df response
+type engineDiskUsage struct {
+	LayersSize int64 `json:"LayersSize"`
+	Images     []struct {
+		ID         string            `json:"Id"`
+		RepoTags   []string          `json:"RepoTags"`
+		Created    int64             `json:"Created"`
+		Size       int64             `json:"Size"`
+		SharedSize int64             `json:"SharedSize"`
+		Labels     map[string]string `json:"Labels"`
+		Containers int64             `json:"Containers"`
+	} `json:"Images"`
+	Containers []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Image   string            `json:"Image"`
+		Created int64             `json:"Created"`
+		State   string            `json:"State"`
+		SizeRw  int64             `json:"SizeRw"`
+		Labels  map[string]string `json:"Labels"`
+	} `json:"Containers"`
+	Volumes []struct {
+		Name      string            `json:"Name"`
+		Driver    string            `json:"Driver"`
+		Labels    map[string]string `json:"Labels"`
+		UsageData *struct {
+			Size     int64 `json:"Size"`
+			RefCount int64 `json:"RefCount"`
+		} `json:"UsageData"`
+	} `json:"Volumes"`
+	BuildCache []struct {
+		ID          string     `json:"ID"`
+		Type        string     `json:"Type"`
+		Description string     `json:"Description"`
+		InUse       bool       `json:"InUse"`
+		Shared      bool       `json:"Shared"`
+		Size        int64      `json:"Size"`
+		CreatedAt   *time.Time `json:"CreatedAt"`
+		LastUsedAt  *time.Time `json:"LastUsedAt"`
+	} `json:"BuildCache"`
+}
+
+// DiskUsageCategory summarizes one resource type like docker system df
+type DiskUsageCategory struct {
+	Total       int   `json:"total"`
+	Active      int   `json:"active"`
+	Size        int64 `json:"size"`
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// PruneItem is a resource that was or would be removed by a prune
+type PruneItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// PruneReport is the result of a prune or of its dry run. Sizes of a dry run
+// are estimates: layers shared between pruned images are counted once per
+// image that uniquely holds them.
+type PruneReport struct {
+	Type           string              `json:"type"`
+	DryRun         bool                `json:"dry_run"`
+	Filters        map[string][]string `json:"filters"`
+	Items          []PruneItem         `json:"items"`
+	Count          int                 `json:"count"`
+	SpaceReclaimed int64               `json:"space_reclaimed"`
+	AuditID        int64               `json:"audit_id,omitempty"`
+}
+
+// pruneFilters lists the filters each prune type accepts
+var pruneFilters = map[string][]string{
+	"containers":  {"until", "label", "label!"},
+	"images":      {"until", "label", "label!", "dangling"},
+	"volumes":     {"label", "label!", "all"},
+	"networks":    {"until", "label", "label!"},
+	"build-cache": {"until", "all"},
+}
+
+// engineDiskUsageData reads /system/df from the engine
+func engineDiskUsageData(ctx context.Context) (*engineDiskUsage, error) {
+	resp, err := engineRequest(ctx, http.MethodGet, "/system/df", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var usage engineDiskUsage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// parseUntil reads an until filter: a duration such as 24h, an RFC 3339 or
+// date timestamp, or Unix seconds, as accepted by docker
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid until filter %q, use a duration such as 24h or a timestamp", value)
+}
+
+// matchLabels reports whether labels satisfy every key or key=value filter
+func matchLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue := strings.Cut(filter, "=")
+		actual, ok := labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// excludedByLabels reports whether labels match any label! filter
+func excludedByLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		if matchLabels(labels, []string{filter}) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneQuery holds the parsed filters of a prune request
+type pruneQuery struct {
+	filters  map[string][]string
+	until    time.Time
+	labels   []string
+	excluded []string
+	dangling bool
+	all      bool
+}
+
+// parsePruneQuery validates the filters of a prune request against its type
+func parsePruneQuery(pruneType string, query url.Values) (*pruneQuery, error) {
+	allowed := make(map[string]bool)
+	for _, name := range pruneFilters[pruneType] {
+		allowed[name] = true
+	}
+
+	pq := &pruneQuery{filters: make(map[string][]string), dangling: true}
+	for _, name := range []string{"until", "label", "label!", "dangling", "all"} {
+		values := query[name]
+		if len(values) == 0 {
+			continue
+		}
+		if !allowed[name] {
+			return nil, fmt.Errorf("filter '%s' is not supported when pruning %s", name, pruneType)
+		}
+		switch name {
+		case "until":
+			until, err := parseUntil(values[0], time.Now())
+			if err != nil {
+				return nil, err
+			}
+			pq.until = until
+			pq.filters["until"] = []string{values[0]}
+		case "label":
+			pq.labels = values
+			pq.filters["label"] = values
+		case "label!":
+			pq.excluded = values
+			pq.filters["label!"] = values
+		case "dangling":
+			pq.dangling = values[0] != "false"
+		case "all":
+			pq.all = values[0] == "true"
+		}
+	}
+	if pruneType == "images" {
+		pq.filters["dangling"] = []string{strconv.FormatBool(pq.dangling)}
+	}
+	if pruneType == "volumes" && pq.all {
+		pq.filters["all"] = []string{"true"}
+	}
+	return pq, nil
+}
+
+// olderThan reports whether a creation time passes the until filter
+func (pq *pruneQuery) olderThan(created time.Time) bool {
+	return pq.until.IsZero() || created.Before(pq.until)
+}
+
+// matches reports whether labels pass the label and label! filters
+func (pq *pruneQuery) matches(labels map[string]string) bool {
+	return matchLabels(labels, pq.labels) && !excludedByLabels(labels, pq.excluded)
+}
+
+// pruneCandidates lists what a prune would remove, for dry runs
+func pruneCandidates(ctx context.Context, pruneType string, pq *pruneQuery) ([]PruneItem, error) {
+	items := []PruneItem{}
+
+	if pruneType == "networks" {
+		networks, err := pruneableNetworks(ctx, pq)
+		if err != nil {
+			return nil, err
+		}
+		return networks, nil
+	}
+
+	usage, err := engineDiskUsageData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pruneType {
+	case "containers":
+		for _, c := range usage.Containers {
+			if c.State == "running" || c.State == "paused" || c.State == "restarting" {
+				continue
+			}
+			if !pq.olderThan(time.Unix(c.Created, 0)) || !pq.matches(c.Labels) {
+				continue
+			}
+			items = append(items, PruneItem{ID: c.ID, Name: strings.TrimPrefix(firstName(c.Names), "/"), Size: c.SizeRw})
+		}
+	case "images":
+		for _, image := range usage.Images {
+			dangling := len(image.RepoTags) == 0 || (len(image.RepoTags) == 1 && image.RepoTags[0] == "<none>:<none>")
+			if image.Containers > 0 || (pq.dangling && !dangling) {
+				continue
+			}
+			if !pq.olderThan(time.Unix(image.Created, 0)) || !pq.matches(image.Labels) {
+				continue
+			}
+			size := image.Size
+			if image.SharedSize > 0 {
+				size -= image.SharedSize
+			}
+			name := ""
+			if !dangling {
+				name = firstTag(image.RepoTags)
+			}
+			items = append(items, PruneItem{ID: image.ID, Name: name, Size: size})
+		}
+	case "volumes":
+		for _, volume := range usage.Volumes {
+			if volume.UsageData == nil || volume.UsageData.RefCount > 0 {
+				continue
+			}
+			// Without all, only anonymous volumes are pruned
+			_, anonymous := volume.Labels["com.docker.volume.anonymous"]
+			if !pq.all && !anonymous && !hexVolumeName.MatchString(volume.Name) {
+				continue
+			}
+			if !pq.matches(volume.Labels) {
+				continue
+			}
+			items = append(items, PruneItem{ID: volume.Name, Name: volume.Name, Size: max(volume.UsageData.Size, 0)})
+		}
+	case "build-cache":
+		for _, record := range usage.BuildCache {
+			if record.InUse || (!pq.all && record.Shared) {
+				continue
+			}
+			lastUsed := record.CreatedAt
+			if record.LastUsedAt != nil {
+				lastUsed = record.LastUsedAt
+			}
+			if lastUsed != nil && !pq.olderThan(*lastUsed) {
+				continue
+			}
+			size := record.Size
+			if record.Shared {
+				size = 0
+			}
+			items = append(items, PruneItem{ID: record.ID, Name: record.Description, Size: size})
+		}
+	}
+	return items, nil
+}
+
+// pruneableNetworks lists custom networks without containers
+func pruneableNetworks(ctx context.Context, pq *pruneQuery) ([]PruneItem, error) {
+	filters, _ := json.Marshal(map[string][]string{"dangling": {"true"}, "label": pq.labels})
+	resp, err := engineRequest(ctx, http.MethodGet, "/networks", url.Values{"filters": {string(filters)}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var networks []struct {
+		ID      string            `json:"Id"`
+		Name    string            `json:"Name"`
+		Created time.Time         `json:"Created"`
+		Labels  map[string]string `json:"Labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&networks); err != nil {
+		return nil, err
+	}
+
+	items := []PruneItem{}
+	for _, network := range networks {
+		if network.Name == "bridge" || network.Name == "host" || network.Name == "none" {
+			continue
+		}
+		if !pq.olderThan(network.Created) || excludedByLabels(network.Labels, pq.excluded) {
+			continue
+		}
+		items = append(items, PruneItem{ID: network.ID, Name: network.Name})
+	}
+	return items, nil
+}
+
+// enginePrune runs a prune and returns what was removed
+func enginePrune(ctx context.Context, pruneType string, pq *pruneQuery) ([]PruneItem, int64, error) {
+	filters, _ := json.Marshal(pq.filters)
+	query := url.Values{"filters": {string(filters)}}
+
+	path := "/" + pruneType + "/prune"
+	if pruneType == "build-cache" {
+		path = "/build/prune"
+		if pq.all {
+			query.Set("all", "1")
+		}
+	}
+
+	resp, err := engineRequest(ctx, http.MethodPost, path, query, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ContainersDeleted []string `json:"ContainersDeleted"`
+		VolumesDeleted    []string `json:"VolumesDeleted"`
+		NetworksDeleted   []string `json:"NetworksDeleted"`
+		CachesDeleted     []string `json:"CachesDeleted"`
+		ImagesDeleted     []struct {
+			Untagged string `json:"Untagged"`
+			Deleted  string `json:"Deleted"`
+		} `json:"ImagesDeleted"`
+		SpaceReclaimed int64 `json:"SpaceReclaimed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+
+	items := []PruneItem{}
+	for _, ids := range [][]string{result.ContainersDeleted, result.VolumesDeleted, result.NetworksDeleted, result.CachesDeleted} {
+		for _, id := range ids {
+			items = append(items, PruneItem{ID: id})
+		}
+	}
+	for _, image := range result.ImagesDeleted {
+		if image.Deleted != "" {
+			items = append(items, PruneItem{ID: image.Deleted})
+		} else if image.Untagged != "" {
+			items = append(items, PruneItem{ID: image.Untagged, Name: image.Untagged})
+		}
+	}
+	return items, result.SpaceReclaimed, nil
+}
+
+// runPrune prunes one resource type, or lists what it would remove when
+// dryRun is set. Every run is recorded in the audit log.
+func runPrune(ctx context.Context, pruneType string, pq *pruneQuery, dryRun bool, user string) (*PruneReport, error) {
+	report := &PruneReport{Type: pruneType, DryRun: dryRun, Filters: pq.filters}
+
+	var err error
+	if dryRun {
+		report.Items, err = pruneCandidates(ctx, pruneType, pq)
+		for _, item := range report.Items {
+			report.SpaceReclaimed += item.Size
+		}
+	} else {
+		report.Items, report.SpaceReclaimed, err = enginePrune(ctx, pruneType, pq)
+	}
+
+	action := "system.prune." + pruneType
+	if err != nil {
+		recordAudit(user, action, pruneType, "failure", map[string]interface{}{
+			"dry_run": dryRun,
+			"filters": pq.filters,
+			"error":   err.Error(),
+		})
+		return nil, err
+	}
+	report.Count = len(report.Items)
+
+	ids := make([]string, len(report.Items))
+	for i, item := range report.Items {
+		ids[i] = item.ID
+	}
+	report.AuditID = recordAudit(user, action, pruneType, "success", map[string]interface{}{
+		"dry_run":         dryRun,
+		"filters":         pq.filters,
+		"count":           report.Count,
+		"space_reclaimed": report.SpaceReclaimed,
+		"items":           ids,
+	})
+	logrus.WithFields(logrus.Fields{
+		"type":    pruneType,
+		"dry_run": dryRun,
+		"count":   report.Count,
+		"bytes":   report.SpaceReclaimed,
+	}).Info("Prune finished")
+	return report, nil
+}
+
+// pruneResources prunes containers, images, volumes, networks or the build
+// cache. ?dry_run=true lists what would be removed instead.
+func pruneResources(w http.ResponseWriter, r *http.Request) {
+	pruneType := mux.Vars(r)["type"]
+	if _, ok := pruneFilters[pruneType]; !ok {
+		writeError(w, http.StatusNotFound, "Unknown prune type "+pruneType+", use containers, images, volumes, networks or build-cache")
+		return
+	}
+
+	pq, err := parsePruneQuery(pruneType, r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Pruning images or the build cache can take longer than the server timeouts
+	disableTimeouts(w)
+	report, err := runPrune(r.Context(), pruneType, pq, r.URL.Query().Get("dry_run") == "true", r.Header.Get("X-User"))
+	if err != nil {
+		logrus.WithError(err).WithField("type", pruneType).Error("Failed to prune")
+		writeFailure(w, "Failed to prune "+pruneType, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// getDiskUsage reports disk usage by images, containers, volumes and the
+// build cache. ?verbose=true adds the per-item engine data.
+func getDiskUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := engineDiskUsageData(r.Context())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get disk usage")
+		writeFailure(w, "Failed to get disk usage", err)
+		return
+	}
+
+	images := DiskUsageCategory{Total: len(usage.Images), Size: usage.LayersSize}
+	for _, image := range usage.Images {
+		if image.Containers > 0 {
+			images.Active++
+			continue
+		}
+		images.Reclaimable += image.Size - max(image.SharedSize, 0)
+	}
+
+	containers := DiskUsageCategory{Total: len(usage.Containers)}
+	for _, c := range usage.Containers {
+		containers.Size += c.SizeRw
+		if c.State == "running" {
+			containers.Active++
+		} else {
+			containers.Reclaimable += c.SizeRw
+		}
+	}
+
+	volumes := DiskUsageCategory{Total: len(usage.Volumes)}
+	for _, volume := range usage.Volumes {
+		if volume.UsageData == nil || volume.UsageData.Size < 0 {
+			continue
+		}
+		volumes.Size += volume.UsageData.Size
+		if volume.UsageData.RefCount > 0 {
+			volumes.Active++
+		} else {
+			volumes.Reclaimable += volume.UsageData.Size
+		}
+	}
+
+	buildCache := DiskUsageCategory{Total: len(usage.BuildCache)}
+	for _, record := range usage.BuildCache {
+		if record.Shared {
+			continue
+		}
+		buildCache.Size += record.Size
+		if record.InUse {
+			buildCache.Active++
+		} else {
+			buildCache.Reclaimable += record.Size
+		}
+	}
+
+	response := map[string]interface{}{
+		"images":      images,
+		"containers":  containers,
+		"volumes":     volumes,
+		"build_cache": buildCache,
+		"total_size":  images.Size + containers.Size + volumes.Size + buildCache.Size,
+		"reclaimable": images.Reclaimable + containers.Reclaimable + volumes.Reclaimable + buildCache.Reclaimable,
+	}
+	if r.URL.Query().Get("verbose") == "true" {
+		response["details"] = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// layerEventInterval throttles progress events for chatty layers
+const layerEventInterval = 250 * time.Millisecond
+
+var platformPattern = regexp.MustCompile(`^[a-z0-9_]+/[a-z0-9_]+(/[a-z0-9_.]+)?$`)
+
+// LayerProgress is the pull or push state of one image layer
+type LayerProgress struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	Downloaded    int64  `json:"downloaded"`
+	DownloadTotal int64  `json:"download_total"`
+	Extracted     int64  `json:"extracted"`
+	ExtractTotal  int64  `json:"extract_total"`
+	Uploaded      int64  `json:"uploaded,omitempty"`
+	UploadTotal   int64  `json:"upload_total,omitempty"`
+	Done          bool   `json:"done"`
+}
+
+// layerTracker aggregates per-layer progress of a pull or push
+type layerTracker struct {
+	mu       sync.Mutex
+	job      *Job
+	layers   map[string]*LayerProgress
+	order    []string
+	lastEmit map[string]time.Time
+	status   string
+	digest   string
+}
+
+func newLayerTracker(job *Job) *layerTracker {
+	return &layerTracker{
+		job:      job,
+		layers:   make(map[string]*LayerProgress),
+		lastEmit: make(map[string]time.Time),
+	}
+}
+
+// handle applies one message of a pull or push stream
+func (t *layerTracker) handle(msg engineMessage) {
+	t.mu.Lock()
+
+	if len(msg.Aux) > 0 {
+		// Pushes report the manifest digest in an aux message
+		var aux struct {
+			Digest string `json:"Digest"`
+		}
+		if json.Unmarshal(msg.Aux, &aux) == nil && aux.Digest != "" {
+			t.digest = aux.Digest
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(msg.Status, "Digest: "):
+		t.digest = strings.TrimPrefix(msg.Status, "Digest: ")
+		t.mu.Unlock()
+		return
+	case strings.HasPrefix(msg.Status, "Status: "):
+		t.status = strings.TrimPrefix(msg.Status, "Status: ")
+		t.mu.Unlock()
+		return
+	case msg.ID == "" || strings.HasPrefix(msg.Status, "Pulling from"):
+		t.mu.Unlock()
+		t.job.Emit("log", map[string]string{"message": strings.TrimSpace(msg.ID + " " + msg.Status)})
+		return
+	}
+
+	layer, ok := t.layers[msg.ID]
+	if !ok {
+		layer = &LayerProgress{ID: msg.ID}
+		t.layers[msg.ID] = layer
+		t.order = append(t.order, msg.ID)
+	}
+	changed := layer.Status != msg.Status
+	layer.Status = msg.Status
+
+	switch msg.Status {
+	case "Downloading":
+		layer.Downloaded = msg.ProgressDetail.Current
+		if msg.ProgressDetail.Total > 0 {
+			layer.DownloadTotal = msg.ProgressDetail.Total
+		}
+	case "Download complete", "Verifying Checksum":
+		if layer.DownloadTotal > 0 {
+			layer.Downloaded = layer.DownloadTotal
+		}
+	case "Extracting":
+		if layer.DownloadTotal > 0 {
+			layer.Downloaded = layer.DownloadTotal
+		}
+		layer.Extracted = msg.ProgressDetail.Current
+		if msg.ProgressDetail.Total > 0 {
+			layer.ExtractTotal = msg.ProgressDetail.Total
+		}
+	case "Pushing":
+		layer.Uploaded = msg.ProgressDetail.Current
+		if msg.ProgressDetail.Total > 0 {
+			layer.UploadTotal = msg.ProgressDetail.Total
+		}
+	case "Pushed", "Layer already exists":
+		layer.Done = true
+		layer.Uploaded = layer.UploadTotal
+	case "Pull complete", "Already exists":
+		layer.Done = true
+		if layer.DownloadTotal > 0 {
+			layer.Downloaded = layer.DownloadTotal
+		}
+		if layer.ExtractTotal > 0 {
+			layer.Extracted = layer.ExtractTotal
+		}
+	default:
+		if strings.HasPrefix(msg.Status, "Mounted from") {
+			layer.Done = true
+		}
+	}
+
+	now := time.Now()
+	if !changed && now.Sub(t.lastEmit[msg.ID]) < layerEventInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastEmit[msg.ID] = now
+	snapshot := *layer
+
+	var current, total int64
+	done := 0
+	for _, l := range t.layers {
+		// Downloading and extracting are weighted equally
+		current += l.Downloaded + l.Extracted + l.Uploaded
+		total += l.DownloadTotal*2 + l.UploadTotal
+		if l.Done {
+			done++
+		}
+	}
+	message := fmt.Sprintf("%d of %d layers complete", done, len(t.layers))
+	t.mu.Unlock()
+
+	t.job.Emit("layer", snapshot)
+	t.job.SetProgress(current, total, message)
+}
+
+// snapshotLayers returns the layers in the order they were announced
+func (t *layerTracker) snapshotLayers() []LayerProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	layers := make([]LayerProgress, 0, len(t.order))
+	for _, id := range t.order {
+		layers = append(layers, *t.layers[id])
+	}
+	return layers
+}
+
+// normalizeImageReference adds the implicit latest tag, since the Engine API
+// pulls every tag of a repository when none is given
+func normalizeImageReference(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	lastSlash := strings.LastIndex(ref, "/")
+	if !strings.Contains(ref[lastSlash+1:], ":") {
+		return ref + ":latest"
+	}
+	return ref
+}
+
+// enginePull pulls an image through the Engine API, reporting every stream
+// message to fn. Cancelling ctx aborts the pull.
+func enginePull(ctx context.Context, reference, platform string, headers map[string]string, fn func(engineMessage)) error {
+	query := url.Values{"fromImage": {reference}}
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+
+	resp, err := engineRequest(ctx, http.MethodPost, "/images/create", query, headers, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := readEngineStream(resp.Body, "docker pull "+reference, fn); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// startPullJob starts a background job pulling an image
+func startPullJob(reference, platform, user string) *Job {
+	return startJob("pull", reference, user, func(ctx context.Context, job *Job) (interface{}, error) {
+		job.SetProgress(0, 0, "Pulling "+reference)
+		started := time.Now()
+
+		tracker := newLayerTracker(job)
+		if err := enginePull(ctx, reference, platform, registryAuthHeaders(reference), tracker.handle); err != nil {
+			return nil, err
+		}
+
+		layers := tracker.snapshotLayers()
+		result := map[string]interface{}{
+			"image":    reference,
+			"platform": platform,
+			"digest":   tracker.digest,
+			"status":   tracker.status,
+			"layers":   layers,
+			"duration": time.Since(started).String(),
+		}
+		if image, err := dockerInspectImageTyped(reference); err == nil {
+			result["id"] = image.ID
+			result["size"] = image.Size
+		}
+
+		job.Emit("complete", result)
+		logrus.WithFields(logrus.Fields{"image": reference, "platform": platform, "status": tracker.status}).Info("Image pulled successfully")
+		return result, nil
+	})
+}
+
+// pullImage starts a pull job and returns it; progress is streamed through
+// /jobs/{id}/events
+func pullImage(w http.ResponseWriter, r *http.Request) {
+	var req PullImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Image = strings.TrimSpace(req.Image)
+	if req.Image == "" {
+		writeError(w, http.StatusBadRequest, "Field 'image' is required")
+		return
+	}
+	if req.Platform != "" && !platformPattern.MatchString(req.Platform) {
+		writeError(w, http.StatusBadRequest, "Invalid platform, use os/arch[/variant] such as linux/arm64")
+		return
+	}
+
+	job := startPullJob(normalizeImageReference(req.Image), req.Platform, r.Header.Get("X-User"))
+	writeJobAccepted(w, job)
+}
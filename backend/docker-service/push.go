@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// imageReferencePattern matches a tag reference such as
+// registry.example.com:5000/team/app:1.2, without a digest
+var imageReferencePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?(:[0-9]+)?/)?[a-z0-9]+([._-]+[a-z0-9]+)*(/[a-z0-9]+([._-]+[a-z0-9]+)*)*(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?$`)
+
+// imageIDPattern matches full or short image IDs
+var imageIDPattern = regexp.MustCompile(`^(sha256:)?[a-f0-9]{12,64}$`)
+
+// TagImageRequest adds a tag to a local image
+type TagImageRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// UntagImageRequest removes one tag from a local image
+type UntagImageRequest struct {
+	Reference string `json:"reference"`
+}
+
+// PushImageRequest pushes a local image
+type PushImageRequest struct {
+	Image string `json:"image"`
+}
+
+// PromoteImageRequest retags an image and pushes it to another repository.
+// The source is pulled first when it is not present locally or Pull is set.
+type PromoteImageRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Pull   bool   `json:"pull"`
+}
+
+// splitImageReference splits a tag reference into repository and tag
+func splitImageReference(reference string) (string, string) {
+	lastSlash := strings.LastIndex(reference, "/")
+	if colon := strings.LastIndex(reference, ":"); colon > lastSlash {
+		return reference[:colon], reference[colon+1:]
+	}
+	return reference, "latest"
+}
+
+// validateTagReference checks a reference that is written as a tag
+func validateTagReference(field, reference string) error {
+	if reference == "" {
+		return fmt.Errorf("field '%s' is required", field)
+	}
+	if strings.Contains(reference, "@") || !imageReferencePattern.MatchString(reference) {
+		return fmt.Errorf("field '%s' is not a valid image reference: %s", field, reference)
+	}
+	return nil
+}
+
+// enginePush pushes a tagged image through the Engine API, reporting every
+// stream message to fn
+func enginePush(ctx context.Context, reference string, headers map[string]string, fn func(engineMessage)) error {
+	if headers == nil {
+		// The engine requires an auth header on push, even an empty one
+		headers = map[string]string{"X-Registry-Auth": encodeRegistryAuth(RegistryAuth{})}
+	}
+	repository, tag := splitImageReference(reference)
+
+	resp, err := engineRequest(ctx, http.MethodPost, "/images/"+repository+"/push", url.Values{"tag": {tag}}, headers, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := readEngineStream(resp.Body, "docker push "+reference, fn); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// pushWithProgress pushes an image, emitting layer events on the job
+func pushWithProgress(ctx context.Context, job *Job, reference string) (*layerTracker, error) {
+	job.Emit("phase", map[string]string{"phase": "push", "image": reference})
+	job.SetProgress(0, 0, "Pushing "+reference)
+
+	tracker := newLayerTracker(job)
+	if err := enginePush(ctx, reference, registryAuthHeaders(reference), tracker.handle); err != nil {
+		return nil, err
+	}
+	return tracker, nil
+}
+
+// startPushJob starts a background job pushing an image
+func startPushJob(reference, user string) *Job {
+	return startJob("push", reference, user, func(ctx context.Context, job *Job) (interface{}, error) {
+		started := time.Now()
+
+		tracker, err := pushWithProgress(ctx, job, reference)
+		if err != nil {
+			recordAudit(user, "images.push", reference, "failure", map[string]interface{}{"job": job.ID, "error": err.Error()})
+			return nil, err
+		}
+
+		result := map[string]interface{}{
+			"image":    reference,
+			"registry": imageRegistryHost(reference),
+			"digest":   tracker.digest,
+			"layers":   tracker.snapshotLayers(),
+			"duration": time.Since(started).String(),
+		}
+		recordAudit(user, "images.push", reference, "success", map[string]interface{}{"job": job.ID, "digest": tracker.digest})
+
+		job.Emit("complete", result)
+		logrus.WithFields(logrus.Fields{"image": reference, "digest": tracker.digest}).Info("Image pushed successfully")
+		return result, nil
+	})
+}
+
+// startPromoteJob starts a background job that pulls a source image if
+// needed, tags it as the target and pushes the target
+func startPromoteJob(req PromoteImageRequest, user string) *Job {
+	return startJob("promote", req.Source+" -> "+req.Target, user, func(ctx context.Context, job *Job) (interface{}, error) {
+		started := time.Now()
+		details := map[string]interface{}{"job": job.ID, "source": req.Source}
+
+		fail := func(err error) (interface{}, error) {
+			details["error"] = err.Error()
+			recordAudit(user, "images.promote", req.Target, "failure", details)
+			return nil, err
+		}
+
+		pulled := false
+		if _, err := dockerInspectImageTyped(req.Source); req.Pull || err != nil {
+			job.Emit("phase", map[string]string{"phase": "pull", "image": req.Source})
+			job.SetProgress(0, 0, "Pulling "+req.Source)
+			tracker := newLayerTracker(job)
+			if err := enginePull(ctx, req.Source, "", registryAuthHeaders(req.Source), tracker.handle); err != nil {
+				return fail(err)
+			}
+			pulled = true
+		}
+
+		job.Emit("phase", map[string]string{"phase": "tag", "image": req.Target})
+		if _, err := executeDockerCommand("tag", req.Source, req.Target); err != nil {
+			return fail(err)
+		}
+
+		tracker, err := pushWithProgress(ctx, job, req.Target)
+		if err != nil {
+			return fail(err)
+		}
+
+		result := map[string]interface{}{
+			"source":   req.Source,
+			"target":   req.Target,
+			"pulled":   pulled,
+			"digest":   tracker.digest,
+			"layers":   tracker.snapshotLayers(),
+			"duration": time.Since(started).String(),
+		}
+		details["digest"] = tracker.digest
+		details["pulled"] = pulled
+		recordAudit(user, "images.promote", req.Target, "success", details)
+
+		job.Emit("complete", result)
+		logrus.WithFields(logrus.Fields{"source": req.Source, "target": req.Target, "digest": tracker.digest}).Info("Image promoted successfully")
+		return result, nil
+	})
+}
+
+// tagImage adds a tag to a local image
+func tagImage(w http.ResponseWriter, r *http.Request) {
+	var req TagImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Source = strings.TrimSpace(req.Source)
+	req.Target = strings.TrimSpace(req.Target)
+	if req.Source == "" {
+		writeError(w, http.StatusBadRequest, "Field 'source' is required")
+		return
+	}
+	if err := validateTagReference("target", req.Target); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Target = normalizeImageReference(req.Target)
+
+	if _, err := executeDockerCommand("tag", req.Source, req.Target); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"source": req.Source, "target": req.Target}).Error("Failed to tag image")
+		writeFailure(w, "Failed to tag image", err)
+		return
+	}
+
+	recordAudit(r.Header.Get("X-User"), "images.tag", req.Target, "success", map[string]interface{}{"source": req.Source})
+	logrus.WithFields(logrus.Fields{"source": req.Source, "target": req.Target}).Info("Image tagged")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Image tagged successfully",
+		"source":  req.Source,
+		"target":  req.Target,
+	})
+}
+
+// untagImage removes a tag from a local image. The last tag of an image is
+// refused, since removing it deletes the image; use DELETE /images/{id}.
+func untagImage(w http.ResponseWriter, r *http.Request) {
+	var req UntagImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := validateTagReference("reference", strings.TrimSpace(req.Reference)); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reference := normalizeImageReference(strings.TrimSpace(req.Reference))
+
+	image, err := dockerInspectImageTyped(reference)
+	if err != nil {
+		writeFailure(w, "Failed to inspect image", err)
+		return
+	}
+	if len(image.RepoTags) <= 1 {
+		writeError(w, http.StatusConflict, "Cannot remove the only tag of image "+image.ID+"; delete the image instead")
+		return
+	}
+
+	if _, err := executeDockerCommand("rmi", reference); err != nil {
+		logrus.WithError(err).WithField("image", reference).Error("Failed to untag image")
+		writeFailure(w, "Failed to untag image", err)
+		return
+	}
+
+	recordAudit(r.Header.Get("X-User"), "images.untag", reference, "success", map[string]interface{}{"image": image.ID})
+	logrus.WithField("image", reference).Info("Image untagged")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":   "Image untagged successfully",
+		"reference": reference,
+		"image":     image.ID,
+	})
+}
+
+// pushImage starts a push job; progress is streamed through /jobs/{id}/events
+func pushImage(w http.ResponseWriter, r *http.Request) {
+	var req PushImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Image = strings.TrimSpace(req.Image)
+	if err := validateTagReference("image", req.Image); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reference := normalizeImageReference(req.Image)
+
+	if _, err := dockerInspectImageTyped(reference); err != nil {
+		writeFailure(w, "Failed to inspect image", err)
+		return
+	}
+
+	job := startPushJob(reference, r.Header.Get("X-User"))
+	writeJobAccepted(w, job)
+}
+
+// promoteImage starts a job retagging an image and pushing it to another
+// registry or repository
+func promoteImage(w http.ResponseWriter, r *http.Request) {
+	var req PromoteImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Source = strings.TrimSpace(req.Source)
+	req.Target = strings.TrimSpace(req.Target)
+	if req.Source == "" {
+		writeError(w, http.StatusBadRequest, "Field 'source' is required")
+		return
+	}
+	if err := validateTagReference("target", req.Target); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !imageIDPattern.MatchString(req.Source) {
+		req.Source = normalizeImageReference(req.Source)
+	}
+	req.Target = normalizeImageReference(req.Target)
+	if req.Source == req.Target {
+		writeError(w, http.StatusBadRequest, "Source and target must differ")
+		return
+	}
+
+	job := startPromoteJob(req, r.Header.Get("X-User"))
+	writeJobAccepted(w, job)
+}
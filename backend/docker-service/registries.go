@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultRegistryHost is the registry of image references without a host
+const defaultRegistryHost = "docker.io"
+
+// Registry is a stored registry endpoint. The password never leaves the
+// service; responses only tell whether one is set.
+type Registry struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Host        string    `json:"host"`
+	Username    string    `json:"username"`
+	HasPassword bool      `json:"has_password"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	password string
+	// passwordErr is set when the stored password cannot be decrypted, for
+	// example after REGISTRY_SECRET_KEY changed
+	passwordErr error
+}
+
+// RegistryRequest creates or updates a registry. An empty password keeps the
+// stored one on update.
+type RegistryRequest struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RegistryAuth is the credential sent to the engine for a registry
+type RegistryAuth struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	ServerAddress string `json:"serveraddress"`
+}
+
+var (
+	secretKeyOnce sync.Once
+	secretKey     []byte
+	secretKeyErr  error
+)
+
+// registrySecretKey returns the key used to encrypt registry passwords. It is
+// derived from REGISTRY_SECRET_KEY, or generated once and kept next to the
+// database with owner-only permissions.
+func registrySecretKey() ([]byte, error) {
+	secretKeyOnce.Do(func() {
+		if secret := os.Getenv("REGISTRY_SECRET_KEY"); secret != "" {
+			sum := sha256.Sum256([]byte(secret))
+			secretKey = sum[:]
+			return
+		}
+
+		keyPath := filepath.Join(dataDirectory, "registry.key")
+		if data, err := os.ReadFile(keyPath); err == nil {
+			secretKey, secretKeyErr = hex.DecodeString(strings.TrimSpace(string(data)))
+			if secretKeyErr == nil && len(secretKey) != 32 {
+				secretKeyErr = fmt.Errorf("%s does not contain a 32 byte key", keyPath)
+			}
+			return
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			secretKeyErr = err
+			return
+		}
+		if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(key)), 0600); err != nil {
+			secretKeyErr = err
+			return
+		}
+		logrus.WithField("path", keyPath).Warn("REGISTRY_SECRET_KEY not provided, generated a key file for registry credentials")
+		secretKey = key
+	})
+	return secretKey, secretKeyErr
+}
+
+// encryptSecret seals a secret with AES-256-GCM
+func encryptSecret(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	key, err := registrySecretKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "v1:" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret opens a secret sealed by encryptSecret
+func decryptSecret(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	encoded, ok := strings.CutPrefix(ciphertext, "v1:")
+	if !ok {
+		return "", fmt.Errorf("unsupported secret format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	key, err := registrySecretKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("secret is too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, was REGISTRY_SECRET_KEY changed? %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// normalizeRegistryHost reduces a registry URL to the host used in image
+// references, e.g. https://registry.example.com:5000/ -> registry.example.com:5000
+func normalizeRegistryHost(host string) string {
+	host = strings.TrimSpace(strings.ToLower(host))
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return defaultRegistryHost
+	}
+	return host
+}
+
+// imageRegistryHost returns the registry host of an image reference
+func imageRegistryHost(reference string) string {
+	first, _, ok := strings.Cut(reference, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return normalizeRegistryHost(first)
+	}
+	return defaultRegistryHost
+}
+
+// engineServerAddress is the address the engine expects for a registry host
+func engineServerAddress(host string) string {
+	if host == defaultRegistryHost {
+		return "https://index.docker.io/v1/"
+	}
+	return host
+}
+
+const registryColumns = `id, name, host, username, secret, created_at, updated_at`
+
+func scanRegistry(row interface{ Scan(...interface{}) error }) (*Registry, error) {
+	var registry Registry
+	var secret string
+	if err := row.Scan(&registry.ID, &registry.Name, &registry.Host, &registry.Username, &secret, &registry.CreatedAt, &registry.UpdatedAt); err != nil {
+		return nil, err
+	}
+	registry.HasPassword = secret != ""
+	password, err := decryptSecret(secret)
+	if err != nil {
+		logrus.WithError(err).WithField("registry", registry.Name).Warn("Failed to decrypt registry password")
+	}
+	registry.password, registry.passwordErr = password, err
+	return &registry, nil
+}
+
+// loadRegistries returns all stored registries
+func loadRegistries() ([]Registry, error) {
+	rows, err := db.Query(`SELECT ` + registryColumns + ` FROM registries ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	registries := []Registry{}
+	for rows.Next() {
+		registry, err := scanRegistry(rows)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to scan registry row")
+			continue
+		}
+		registries = append(registries, *registry)
+	}
+	return registries, rows.Err()
+}
+
+// loadRegistry returns a stored registry by ID
+func loadRegistry(id int64) (*Registry, error) {
+	return scanRegistry(db.QueryRow(`SELECT `+registryColumns+` FROM registries WHERE id = ?`, id))
+}
+
+// registryForImage finds the stored credentials for an image reference's host
+func registryForImage(reference string) *Registry {
+	if db == nil {
+		return nil
+	}
+	registry, err := scanRegistry(db.QueryRow(`SELECT `+registryColumns+` FROM registries WHERE host = ?`, imageRegistryHost(reference)))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logrus.WithError(err).Warn("Failed to look up registry credentials")
+		}
+		return nil
+	}
+	return registry
+}
+
+// auth returns the engine credential of a registry
+func (r *Registry) auth() RegistryAuth {
+	return RegistryAuth{Username: r.Username, Password: r.password, ServerAddress: engineServerAddress(r.Host)}
+}
+
+// encodeRegistryAuth encodes a credential for the X-Registry-Auth header
+func encodeRegistryAuth(auth RegistryAuth) string {
+	data, _ := json.Marshal(auth)
+	return base64.URLEncoding.EncodeToString(data)
+}
+
+// registryAuthHeaders returns the engine headers authenticating against the
+// registry of an image reference, or nil if no credentials are stored
+func registryAuthHeaders(reference string) map[string]string {
+	registry := registryForImage(reference)
+	if registry == nil || registry.Username == "" {
+		return nil
+	}
+	return map[string]string{"X-Registry-Auth": encodeRegistryAuth(registry.auth())}
+}
+
+// engineLogin checks a credential against a registry through the engine
+func engineLogin(ctx context.Context, auth RegistryAuth) (string, error) {
+	body, _ := json.Marshal(auth)
+	resp, err := engineRequest(ctx, http.MethodPost, "/auth", nil, map[string]string{"Content-Type": "application/json"}, strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"Status"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result.Status, nil
+}
+
+// ensureImage pulls an image with stored registry credentials when it is not
+// present locally. Without stored credentials the docker CLI pulls it on run
+// as before.
+func ensureImage(ctx context.Context, reference string) error {
+	headers := registryAuthHeaders(reference)
+	if headers == nil {
+		return nil
+	}
+	if _, err := dockerInspectImageTyped(reference); err == nil {
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{"image": reference, "registry": imageRegistryHost(reference)}).Info("Pulling image with stored registry credentials")
+	return enginePull(ctx, normalizeImageReference(reference), "", headers, func(engineMessage) {})
+}
+
+// validate checks a registry request and normalizes its host
+func (req *RegistryRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Host = normalizeRegistryHost(req.Host)
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Host == "" {
+		return fmt.Errorf("field 'host' is required")
+	}
+	if req.Name == "" {
+		req.Name = req.Host
+	}
+	return nil
+}
+
+func requireDatabase(w http.ResponseWriter) bool {
+	if db == nil {
+		writeError(w, http.StatusServiceUnavailable, "This feature requires the database")
+		return false
+	}
+	return true
+}
+
+func registryID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid registry ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// isUniqueViolation reports whether an insert failed on a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// listRegistries returns the stored registries without their passwords
+func listRegistries(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	registries, err := loadRegistries()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load registries")
+		writeFailure(w, "Failed to load registries", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(registries)
+}
+
+// getRegistry returns one stored registry
+func getRegistry(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	id, ok := registryID(w, r)
+	if !ok {
+		return
+	}
+	registry, err := loadRegistry(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Registry not found")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load registry", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(registry)
+}
+
+// createRegistry stores a registry with its encrypted credentials
+func createRegistry(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	var req RegistryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret, err := encryptSecret(req.Password)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encrypt registry password")
+		writeFailure(w, "Failed to encrypt password", err)
+		return
+	}
+
+	now := time.Now()
+	result, err := db.Exec(`INSERT INTO registries (name, host, username, secret, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		req.Name, req.Host, req.Username, secret, now, now)
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A registry with this name or host already exists")
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to store registry")
+		writeFailure(w, "Failed to store registry", err)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	recordAudit(r.Header.Get("X-User"), "registries.create", req.Host, "success", map[string]interface{}{"name": req.Name, "username": req.Username})
+	logrus.WithFields(logrus.Fields{"registry": req.Name, "host": req.Host}).Info("Registry created")
+
+	registry, err := loadRegistry(id)
+	if err != nil {
+		writeFailure(w, "Failed to load registry", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(registry)
+}
+
+// updateRegistry changes a stored registry
+func updateRegistry(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	id, ok := registryID(w, r)
+	if !ok {
+		return
+	}
+	existing, err := loadRegistry(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Registry not found")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load registry", err)
+		return
+	}
+
+	var req RegistryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Host == "" {
+		req.Host = existing.Host
+	}
+	if req.Name == "" {
+		req.Name = existing.Name
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	password := existing.password
+	if req.Password != "" {
+		password = req.Password
+	} else if existing.passwordErr != nil {
+		// Keeping the password would store it as empty
+		writeError(w, http.StatusConflict, "The stored password cannot be decrypted, was REGISTRY_SECRET_KEY changed? Send the password again")
+		return
+	}
+	secret, err := encryptSecret(password)
+	if err != nil {
+		writeFailure(w, "Failed to encrypt password", err)
+		return
+	}
+
+	_, err = db.Exec(`UPDATE registries SET name = ?, host = ?, username = ?, secret = ?, updated_at = ? WHERE id = ?`,
+		req.Name, req.Host, req.Username, secret, time.Now(), id)
+	if isUniqueViolation(err) {
+		writeError(w, http.StatusConflict, "A registry with this name or host already exists")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to update registry", err)
+		return
+	}
+
+	recordAudit(r.Header.Get("X-User"), "registries.update", req.Host, "success", map[string]interface{}{
+		"name":             req.Name,
+		"username":         req.Username,
+		"password_changed": req.Password != "",
+	})
+
+	registry, err := loadRegistry(id)
+	if err != nil {
+		writeFailure(w, "Failed to load registry", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(registry)
+}
+
+// deleteRegistry removes a stored registry
+func deleteRegistry(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	id, ok := registryID(w, r)
+	if !ok {
+		return
+	}
+	registry, err := loadRegistry(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Registry not found")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load registry", err)
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM registries WHERE id = ?`, id); err != nil {
+		writeFailure(w, "Failed to delete registry", err)
+		return
+	}
+
+	recordAudit(r.Header.Get("X-User"), "registries.delete", registry.Host, "success", map[string]interface{}{"name": registry.Name})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Registry deleted successfully"})
+}
+
+// writeLoginResult runs a test login and reports whether it succeeded. A
+// rejected login is a result, not a failed request.
+func writeLoginResult(w http.ResponseWriter, r *http.Request, auth RegistryAuth) {
+	ctx, cancel := context.WithTimeout(r.Context(), 12*time.Second)
+	defer cancel()
+
+	status, err := engineLogin(ctx, auth)
+	if err != nil {
+		httpStatus, code := classifyError(err)
+		if httpStatus == http.StatusServiceUnavailable {
+			writeFailure(w, "Failed to test login", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": status,
+	})
+}
+
+// testRegistry checks the stored credentials of a registry
+func testRegistry(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	id, ok := registryID(w, r)
+	if !ok {
+		return
+	}
+	registry, err := loadRegistry(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "Registry not found")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load registry", err)
+		return
+	}
+
+	writeLoginResult(w, r, registry.auth())
+}
+
+// testRegistryCredentials checks credentials before they are stored
+func testRegistryCredentials(w http.ResponseWriter, r *http.Request) {
+	var req RegistryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeLoginResult(w, r, RegistryAuth{Username: req.Username, Password: req.Password, ServerAddress: engineServerAddress(req.Host)})
+}
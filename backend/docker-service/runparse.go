@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ImportRunRequest carries a pasted `docker run` command line
+type ImportRunRequest struct {
+	Command  string            `json:"command"`
+	EnvFiles map[string]string `json:"env_files,omitempty"`
+	Run      bool              `json:"run,omitempty"`
+}
+
+// UnsupportedFlag is a flag that has no equivalent in RunContainerRequest
+type UnsupportedFlag struct {
+	Flag  string `json:"flag"`
+	Value string `json:"value,omitempty"`
+}
+
+// ImportRunResult is the outcome of parsing a `docker run` command line
+type ImportRunResult struct {
+	Spec        RunContainerRequest `json:"spec"`
+	Unsupported []UnsupportedFlag   `json:"unsupported"`
+	Warnings    []string            `json:"warnings"`
+	ContainerID string              `json:"containerID,omitempty"`
+}
+
+// runFlagAliases maps short flags and legacy spellings to their long form
+var runFlagAliases = map[string]string{
+	"-d":    "--detach",
+	"-e":    "--env",
+	"-h":    "--hostname",
+	"-i":    "--interactive",
+	"-l":    "--label",
+	"-m":    "--memory",
+	"-p":    "--publish",
+	"-P":    "--publish-all",
+	"-t":    "--tty",
+	"-u":    "--user",
+	"-v":    "--volume",
+	"-w":    "--workdir",
+	"-a":    "--attach",
+	"-c":    "--cpu-shares",
+	"--net": "--network",
+}
+
+// runBoolFlags are the `docker run` flags that never take a separate value
+var runBoolFlags = map[string]bool{
+	"--detach":                true,
+	"--interactive":           true,
+	"--tty":                   true,
+	"--rm":                    true,
+	"--privileged":            true,
+	"--read-only":             true,
+	"--publish-all":           true,
+	"--init":                  true,
+	"--no-healthcheck":        true,
+	"--oom-kill-disable":      true,
+	"--sig-proxy":             true,
+	"--disable-content-trust": true,
+	"--help":                  true,
+	"--quiet":                 true,
+	"--use-api-socket":        true,
+}
+
+// tokenizeCommandLine splits a command line the way a POSIX shell would,
+// handling quotes, backslash escapes and line continuations
+func tokenizeCommandLine(line string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inToken := false
+	runes := []rune(line)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\':
+			if i+1 < len(runes) {
+				i++
+				if runes[i] == '\n' || runes[i] == '\r' {
+					// Line continuation
+					if runes[i] == '\r' && i+1 < len(runes) && runes[i+1] == '\n' {
+						i++
+					}
+					continue
+				}
+				current.WriteRune(runes[i])
+				inToken = true
+			}
+		case r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			current.WriteString(string(runes[i+1 : end]))
+			inToken = true
+			i = end
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				current.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inToken = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		case r == '#' && !inToken:
+			// Comment until end of line
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens, nil
+}
+
+// parseDockerRunCommand converts a `docker run` command line into a run request
+func parseDockerRunCommand(command string, envFiles map[string]string) (*ImportRunResult, error) {
+	tokens, err := tokenizeCommandLine(command)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip prompt, sudo and the docker run prefix
+	for len(tokens) > 0 && (tokens[0] == "$" || tokens[0] == "sudo") {
+		tokens = tokens[1:]
+	}
+	if len(tokens) > 0 && (tokens[0] == "docker" || strings.HasSuffix(tokens[0], "/docker") || tokens[0] == "podman") {
+		tokens = tokens[1:]
+	}
+	if len(tokens) > 0 && tokens[0] == "container" {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 || (tokens[0] != "run" && tokens[0] != "create") {
+		return nil, fmt.Errorf("command is not a docker run command")
+	}
+	tokens = tokens[1:]
+
+	result := &ImportRunResult{
+		Unsupported: []UnsupportedFlag{},
+		Warnings:    []string{},
+	}
+	spec := &result.Spec
+
+	i := 0
+	for i < len(tokens) {
+		token := tokens[i]
+		if token == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(token, "-") || token == "-" {
+			break
+		}
+		i++
+
+		var name, value string
+		hasValue := false
+
+		if strings.HasPrefix(token, "--") {
+			name = token
+			if eq := strings.Index(token, "="); eq != -1 {
+				name, value, hasValue = token[:eq], token[eq+1:], true
+			}
+		} else {
+			// Short flags may be combined (-itd) or carry an attached value (-p80:80)
+			shorts := token[1:]
+			for j := 0; j < len(shorts); j++ {
+				flag := "-" + string(shorts[j])
+				long, known := runFlagAliases[flag]
+				if !known {
+					long = flag
+				}
+				rest := shorts[j+1:]
+				if runBoolFlags[long] {
+					if j == len(shorts)-1 {
+						name = flag
+					} else if err := applyRunFlag(result, long, "", envFiles); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				name = flag
+				if rest != "" {
+					value, hasValue = strings.TrimPrefix(rest, "="), true
+				}
+				break
+			}
+		}
+
+		if long, ok := runFlagAliases[name]; ok {
+			name = long
+		}
+
+		if !runBoolFlags[name] && !hasValue {
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("flag %s requires a value", name)
+			}
+			value = tokens[i]
+			i++
+		} else if runBoolFlags[name] && hasValue && (value == "false" || value == "0") {
+			continue
+		}
+
+		if err := applyRunFlag(result, name, value, envFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	if i >= len(tokens) {
+		return nil, fmt.Errorf("no image specified")
+	}
+	spec.Image = tokens[i]
+	if len(tokens) > i+1 {
+		spec.Command = append([]string{}, tokens[i+1:]...)
+	}
+
+	return result, nil
+}
+
+// applyRunFlag maps one parsed flag onto the run request
+func applyRunFlag(result *ImportRunResult, name, value string, envFiles map[string]string) error {
+	spec := &result.Spec
+
+	switch name {
+	case "--detach":
+		// Containers are always started detached
+	case "--name":
+		spec.Name = value
+	case "--publish":
+		mapping, err := parsePortSpec(value)
+		if err != nil {
+			return err
+		}
+		spec.Ports = append(spec.Ports, mapping)
+	case "--env":
+		if !strings.Contains(value, "=") {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("environment variable %s is taken from the caller's shell and was skipped", value))
+			return nil
+		}
+		spec.Environment = append(spec.Environment, value)
+	case "--env-file":
+		contents, ok := envFiles[value]
+		if !ok {
+			result.Unsupported = append(result.Unsupported, UnsupportedFlag{Flag: name, Value: value})
+			result.Warnings = append(result.Warnings, fmt.Sprintf("contents of env file %s were not provided", value))
+			return nil
+		}
+		env, skipped := parseEnvFile(contents)
+		spec.Environment = append(spec.Environment, env...)
+		for _, key := range skipped {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("environment variable %s in %s has no value and was skipped", key, value))
+		}
+	case "--volume":
+		spec.Volumes = append(spec.Volumes, value)
+	case "--mount":
+		volume, err := mountToVolume(value)
+		if err != nil {
+			result.Unsupported = append(result.Unsupported, UnsupportedFlag{Flag: name, Value: value})
+			result.Warnings = append(result.Warnings, err.Error())
+			return nil
+		}
+		spec.Volumes = append(spec.Volumes, volume)
+	case "--workdir":
+		spec.WorkingDir = value
+	case "--restart":
+		spec.RestartPolicy = value
+	case "--user":
+		spec.User = value
+	case "--hostname":
+		spec.Hostname = value
+	case "--label":
+		key, labelValue, _ := strings.Cut(value, "=")
+		if spec.Labels == nil {
+			spec.Labels = map[string]string{}
+		}
+		spec.Labels[key] = labelValue
+	case "--network":
+		spec.Network = value
+	case "--privileged":
+		spec.Privileged = true
+	case "--read-only":
+		spec.ReadOnly = true
+	case "--rm":
+		spec.AutoRemove = true
+	case "--interactive":
+		spec.Interactive = true
+	case "--tty":
+		spec.Tty = true
+	case "--cap-add":
+		spec.CapAdd = append(spec.CapAdd, value)
+	case "--cap-drop":
+		spec.CapDrop = append(spec.CapDrop, value)
+	case "--dns":
+		spec.DNS = append(spec.DNS, value)
+	case "--add-host":
+		spec.ExtraHosts = append(spec.ExtraHosts, value)
+	case "--memory":
+		memory, err := parseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid memory value %q: %v", value, err)
+		}
+		spec.Memory = memory
+	case "--cpus":
+		cpus, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cpus value %q", value)
+		}
+		spec.CPUs = cpus
+	case "--entrypoint":
+		if value != "" {
+			spec.Entrypoint = []string{value}
+		}
+	case "--health-cmd":
+		spec.HealthCmd = value
+	case "--health-interval":
+		spec.HealthInterval = value
+	case "--health-timeout":
+		spec.HealthTimeout = value
+	case "--health-retries":
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid health retries value %q", value)
+		}
+		spec.HealthRetries = retries
+	case "--stop-signal":
+		spec.StopSignal = value
+	case "--no-healthcheck":
+		spec.NoHealthcheck = true
+	case "--shm-size":
+		size, err := parseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid shm size value %q: %v", value, err)
+		}
+		spec.ShmSize = size
+	case "--tmpfs":
+		spec.Tmpfs = append(spec.Tmpfs, value)
+	case "--device":
+		spec.Devices = append(spec.Devices, value)
+	case "--ulimit":
+		spec.Ulimits = append(spec.Ulimits, value)
+	case "--expose":
+		spec.Expose = append(spec.Expose, value)
+	case "--log-driver":
+		spec.LogDriver = value
+	case "--log-opt":
+		key, optionValue, _ := strings.Cut(value, "=")
+		if spec.LogOptions == nil {
+			spec.LogOptions = map[string]string{}
+		}
+		spec.LogOptions[key] = optionValue
+	default:
+		result.Unsupported = append(result.Unsupported, UnsupportedFlag{Flag: name, Value: value})
+	}
+
+	return nil
+}
+
+// parseEnvFile parses the contents of a docker env file. Variables without a
+// value refer to the caller's environment and are reported separately.
+func parseEnvFile(contents string) ([]string, []string) {
+	var env, skipped []string
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "=") {
+			skipped = append(skipped, line)
+			continue
+		}
+		env = append(env, line)
+	}
+	return env, skipped
+}
+
+// mountToVolume converts a bind or volume --mount specification into -v syntax
+func mountToVolume(spec string) (string, error) {
+	fields := map[string]string{}
+	readOnly := false
+	for _, part := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "readonly", "ro":
+			readOnly = value == "" || value == "true" || value == "1"
+		default:
+			fields[key] = value
+		}
+	}
+
+	mountType := fields["type"]
+	if mountType == "" {
+		mountType = "volume"
+	}
+	if mountType != "bind" && mountType != "volume" {
+		return "", fmt.Errorf("mount type %s is not supported", mountType)
+	}
+
+	source := fields["source"]
+	if source == "" {
+		source = fields["src"]
+	}
+	target := fields["target"]
+	if target == "" {
+		target = fields["destination"]
+	}
+	if target == "" {
+		target = fields["dst"]
+	}
+	if target == "" {
+		return "", fmt.Errorf("mount %s has no target", spec)
+	}
+
+	volume := target
+	if source != "" {
+		volume = source + ":" + target
+	}
+	if readOnly {
+		if source == "" {
+			return "", fmt.Errorf("read-only anonymous mount %s is not supported", spec)
+		}
+		volume += ":ro"
+	}
+	return volume, nil
+}
+
+// parseByteSize parses docker style sizes such as 512m or 2g into bytes
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "b")
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k':
+			multiplier = 1024
+		case 'm':
+			multiplier = 1024 * 1024
+		case 'g':
+			multiplier = 1024 * 1024 * 1024
+		case 't':
+			multiplier = 1024 * 1024 * 1024 * 1024
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size")
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// importRunCommand parses a `docker run` command line and optionally launches it
+func importRunCommand(w http.ResponseWriter, r *http.Request) {
+	var req ImportRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.Command) == "" {
+		writeError(w, http.StatusBadRequest, "Field 'command' is required")
+		return
+	}
+
+	result, err := parseDockerRunCommand(req.Command, req.EnvFiles)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to parse command: "+err.Error())
+		return
+	}
+
+	if req.Run {
+		if !preflightPorts(w, result.Spec.Ports) {
+			return
+		}
+		containerID, err := dockerRun(result.Spec)
+		if err != nil {
+			logrus.WithError(err).WithField("image", result.Spec.Image).Error("Failed to run imported container")
+			writeFailure(w, "Failed to run container", err)
+			return
+		}
+		result.ContainerID = containerID
+		logrus.WithFields(logrus.Fields{
+			"container": containerID,
+			"image":     result.Spec.Image,
+		}).Info("Imported container created and started")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the part size of multipart uploads; objects up to this
+	// size are uploaded with a single PUT
+	s3PartSize = 64 * 1024 * 1024
+	// s3EmptyPayloadHash is the SHA-256 of an empty request body
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Client talks to Amazon S3 or an S3-compatible service such as MinIO,
+// signing requests with AWS Signature Version 4
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	// pathStyle puts the bucket in the path instead of the host name, which
+	// is what most S3-compatible services expect
+	pathStyle bool
+	client    *http.Client
+}
+
+// s3Error is an error response of the service
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed with HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("s3 %s: %s (HTTP %d)", e.Code, e.Message, e.Status)
+}
+
+// newS3Client returns a client for an endpoint, or for AWS in the region
+// when the endpoint is empty
+func newS3Client(endpoint, region, accessKey, secretKey string) (*s3Client, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	pathStyle := endpoint != ""
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: expected http(s)://host[:port]", endpoint)
+	}
+	return &s3Client{
+		endpoint:  u,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		pathStyle: pathStyle,
+		client:    &http.Client{},
+	}, nil
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and '/'
+// unless it is part of a query string
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && keepSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectURL returns the URL of an object, or of the bucket if key is empty
+func (c *s3Client) objectURL(bucket, key string) *url.URL {
+	u := *c.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	// Bucket names with dots do not match the wildcard TLS certificate
+	if c.pathStyle || strings.Contains(bucket, ".") {
+		u.Path = base + "/" + bucket + "/" + key
+		u.RawPath = s3Escape(base, true) + "/" + s3Escape(bucket, false) + "/" + s3Escape(key, true)
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = base + "/" + key
+		u.RawPath = s3Escape(base, true) + "/" + s3Escape(key, true)
+	}
+	return &u
+}
+
+// canonicalQuery encodes query parameters in the order required for signing
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds the Signature Version 4 authorization to a request. The host,
+// x-amz-* and content-md5 headers are signed.
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// do sends a signed request. body may be nil; payloadHash is the SHA-256 of
+// the body, which the service checks. Error responses become *s3Error.
+func (c *s3Client) do(ctx context.Context, method, bucket, key string, query url.Values, body io.Reader, size int64, payloadHash string, headers map[string]string) (*http.Response, error) {
+	u := c.objectURL(bucket, key)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// NewRequest re-parses the URL, so the escaped path is set again
+	req.URL.Path, req.URL.RawPath = u.Path, u.RawPath
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if payloadHash == "" {
+		payloadHash = s3EmptyPayloadHash
+	}
+	c.sign(req, payloadHash, time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readS3Error(resp.StatusCode, resp.Body)
+	}
+	return resp, nil
+}
+
+// readS3Error decodes an XML error response
+func readS3Error(status int, body io.Reader) error {
+	data, _ := io.ReadAll(io.LimitReader(body, 64*1024))
+	s3Err := &s3Error{Status: status}
+	xml.Unmarshal(data, s3Err)
+	return s3Err
+}
+
+// sectionHash returns the SHA-256 of a part of a file
+func sectionHash(file *os.File, offset, size int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, offset, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// putObject uploads a file, in parts when it is larger than s3PartSize.
+// sha256 is the checksum of the whole file and is kept as object metadata.
+func (c *s3Client) putObject(ctx context.Context, bucket, key string, file *os.File, size int64, sha256 string) error {
+	metadata := map[string]string{"X-Amz-Meta-Sha256": sha256, "Content-Type": "application/gzip"}
+	if size <= s3PartSize {
+		resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, io.NewSectionReader(file, 0, size), size, sha256, metadata)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil, 0, "", metadata)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+
+	uploadID := initiated.UploadID
+	if err := c.uploadParts(ctx, bucket, key, uploadID, file, size); err != nil {
+		// Abort so the service frees the uploaded parts
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if resp, abortErr := c.do(abortCtx, http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil, 0, "", nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts uploads a file as the parts of a multipart upload and
+// completes it
+func (c *s3Client) uploadParts(ctx context.Context, bucket, key, uploadID string, file *os.File, size int64) error {
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+s3PartSize, number+1 {
+		length := size - offset
+		if length > s3PartSize {
+			length = s3PartSize
+		}
+		hash, err := sectionHash(file, offset, length)
+		if err != nil {
+			return err
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := c.do(ctx, http.MethodPut, bucket, key, query, io.NewSectionReader(file, offset, length), length, hash, nil)
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", number, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	hash := sha256.Sum256(body)
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(hash[:]), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Completion can fail after the 200 status has been sent
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if bytes.Contains(data, []byte("<Error>")) {
+		return readS3Error(resp.StatusCode, bytes.NewReader(data))
+	}
+	return nil
+}
+
+// getObject downloads an object
+func (c *s3Client) getObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, nil, 0, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// deleteObject removes an object; removing a missing object is not an error
+func (c *s3Client) deleteObject(ctx context.Context, bucket, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, bucket, key, nil, nil, 0, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,1104 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Package databases are read from the image filesystem, exported from a
+// container that is created but never started, so scans need no network
+// access and run nothing from the image.
+
+const (
+	// sbomMaxFileSize bounds a package database or lockfile read from an image
+	sbomMaxFileSize = 256 * 1024 * 1024
+	// sbomCacheSize is the number of scanned images kept in memory
+	sbomCacheSize = 32
+)
+
+// SBOMPackage is a package installed in an image or listed in a lockfile
+type SBOMPackage struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Type          string `json:"type"`
+	Source        string `json:"source,omitempty"`
+	SourceVersion string `json:"source_version,omitempty"`
+	Arch          string `json:"arch,omitempty"`
+	License       string `json:"license,omitempty"`
+	Location      string `json:"location"`
+	PURL          string `json:"purl"`
+}
+
+// osRelease identifies the distribution of an image
+type osRelease struct {
+	ID         string `json:"id"`
+	VersionID  string `json:"version_id"`
+	PrettyName string `json:"pretty_name"`
+}
+
+// imageContents is the result of scanning an image filesystem
+type imageContents struct {
+	ImageID   string
+	OS        osRelease
+	Packages  []SBOMPackage
+	Warnings  []string
+	ScannedAt time.Time
+}
+
+var (
+	sbomCache   = make(map[string]*imageContents)
+	sbomCacheMu sync.Mutex
+)
+
+// lockfileParsers read language lockfiles by file name
+var lockfileParsers = map[string]func(data []byte, location string) []SBOMPackage{
+	"package-lock.json": parsePackageLock,
+	"yarn.lock":         parseYarnLock,
+	"Pipfile.lock":      parsePipfileLock,
+	"poetry.lock":       parsePoetryLock,
+	"requirements.txt":  parseRequirements,
+	"Gemfile.lock":      parseGemfileLock,
+	"Cargo.lock":        parseCargoLock,
+	"composer.lock":     parseComposerLock,
+	"go.mod":            parseGoMod,
+}
+
+// rpmDatabases maps rpm database paths to their format
+var rpmDatabases = map[string]string{
+	"var/lib/rpm/Packages":              "bdb",
+	"var/lib/rpm/rpmdb.sqlite":          "sqlite",
+	"var/lib/rpm/Packages.db":           "ndb",
+	"usr/lib/sysimage/rpm/Packages":     "bdb",
+	"usr/lib/sysimage/rpm/rpmdb.sqlite": "sqlite",
+	"usr/lib/sysimage/rpm/Packages.db":  "ndb",
+}
+
+// scanImage lists the packages of an image. Results are cached by image ID
+// since image filesystems never change.
+func scanImage(ctx context.Context, reference string) (*ImageInspect, *imageContents, error) {
+	image, err := dockerInspectImageTyped(reference)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sbomCacheMu.Lock()
+	cached := sbomCache[image.ID]
+	sbomCacheMu.Unlock()
+	if cached != nil {
+		return image, cached, nil
+	}
+
+	// The entrypoint is never run; it only lets images without a command be created
+	output, err := executeDockerCommand("create", "--network", "none", "--label", "dockmaster.sbom=true", "--entrypoint", "/dockmaster-sbom", image.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	containerID := strings.TrimSpace(string(output))
+	defer func() {
+		if err := dockerRemove(containerID, true); err != nil {
+			logrus.WithError(err).WithField("container", containerID).Warn("Failed to remove SBOM scan container")
+		}
+	}()
+
+	proc, err := startDockerCommand(ctx, nil, "export", containerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	contents, scanErr := scanFilesystem(proc.Stdout)
+	// Drain the rest of the export so the CLI can exit
+	io.Copy(io.Discard, proc.Stdout)
+	if err := proc.Wait(); err != nil {
+		return nil, nil, err
+	}
+	if scanErr != nil {
+		return nil, nil, fmt.Errorf("failed to read image filesystem: %v", scanErr)
+	}
+	contents.ImageID = image.ID
+
+	sbomCacheMu.Lock()
+	if len(sbomCache) >= sbomCacheSize {
+		for id := range sbomCache {
+			delete(sbomCache, id)
+			break
+		}
+	}
+	sbomCache[image.ID] = contents
+	sbomCacheMu.Unlock()
+
+	logrus.WithFields(logrus.Fields{"image": reference, "packages": len(contents.Packages)}).Info("Image scanned")
+	return image, contents, nil
+}
+
+// scanFilesystem reads package databases and lockfiles from a flattened
+// filesystem tarball
+func scanFilesystem(r io.Reader) (*imageContents, error) {
+	contents := &imageContents{ScannedAt: time.Now()}
+	var packages []SBOMPackage
+	osReleases := make(map[string][]byte)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		location := "/" + name
+
+		read := func() ([]byte, bool) {
+			if header.Size > sbomMaxFileSize {
+				contents.Warnings = append(contents.Warnings, fmt.Sprintf("%s is too large to read", location))
+				return nil, false
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				contents.Warnings = append(contents.Warnings, fmt.Sprintf("failed to read %s: %v", location, err))
+				return nil, false
+			}
+			return data, true
+		}
+
+		switch {
+		case name == "etc/os-release" || name == "usr/lib/os-release":
+			if data, ok := read(); ok {
+				osReleases[name] = data
+			}
+		case name == "var/lib/dpkg/status" || (path.Dir(name) == "var/lib/dpkg/status.d" && !strings.HasSuffix(name, ".md5sums")):
+			if data, ok := read(); ok {
+				packages = append(packages, parseDpkgStatus(data, location)...)
+			}
+		case name == "lib/apk/db/installed":
+			if data, ok := read(); ok {
+				packages = append(packages, parseApkInstalled(data, location)...)
+			}
+		case rpmDatabases[name] != "":
+			data, ok := read()
+			if !ok {
+				continue
+			}
+			found, err := parseRPMDatabase(data, rpmDatabases[name], location)
+			if err != nil {
+				contents.Warnings = append(contents.Warnings, fmt.Sprintf("%s: %v", location, err))
+			}
+			packages = append(packages, found...)
+		default:
+			parse := lockfileParsers[path.Base(name)]
+			// Lockfiles of installed dependencies and module caches describe
+			// packages, not the application
+			if parse == nil || strings.Contains(location, "/node_modules/") || strings.Contains(location, "/pkg/mod/") ||
+				strings.HasPrefix(name, "proc/") || strings.HasPrefix(name, "sys/") {
+				continue
+			}
+			if data, ok := read(); ok {
+				packages = append(packages, parse(data, location)...)
+			}
+		}
+	}
+
+	release := osReleases["etc/os-release"]
+	if release == nil {
+		release = osReleases["usr/lib/os-release"]
+	}
+	contents.OS = parseOSRelease(release)
+
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		if pkg.Name == "" || pkg.Version == "" {
+			continue
+		}
+		key := pkg.Type + "\x00" + pkg.Name + "\x00" + pkg.Version + "\x00" + pkg.Location
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		pkg.PURL = packageURL(pkg, contents.OS)
+		contents.Packages = append(contents.Packages, pkg)
+	}
+	sort.Slice(contents.Packages, func(i, j int) bool {
+		a, b := contents.Packages[i], contents.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Location < b.Location
+	})
+	return contents, nil
+}
+
+// parseOSRelease reads the fields of an os-release file
+func parseOSRelease(data []byte) osRelease {
+	var release osRelease
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			release.ID = strings.ToLower(value)
+		case "VERSION_ID":
+			release.VersionID = value
+		case "PRETTY_NAME":
+			release.PrettyName = value
+		}
+	}
+	return release
+}
+
+// controlFields parses one stanza of a Debian control file
+func controlFields(stanza string) map[string]string {
+	fields := make(map[string]string)
+	var last string
+	for _, line := range strings.Split(stanza, "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if last != "" {
+				fields[last] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		last = key
+		fields[key] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// parseDpkgStatus lists the installed packages of a dpkg status file, or of
+// a distroless status.d entry
+func parseDpkgStatus(data []byte, location string) []SBOMPackage {
+	var packages []SBOMPackage
+	for _, stanza := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n\n") {
+		fields := controlFields(stanza)
+		if fields["Package"] == "" {
+			continue
+		}
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		pkg := SBOMPackage{
+			Name:     fields["Package"],
+			Version:  fields["Version"],
+			Type:     "deb",
+			Arch:     fields["Architecture"],
+			Location: location,
+		}
+		// Source is "name" or "name (version)" when the versions differ
+		if source := fields["Source"]; source != "" {
+			name, version, _ := strings.Cut(source, " ")
+			pkg.Source = name
+			pkg.SourceVersion = strings.Trim(version, "()")
+		}
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+// parseApkInstalled lists the packages of an apk installed database
+func parseApkInstalled(data []byte, location string) []SBOMPackage {
+	var packages []SBOMPackage
+	pkg := SBOMPackage{Type: "apk", Location: location}
+	flush := func() {
+		if pkg.Name != "" {
+			packages = append(packages, pkg)
+		}
+		pkg = SBOMPackage{Type: "apk", Location: location}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'P':
+			pkg.Name = value
+		case 'V':
+			pkg.Version = value
+		case 'A':
+			pkg.Arch = value
+		case 'L':
+			pkg.License = value
+		case 'o':
+			pkg.Source = value
+		}
+	}
+	flush()
+	return packages
+}
+
+// RPM header tags
+const (
+	rpmTagName      = 1000
+	rpmTagVersion   = 1001
+	rpmTagRelease   = 1002
+	rpmTagEpoch     = 1003
+	rpmTagLicense   = 1014
+	rpmTagArch      = 1022
+	rpmTagSourceRPM = 1044
+)
+
+// parseRPMDatabase lists the packages of an rpm database
+func parseRPMDatabase(data []byte, format, location string) ([]SBOMPackage, error) {
+	var blobs [][]byte
+	switch format {
+	case "sqlite":
+		var err error
+		if blobs, err = readRPMSqlite(data); err != nil {
+			return nil, err
+		}
+	case "bdb":
+		var err error
+		if blobs, err = readBerkeleyDBValues(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("rpm %s databases are not supported", format)
+	}
+
+	var packages []SBOMPackage
+	for _, blob := range blobs {
+		pkg, err := parseRPMHeader(blob)
+		if err != nil || pkg.Name == "gpg-pubkey" {
+			continue
+		}
+		pkg.Location = location
+		packages = append(packages, pkg)
+	}
+	return packages, nil
+}
+
+// readRPMSqlite returns the header blobs of an rpmdb.sqlite database
+func readRPMSqlite(data []byte) ([][]byte, error) {
+	dir, err := os.MkdirTemp("", "dockmaster-rpmdb-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rpmdb.sqlite")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return nil, err
+	}
+
+	rpmdb, err := sql.Open("sqlite3", "file:"+file+"?mode=ro&immutable=1")
+	if err != nil {
+		return nil, err
+	}
+	defer rpmdb.Close()
+
+	rows, err := rpmdb.Query(`SELECT blob FROM Packages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var blobs [][]byte
+	for rows.Next() {
+		var blob []byte
+		if err := rows.Scan(&blob); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// readBerkeleyDBValues returns the values of a Berkeley DB hash database,
+// the format of the rpm Packages file before rpm 4.16. rpm headers are
+// always large enough to be stored on overflow pages.
+func readBerkeleyDBValues(data []byte) ([][]byte, error) {
+	const (
+		hashMagic      = 0x061561
+		pageHash       = 13
+		pageHashOld    = 2
+		pageOverflow   = 7
+		itemOffPage    = 3
+		pageHeaderSize = 26
+	)
+	if len(data) < 512 {
+		return nil, errors.New("not a Berkeley DB hash database")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(data[12:16]) != hashMagic {
+		order = binary.BigEndian
+		if order.Uint32(data[12:16]) != hashMagic {
+			return nil, errors.New("not a Berkeley DB hash database")
+		}
+	}
+	pageSize := int(order.Uint32(data[20:24]))
+	if pageSize < 512 || pageSize > 65536 {
+		return nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	lastPage := int(order.Uint32(data[32:36]))
+	page := func(n int) []byte {
+		offset := n * pageSize
+		if n <= 0 || offset+pageSize > len(data) {
+			return nil
+		}
+		return data[offset : offset+pageSize]
+	}
+
+	var values [][]byte
+	for n := 1; n <= lastPage; n++ {
+		p := page(n)
+		if p == nil {
+			break
+		}
+		if p[25] != pageHash && p[25] != pageHashOld {
+			continue
+		}
+		entries := int(order.Uint16(p[20:22]))
+		// Entries alternate between keys and values
+		for i := 1; i < entries; i += 2 {
+			index := pageHeaderSize + i*2
+			if index+2 > len(p) {
+				break
+			}
+			offset := int(order.Uint16(p[index:]))
+			if offset+12 > len(p) || p[offset] != itemOffPage {
+				continue
+			}
+			next := int(order.Uint32(p[offset+4:]))
+			total := int(order.Uint32(p[offset+8:]))
+
+			var value []byte
+			for next != 0 && len(value) < total {
+				overflow := page(next)
+				if overflow == nil || overflow[25] != pageOverflow {
+					break
+				}
+				length := int(order.Uint16(overflow[22:24]))
+				if pageHeaderSize+length > len(overflow) {
+					break
+				}
+				value = append(value, overflow[pageHeaderSize:pageHeaderSize+length]...)
+				next = int(order.Uint32(overflow[16:20]))
+			}
+			if len(value) >= total {
+				values = append(values, value[:total])
+			}
+		}
+	}
+	return values, nil
+}
+
+// parseRPMHeader reads package fields from an rpm header blob
+func parseRPMHeader(blob []byte) (SBOMPackage, error) {
+	pkg := SBOMPackage{Type: "rpm"}
+	if len(blob) < 8 {
+		return pkg, errors.New("header too short")
+	}
+	count := int(binary.BigEndian.Uint32(blob[0:4]))
+	size := int(binary.BigEndian.Uint32(blob[4:8]))
+	start := 8 + count*16
+	if count < 0 || size < 0 || count > len(blob) || start+size > len(blob) {
+		return pkg, errors.New("invalid header")
+	}
+	store := blob[start : start+size]
+
+	stringAt := func(offset int) string {
+		if offset < 0 || offset >= len(store) {
+			return ""
+		}
+		end := offset
+		for end < len(store) && store[end] != 0 {
+			end++
+		}
+		return string(store[offset:end])
+	}
+
+	var version, release, sourceRPM string
+	epoch := -1
+	for i := 0; i < count; i++ {
+		entry := blob[8+i*16 : 8+i*16+16]
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		kind := binary.BigEndian.Uint32(entry[4:8])
+		offset := int(int32(binary.BigEndian.Uint32(entry[8:12])))
+		isString := kind == 6 || kind == 8 || kind == 9
+
+		switch tag {
+		case rpmTagName:
+			if isString {
+				pkg.Name = stringAt(offset)
+			}
+		case rpmTagVersion:
+			if isString {
+				version = stringAt(offset)
+			}
+		case rpmTagRelease:
+			if isString {
+				release = stringAt(offset)
+			}
+		case rpmTagEpoch:
+			if kind == 4 && offset >= 0 && offset+4 <= len(store) {
+				epoch = int(binary.BigEndian.Uint32(store[offset:]))
+			}
+		case rpmTagLicense:
+			if isString {
+				pkg.License = stringAt(offset)
+			}
+		case rpmTagArch:
+			if isString {
+				pkg.Arch = stringAt(offset)
+			}
+		case rpmTagSourceRPM:
+			if isString {
+				sourceRPM = stringAt(offset)
+			}
+		}
+	}
+
+	pkg.Version = version
+	if release != "" {
+		pkg.Version += "-" + release
+	}
+	if epoch > 0 {
+		pkg.Version = fmt.Sprintf("%d:%s", epoch, pkg.Version)
+	}
+	// The source rpm is name-version-release.src.rpm
+	if name := strings.TrimSuffix(strings.TrimSuffix(sourceRPM, ".rpm"), ".src"); name != "" {
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			name = name[:i]
+			if i := strings.LastIndex(name, "-"); i > 0 {
+				pkg.Source = name[:i]
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// parsePackageLock lists the packages of an npm package-lock.json
+func parsePackageLock(data []byte, location string) []SBOMPackage {
+	type dependency struct {
+		Version      string                     `json:"version"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	if json.Unmarshal(data, &lock) != nil {
+		return nil
+	}
+
+	var packages []SBOMPackage
+	if len(lock.Packages) > 0 {
+		for key, pkg := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || pkg.Link || pkg.Version == "" {
+				continue
+			}
+			packages = append(packages, SBOMPackage{Name: key[i+len("node_modules/"):], Version: pkg.Version, Type: "npm", Location: location})
+		}
+		return packages
+	}
+
+	// Lockfile version 1 nests dependencies
+	var walk func(map[string]json.RawMessage)
+	walk = func(dependencies map[string]json.RawMessage) {
+		for name, raw := range dependencies {
+			var dep dependency
+			if json.Unmarshal(raw, &dep) != nil {
+				continue
+			}
+			if dep.Version != "" {
+				packages = append(packages, SBOMPackage{Name: name, Version: dep.Version, Type: "npm", Location: location})
+			}
+			walk(dep.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return packages
+}
+
+// parseYarnLock lists the packages of a yarn.lock, classic or berry
+func parseYarnLock(data []byte, location string) []SBOMPackage {
+	var packages []SBOMPackage
+	var name string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			name = ""
+			if !strings.HasSuffix(line, ":") {
+				continue
+			}
+			spec := strings.Trim(strings.TrimSpace(strings.Split(strings.TrimSuffix(line, ":"), ",")[0]), `"`)
+			if strings.Contains(spec, "@workspace:") || strings.HasPrefix(spec, "__metadata") {
+				continue
+			}
+			if i := strings.LastIndex(spec, "@"); i > 0 {
+				name = spec[:i]
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if name != "" && !strings.HasPrefix(line, "   ") && strings.HasPrefix(trimmed, "version") {
+			version := strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(trimmed, "version"), ":")), `"`)
+			packages = append(packages, SBOMPackage{Name: name, Version: version, Type: "npm", Location: location})
+			name = ""
+		}
+	}
+	return packages
+}
+
+// parsePipfileLock lists the packages of a Pipfile.lock
+func parsePipfileLock(data []byte, location string) []SBOMPackage {
+	var lock map[string]json.RawMessage
+	if json.Unmarshal(data, &lock) != nil {
+		return nil
+	}
+	var packages []SBOMPackage
+	for _, section := range []string{"default", "develop"} {
+		var entries map[string]struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(lock[section], &entries) != nil {
+			continue
+		}
+		for name, entry := range entries {
+			if version := strings.TrimPrefix(entry.Version, "=="); version != "" {
+				packages = append(packages, SBOMPackage{Name: name, Version: version, Type: "pypi", Location: location})
+			}
+		}
+	}
+	return packages
+}
+
+// tomlPackages reads name and version of the [[package]] tables of a
+// poetry.lock or Cargo.lock
+func tomlPackages(data []byte, kind, location string) []SBOMPackage {
+	var packages []SBOMPackage
+	var current *SBOMPackage
+	flush := func() {
+		if current != nil && current.Name != "" {
+			packages = append(packages, *current)
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			flush()
+			if line == "[[package]]" {
+				current = &SBOMPackage{Type: kind, Location: location}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch strings.TrimSpace(key) {
+		case "name":
+			current.Name = value
+		case "version":
+			current.Version = value
+		}
+	}
+	flush()
+	return packages
+}
+
+func parsePoetryLock(data []byte, location string) []SBOMPackage {
+	return tomlPackages(data, "pypi", location)
+}
+
+func parseCargoLock(data []byte, location string) []SBOMPackage {
+	return tomlPackages(data, "cargo", location)
+}
+
+// parseRequirements lists the pinned packages of a requirements.txt
+func parseRequirements(data []byte, location string) []SBOMPackage {
+	var packages []SBOMPackage
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-") || strings.Contains(line, "===") {
+			continue
+		}
+		name, version, ok := strings.Cut(line, "==")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, "[")
+		version, _, _ = strings.Cut(version, ";")
+		version = strings.Fields(version + " ")[0]
+		if name = strings.TrimSpace(name); name != "" && version != "" {
+			packages = append(packages, SBOMPackage{Name: name, Version: version, Type: "pypi", Location: location})
+		}
+	}
+	return packages
+}
+
+// parseGemfileLock lists the gems of a Gemfile.lock
+func parseGemfileLock(data []byte, location string) []SBOMPackage {
+	var packages []SBOMPackage
+	inGems := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" && line[0] != ' ' {
+			inGems = line == "GEM"
+			continue
+		}
+		// Specs are indented by four spaces, their dependencies by six
+		if !inGems || !strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "     ") {
+			continue
+		}
+		name, version, ok := strings.Cut(strings.TrimSpace(line), " (")
+		if !ok {
+			continue
+		}
+		version = strings.TrimSuffix(version, ")")
+		// Platform gems carry the platform after the version
+		if i := strings.Index(version, "-"); i > 0 {
+			version = version[:i]
+		}
+		packages = append(packages, SBOMPackage{Name: name, Version: version, Type: "gem", Location: location})
+	}
+	return packages
+}
+
+// parseComposerLock lists the packages of a composer.lock
+func parseComposerLock(data []byte, location string) []SBOMPackage {
+	var lock struct {
+		Packages    []struct{ Name, Version string } `json:"packages"`
+		PackagesDev []struct{ Name, Version string } `json:"packages-dev"`
+	}
+	if json.Unmarshal(data, &lock) != nil {
+		return nil
+	}
+	var packages []SBOMPackage
+	for _, pkg := range append(lock.Packages, lock.PackagesDev...) {
+		packages = append(packages, SBOMPackage{Name: pkg.Name, Version: strings.TrimPrefix(pkg.Version, "v"), Type: "composer", Location: location})
+	}
+	return packages
+}
+
+// parseGoMod lists the required modules of a go.mod
+func parseGoMod(data []byte, location string) []SBOMPackage {
+	var packages []SBOMPackage
+	inRequire := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inRequire && fields[0] == ")":
+			inRequire = false
+			continue
+		case fields[0] == "require" && len(fields) >= 2 && fields[1] == "(":
+			inRequire = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		case !inRequire:
+			continue
+		}
+		if len(fields) >= 2 {
+			packages = append(packages, SBOMPackage{Name: fields[0], Version: fields[1], Type: "golang", Location: location})
+		}
+	}
+	return packages
+}
+
+// packageURL builds the purl of a package
+func packageURL(pkg SBOMPackage, release osRelease) string {
+	escape := func(value string) string {
+		return strings.ReplaceAll(url.PathEscape(value), "@", "%40")
+	}
+	escapePath := func(value string) string {
+		parts := strings.Split(value, "/")
+		for i, part := range parts {
+			parts[i] = escape(part)
+		}
+		return strings.Join(parts, "/")
+	}
+
+	qualifiers := url.Values{}
+	version := pkg.Version
+	var purl string
+	switch pkg.Type {
+	case "deb", "apk", "rpm":
+		namespace := release.ID
+		if namespace == "" {
+			namespace = map[string]string{"deb": "debian", "apk": "alpine", "rpm": "redhat"}[pkg.Type]
+		}
+		if pkg.Type == "rpm" {
+			if epoch, rest, ok := strings.Cut(version, ":"); ok {
+				qualifiers.Set("epoch", epoch)
+				version = rest
+			}
+		}
+		if pkg.Arch != "" {
+			qualifiers.Set("arch", pkg.Arch)
+		}
+		if release.ID != "" && release.VersionID != "" {
+			qualifiers.Set("distro", release.ID+"-"+release.VersionID)
+		}
+		purl = "pkg:" + pkg.Type + "/" + escape(namespace) + "/" + escape(pkg.Name)
+	case "pypi":
+		purl = "pkg:pypi/" + escape(normalizePyPIName(pkg.Name))
+	case "npm", "composer", "golang":
+		purl = "pkg:" + pkg.Type + "/" + escapePath(pkg.Name)
+	default:
+		purl = "pkg:" + pkg.Type + "/" + escape(pkg.Name)
+	}
+	purl += "@" + escape(version)
+	if len(qualifiers) > 0 {
+		purl += "?" + qualifiers.Encode()
+	}
+	return purl
+}
+
+// newDocumentID returns a random UUID for SBOM serial numbers
+func newDocumentID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// imageDisplayName names an image by its first tag when scanned by ID
+func imageDisplayName(reference string, image *ImageInspect) string {
+	if strings.HasPrefix(reference, "sha256:") || strings.HasPrefix(image.ID, "sha256:"+reference) {
+		if len(image.RepoTags) > 0 {
+			return image.RepoTags[0]
+		}
+	}
+	return reference
+}
+
+// cycloneDXDocument builds a CycloneDX 1.5 SBOM, including the vulnerabilities
+// found in the local vulnerability database
+func cycloneDXDocument(name string, image *ImageInspect, contents *imageContents, matches []VulnerabilityMatch) map[string]interface{} {
+	components := []map[string]interface{}{}
+	if contents.OS.ID != "" {
+		components = append(components, map[string]interface{}{
+			"type":        "operating-system",
+			"bom-ref":     "os:" + contents.OS.ID,
+			"name":        contents.OS.ID,
+			"version":     contents.OS.VersionID,
+			"description": contents.OS.PrettyName,
+		})
+	}
+	for _, pkg := range contents.Packages {
+		component := map[string]interface{}{
+			"type":    "library",
+			"bom-ref": pkg.PURL + "#" + pkg.Location,
+			"name":    pkg.Name,
+			"version": pkg.Version,
+			"purl":    pkg.PURL,
+			"properties": []map[string]string{
+				{"name": "dockmaster:package:type", "value": pkg.Type},
+				{"name": "dockmaster:package:location", "value": pkg.Location},
+			},
+		}
+		if pkg.License != "" {
+			component["licenses"] = []map[string]interface{}{{"license": map[string]string{"name": pkg.License}}}
+		}
+		components = append(components, component)
+	}
+
+	vulnerabilities := []map[string]interface{}{}
+	for _, match := range matches {
+		vulnerability := map[string]interface{}{
+			"id":          match.ID,
+			"source":      map[string]string{"name": "OSV"},
+			"ratings":     []map[string]interface{}{{"severity": match.Severity}},
+			"description": match.Summary,
+			"affects":     []map[string]string{{"ref": match.PURL + "#" + match.Location}},
+		}
+		if match.Score > 0 {
+			vulnerability["ratings"] = []map[string]interface{}{{"severity": match.Severity, "score": match.Score, "method": "CVSSv31"}}
+		}
+		if match.FixedVersion != "" {
+			vulnerability["recommendation"] = "Upgrade " + match.Package + " to " + match.FixedVersion
+		}
+		if len(match.Aliases) > 0 {
+			references := []map[string]interface{}{}
+			for _, alias := range match.Aliases {
+				references = append(references, map[string]interface{}{"id": alias, "source": map[string]string{"name": "OSV"}})
+			}
+			vulnerability["references"] = references
+		}
+		vulnerabilities = append(vulnerabilities, vulnerability)
+	}
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + newDocumentID(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": contents.ScannedAt.UTC().Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []map[string]string{{"type": "application", "name": "DockMaster"}},
+			},
+			"component": map[string]interface{}{
+				"type":    "container",
+				"bom-ref": image.ID,
+				"name":    name,
+				"version": image.ID,
+			},
+		},
+		"components":      components,
+		"vulnerabilities": vulnerabilities,
+	}
+}
+
+// spdxDocument builds an SPDX 2.3 SBOM
+func spdxDocument(name string, image *ImageInspect, contents *imageContents) map[string]interface{} {
+	packages := []map[string]interface{}{{
+		"name":                  name,
+		"SPDXID":                "SPDXRef-Image",
+		"versionInfo":           image.ID,
+		"downloadLocation":      "NOASSERTION",
+		"filesAnalyzed":         false,
+		"primaryPackagePurpose": "CONTAINER",
+	}}
+	relationships := []map[string]string{{
+		"spdxElementId":      "SPDXRef-DOCUMENT",
+		"relationshipType":   "DESCRIBES",
+		"relatedSpdxElement": "SPDXRef-Image",
+	}}
+
+	for i, pkg := range contents.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		entry := map[string]interface{}{
+			"name":             pkg.Name,
+			"SPDXID":           id,
+			"versionInfo":      pkg.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseConcluded": "NOASSERTION",
+			"licenseDeclared":  "NOASSERTION",
+			"sourceInfo":       "found in " + pkg.Location,
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  pkg.PURL,
+			}},
+		}
+		// Package databases hold free-form license text, not SPDX expressions
+		if pkg.License != "" {
+			entry["licenseComments"] = pkg.License
+		}
+		packages = append(packages, entry)
+		relationships = append(relationships, map[string]string{
+			"spdxElementId":      "SPDXRef-Image",
+			"relationshipType":   "CONTAINS",
+			"relatedSpdxElement": id,
+		})
+	}
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": "https://dockmaster.local/spdx/" + strings.TrimPrefix(image.ID, "sha256:") + "/" + newDocumentID(),
+		"creationInfo": map[string]interface{}{
+			"created":  contents.ScannedAt.UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: DockMaster"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// getImageSBOM returns the SBOM of an image as CycloneDX (default) or SPDX
+// JSON. CycloneDX documents include matches from the vulnerability database.
+func getImageSBOM(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "cyclonedx"
+	}
+	if format != "cyclonedx" && format != "spdx" {
+		writeError(w, http.StatusBadRequest, "Format must be cyclonedx or spdx")
+		return
+	}
+
+	disableTimeouts(w)
+	image, contents, err := scanImage(r.Context(), id)
+	if err != nil {
+		logrus.WithError(err).WithField("image", id).Error("Failed to scan image")
+		writeFailure(w, "Failed to generate SBOM", err)
+		return
+	}
+	name := imageDisplayName(id, image)
+
+	var document map[string]interface{}
+	contentType := "application/spdx+json"
+	if format == "cyclonedx" {
+		matches, err := matchVulnerabilities(contents)
+		if err != nil {
+			writeFailure(w, "Failed to match vulnerabilities", err)
+			return
+		}
+		document = cycloneDXDocument(name, image, contents, matches)
+		contentType = "application/vnd.cyclonedx+json"
+	} else {
+		document = spdxDocument(name, image, contents)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if r.URL.Query().Get("download") == "true" {
+		filename := strings.Trim(unsafeFilenameChars.ReplaceAllString(name, "_"), "_") + "." + format + ".json"
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	json.NewEncoder(w).Encode(document)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runGuard keeps more than one run of the same stored item from happening
+// at a time
+type runGuard struct {
+	mu      sync.Mutex
+	running map[int64]bool
+}
+
+func newRunGuard() *runGuard {
+	return &runGuard{running: make(map[int64]bool)}
+}
+
+// acquire marks id as running. It returns false if a run is in progress.
+func (g *runGuard) acquire(id int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running[id] {
+		return false
+	}
+	g.running[id] = true
+	return true
+}
+
+// release ends the run of id
+func (g *runGuard) release(id int64) {
+	g.mu.Lock()
+	delete(g.running, id)
+	g.mu.Unlock()
+}
+
+// busy reports whether id is running
+func (g *runGuard) busy(id int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.running[id]
+}
+
+// scheduledItem is a stored item, such as a cleanup policy, that runs on a
+// cron schedule
+type scheduledItem struct {
+	ID        int64
+	Name      string
+	Schedule  string
+	Enabled   bool
+	NextRunAt *time.Time
+	run       func(ctx context.Context) error
+}
+
+// dueRunner runs the scheduled items of one table when they are due. The
+// table needs id and next_run_at columns.
+type dueRunner struct {
+	// kind names the items in log messages, e.g. "cleanup policy"
+	kind     string
+	table    string
+	interval time.Duration
+	timeout  time.Duration
+	load     func() ([]scheduledItem, error)
+}
+
+// start runs due items in the background. An item whose run was missed
+// while the service was down runs once on startup.
+func (r *dueRunner) start() {
+	if db == nil {
+		logrus.WithField("kind", r.kind).Warn("Scheduling requires the database, scheduler not started")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.runDue(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// runDue starts every enabled item whose next run has passed
+func (r *dueRunner) runDue(now time.Time) {
+	items, err := r.load()
+	if err != nil {
+		logrus.WithError(err).WithField("kind", r.kind).Error("Failed to load scheduled items")
+		return
+	}
+	for i := range items {
+		item := &items[i]
+		if !item.Enabled || item.NextRunAt == nil || item.NextRunAt.After(now) {
+			continue
+		}
+		log := logrus.WithFields(logrus.Fields{"kind": r.kind, "name": item.Name})
+
+		// Advance the schedule first so a failing run is not retried every tick
+		if _, err := db.Exec(`UPDATE `+r.table+` SET next_run_at = ? WHERE id = ?`, nextRunTime(item.Schedule, true, now), item.ID); err != nil {
+			log.WithError(err).Error("Failed to schedule next run")
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+			defer cancel()
+			if err := item.run(ctx); err != nil {
+				log.WithError(err).Warn("Scheduled run did not happen")
+			}
+		}()
+	}
+}
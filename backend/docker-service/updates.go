@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// updateCheckSpacing is the minimum time between two requests to one registry
+	updateCheckSpacing = 2 * time.Second
+	// updateRateLimitBackoff pauses checks against a registry that rate limited us
+	updateRateLimitBackoff = time.Hour
+)
+
+// Update check statuses
+const (
+	UpdateUpToDate  = "up_to_date"
+	UpdateAvailable = "update_available"
+	UpdateLocalOnly = "local_only"
+	UpdateError     = "error"
+)
+
+// ImageUpdate is the last update check of a local image tag. Images that
+// were built or loaded locally have no registry digest to compare.
+type ImageUpdate struct {
+	Reference       string    `json:"reference"`
+	ImageID         string    `json:"image_id"`
+	Status          string    `json:"status"`
+	UpdateAvailable bool      `json:"update_available"`
+	LocalDigest     string    `json:"local_digest,omitempty"`
+	RemoteDigest    string    `json:"remote_digest,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	CheckedAt       time.Time `json:"checked_at"`
+	notifiedDigest  string
+}
+
+var (
+	imageUpdates   = make(map[string]*ImageUpdate)
+	imageUpdatesMu sync.RWMutex
+
+	// updateCheckMu serializes check passes; the registry maps below are
+	// only used while holding it
+	updateCheckMu     sync.Mutex
+	registryNextCheck = make(map[string]time.Time)
+	registryBackoff   = make(map[string]time.Time)
+)
+
+// updateCheckInterval is how often tags are checked in the background, and
+// how old a result may be before a background pass checks it again
+func updateCheckInterval() time.Duration {
+	value := getEnvOrDefault("UPDATE_CHECK_INTERVAL", "6h")
+	if value == "off" || value == "0" {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Minute {
+		logrus.WithField("value", value).Warn("Invalid UPDATE_CHECK_INTERVAL, using 6h")
+		return 6 * time.Hour
+	}
+	return interval
+}
+
+// familiarRepository shortens Docker Hub repository names the way RepoTags
+// and RepoDigests print them
+func familiarRepository(repository string) string {
+	repository = strings.TrimPrefix(repository, "docker.io/")
+	return strings.TrimPrefix(repository, "library/")
+}
+
+// tagReference normalizes a reference to the repo:tag form of RepoTags.
+// Digest references and image IDs never have updates and return "".
+func tagReference(reference string) string {
+	if reference == "" || strings.Contains(reference, "@") || strings.HasPrefix(reference, "sha256:") {
+		return ""
+	}
+	repository, tag := splitImageReference(reference)
+	return familiarRepository(repository) + ":" + tag
+}
+
+// imageUpdateFor returns a copy of the last check of a tag, or nil
+func imageUpdateFor(reference string) *ImageUpdate {
+	imageUpdatesMu.RLock()
+	defer imageUpdatesMu.RUnlock()
+	update, ok := imageUpdates[reference]
+	if !ok {
+		return nil
+	}
+	copied := *update
+	return &copied
+}
+
+// saveImageUpdate keeps a check result in memory and in the database
+func saveImageUpdate(update *ImageUpdate) {
+	imageUpdatesMu.Lock()
+	copied := *update
+	imageUpdates[update.Reference] = &copied
+	imageUpdatesMu.Unlock()
+
+	if db == nil {
+		return
+	}
+	_, err := db.Exec(`INSERT OR REPLACE INTO image_updates (reference, image_id, status, local_digest, remote_digest, error, notified_digest, checked_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		update.Reference, update.ImageID, update.Status, update.LocalDigest, update.RemoteDigest, update.Error, update.notifiedDigest, update.CheckedAt)
+	if err != nil {
+		logrus.WithError(err).WithField("image", update.Reference).Error("Failed to store image update check")
+	}
+}
+
+// forgetImageUpdates drops the results of tags that no longer exist locally
+func forgetImageUpdates(keep map[string]string) {
+	imageUpdatesMu.Lock()
+	var stale []string
+	for reference := range imageUpdates {
+		if _, ok := keep[reference]; !ok {
+			stale = append(stale, reference)
+			delete(imageUpdates, reference)
+		}
+	}
+	imageUpdatesMu.Unlock()
+
+	if db == nil {
+		return
+	}
+	for _, reference := range stale {
+		db.Exec(`DELETE FROM image_updates WHERE reference = ?`, reference)
+	}
+}
+
+// loadImageUpdates restores the stored check results
+func loadImageUpdates() error {
+	rows, err := db.Query(`SELECT reference, image_id, status, local_digest, remote_digest, error, notified_digest, checked_at FROM image_updates`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	imageUpdatesMu.Lock()
+	defer imageUpdatesMu.Unlock()
+	for rows.Next() {
+		var update ImageUpdate
+		if err := rows.Scan(&update.Reference, &update.ImageID, &update.Status, &update.LocalDigest, &update.RemoteDigest,
+			&update.Error, &update.notifiedDigest, &update.CheckedAt); err != nil {
+			logrus.WithError(err).Error("Failed to scan image update row")
+			continue
+		}
+		update.UpdateAvailable = update.Status == UpdateAvailable
+		imageUpdates[update.Reference] = &update
+	}
+	return rows.Err()
+}
+
+// engineDistributionDigest asks the registry, through the engine, for the
+// digest a tag currently points to. For multi-platform images this is the
+// digest of the index, which is also what a pull records in RepoDigests.
+func engineDistributionDigest(ctx context.Context, reference string) (string, error) {
+	resp, err := engineRequest(ctx, http.MethodGet, "/distribution/"+reference+"/json", nil, registryAuthHeaders(reference), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var inspect struct {
+		Descriptor struct {
+			Digest string `json:"digest"`
+		} `json:"Descriptor"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return "", err
+	}
+	if inspect.Descriptor.Digest == "" {
+		return "", fmt.Errorf("registry returned no digest for %s", reference)
+	}
+	return inspect.Descriptor.Digest, nil
+}
+
+// isRateLimited reports whether a registry error is a rate limit
+func isRateLimited(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "toomanyrequests") || strings.Contains(message, "rate limit") || strings.Contains(message, "429")
+}
+
+// repositoryDigests returns the registry digests recorded for a repository
+func repositoryDigests(image *ImageInspect, repository string) []string {
+	var digests []string
+	for _, repoDigest := range image.RepoDigests {
+		if name, digest, ok := strings.Cut(repoDigest, "@"); ok && familiarRepository(name) == repository {
+			digests = append(digests, digest)
+		}
+	}
+	return digests
+}
+
+// waitForRegistry spaces requests to one registry and reports whether it
+// is paused after rate limiting us
+func waitForRegistry(ctx context.Context, host string) error {
+	if until, ok := registryBackoff[host]; ok && time.Now().Before(until) {
+		return fmt.Errorf("rate limited by %s, checks resume at %s", host, until.Format(time.RFC3339))
+	}
+	if wait := time.Until(registryNextCheck[host]); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	registryNextCheck[host] = time.Now().Add(updateCheckSpacing)
+	return nil
+}
+
+// checkImageUpdate compares a local tag with its registry. A failed check
+// keeps the previous result for the same image and only records the error.
+func checkImageUpdate(ctx context.Context, reference string, image *ImageInspect) *ImageUpdate {
+	update := &ImageUpdate{Reference: reference, ImageID: image.ID, CheckedAt: time.Now()}
+	previous := imageUpdateFor(reference)
+	if previous != nil {
+		update.notifiedDigest = previous.notifiedDigest
+	}
+
+	repository, _ := splitImageReference(reference)
+	digests := repositoryDigests(image, repository)
+	if len(digests) == 0 {
+		update.Status = UpdateLocalOnly
+		return update
+	}
+	update.LocalDigest = digests[0]
+
+	host := imageRegistryHost(reference)
+	remote, err := "", waitForRegistry(ctx, host)
+	if err == nil {
+		remote, err = engineDistributionDigest(ctx, reference)
+		if err != nil && isRateLimited(err) {
+			registryBackoff[host] = time.Now().Add(updateRateLimitBackoff)
+		}
+	}
+	if err != nil {
+		if previous != nil && previous.ImageID == image.ID && previous.Status != UpdateError {
+			update.Status, update.RemoteDigest = previous.Status, previous.RemoteDigest
+		} else {
+			update.Status = UpdateError
+		}
+		update.Error = err.Error()
+		update.UpdateAvailable = update.Status == UpdateAvailable
+		return update
+	}
+
+	update.RemoteDigest = remote
+	update.Status = UpdateAvailable
+	for _, digest := range digests {
+		if digest == remote {
+			update.Status = UpdateUpToDate
+			update.LocalDigest = digest
+		}
+	}
+	update.UpdateAvailable = update.Status == UpdateAvailable
+	return update
+}
+
+// recordPulledImage marks a tag up to date after its registry digest was pulled
+func recordPulledImage(reference string, image *ImageInspect) {
+	update := imageUpdateFor(reference)
+	if update == nil || update.RemoteDigest == "" {
+		return
+	}
+	repository, _ := splitImageReference(reference)
+	for _, digest := range repositoryDigests(image, repository) {
+		if digest == update.RemoteDigest {
+			update.ImageID = image.ID
+			update.LocalDigest = digest
+			update.Status = UpdateUpToDate
+			update.UpdateAvailable = false
+			update.Error = ""
+			saveImageUpdate(update)
+			return
+		}
+	}
+}
+
+// localTags maps every local tag to the ID of its image
+func localTags() (map[string]string, error) {
+	images, err := getRealImages()
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			if tag != "<none>:<none>" {
+				tags[tag] = image.ID
+			}
+		}
+	}
+	return tags, nil
+}
+
+// runUpdateCheck checks the given tags, or every local tag when none are
+// given. Results newer than maxAge for an unchanged image are reused, so a
+// zero maxAge checks everything again.
+func runUpdateCheck(ctx context.Context, references []string, maxAge time.Duration, progress func(done, total int, reference string)) ([]ImageUpdate, error) {
+	updateCheckMu.Lock()
+	defer updateCheckMu.Unlock()
+
+	tags, err := localTags()
+	if err != nil {
+		return nil, err
+	}
+	full := len(references) == 0
+	if full {
+		for tag := range tags {
+			references = append(references, tag)
+		}
+	}
+	sort.Strings(references)
+
+	var ids []string
+	seen := make(map[string]bool)
+	for _, reference := range references {
+		if id, ok := tags[reference]; ok && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	inspections, err := dockerInspectImages(ids...)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*ImageInspect, len(inspections))
+	for i := range inspections {
+		byID[inspections[i].ID] = &inspections[i]
+	}
+
+	results := []ImageUpdate{}
+	for i, reference := range references {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if progress != nil {
+			progress(i, len(references), reference)
+		}
+		image, ok := byID[tags[reference]]
+		if !ok {
+			continue
+		}
+		if previous := imageUpdateFor(reference); previous != nil && maxAge > 0 &&
+			previous.ImageID == image.ID && time.Since(previous.CheckedAt) < maxAge {
+			results = append(results, *previous)
+			continue
+		}
+
+		update := checkImageUpdate(ctx, reference, image)
+		saveImageUpdate(update)
+		results = append(results, *update)
+	}
+	if full {
+		forgetImageUpdates(tags)
+	}
+
+	notifyContainerUpdates(results)
+	return results, nil
+}
+
+// notifyContainerUpdates sends one notification per new registry digest of
+// a tag that running containers use
+func notifyContainerUpdates(updates []ImageUpdate) {
+	var pending []ImageUpdate
+	for _, update := range updates {
+		if update.UpdateAvailable && update.RemoteDigest != update.notifiedDigest {
+			pending = append(pending, update)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	containers, err := getRealContainers(false)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to list running containers for update notifications")
+		return
+	}
+	for _, update := range pending {
+		var names []string
+		for _, container := range containers {
+			if tagReference(container.Image) == update.Reference {
+				names = append(names, strings.TrimPrefix(firstName(container.Names), "/"))
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+
+		notify("image.update_available", "Update available for "+update.Reference,
+			fmt.Sprintf("%s has a newer image in its registry and is used by %d running container(s): %s",
+				update.Reference, len(names), strings.Join(names, ", ")),
+			map[string]interface{}{
+				"reference":     update.Reference,
+				"local_digest":  update.LocalDigest,
+				"remote_digest": update.RemoteDigest,
+				"containers":    names,
+			})
+		update.notifiedDigest = update.RemoteDigest
+		saveImageUpdate(&update)
+	}
+}
+
+// startUpdateChecker restores stored results and checks local tags in the
+// background every UPDATE_CHECK_INTERVAL
+func startUpdateChecker() {
+	if db != nil {
+		if err := loadImageUpdates(); err != nil {
+			logrus.WithError(err).Warn("Failed to load image update checks")
+		}
+	}
+	interval := updateCheckInterval()
+	if interval == 0 {
+		logrus.Info("Background image update checks disabled")
+		return
+	}
+	go func() {
+		// Let the service settle before the first pass
+		time.Sleep(time.Minute)
+		for {
+			results, err := runUpdateCheck(context.Background(), nil, interval, nil)
+			if err != nil {
+				logrus.WithError(err).Warn("Image update check failed")
+			} else {
+				available := 0
+				for _, update := range results {
+					if update.UpdateAvailable {
+						available++
+					}
+				}
+				logrus.WithFields(logrus.Fields{"images": len(results), "updates": available}).Info("Image update check finished")
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// annotateImageUpdates flags images with a newer registry digest for any
+// of their tags
+func annotateImageUpdates(images []ImageSummary) {
+	for i := range images {
+		for _, tag := range images[i].RepoTags {
+			if update := imageUpdateFor(tag); update != nil && update.UpdateAvailable && update.ImageID == images[i].ID {
+				images[i].UpdateAvailable = true
+			}
+		}
+	}
+}
+
+// annotateContainerUpdates flags containers whose image tag has a newer
+// registry digest, or now points to a newer local image than the one the
+// container runs
+func annotateContainerUpdates(ctx context.Context, containers []ContainerSummary) error {
+	imageUpdatesMu.RLock()
+	empty := len(imageUpdates) == 0
+	imageUpdatesMu.RUnlock()
+	if empty {
+		return nil
+	}
+
+	usage, err := containersByImage(ctx)
+	if err != nil {
+		return err
+	}
+	imageOf := make(map[string]string)
+	for imageID, users := range usage {
+		for _, container := range users {
+			imageOf[container.ID] = imageID
+		}
+	}
+
+	for i := range containers {
+		update := imageUpdateFor(tagReference(containers[i].Image))
+		if update == nil {
+			continue
+		}
+		imageID := imageOf[containers[i].ID]
+		containers[i].UpdateAvailable = update.UpdateAvailable || (imageID != "" && update.ImageID != "" && imageID != update.ImageID)
+	}
+	return nil
+}
+
+// listImageUpdates returns the last check of every local tag. With
+// ?update_available=true only tags with updates are returned.
+func listImageUpdates(w http.ResponseWriter, r *http.Request) {
+	onlyAvailable := r.URL.Query().Get("update_available") == "true"
+
+	imageUpdatesMu.RLock()
+	updates := make([]ImageUpdate, 0, len(imageUpdates))
+	for _, update := range imageUpdates {
+		if !onlyAvailable || update.UpdateAvailable {
+			updates = append(updates, *update)
+		}
+	}
+	imageUpdatesMu.RUnlock()
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Reference < updates[j].Reference })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updates)
+}
+
+// checkImageUpdates checks tags against their registries now as a job. Tags
+// are given as repeated ?image= parameters, otherwise every local tag is
+// checked.
+func checkImageUpdates(w http.ResponseWriter, r *http.Request) {
+	var references []string
+	for _, image := range r.URL.Query()["image"] {
+		if reference := tagReference(strings.TrimSpace(image)); reference != "" {
+			references = append(references, reference)
+		}
+	}
+	if len(r.URL.Query()["image"]) > 0 && len(references) == 0 {
+		writeError(w, http.StatusBadRequest, "Only tagged images can be checked for updates")
+		return
+	}
+
+	target := strings.Join(references, ",")
+	if target == "" {
+		target = "all"
+	}
+	job := startJob("update-check", target, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		results, err := runUpdateCheck(ctx, references, 0, func(done, total int, reference string) {
+			job.SetProgress(int64(done), int64(total), "Checking "+reference)
+		})
+		if err != nil {
+			return nil, err
+		}
+		available := 0
+		for _, update := range results {
+			if update.UpdateAvailable {
+				available++
+			}
+		}
+		job.SetProgress(int64(len(results)), int64(len(results)), fmt.Sprintf("%d update(s) available", available))
+		return map[string]interface{}{"images": results, "updates_available": available}, nil
+	})
+	writeJobAccepted(w, job)
+}
@@ -0,0 +1,945 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// volumeHelperLabel marks the short-lived containers that read and write volumes
+const volumeHelperLabel = "dockmaster.volume-helper"
+
+// maxVolumeRestoreSize bounds the size of an uploaded volume archive
+var maxVolumeRestoreSize = envByteSize("VOLUME_RESTORE_MAX", 32*1024*1024*1024)
+
+// errInvalidVolumeArchive wraps archives that cannot be restored
+var errInvalidVolumeArchive = errors.New("invalid volume archive")
+
+// volumeInUseError refuses to write to a volume used by running containers
+type volumeInUseError struct {
+	Volume     string
+	Containers []string
+}
+
+func (e *volumeInUseError) Error() string {
+	return fmt.Sprintf("volume %s is used by running containers (%s); stop them or pass stop=true", e.Volume, strings.Join(e.Containers, ", "))
+}
+
+// VolumeBackup is a backup archive kept on a backup target
+type VolumeBackup struct {
+	ID         int64      `json:"id"`
+	Volume     string     `json:"volume"`
+	TargetID   int64      `json:"target_id"`
+	ScheduleID int64      `json:"schedule_id,omitempty"`
+	Filename   string     `json:"filename"`
+	Size       int64      `json:"size"`
+	SHA256     string     `json:"sha256"`
+	Files      int64      `json:"files"`
+	Stopped    []string   `json:"stopped_containers"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	Missing    bool       `json:"missing,omitempty"`
+}
+
+// VolumeRestoreResult describes a restored archive
+type VolumeRestoreResult struct {
+	Volume   string   `json:"volume"`
+	Created  bool     `json:"created"`
+	Replaced bool     `json:"replaced"`
+	Entries  int      `json:"entries"`
+	Stopped  []string `json:"stopped_containers"`
+}
+
+// VolumeBackupRequest stores a backup of a volume on a backup target
+type VolumeBackupRequest struct {
+	Stop     bool  `json:"stop"`
+	TargetID int64 `json:"target_id"`
+}
+
+// VolumeRestoreRequest restores a stored backup
+type VolumeRestoreRequest struct {
+	Volume  string `json:"volume"`
+	Replace bool   `json:"replace"`
+	Stop    bool   `json:"stop"`
+}
+
+// volumeBackupDir is the directory of the default backup target
+func volumeBackupDir() string {
+	return getEnvOrDefault("VOLUME_BACKUP_DIR", filepath.Join(dataDirectory, "volume-backups"))
+}
+
+// volumeHelperImage is the image of the helper containers. They are never
+// started to copy data, so any small image works; it needs find only for
+// restores that replace the volume's contents.
+func volumeHelperImage() string {
+	return getEnvOrDefault("VOLUME_HELPER_IMAGE", "busybox:latest")
+}
+
+// createVolumeHelper creates, without starting, a container mounting a
+// volume at /volume, which `docker cp` can read from and write to
+func createVolumeHelper(ctx context.Context, volume string, readOnly bool) (string, error) {
+	image := volumeHelperImage()
+	if err := ensureImage(ctx, image); err != nil {
+		return "", err
+	}
+	mount := volume + ":/volume"
+	if readOnly {
+		mount += ":ro"
+	}
+	output, err := executeDockerCommand("create", "--network", "none", "--label", volumeHelperLabel+"=true", "-v", mount, image)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// removeVolumeHelper removes a helper container, logging failures
+func removeVolumeHelper(id string) {
+	if err := dockerRemove(id, true); err != nil {
+		logrus.WithError(err).WithField("container", id).Warn("Failed to remove volume helper container")
+	}
+}
+
+// stopVolumeUsers stops the running containers that mount a volume and
+// returns them so they can be started again
+func stopVolumeUsers(volume string) ([]VolumeUser, error) {
+	users, err := volumeUsers(volume)
+	if err != nil {
+		return nil, err
+	}
+	var stopped []VolumeUser
+	for _, user := range users {
+		if user.State != "running" {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"volume": volume, "container": user.Name}).Info("Stopping container using volume")
+		if err := dockerStop(user.ID); err != nil {
+			startVolumeUsers(stopped)
+			return nil, fmt.Errorf("failed to stop container %s: %v", user.Name, err)
+		}
+		stopped = append(stopped, user)
+	}
+	return stopped, nil
+}
+
+// startVolumeUsers starts containers stopped by stopVolumeUsers again
+func startVolumeUsers(users []VolumeUser) error {
+	var failed []string
+	for _, user := range users {
+		if err := dockerStart(user.ID); err != nil {
+			logrus.WithError(err).WithField("container", user.Name).Error("Failed to restart container after volume operation")
+			failed = append(failed, user.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restart containers: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// userNames returns the container names of volume users
+func userNames(users []VolumeUser) []string {
+	names := make([]string, len(users))
+	for i, user := range users {
+		names[i] = user.Name
+	}
+	return names
+}
+
+// volumeSnapshot is the contents of a volume being read out of a helper
+// container. Close must be called to remove the helper and restart any
+// containers stopped for the snapshot.
+type volumeSnapshot struct {
+	volume   string
+	helperID string
+	stopped  []VolumeUser
+	cancel   context.CancelFunc
+	proc     *dockerProcess
+	reader   *tar.Reader
+	first    *tar.Header
+}
+
+// openVolumeSnapshot starts reading a volume. The first archive entry is
+// read before returning so errors can still be reported to the client.
+func openVolumeSnapshot(ctx context.Context, volume string, stop bool) (*volumeSnapshot, error) {
+	if _, err := dockerInspectVolumes(volume); err != nil {
+		return nil, err
+	}
+
+	snap := &volumeSnapshot{volume: volume}
+	fail := func(err error) (*volumeSnapshot, error) {
+		snap.Close()
+		return nil, err
+	}
+	var err error
+	if stop {
+		if snap.stopped, err = stopVolumeUsers(volume); err != nil {
+			return nil, err
+		}
+	}
+	if snap.helperID, err = createVolumeHelper(ctx, volume, true); err != nil {
+		return fail(err)
+	}
+
+	ctx, snap.cancel = context.WithCancel(ctx)
+	if snap.proc, err = startDockerCommand(ctx, nil, "cp", snap.helperID+":/volume", "-"); err != nil {
+		return fail(err)
+	}
+	snap.reader = tar.NewReader(bufio.NewReaderSize(snap.proc.Stdout, 64*1024))
+	if snap.first, err = snap.reader.Next(); err != nil {
+		if waitErr := snap.proc.Wait(); waitErr != nil {
+			err = waitErr
+		}
+		return fail(err)
+	}
+	return snap, nil
+}
+
+// rebaseVolumeEntry strips the /volume directory from an archive entry.
+// The directory itself is not part of the backup.
+func rebaseVolumeEntry(name string) (string, bool) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	rest, ok := strings.CutPrefix(cleaned, "volume/")
+	return rest, ok && rest != ""
+}
+
+// writeArchive writes the volume as a gzip-compressed tar archive with paths
+// relative to the volume root. progress is called at most once a second.
+func (s *volumeSnapshot) writeArchive(dst io.Writer, progress func(files, bytes int64)) (files int64, err error) {
+	gz, _ := gzip.NewWriterLevel(dst, gzip.DefaultCompression)
+	tw := tar.NewWriter(gz)
+
+	var bytes int64
+	lastProgress := time.Now()
+	for header := s.first; ; {
+		if name, ok := rebaseVolumeEntry(header.Name); ok {
+			header.Name = name
+			if header.Typeflag == tar.TypeDir {
+				header.Name += "/"
+			}
+			if header.Typeflag == tar.TypeLink {
+				if header.Linkname, ok = rebaseVolumeEntry(header.Linkname); !ok {
+					return files, fmt.Errorf("hard link %s points outside the volume", name)
+				}
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return files, err
+			}
+			if header.Typeflag == tar.TypeReg {
+				n, err := io.Copy(tw, s.reader)
+				bytes += n
+				if err != nil {
+					return files, err
+				}
+			}
+			files++
+			if progress != nil && time.Since(lastProgress) >= time.Second {
+				progress(files, bytes)
+				lastProgress = time.Now()
+			}
+		}
+
+		if header, err = s.reader.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return files, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return files, err
+	}
+	if err := gz.Close(); err != nil {
+		return files, err
+	}
+	if progress != nil {
+		progress(files, bytes)
+	}
+	return files, s.proc.Wait()
+}
+
+// Close removes the helper container and restarts stopped containers. Its
+// error only reports containers that could not be restarted.
+func (s *volumeSnapshot) Close() error {
+	if s.proc != nil {
+		s.cancel()
+		s.proc.Wait()
+	}
+	if s.helperID != "" {
+		removeVolumeHelper(s.helperID)
+	}
+	return startVolumeUsers(s.stopped)
+}
+
+// stageVolumeArchive validates an uploaded archive, plain or gzip-compressed,
+// into a temporary tar file before anything is written to the volume
+func stageVolumeArchive(archive io.Reader) (*os.File, int, error) {
+	staged, err := os.CreateTemp("", "dockmaster-restore-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	os.Remove(staged.Name())
+
+	tw := tar.NewWriter(staged)
+	entries, err := sanitizeArchive(archive, tw)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		staged.Close()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, 0, err
+		}
+		return nil, 0, fmt.Errorf("%w: %v", errInvalidVolumeArchive, err)
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		staged.Close()
+		return nil, 0, err
+	}
+	return staged, entries, nil
+}
+
+// restoreVolume extracts an archive into a volume, creating the volume when
+// it does not exist. With replace the current contents are removed first.
+// Ownership and permissions are kept as recorded in the archive.
+func restoreVolume(ctx context.Context, volume string, archive io.Reader, replace, stop bool) (*VolumeRestoreResult, error) {
+	staged, entries, err := stageVolumeArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer staged.Close()
+
+	result := &VolumeRestoreResult{Volume: volume, Entries: entries, Stopped: []string{}}
+	if _, err := dockerInspectVolumes(volume); err != nil {
+		if _, err := executeDockerCommand("volume", "create", volume); err != nil {
+			return nil, err
+		}
+		result.Created = true
+		logrus.WithField("volume", volume).Info("Volume created for restore")
+	}
+
+	if !result.Created {
+		users, err := volumeUsers(volume)
+		if err != nil {
+			return nil, err
+		}
+		var running []string
+		for _, user := range users {
+			if user.State == "running" {
+				running = append(running, user.Name)
+			}
+		}
+		if len(running) > 0 && !stop {
+			return nil, &volumeInUseError{Volume: volume, Containers: running}
+		}
+		if stop {
+			stopped, err := stopVolumeUsers(volume)
+			if err != nil {
+				return nil, err
+			}
+			result.Stopped = userNames(stopped)
+			defer func() {
+				if err := startVolumeUsers(stopped); err != nil {
+					logrus.WithError(err).WithField("volume", volume).Error("Containers not restarted after volume restore")
+				}
+			}()
+		}
+	}
+
+	if replace && !result.Created {
+		if _, err := executeDockerCommand("run", "--rm", "--network", "none", "--label", volumeHelperLabel+"=true",
+			"-v", volume+":/volume", volumeHelperImage(), "find", "/volume", "-mindepth", "1", "-delete"); err != nil {
+			return nil, err
+		}
+		result.Replaced = true
+	}
+
+	helperID, err := createVolumeHelper(ctx, volume, false)
+	if err != nil {
+		return nil, err
+	}
+	defer removeVolumeHelper(helperID)
+
+	proc, err := startDockerCommand(ctx, staged, "cp", "-a", "-", helperID+":/volume")
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, proc.Stdout)
+	if err := proc.Wait(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writeVolumeRestoreError reports a failed restore with a fitting status
+func writeVolumeRestoreError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var inUse *volumeInUseError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeError(w, http.StatusRequestEntityTooLarge, errTransferTooLarge.Error())
+	case errors.As(err, &inUse):
+		writeErrorDetails(w, http.StatusConflict, codeConflict, inUse.Error(), map[string]interface{}{"containers": inUse.Containers})
+	case errors.Is(err, errInvalidVolumeArchive):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeFailure(w, "Failed to restore volume", err)
+	}
+}
+
+// backupFilename names a stored backup after its volume and time
+func backupFilename(volume string, at time.Time) string {
+	return volume + "-" + at.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+// volumeBackupOptions controls a stored backup
+type volumeBackupOptions struct {
+	stop       bool
+	user       string
+	target     *BackupTarget
+	scheduleID int64
+	progress   func(files, bytes int64)
+}
+
+// storeVolumeBackup writes a backup of a volume to a backup target and
+// records it in the catalog. The archive is spooled to a file first so its
+// size and checksum are known before it is uploaded.
+func storeVolumeBackup(ctx context.Context, volume string, opts volumeBackupOptions) (*VolumeBackup, error) {
+	store, err := opts.target.store()
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := openVolumeSnapshot(ctx, volume, opts.stop)
+	if err != nil {
+		return nil, err
+	}
+	backup := &VolumeBackup{
+		Volume:     volume,
+		TargetID:   opts.target.ID,
+		ScheduleID: opts.scheduleID,
+		Stopped:    userNames(snap.stopped),
+		CreatedBy:  opts.user,
+		CreatedAt:  time.Now(),
+	}
+
+	partial, err := os.CreateTemp(store.spoolDir(), ".partial-*")
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	defer os.Remove(partial.Name())
+	defer partial.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(partial, hash)}
+	backup.Files, err = snap.writeArchive(counter, opts.progress)
+	if closeErr := snap.Close(); closeErr != nil {
+		logrus.WithError(closeErr).WithField("volume", volume).Error("Containers not restarted after volume backup")
+	}
+	if err != nil {
+		return nil, err
+	}
+	backup.Size = counter.n
+	backup.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// Backups of the same second get a numbered suffix
+	base := strings.TrimSuffix(backupFilename(volume, backup.CreatedAt), ".tar.gz")
+	backup.Filename = base + ".tar.gz"
+	for i := 2; ; i++ {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM volume_backups WHERE target_id = ? AND filename = ?`, backup.TargetID, backup.Filename).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			break
+		}
+		backup.Filename = base + "-" + strconv.Itoa(i) + ".tar.gz"
+	}
+	if err := store.put(ctx, backup.Filename, partial, backup.Size, backup.SHA256); err != nil {
+		return nil, err
+	}
+
+	stopped, _ := json.Marshal(backup.Stopped)
+	result, err := db.Exec(`INSERT INTO volume_backups (volume, target_id, schedule_id, filename, size, sha256, files, stopped_containers, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		backup.Volume, backup.TargetID, backup.ScheduleID, backup.Filename, backup.Size, backup.SHA256, backup.Files, string(stopped), backup.CreatedBy, backup.CreatedAt)
+	if err != nil {
+		store.remove(ctx, backup.Filename)
+		return nil, err
+	}
+	backup.ID, _ = result.LastInsertId()
+	return backup, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// scanVolumeBackup reads a catalog row
+func scanVolumeBackup(scanner interface{ Scan(...interface{}) error }) (*VolumeBackup, error) {
+	var backup VolumeBackup
+	var stopped string
+	var verified sql.NullTime
+	if err := scanner.Scan(&backup.ID, &backup.Volume, &backup.TargetID, &backup.ScheduleID, &backup.Filename, &backup.Size, &backup.SHA256,
+		&backup.Files, &stopped, &backup.CreatedBy, &backup.CreatedAt, &verified); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(stopped), &backup.Stopped); err != nil || backup.Stopped == nil {
+		backup.Stopped = []string{}
+	}
+	if verified.Valid {
+		backup.VerifiedAt = &verified.Time
+	}
+	return &backup, nil
+}
+
+const volumeBackupColumns = `id, volume, target_id, schedule_id, filename, size, sha256, files, stopped_containers, created_by, created_at, verified_at`
+
+// getVolumeBackup loads a catalog entry, returning sql.ErrNoRows if there is none
+func getVolumeBackup(id string) (*VolumeBackup, error) {
+	return scanVolumeBackup(db.QueryRow(`SELECT `+volumeBackupColumns+` FROM volume_backups WHERE id = ?`, id))
+}
+
+// store returns the store of the target a backup was written to
+func (b *VolumeBackup) store() (backupStore, error) {
+	target, err := loadBackupTarget(b.TargetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup target %d: %v", b.TargetID, err)
+	}
+	return target.store()
+}
+
+// checkSum compares the checksum of backup content with the catalog
+func (b *VolumeBackup) checkSum(content io.Reader) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != b.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", b.Filename, b.SHA256, sum)
+	}
+	return nil
+}
+
+// verify reads a stored backup back from its target, compares its checksum
+// and records when it was last found intact
+func (b *VolumeBackup) verify(ctx context.Context, store backupStore) error {
+	reader, err := store.open(ctx, b.Filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := b.checkSum(reader); err != nil {
+		return err
+	}
+	now := time.Now()
+	b.VerifiedAt = &now
+	if _, err := db.Exec(`UPDATE volume_backups SET verified_at = ? WHERE id = ?`, now, b.ID); err != nil {
+		logrus.WithError(err).WithField("backup", b.ID).Warn("Failed to record backup verification")
+	}
+	return nil
+}
+
+// fetchVerifiedBackup returns the content of a stored backup after checking
+// its checksum. Remote backups are downloaded to a temporary file first.
+func fetchVerifiedBackup(ctx context.Context, backup *VolumeBackup, store backupStore) (*os.File, error) {
+	reader, err := store.open(ctx, backup.Filename)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := reader.(*os.File)
+	if !ok {
+		defer reader.Close()
+		if file, err = os.CreateTemp("", "dockmaster-backup-*"); err != nil {
+			return nil, err
+		}
+		os.Remove(file.Name())
+		if _, err := io.Copy(file, reader); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err == nil {
+		err = backup.checkSum(file)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// removeVolumeBackup deletes a stored backup from its target and the catalog
+func removeVolumeBackup(ctx context.Context, backup *VolumeBackup, store backupStore) error {
+	if err := store.remove(ctx, backup.Filename); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM volume_backups WHERE id = ?`, backup.ID)
+	return err
+}
+
+// backupVolume streams a gzip-compressed tar of a volume's contents.
+// ?stop=true stops the running containers using the volume meanwhile.
+func backupVolume(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	stop := r.URL.Query().Get("stop") == "true"
+	disableTimeouts(w)
+
+	snap, err := openVolumeSnapshot(r.Context(), name, stop)
+	if err != nil {
+		logrus.WithError(err).WithField("volume", name).Error("Failed to back up volume")
+		recordAudit(r.Header.Get("X-User"), "volumes.backup", name, "failure", map[string]interface{}{"error": err.Error()})
+		writeFailure(w, "Failed to back up volume", err)
+		return
+	}
+	stopped := userNames(snap.stopped)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": backupFilename(name, time.Now())}))
+
+	files, err := snap.writeArchive(w, nil)
+	if closeErr := snap.Close(); closeErr != nil {
+		logrus.WithError(closeErr).WithField("volume", name).Error("Containers not restarted after volume backup")
+	}
+	log := logrus.WithFields(logrus.Fields{"volume": name, "files": files, "stopped": stopped})
+	if err != nil {
+		log.WithError(err).Warn("Volume backup interrupted")
+		recordAudit(r.Header.Get("X-User"), "volumes.backup", name, "failure", map[string]interface{}{"error": err.Error(), "stopped_containers": stopped})
+		// Abort the response so the client sees a failed transfer rather
+		// than a complete-looking but truncated archive
+		panic(http.ErrAbortHandler)
+	}
+	recordAudit(r.Header.Get("X-User"), "volumes.backup", name, "success", map[string]interface{}{"files": files, "stopped_containers": stopped})
+	log.Info("Volume backup downloaded")
+}
+
+// restoreVolumeUpload restores an uploaded archive, raw or as the first file
+// of a multipart form, into a new or existing volume
+func restoreVolumeUpload(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	query := r.URL.Query()
+	replace, stop := query.Get("replace") == "true", query.Get("stop") == "true"
+	if !volumeNamePattern.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "Invalid volume name")
+		return
+	}
+
+	disableTimeouts(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxVolumeRestoreSize)
+	body, err := imageLoadBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+
+	result, err := restoreVolume(r.Context(), name, body, replace, stop)
+	if err != nil {
+		logrus.WithError(err).WithField("volume", name).Error("Failed to restore volume")
+		recordAudit(r.Header.Get("X-User"), "volumes.restore", name, "failure", map[string]interface{}{"error": err.Error(), "replace": replace})
+		writeVolumeRestoreError(w, err)
+		return
+	}
+	recordAudit(r.Header.Get("X-User"), "volumes.restore", name, "success", map[string]interface{}{
+		"entries": result.Entries, "created": result.Created, "replace": replace, "stopped_containers": result.Stopped,
+	})
+	logrus.WithFields(logrus.Fields{"volume": name, "entries": result.Entries, "created": result.Created}).Info("Volume restored")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// createVolumeBackup stores a backup of a volume on a backup target, the
+// backup directory by default, as a background job
+func createVolumeBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	var req VolumeBackupRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+	target, err := loadBackupTarget(req.TargetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusBadRequest, "Unknown backup target")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load backup target", err)
+		return
+	}
+	if _, err := dockerInspectVolumes(name); err != nil {
+		writeFailure(w, "Failed to back up volume", err)
+		return
+	}
+
+	job := startJob("volume-backup", name, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		job.SetProgress(0, 0, "Backing up "+name)
+		backup, err := storeVolumeBackup(ctx, name, volumeBackupOptions{
+			stop:   req.Stop,
+			user:   job.CreatedBy,
+			target: target,
+			progress: func(files, bytes int64) {
+				job.SetProgress(files, 0, fmt.Sprintf("Archived %d files (%d bytes)", files, bytes))
+			},
+		})
+		if err != nil {
+			recordAudit(job.CreatedBy, "volumes.backup", name, "failure", map[string]interface{}{"error": err.Error(), "target": target.Name})
+			return nil, err
+		}
+		recordAudit(job.CreatedBy, "volumes.backup", name, "success", map[string]interface{}{
+			"target": target.Name, "backup_id": backup.ID, "filename": backup.Filename, "size": backup.Size, "stopped_containers": backup.Stopped,
+		})
+		logrus.WithFields(logrus.Fields{"volume": name, "target": target.Name, "file": backup.Filename, "size": backup.Size}).Info("Volume backup stored")
+		return backup, nil
+	})
+	writeJobAccepted(w, job)
+}
+
+// listVolumeBackups lists stored backups, newest first, optionally filtered
+// by ?volume=, ?target_id= or ?schedule_id=
+func listVolumeBackups(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	query := `SELECT ` + volumeBackupColumns + ` FROM volume_backups`
+	var conditions []string
+	args := []interface{}{}
+	for _, filter := range []string{"volume", "target_id", "schedule_id"} {
+		if value := r.URL.Query().Get(filter); value != "" {
+			conditions = append(conditions, filter+` = ?`)
+			args = append(args, value)
+		}
+	}
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	rows, err := db.Query(query+` ORDER BY id DESC`, args...)
+	if err != nil {
+		writeFailure(w, "Failed to load volume backups", err)
+		return
+	}
+	defer rows.Close()
+
+	// Files of local targets are checked; remote ones only when verified
+	localDirs := map[int64]string{}
+	if targets, err := loadBackupTargets(); err == nil {
+		for _, target := range targets {
+			if target.Type == backupTargetLocal {
+				localDirs[target.ID] = target.Path
+			}
+		}
+	}
+
+	backups := []VolumeBackup{}
+	for rows.Next() {
+		backup, err := scanVolumeBackup(rows)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to scan volume backup row")
+			continue
+		}
+		if dir, ok := localDirs[backup.TargetID]; ok {
+			if _, err := os.Stat(filepath.Join(dir, backup.Filename)); err != nil {
+				backup.Missing = true
+			}
+		}
+		backups = append(backups, *backup)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(backups)
+}
+
+// loadVolumeBackup loads the backup of the request, writing an error if it
+// does not exist
+func loadVolumeBackup(w http.ResponseWriter, r *http.Request) *VolumeBackup {
+	if !requireDatabase(w) {
+		return nil
+	}
+	backup, err := getVolumeBackup(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Volume backup not found")
+		return nil
+	}
+	if err != nil {
+		writeFailure(w, "Failed to load volume backup", err)
+		return nil
+	}
+	return backup
+}
+
+// downloadVolumeBackup sends a stored backup from its target
+func downloadVolumeBackup(w http.ResponseWriter, r *http.Request) {
+	backup := loadVolumeBackup(w, r)
+	if backup == nil {
+		return
+	}
+	store, err := backup.store()
+	if err != nil {
+		writeFailure(w, "Failed to open backup target", err)
+		return
+	}
+	disableTimeouts(w)
+	reader, err := store.open(r.Context(), backup.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "Backup file is missing from the backup target")
+		return
+	}
+	if err != nil {
+		writeFailure(w, "Failed to read volume backup", err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": backup.Filename}))
+	w.Header().Set("X-Checksum-SHA256", backup.SHA256)
+	if file, ok := reader.(*os.File); ok {
+		http.ServeContent(w, r, "", backup.CreatedAt, file)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(backup.Size, 10))
+	if _, err := io.Copy(w, reader); err != nil {
+		logrus.WithError(err).WithField("backup", backup.Filename).Warn("Backup download interrupted")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// verifyVolumeBackup reads a stored backup back and compares its checksum.
+// A mismatch is a result, not a failed request, and is notified.
+func verifyVolumeBackup(w http.ResponseWriter, r *http.Request) {
+	backup := loadVolumeBackup(w, r)
+	if backup == nil {
+		return
+	}
+	store, err := backup.store()
+	if err != nil {
+		writeFailure(w, "Failed to open backup target", err)
+		return
+	}
+	disableTimeouts(w)
+
+	result := map[string]interface{}{"id": backup.ID, "valid": true, "sha256": backup.SHA256}
+	if err := backup.verify(r.Context(), store); err != nil {
+		result["valid"], result["error"] = false, err.Error()
+		notify("volume.backup_invalid", "Backup "+backup.Filename+" failed verification", err.Error(),
+			map[string]interface{}{"backup_id": backup.ID, "volume": backup.Volume, "target_id": backup.TargetID})
+	} else {
+		result["verified_at"] = backup.VerifiedAt
+	}
+	recordAudit(r.Header.Get("X-User"), "volumes.backup.verify", backup.Volume, "success", result)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// restoreVolumeBackup restores a stored backup into its volume, or the
+// volume named in the request, as a background job. The checksum is
+// verified first.
+func restoreVolumeBackup(w http.ResponseWriter, r *http.Request) {
+	backup := loadVolumeBackup(w, r)
+	if backup == nil {
+		return
+	}
+	var req VolumeRestoreRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+	if req.Volume == "" {
+		req.Volume = backup.Volume
+	}
+	if !volumeNamePattern.MatchString(req.Volume) {
+		writeError(w, http.StatusBadRequest, "Invalid volume name")
+		return
+	}
+	store, err := backup.store()
+	if err != nil {
+		writeFailure(w, "Failed to open backup target", err)
+		return
+	}
+
+	job := startJob("volume-restore", req.Volume, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		details := map[string]interface{}{"backup_id": backup.ID, "filename": backup.Filename, "replace": req.Replace}
+		fail := func(err error) (interface{}, error) {
+			details["error"] = err.Error()
+			recordAudit(job.CreatedBy, "volumes.restore", req.Volume, "failure", details)
+			return nil, err
+		}
+
+		job.SetProgress(0, 2, "Verifying "+backup.Filename)
+		file, err := fetchVerifiedBackup(ctx, backup, store)
+		if err != nil {
+			return fail(err)
+		}
+		defer file.Close()
+
+		job.SetProgress(1, 2, "Restoring into "+req.Volume)
+		result, err := restoreVolume(ctx, req.Volume, file, req.Replace, req.Stop)
+		if err != nil {
+			return fail(err)
+		}
+		details["entries"], details["created"], details["stopped_containers"] = result.Entries, result.Created, result.Stopped
+		recordAudit(job.CreatedBy, "volumes.restore", req.Volume, "success", details)
+		logrus.WithFields(logrus.Fields{"volume": req.Volume, "backup": backup.Filename, "entries": result.Entries}).Info("Volume restored from backup")
+		job.SetProgress(2, 2, "Restore complete")
+		return result, nil
+	})
+	writeJobAccepted(w, job)
+}
+
+// deleteVolumeBackup removes a stored backup from its target and the catalog
+func deleteVolumeBackup(w http.ResponseWriter, r *http.Request) {
+	backup := loadVolumeBackup(w, r)
+	if backup == nil {
+		return
+	}
+	store, err := backup.store()
+	if err != nil {
+		writeFailure(w, "Failed to open backup target", err)
+		return
+	}
+	if err := removeVolumeBackup(r.Context(), backup, store); err != nil {
+		writeFailure(w, "Failed to delete volume backup", err)
+		return
+	}
+	recordAudit(r.Header.Get("X-User"), "volumes.backup.delete", backup.Volume, "success", map[string]interface{}{"backup_id": backup.ID, "filename": backup.Filename})
+	logrus.WithFields(logrus.Fields{"volume": backup.Volume, "file": backup.Filename}).Info("Volume backup deleted")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Volume backup deleted successfully"})
+}
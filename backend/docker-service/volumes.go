@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// volumeNamePattern is the volume name format accepted by docker
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// localVolumeOptions are the options understood by the local volume driver
+var localVolumeOptions = map[string]bool{"type": true, "o": true, "device": true, "size": true}
+
+// CreateVolumeRequest creates a named volume
+type CreateVolumeRequest struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driver_opts"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// VolumeUser is a container mounting a volume
+type VolumeUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"read_only"`
+}
+
+// VolumeDetails is a volume as returned by GET /volumes/{name}
+type VolumeDetails struct {
+	VolumeSummary
+	Status map[string]interface{} `json:"Status,omitempty"`
+	UsedBy []VolumeUser           `json:"UsedBy"`
+}
+
+// volumeUsageData is the size of a volume as measured by the engine
+type volumeUsageData struct {
+	Size     int64
+	RefCount int64
+}
+
+// validate checks a volume request. The local driver's mount options are
+// checked here because the engine only rejects them when a container
+// first mounts the volume.
+func (req *CreateVolumeRequest) validate() error {
+	if req.Name != "" && !volumeNamePattern.MatchString(req.Name) {
+		return fmt.Errorf("invalid volume name %q: use letters, digits, '_', '.' or '-', starting with a letter or digit", req.Name)
+	}
+	if req.Driver == "" {
+		req.Driver = "local"
+	}
+	for key := range req.DriverOpts {
+		if key == "" {
+			return fmt.Errorf("driver option names must not be empty")
+		}
+	}
+	for key := range req.Labels {
+		if key == "" {
+			return fmt.Errorf("label names must not be empty")
+		}
+	}
+	if req.Driver != "local" || len(req.DriverOpts) == 0 {
+		return nil
+	}
+
+	for key := range req.DriverOpts {
+		if !localVolumeOptions[key] {
+			return fmt.Errorf("unknown local driver option %q (expected type, o, device or size)", key)
+		}
+	}
+	mountType, device, options := req.DriverOpts["type"], req.DriverOpts["device"], req.DriverOpts["o"]
+	if (mountType == "") != (device == "") {
+		return fmt.Errorf("local driver options type and device must be given together")
+	}
+	switch mountType {
+	case "none":
+		if !strings.Contains(","+options+",", ",bind,") {
+			return fmt.Errorf("bind volumes (type none) need o=bind")
+		}
+		if !path.IsAbs(device) {
+			return fmt.Errorf("bind volume device must be an absolute host path")
+		}
+	case "nfs", "nfs4":
+		if !strings.Contains(options, "addr=") {
+			return fmt.Errorf("NFS volumes need the server address in o, e.g. o=addr=10.0.0.5,rw")
+		}
+		if !strings.HasPrefix(device, ":") {
+			return fmt.Errorf("NFS volume device must be the exported path prefixed with ':', e.g. :/export")
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a map in order, for stable CLI arguments
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// volumeSummaryFromInspect converts inspect data to the listing format
+func volumeSummaryFromInspect(volume VolumeInspect) VolumeSummary {
+	summary := VolumeSummary{
+		Name:       volume.Name,
+		Driver:     volume.Driver,
+		Mountpoint: volume.Mountpoint,
+		CreatedAt:  volume.CreatedAt,
+		Scope:      volume.Scope,
+		Labels:     volume.Labels,
+		Options:    volume.Options,
+	}
+	if summary.Labels == nil {
+		summary.Labels = map[string]string{}
+	}
+	if summary.Options == nil {
+		summary.Options = map[string]string{}
+	}
+	return summary
+}
+
+// engineVolumeUsage measures volume sizes. The engine walks every volume
+// to do so, which is why sizes are only computed when asked for.
+func engineVolumeUsage(ctx context.Context) (map[string]volumeUsageData, error) {
+	resp, err := engineRequest(ctx, http.MethodGet, "/system/df", url.Values{"type": {"volume"}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var usage engineDiskUsage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]volumeUsageData, len(usage.Volumes))
+	for _, volume := range usage.Volumes {
+		// Sizes of volumes of other drivers are reported as -1
+		if volume.UsageData != nil && volume.UsageData.Size >= 0 {
+			sizes[volume.Name] = volumeUsageData{Size: volume.UsageData.Size, RefCount: volume.UsageData.RefCount}
+		}
+	}
+	return sizes, nil
+}
+
+// addVolumeSizes fills in the sizes of volumes the engine could measure
+func addVolumeSizes(ctx context.Context, volumes []VolumeSummary) error {
+	sizes, err := engineVolumeUsage(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range volumes {
+		if usage, ok := sizes[volumes[i].Name]; ok {
+			size, refs := usage.Size, usage.RefCount
+			volumes[i].Size = &size
+			volumes[i].RefCount = &refs
+		}
+	}
+	return nil
+}
+
+// volumeUsers lists the containers, running or not, that mount a volume
+func volumeUsers(name string) ([]VolumeUser, error) {
+	containers, err := getRealContainers(true, "--filter", "volume="+name)
+	if err != nil {
+		return nil, err
+	}
+	users := []VolumeUser{}
+	if len(containers) == 0 {
+		return users, nil
+	}
+
+	ids := make([]string, len(containers))
+	for i, container := range containers {
+		ids[i] = container.ID
+	}
+	inspections, err := dockerInspectContainers(ids...)
+	if err != nil {
+		return nil, err
+	}
+	for _, container := range inspections {
+		for _, mount := range container.Mounts {
+			if mount.Type != "volume" || mount.Name != name {
+				continue
+			}
+			users = append(users, VolumeUser{
+				ID:          container.ID,
+				Name:        strings.TrimPrefix(container.Name, "/"),
+				State:       container.State.Status,
+				Destination: mount.Destination,
+				ReadOnly:    !mount.RW,
+			})
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+// createVolume creates a volume with a driver, driver options and labels
+func createVolume(w http.ResponseWriter, r *http.Request) {
+	var req CreateVolumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// docker volume create returns an existing volume of the same name
+	// unchanged, which would silently ignore the requested options
+	if req.Name != "" {
+		if _, err := dockerInspectVolumes(req.Name); err == nil {
+			writeError(w, http.StatusConflict, "A volume with this name already exists")
+			return
+		}
+	}
+
+	args := []string{"volume", "create", "--driver", req.Driver}
+	for _, key := range sortedKeys(req.DriverOpts) {
+		args = append(args, "--opt", key+"="+req.DriverOpts[key])
+	}
+	for _, key := range sortedKeys(req.Labels) {
+		args = append(args, "--label", key+"="+req.Labels[key])
+	}
+	if req.Name != "" {
+		args = append(args, req.Name)
+	}
+
+	output, err := executeDockerCommand(args...)
+	if err != nil {
+		logrus.WithError(err).WithField("volume", req.Name).Error("Failed to create volume")
+		recordAudit(r.Header.Get("X-User"), "volumes.create", req.Name, "failure", map[string]interface{}{"driver": req.Driver, "error": err.Error()})
+		writeFailure(w, "Failed to create volume", err)
+		return
+	}
+	name := strings.TrimSpace(string(output))
+
+	recordAudit(r.Header.Get("X-User"), "volumes.create", name, "success", map[string]interface{}{
+		"driver":      req.Driver,
+		"driver_opts": req.DriverOpts,
+		"labels":      req.Labels,
+	})
+	logrus.WithFields(logrus.Fields{"volume": name, "driver": req.Driver}).Info("Volume created")
+
+	inspections, err := dockerInspectVolumes(name)
+	if err != nil || len(inspections) == 0 {
+		writeFailure(w, "Failed to inspect created volume", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(volumeSummaryFromInspect(inspections[0]))
+}
+
+// getVolume returns the inspect data of a volume and the containers
+// mounting it. ?size=true also measures its size.
+func getVolume(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	inspections, err := dockerInspectVolumes(name)
+	if err != nil {
+		writeFailure(w, "Failed to inspect volume", err)
+		return
+	}
+	if len(inspections) == 0 {
+		writeError(w, http.StatusNotFound, "Volume not found")
+		return
+	}
+	details := VolumeDetails{
+		VolumeSummary: volumeSummaryFromInspect(inspections[0]),
+		Status:        inspections[0].Status,
+	}
+
+	if details.UsedBy, err = volumeUsers(details.Name); err != nil {
+		writeFailure(w, "Failed to list containers using volume", err)
+		return
+	}
+	if r.URL.Query().Get("size") == "true" {
+		summaries := []VolumeSummary{details.VolumeSummary}
+		if err := addVolumeSizes(r.Context(), summaries); err != nil {
+			writeFailure(w, "Failed to measure volume size", err)
+			return
+		}
+		details.VolumeSummary = summaries[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
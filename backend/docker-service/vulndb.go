@@ -0,0 +1,1076 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// The vulnerability database is imported from OSV files (https://osv.dev),
+// such as the per-ecosystem all.zip exports, so images can be checked
+// without network access.
+
+// maxVulnerabilityImportSize bounds an uploaded vulnerability database
+var maxVulnerabilityImportSize = envByteSize("VULN_DB_IMPORT_MAX", 4*1024*1024*1024)
+
+// severityOrder ranks severities from most to least severe
+var severityOrder = map[string]int{"critical": 0, "high": 1, "medium": 2, "low": 3, "unknown": 4}
+
+// osvEvent is one boundary of an affected version range
+type osvEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// osvRange is an affected version range
+type osvRange struct {
+	Type   string     `json:"type"`
+	Events []osvEvent `json:"events"`
+}
+
+// osvSeverity is a severity score, usually a CVSS vector
+type osvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+// osvAffected is a package affected by a vulnerability
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges            []osvRange             `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	Severity          []osvSeverity          `json:"severity"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+// osvEntry is a vulnerability in the OSV schema
+type osvEntry struct {
+	ID               string                 `json:"id"`
+	Aliases          []string               `json:"aliases"`
+	Summary          string                 `json:"summary"`
+	Details          string                 `json:"details"`
+	Modified         string                 `json:"modified"`
+	Withdrawn        string                 `json:"withdrawn"`
+	Severity         []osvSeverity          `json:"severity"`
+	Affected         []osvAffected          `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// affectedVersions is the stored version data of one affected package
+type affectedVersions struct {
+	Ranges   []osvRange `json:"ranges,omitempty"`
+	Versions []string   `json:"versions,omitempty"`
+}
+
+// VulnerabilityMatch is a vulnerability affecting a package of an image
+type VulnerabilityMatch struct {
+	ID           string   `json:"id"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	Type         string   `json:"type"`
+	Ecosystem    string   `json:"ecosystem"`
+	Severity     string   `json:"severity"`
+	Score        float64  `json:"score,omitempty"`
+	FixedVersion string   `json:"fixed_version,omitempty"`
+	Summary      string   `json:"summary"`
+	Location     string   `json:"location"`
+	PURL         string   `json:"purl"`
+}
+
+// VulnerabilityImport is a recorded database import
+type VulnerabilityImport struct {
+	ID         int64     `json:"id"`
+	Filename   string    `json:"filename"`
+	Entries    int       `json:"entries"`
+	Records    int       `json:"records"`
+	Replaced   bool      `json:"replaced"`
+	ImportedBy string    `json:"imported_by"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// normalizePyPIName applies PEP 503 name normalization
+func normalizePyPIName(name string) string {
+	return strings.ToLower(pypiSeparators.ReplaceAllString(name, "-"))
+}
+
+var pypiSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizeSeverity maps the severity words used by OSV sources to
+// critical, high, medium or low
+func normalizeSeverity(severity string) string {
+	switch strings.ToLower(strings.TrimSpace(severity)) {
+	case "critical":
+		return "critical"
+	case "high", "important":
+		return "high"
+	case "medium", "moderate":
+		return "medium"
+	case "low", "negligible", "unimportant":
+		return "low"
+	}
+	return ""
+}
+
+// severityFromScore rates a CVSS score
+func severityFromScore(score float64) string {
+	switch {
+	case score >= 9:
+		return "critical"
+	case score >= 7:
+		return "high"
+	case score >= 4:
+		return "medium"
+	case score > 0:
+		return "low"
+	}
+	return "unknown"
+}
+
+// cvss3BaseScore computes the base score of a CVSS 3.x vector
+func cvss3BaseScore(vector string) (float64, bool) {
+	if !strings.HasPrefix(vector, "CVSS:3.") {
+		return 0, false
+	}
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/")[1:] {
+		if key, value, ok := strings.Cut(part, ":"); ok {
+			metrics[key] = value
+		}
+	}
+
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	value := make(map[string]float64)
+	for metric, options := range weights {
+		weight, ok := options[metrics[metric]]
+		if !ok {
+			return 0, false
+		}
+		value[metric] = weight
+	}
+	changed := metrics["S"] == "C"
+	if !changed && metrics["S"] != "U" {
+		return 0, false
+	}
+	privileges := map[string]float64{"N": 0.85, "L": 0.62, "H": 0.27}
+	if changed {
+		privileges = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+	}
+	pr, ok := privileges[metrics["PR"]]
+	if !ok {
+		return 0, false
+	}
+
+	iss := 1 - (1-value["C"])*(1-value["I"])*(1-value["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * value["AV"] * value["AC"] * pr * value["UI"]
+	score := impact + exploitability
+	if changed {
+		score *= 1.08
+	}
+	return cvssRoundUp(math.Min(score, 10)), true
+}
+
+// cvssRoundUp rounds up to one decimal as defined by CVSS 3.1
+func cvssRoundUp(value float64) float64 {
+	scaled := int(math.Round(value * 100000))
+	if scaled%10000 == 0 {
+		return float64(scaled) / 100000
+	}
+	return float64(scaled/10000+1) / 10
+}
+
+// entrySeverity rates a vulnerability for one affected package. Ratings
+// given by the source win over computed CVSS scores.
+func entrySeverity(entry osvEntry, affected osvAffected) (string, float64) {
+	var score float64
+	for _, severity := range append(append([]osvSeverity{}, affected.Severity...), entry.Severity...) {
+		if s, ok := cvss3BaseScore(severity.Score); ok && s > score {
+			score = s
+		}
+	}
+	for _, source := range []map[string]interface{}{affected.EcosystemSpecific, affected.DatabaseSpecific, entry.DatabaseSpecific} {
+		if value, ok := source["severity"].(string); ok {
+			if severity := normalizeSeverity(value); severity != "" {
+				return severity, score
+			}
+		}
+	}
+	if score > 0 {
+		return severityFromScore(score), score
+	}
+	// Some distributions rate with a word instead of a vector
+	for _, severity := range append(append([]osvSeverity{}, affected.Severity...), entry.Severity...) {
+		if rating := normalizeSeverity(severity.Score); rating != "" {
+			return rating, 0
+		}
+	}
+	return "unknown", 0
+}
+
+// vulnerabilityImporter stores OSV entries in one transaction
+type vulnerabilityImporter struct {
+	stmt    *sql.Stmt
+	entries int
+	records int
+}
+
+// add stores the affected packages of an entry
+func (vi *vulnerabilityImporter) add(entry osvEntry) error {
+	if entry.ID == "" || entry.Withdrawn != "" {
+		return nil
+	}
+	summary := entry.Summary
+	if summary == "" {
+		summary, _, _ = strings.Cut(strings.TrimSpace(entry.Details), "\n")
+	}
+	aliases, _ := json.Marshal(entry.Aliases)
+
+	type record struct {
+		versions affectedVersions
+		severity string
+		score    float64
+	}
+	records := make(map[[2]string]*record)
+	var order [][2]string
+	for _, affected := range entry.Affected {
+		name := affected.Package.Name
+		if affected.Package.Ecosystem == "PyPI" {
+			name = normalizePyPIName(name)
+		}
+		key := [2]string{affected.Package.Ecosystem, name}
+		if key[0] == "" || key[1] == "" {
+			continue
+		}
+		severity, score := entrySeverity(entry, affected)
+		rec := records[key]
+		if rec == nil {
+			rec = &record{severity: severity, score: score}
+			records[key] = rec
+			order = append(order, key)
+		} else if severityOrder[severity] < severityOrder[rec.severity] {
+			rec.severity, rec.score = severity, score
+		}
+		rec.versions.Ranges = append(rec.versions.Ranges, affected.Ranges...)
+		rec.versions.Versions = append(rec.versions.Versions, affected.Versions...)
+	}
+
+	for _, key := range order {
+		rec := records[key]
+		versions, _ := json.Marshal(rec.versions)
+		if _, err := vi.stmt.Exec(entry.ID, key[0], key[1], string(aliases), summary, rec.severity, rec.score, string(versions), entry.Modified); err != nil {
+			return err
+		}
+		vi.records++
+	}
+	vi.entries++
+	return nil
+}
+
+// addJSON stores a single OSV entry or an array of them
+func (vi *vulnerabilityImporter) addJSON(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var entries []osvEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := vi.add(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var entry osvEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	return vi.add(entry)
+}
+
+// importVulnerabilityFile loads an OSV JSON file or a zip of them. With
+// replace the existing database is dropped in the same transaction.
+func importVulnerabilityFile(ctx context.Context, file *os.File, size int64, replace bool, progress func(done, total int)) (int, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM vulnerabilities`); err != nil {
+			return 0, 0, err
+		}
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO vulnerabilities (vuln_id, ecosystem, package, aliases, summary, severity, score, affected, modified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+	importer := &vulnerabilityImporter{stmt: stmt}
+
+	magic := make([]byte, 4)
+	file.ReadAt(magic, 0)
+	if string(magic) == "PK\x03\x04" {
+		archive, err := zip.NewReader(file, size)
+		if err != nil {
+			return 0, 0, err
+		}
+		for i, entry := range archive.File {
+			if ctx.Err() != nil {
+				return 0, 0, ctx.Err()
+			}
+			if !strings.HasSuffix(entry.Name, ".json") {
+				continue
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return 0, 0, err
+			}
+			err = importer.addJSON(rc)
+			rc.Close()
+			if err != nil {
+				return 0, 0, fmt.Errorf("%s: %v", entry.Name, err)
+			}
+			if progress != nil && i%500 == 0 {
+				progress(i, len(archive.File))
+			}
+		}
+	} else if err := importer.addJSON(file); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return importer.entries, importer.records, nil
+}
+
+// uploadFilename returns the name of an uploaded multipart file
+func uploadFilename(body io.Reader, fallback string) string {
+	if part, ok := body.(*multipart.Part); ok && part.FileName() != "" {
+		return part.FileName()
+	}
+	return fallback
+}
+
+// importVulnerabilities loads an uploaded OSV file or zip into the
+// vulnerability database as a background job. ?replace=true drops the
+// existing entries first.
+func importVulnerabilities(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	disableTimeouts(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxVulnerabilityImportSize)
+
+	body, err := imageLoadBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+	filename := uploadFilename(body, r.URL.Query().Get("filename"))
+	if filename == "" {
+		filename = "upload"
+	}
+	file, size, err := spoolUpload(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read upload: "+err.Error())
+		return
+	}
+	if size == 0 {
+		file.Close()
+		writeError(w, http.StatusBadRequest, "The uploaded file is empty")
+		return
+	}
+	replace := r.URL.Query().Get("replace") == "true"
+
+	job := startJob("vulnerability-import", filename, r.Header.Get("X-User"), func(ctx context.Context, job *Job) (interface{}, error) {
+		defer file.Close()
+		job.SetProgress(0, 0, "Importing "+filename)
+		entries, records, err := importVulnerabilityFile(ctx, file, size, replace, func(done, total int) {
+			job.SetProgress(int64(done), int64(total), "Importing "+filename)
+		})
+		if err != nil {
+			recordAudit(job.CreatedBy, "vulnerabilities.import", filename, "failure", map[string]interface{}{"error": err.Error()})
+			return nil, err
+		}
+
+		if _, err := db.Exec(`INSERT INTO vulnerability_imports (filename, entries, records, replaced, imported_by, imported_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			filename, entries, records, replace, job.CreatedBy, time.Now()); err != nil {
+			logrus.WithError(err).Error("Failed to record vulnerability import")
+		}
+		recordAudit(job.CreatedBy, "vulnerabilities.import", filename, "success", map[string]interface{}{"entries": entries, "records": records, "replace": replace})
+		logrus.WithFields(logrus.Fields{"file": filename, "entries": entries, "records": records}).Info("Vulnerability database imported")
+		job.SetProgress(int64(entries), int64(entries), "Import complete")
+		return map[string]interface{}{"filename": filename, "entries": entries, "records": records}, nil
+	})
+	writeJobAccepted(w, job)
+}
+
+// vulnerabilityDatabaseStatus describes the imported vulnerability data
+func vulnerabilityDatabaseStatus() (map[string]interface{}, error) {
+	var entries, records int
+	if err := db.QueryRow(`SELECT COUNT(DISTINCT vuln_id), COUNT(*) FROM vulnerabilities`).Scan(&entries, &records); err != nil {
+		return nil, err
+	}
+
+	ecosystems := map[string]int{}
+	rows, err := db.Query(`SELECT ecosystem, COUNT(*) FROM vulnerabilities GROUP BY ecosystem`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ecosystem string
+		var count int
+		if err := rows.Scan(&ecosystem, &count); err != nil {
+			return nil, err
+		}
+		ecosystems[ecosystem] = count
+	}
+
+	imports := []VulnerabilityImport{}
+	importRows, err := db.Query(`SELECT id, filename, entries, records, replaced, imported_by, imported_at FROM vulnerability_imports ORDER BY id DESC LIMIT 20`)
+	if err != nil {
+		return nil, err
+	}
+	defer importRows.Close()
+	for importRows.Next() {
+		var imp VulnerabilityImport
+		if err := importRows.Scan(&imp.ID, &imp.Filename, &imp.Entries, &imp.Records, &imp.Replaced, &imp.ImportedBy, &imp.ImportedAt); err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+
+	return map[string]interface{}{
+		"entries":    entries,
+		"records":    records,
+		"ecosystems": ecosystems,
+		"imports":    imports,
+	}, nil
+}
+
+// getVulnerabilityDatabase reports what the vulnerability database contains
+func getVulnerabilityDatabase(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	status, err := vulnerabilityDatabaseStatus()
+	if err != nil {
+		writeFailure(w, "Failed to read vulnerability database", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// clearVulnerabilityDatabase removes all imported vulnerabilities
+func clearVulnerabilityDatabase(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	result, err := db.Exec(`DELETE FROM vulnerabilities`)
+	if err != nil {
+		writeFailure(w, "Failed to clear vulnerability database", err)
+		return
+	}
+	removed, _ := result.RowsAffected()
+	recordAudit(r.Header.Get("X-User"), "vulnerabilities.clear", "", "success", map[string]interface{}{"records": removed})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"removed": removed})
+}
+
+// osvEcosystem returns the OSV ecosystem of a package, or "" when the
+// distribution has no OSV data
+func osvEcosystem(pkg SBOMPackage, release osRelease) string {
+	switch pkg.Type {
+	case "npm":
+		return "npm"
+	case "pypi":
+		return "PyPI"
+	case "gem":
+		return "RubyGems"
+	case "cargo":
+		return "crates.io"
+	case "composer":
+		return "Packagist"
+	case "golang":
+		return "Go"
+	}
+
+	parts := strings.Split(release.VersionID, ".")
+	major := parts[0]
+	withVersion := func(ecosystem, version string) string {
+		if version == "" {
+			return ecosystem
+		}
+		return ecosystem + ":" + version
+	}
+	switch release.ID {
+	case "debian":
+		return withVersion("Debian", major)
+	case "ubuntu":
+		return withVersion("Ubuntu", release.VersionID)
+	case "alpine":
+		if len(parts) >= 2 {
+			return "Alpine:v" + parts[0] + "." + parts[1]
+		}
+		return "Alpine"
+	case "wolfi":
+		return "Wolfi"
+	case "chainguard":
+		return "Chainguard"
+	case "rocky":
+		return withVersion("Rocky Linux", major)
+	case "almalinux":
+		return withVersion("AlmaLinux", major)
+	case "rhel":
+		return "Red Hat"
+	case "opensuse-leap", "opensuse-tumbleweed":
+		return "openSUSE"
+	case "sles":
+		return "SUSE"
+	case "mariner":
+		return "Mariner"
+	}
+	return map[string]string{"deb": "Debian", "apk": "Alpine"}[pkg.Type]
+}
+
+// versionComparator returns the version ordering of an OSV ecosystem
+func versionComparator(ecosystem string) func(a, b string) int {
+	family, _, _ := strings.Cut(ecosystem, ":")
+	switch family {
+	case "Debian", "Ubuntu":
+		return compareDebianVersions
+	case "Alpine", "Wolfi", "Chainguard":
+		return compareAlpineVersions
+	case "Red Hat", "Rocky Linux", "AlmaLinux", "openSUSE", "SUSE", "Mariner":
+		return compareRPMVersions
+	}
+	return compareGenericVersions
+}
+
+// versionAffected reports whether a version falls in the affected data of a
+// package, and the first fixed version above it
+func versionAffected(version string, affected affectedVersions, compare func(a, b string) int) (bool, string) {
+	for _, v := range affected.Versions {
+		if v == version {
+			return true, ""
+		}
+	}
+	for _, r := range affected.Ranges {
+		if r.Type == "GIT" {
+			continue
+		}
+		inRange, fixed := false, ""
+		for _, event := range r.Events {
+			switch {
+			case event.Introduced != "":
+				if event.Introduced == "0" || compare(version, event.Introduced) >= 0 {
+					inRange, fixed = true, ""
+				}
+			case event.Fixed != "":
+				if compare(version, event.Fixed) >= 0 {
+					inRange = false
+				} else if inRange && fixed == "" {
+					fixed = event.Fixed
+				}
+			case event.LastAffected != "":
+				if compare(version, event.LastAffected) > 0 {
+					inRange = false
+				}
+			case event.Limit != "":
+				if compare(version, event.Limit) >= 0 {
+					inRange = false
+				}
+			}
+		}
+		if inRange {
+			return true, fixed
+		}
+	}
+	return false, ""
+}
+
+// matchVulnerabilities finds the vulnerabilities of scanned packages in the
+// vulnerability database. Distribution packages are also matched by their
+// source package, which is what distribution advisories name.
+func matchVulnerabilities(contents *imageContents) ([]VulnerabilityMatch, error) {
+	matches := []VulnerabilityMatch{}
+	if db == nil {
+		return matches, nil
+	}
+	stmt, err := db.Prepare(`SELECT vuln_id, ecosystem, aliases, summary, severity, score, affected FROM vulnerabilities
+		WHERE package = ? AND (ecosystem = ? OR ecosystem LIKE ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	seen := make(map[string]bool)
+	for _, pkg := range contents.Packages {
+		ecosystem := osvEcosystem(pkg, contents.OS)
+		if ecosystem == "" {
+			continue
+		}
+		type candidate struct{ name, version string }
+		name := pkg.Name
+		if pkg.Type == "pypi" {
+			name = normalizePyPIName(name)
+		}
+		candidates := []candidate{{name, pkg.Version}}
+		if pkg.Source != "" && pkg.Source != pkg.Name {
+			version := pkg.Version
+			if pkg.SourceVersion != "" {
+				version = pkg.SourceVersion
+			}
+			candidates = append(candidates, candidate{pkg.Source, version})
+		}
+
+		for _, c := range candidates {
+			rows, err := stmt.Query(c.name, ecosystem, ecosystem+":%")
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				var match VulnerabilityMatch
+				var aliases, affected string
+				if err := rows.Scan(&match.ID, &match.Ecosystem, &aliases, &match.Summary, &match.Severity, &match.Score, &affected); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				key := match.ID + "\x00" + pkg.PURL + "\x00" + pkg.Location
+				if seen[key] {
+					continue
+				}
+				var versions affectedVersions
+				json.Unmarshal([]byte(affected), &versions)
+				ok, fixed := versionAffected(c.version, versions, versionComparator(match.Ecosystem))
+				if !ok {
+					continue
+				}
+				seen[key] = true
+				json.Unmarshal([]byte(aliases), &match.Aliases)
+				match.Package = pkg.Name
+				match.Version = pkg.Version
+				match.Type = pkg.Type
+				match.FixedVersion = fixed
+				match.Location = pkg.Location
+				match.PURL = pkg.PURL
+				matches = append(matches, match)
+			}
+			rows.Close()
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if severityOrder[a.Severity] != severityOrder[b.Severity] {
+			return severityOrder[a.Severity] < severityOrder[b.Severity]
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.ID < b.ID
+	})
+	return matches, nil
+}
+
+// getImageVulnerabilities scans an image and reports the vulnerabilities of
+// its packages with a severity summary. ?severity= hides less severe ones
+// from the list; the summary always counts all of them.
+func getImageVulnerabilities(w http.ResponseWriter, r *http.Request) {
+	if !requireDatabase(w) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	minimum := strings.ToLower(r.URL.Query().Get("severity"))
+	if _, ok := severityOrder[minimum]; minimum != "" && !ok {
+		writeError(w, http.StatusBadRequest, "Severity must be critical, high, medium, low or unknown")
+		return
+	}
+
+	disableTimeouts(w)
+	image, contents, err := scanImage(r.Context(), id)
+	if err != nil {
+		logrus.WithError(err).WithField("image", id).Error("Failed to scan image")
+		writeFailure(w, "Failed to scan image", err)
+		return
+	}
+	matches, err := matchVulnerabilities(contents)
+	if err != nil {
+		writeFailure(w, "Failed to match vulnerabilities", err)
+		return
+	}
+
+	summary := map[string]int{"critical": 0, "high": 0, "medium": 0, "low": 0, "unknown": 0, "total": len(matches)}
+	listed := []VulnerabilityMatch{}
+	for _, match := range matches {
+		summary[match.Severity]++
+		if minimum == "" || severityOrder[match.Severity] <= severityOrder[minimum] {
+			listed = append(listed, match)
+		}
+	}
+
+	warnings := append([]string{}, contents.Warnings...)
+	var entries int
+	db.QueryRow(`SELECT COUNT(DISTINCT vuln_id) FROM vulnerabilities`).Scan(&entries)
+	if entries == 0 {
+		warnings = append(warnings, "No vulnerability database has been imported")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"image":           imageDisplayName(id, image),
+		"image_id":        image.ID,
+		"os":              contents.OS,
+		"packages":        len(contents.Packages),
+		"database":        map[string]int{"entries": entries},
+		"summary":         summary,
+		"vulnerabilities": listed,
+		"warnings":        warnings,
+		"scanned_at":      contents.ScannedAt,
+	})
+}
+
+// compareDebianVersions orders [epoch:]upstream[-revision] versions as dpkg does
+func compareDebianVersions(a, b string) int {
+	split := func(v string) (int, string, string) {
+		epoch := 0
+		if e, rest, ok := strings.Cut(v, ":"); ok {
+			if n, err := strconv.Atoi(e); err == nil {
+				epoch, v = n, rest
+			}
+		}
+		if i := strings.LastIndex(v, "-"); i >= 0 {
+			return epoch, v[:i], v[i+1:]
+		}
+		return epoch, v, ""
+	}
+	epochA, upstreamA, revisionA := split(a)
+	epochB, upstreamB, revisionB := split(b)
+	if epochA != epochB {
+		return sign(epochA - epochB)
+	}
+	if c := debianVerRevCmp(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return debianVerRevCmp(revisionA, revisionB)
+}
+
+// debianVerRevCmp compares one version part: non-digit runs by character
+// order with ~ sorting first, digit runs numerically
+func debianVerRevCmp(a, b string) int {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	order := func(s string, i int) int {
+		switch {
+		case i >= len(s) || isDigit(s[i]):
+			return 0
+		case s[i] >= 'A' && s[i] <= 'Z' || s[i] >= 'a' && s[i] <= 'z':
+			return int(s[i])
+		case s[i] == '~':
+			return -1
+		}
+		return int(s[i]) + 256
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			if oa, ob := order(a, i), order(b, j); oa != ob {
+				return sign(oa - ob)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		first := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if first == 0 {
+				first = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if first != 0 {
+			return sign(first)
+		}
+	}
+	return 0
+}
+
+// compareRPMVersions orders [epoch:]version-release versions as rpm does
+func compareRPMVersions(a, b string) int {
+	split := func(v string) (int, string, string) {
+		epoch := 0
+		if e, rest, ok := strings.Cut(v, ":"); ok {
+			if n, err := strconv.Atoi(e); err == nil {
+				epoch, v = n, rest
+			}
+		}
+		if i := strings.LastIndex(v, "-"); i >= 0 {
+			return epoch, v[:i], v[i+1:]
+		}
+		return epoch, v, ""
+	}
+	epochA, versionA, releaseA := split(a)
+	epochB, versionB, releaseB := split(b)
+	if epochA != epochB {
+		return sign(epochA - epochB)
+	}
+	if c := rpmVerCmp(versionA, versionB); c != 0 {
+		return c
+	}
+	if releaseA == "" || releaseB == "" {
+		return 0
+	}
+	return rpmVerCmp(releaseA, releaseB)
+}
+
+// rpmVerCmp is rpm's segment-wise version comparison
+func rpmVerCmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isAlpha := func(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+	isSeparator := func(c byte) bool { return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^' }
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && isSeparator(a[i]) {
+			i++
+		}
+		for j < len(b) && isSeparator(b[j]) {
+			j++
+		}
+		// A tilde sorts before everything, even the end of the version
+		if (i < len(a) && a[i] == '~') || (j < len(b) && b[j] == '~') {
+			if i >= len(a) || a[i] != '~' {
+				return 1
+			}
+			if j >= len(b) || b[j] != '~' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		// A caret sorts after the end of the version but before anything else
+		if (i < len(a) && a[i] == '^') || (j < len(b) && b[j] == '^') {
+			if i >= len(a) {
+				return -1
+			}
+			if j >= len(b) {
+				return 1
+			}
+			if a[i] != '^' {
+				return 1
+			}
+			if b[j] != '^' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		startA, startB := i, j
+		numeric := isDigit(a[i])
+		class := isAlpha
+		if numeric {
+			class = isDigit
+		}
+		for i < len(a) && class(a[i]) {
+			i++
+		}
+		for j < len(b) && class(b[j]) {
+			j++
+		}
+		segA, segB := a[startA:i], b[startB:j]
+		if segB == "" {
+			// Numeric segments are newer than alphabetic ones
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				return sign(len(segA) - len(segB))
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case i >= len(a) && j >= len(b):
+		return 0
+	case i < len(a):
+		return 1
+	}
+	return -1
+}
+
+// compareAlpineVersions orders apk versions: the -rN package revision is
+// compared after the upstream version
+func compareAlpineVersions(a, b string) int {
+	split := func(v string) (string, int) {
+		if i := strings.LastIndex(v, "-r"); i >= 0 {
+			if n, err := strconv.Atoi(v[i+2:]); err == nil {
+				return v[:i], n
+			}
+		}
+		return v, 0
+	}
+	versionA, revisionA := split(a)
+	versionB, revisionB := split(b)
+	if c := compareGenericVersions(versionA, versionB); c != 0 {
+		return c
+	}
+	return sign(revisionA - revisionB)
+}
+
+// preReleaseWords sort before the version they are attached to
+var preReleaseWords = map[string]bool{
+	"alpha": true, "a": true, "beta": true, "b": true, "pre": true, "preview": true,
+	"rc": true, "c": true, "dev": true,
+}
+
+// compareGenericVersions orders dotted versions with optional pre-release
+// suffixes, as used by semver, PyPI and most language ecosystems
+func compareGenericVersions(a, b string) int {
+	tokens := func(v string) []string {
+		v = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(v), "v"), "V")
+		v, _, _ = strings.Cut(v, "+")
+		var out []string
+		start := -1
+		digit := false
+		for i := 0; i <= len(v); i++ {
+			var c byte
+			if i < len(v) {
+				c = v[i]
+			}
+			isDigit := c >= '0' && c <= '9'
+			isAlpha := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+			if start >= 0 && (i == len(v) || (!isDigit && !isAlpha) || isDigit != digit) {
+				out = append(out, strings.ToLower(v[start:i]))
+				start = -1
+			}
+			if start < 0 && (isDigit || isAlpha) {
+				start, digit = i, isDigit
+			}
+		}
+		return out
+	}
+	isNumber := func(s string) bool { return s != "" && s[0] >= '0' && s[0] <= '9' }
+
+	ta, tb := tokens(a), tokens(b)
+	for i := 0; i < len(ta) || i < len(tb); i++ {
+		if i >= len(ta) || i >= len(tb) {
+			// The longer version is older only when it continues with a pre-release
+			longer, result := tb, -1
+			if i < len(ta) {
+				longer, result = ta, 1
+			}
+			if preReleaseWords[longer[i]] {
+				return -result
+			}
+			return result
+		}
+		x, y := ta[i], tb[i]
+		switch {
+		case isNumber(x) && isNumber(y):
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(x) != len(y) {
+				return sign(len(x) - len(y))
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		case isNumber(x):
+			return 1
+		case isNumber(y):
+			return -1
+		default:
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -7,7 +7,7 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
   const [formData, setFormData] = useState({
     image: '',
     name: '',
-    ports: [{ host: '', container: '' }],
+    ports: [{ host: '', container: '', protocol: 'tcp', hostIp: '' }],
     environment: [''],
     volumes: [''],
     command: '',
@@ -26,7 +26,7 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
   const fetchImages = async () => {
     try {
       const response = await api.getImages();
-      setImages(response.data);
+      setImages(response.data.items);
     } catch (err) {
       console.error('Failed to fetch images:', err);
     }
@@ -49,7 +49,7 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
   const addArrayItem = (field) => {
     setFormData(prev => ({
       ...prev,
-      [field]: [...prev[field], field === 'ports' ? { host: '', container: '' } : '']
+      [field]: [...prev[field], field === 'ports' ? { host: '', container: '', protocol: 'tcp', hostIp: '' } : '']
     }));
   };
 
@@ -89,12 +89,14 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
       };
 
       // Add ports
-      const validPorts = formData.ports.filter(p => p.host && p.container);
+      const validPorts = formData.ports.filter(p => p.container);
       if (validPorts.length > 0) {
-        requestData.ports = {};
-        validPorts.forEach(port => {
-          requestData.ports[port.host] = port.container;
-        });
+        requestData.ports = validPorts.map(port => ({
+          host_ip: port.hostIp.trim() || undefined,
+          host_port: port.host.trim() || undefined,
+          container_port: port.container.trim(),
+          protocol: port.protocol
+        }));
       }
 
       // Add environment variables
@@ -120,6 +122,19 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
       handleClose();
     } catch (err) {
       console.error('Failed to run container:', err);
+      const conflicts = err.response?.status === 409 ? err.response.data?.details?.conflicts : null;
+      if (conflicts?.length) {
+        // Offer the suggested free ports in place of the taken ones
+        setFormData(prev => ({
+          ...prev,
+          ports: prev.ports.map(port => {
+            const conflict = conflicts.find(c => String(c.host_port) === port.host.trim());
+            return conflict?.suggested_port ? { ...port, host: String(conflict.suggested_port) } : port;
+          })
+        }));
+        toast.error(conflicts.map(c => `Port ${c.host_port}/${c.protocol} is used by ${c.owner_type} ${c.owner}`).join('\n') + '\nSuggested free ports have been filled in.');
+        return;
+      }
       toast.error(`Failed to run container: ${err.response?.data?.message || err.message}`);
     } finally {
       setLoading(false);
@@ -130,7 +145,7 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
     setFormData({
       image: '',
       name: '',
-      ports: [{ host: '', container: '' }],
+      ports: [{ host: '', container: '', protocol: 'tcp', hostIp: '' }],
       environment: [''],
       volumes: [''],
       command: '',
@@ -212,6 +227,13 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
             </label>
             {formData.ports.map((port, index) => (
               <div key={index} className="flex gap-2 mb-2">
+                <input
+                  type="text"
+                  value={port.hostIp}
+                  onChange={(e) => handlePortChange(index, 'hostIp', e.target.value)}
+                  placeholder="Host IP (optional)"
+                  className="w-36 px-3 py-2 border border-gray-300 dark:border-gray-600 rounded-md shadow-sm focus:outline-none focus:ring-blue-500 focus:border-blue-500 dark:bg-gray-700 dark:text-white"
+                />
                 <input
                   type="text"
                   value={port.host}
@@ -227,6 +249,14 @@ const RunContainerModal = ({ isOpen, onClose, onSuccess }) => {
                   placeholder="Container port (e.g., 80)"
                   className="flex-1 px-3 py-2 border border-gray-300 dark:border-gray-600 rounded-md shadow-sm focus:outline-none focus:ring-blue-500 focus:border-blue-500 dark:bg-gray-700 dark:text-white"
                 />
+                <select
+                  value={port.protocol}
+                  onChange={(e) => handlePortChange(index, 'protocol', e.target.value)}
+                  className="px-2 py-2 border border-gray-300 dark:border-gray-600 rounded-md shadow-sm focus:outline-none focus:ring-blue-500 focus:border-blue-500 dark:bg-gray-700 dark:text-white"
+                >
+                  <option value="tcp">TCP</option>
+                  <option value="udp">UDP</option>
+                </select>
                 {formData.ports.length > 1 && (
                   <button
                     type="button"
@@ -34,7 +34,7 @@ const ContainersPage = () => {
     try {
       setLoading(true);
       const response = await api.getContainers(showAll);
-      setContainers(response.data);
+      setContainers(response.data.items);
       setError(null);
     } catch (err) {
       console.error('Failed to fetch containers:', err);
@@ -221,12 +221,13 @@ const ContainersPage = () => {
                         </div>
                       </td>
                       <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500 dark:text-gray-400">
-                        {container.Ports?.map((port, index) => (
-                          <div key={index}>
-                            {port.PublicPort ? `${port.PublicPort}:${port.PrivatePort}` : port.PrivatePort}
-                            /{port.Type}
+                        {container.PortGroups?.length ? container.PortGroups.map((group, index) => (
+                          <div key={index} title={group.HostIPs?.join(', ')}>
+                            {group.Published && group.HostPort ? `${group.HostPort}:${group.ContainerPort}` : group.ContainerPort}
+                            /{group.Protocol}
+                            {!group.Published && <span className="ml-1 text-xs">(exposed)</span>}
                           </div>
-                        )) || 'None'}
+                        )) : 'None'}
                       </td>
                       <td className="px-6 py-4 whitespace-nowrap text-sm font-medium">
                         <div className="flex space-x-2">
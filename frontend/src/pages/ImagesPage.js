@@ -1,4 +1,4 @@
-import React, { useState, useEffect } from 'react';
+import React, { useState, useEffect, useRef } from 'react';
 import { 
   TrashIcon,
   PhotoIcon,
@@ -22,16 +22,19 @@ const ImagesPage = () => {
   const [imageDetails, setImageDetails] = useState(null);
   const [showImageModal, setShowImageModal] = useState(false);
   const [pulling, setPulling] = useState(false);
+  const [pullJob, setPullJob] = useState(null);
+  const pullEvents = useRef(null);
 
   useEffect(() => {
     fetchImages();
+    return () => pullEvents.current?.close();
   }, []);
 
   const fetchImages = async () => {
     try {
       setLoading(true);
       const response = await api.getImages();
-      setImages(response.data);
+      setImages(response.data.items);
       setError(null);
     } catch (err) {
       console.error('Failed to fetch images:', err);
@@ -41,15 +44,25 @@ const ImagesPage = () => {
     }
   };
 
-  const handleDeleteImage = async (imageId) => {
-    if (window.confirm('Are you sure you want to delete this image?')) {
+  const handleDeleteImage = async (imageId, override = false) => {
+    if (override || window.confirm('Are you sure you want to delete this image?')) {
       try {
-        await api.deleteImage(imageId, true);
+        await api.deleteImage(imageId, true, override);
         await fetchImages();
         toast.success('Image deleted successfully');
       } catch (err) {
+        const data = err.response?.data;
+        if (!override && err.response?.status === 409 && data?.code === 'image_in_use') {
+          const containers = (data.details?.containers || []).map(c => `  • ${c.Name} (${c.State})`);
+          const children = data.details?.children?.length ? [`  • ${data.details.children.length} child image(s)`] : [];
+          const dependents = [...containers, ...children].join('\n');
+          if (window.confirm(`This image is still in use by:\n${dependents}\n\nDelete it anyway?`)) {
+            await handleDeleteImage(imageId, true);
+          }
+          return;
+        }
         console.error('Failed to delete image:', err);
-        toast.error(`Failed to delete image: ${err.response?.data?.message || err.message}`);
+        toast.error(`Failed to delete image: ${data?.message || err.message}`);
       }
     }
   };
@@ -108,19 +121,59 @@ const ImagesPage = () => {
   const handlePullImage = async (imageName) => {
     try {
       setPulling(true);
-      await api.pullImage(imageName);
-      toast.success(`Image ${imageName} pulled successfully`);
-      await fetchImages();
-      setSearchResults(null);
-      setSearchQuery('');
+      const response = await api.pullImage(imageName);
+      const job = response.data;
+      setPullJob({ id: job.id, image: imageName, progress: job.progress, layers: {} });
+
+      // Pulls run as background jobs; follow their progress over SSE
+      const events = new EventSource(api.jobEventsUrl(job.id));
+      pullEvents.current = events;
+      events.addEventListener('layer', (e) => {
+        const layer = JSON.parse(e.data).data;
+        setPullJob(prev => prev && { ...prev, layers: { ...prev.layers, [layer.id]: layer } });
+      });
+      events.addEventListener('progress', (e) => {
+        const progress = JSON.parse(e.data).data;
+        setPullJob(prev => prev && { ...prev, progress });
+      });
+      events.addEventListener('done', async (e) => {
+        events.close();
+        pullEvents.current = null;
+        const finished = JSON.parse(e.data);
+        setPulling(false);
+        setPullJob(null);
+        if (finished.status === 'succeeded') {
+          toast.success(`Image ${imageName} pulled successfully`);
+          await fetchImages();
+          setSearchResults(null);
+          setSearchQuery('');
+        } else if (finished.status === 'cancelled') {
+          toast.info(`Pull of ${imageName} cancelled`);
+        } else {
+          toast.error(`Failed to pull image: ${finished.error}`);
+        }
+      });
+      events.onerror = () => {
+        if (events.readyState === EventSource.CLOSED) {
+          setPulling(false);
+        }
+      };
     } catch (err) {
       console.error('Failed to pull image:', err);
       toast.error(`Failed to pull image: ${err.response?.data?.message || err.message}`);
-    } finally {
       setPulling(false);
     }
   };
 
+  const handleCancelPull = async () => {
+    if (!pullJob) return;
+    try {
+      await api.cancelJob(pullJob.id);
+    } catch (err) {
+      toast.error(`Failed to cancel pull: ${err.response?.data?.message || err.message}`);
+    }
+  };
+
   const formatSize = (bytes) => {
     if (bytes === 0) return '0 Bytes';
     const k = 1024;
@@ -188,6 +241,44 @@ const ImagesPage = () => {
         </div>
       </div>
 
+      {/* Pull Progress */}
+      {pullJob && (
+        <div className="bg-white dark:bg-gray-800 shadow rounded-lg p-6">
+          <div className="flex items-center justify-between mb-2">
+            <h2 className="text-lg font-medium text-gray-900 dark:text-white">
+              Pulling {pullJob.image}
+            </h2>
+            <button
+              onClick={handleCancelPull}
+              className="px-3 py-1 text-sm bg-red-600 text-white rounded-md hover:bg-red-700"
+            >
+              Cancel
+            </button>
+          </div>
+          <div className="w-full bg-gray-200 dark:bg-gray-700 rounded-full h-2 mb-1">
+            <div
+              className="bg-blue-600 h-2 rounded-full"
+              style={{ width: `${Math.round(pullJob.progress?.percent || 0)}%` }}
+            />
+          </div>
+          <div className="text-sm text-gray-500 dark:text-gray-400 mb-3">
+            {pullJob.progress?.message}
+          </div>
+          <div className="grid gap-1">
+            {Object.values(pullJob.layers).map((layer) => (
+              <div key={layer.id} className="flex justify-between text-xs text-gray-600 dark:text-gray-300 font-mono">
+                <span>{layer.id}</span>
+                <span>
+                  {layer.status}
+                  {layer.status === 'Downloading' && layer.download_total > 0 && ` ${formatSize(layer.downloaded)} / ${formatSize(layer.download_total)}`}
+                  {layer.status === 'Extracting' && layer.extract_total > 0 && ` ${formatSize(layer.extracted)} / ${formatSize(layer.extract_total)}`}
+                </span>
+              </div>
+            ))}
+          </div>
+        </div>
+      )}
+
       {/* Search Results */}
       {searchResults && (
         <div className="bg-white dark:bg-gray-800 shadow rounded-lg p-6">
@@ -346,6 +437,14 @@ const ImagesPage = () => {
                           <div className="text-sm font-medium text-gray-900 dark:text-white">
                             {repository || '<none>'}
                           </div>
+                          {image.UsedBy?.length > 0 && (
+                            <div className="text-xs text-gray-500 dark:text-gray-400" title={image.UsedBy.map(c => c.Name).join(', ')}>
+                              Used by {image.UsedBy.length} container{image.UsedBy.length === 1 ? '' : 's'}
+                            </div>
+                          )}
+                          {image.Dangling && (
+                            <div className="text-xs text-yellow-600 dark:text-yellow-400">Dangling</div>
+                          )}
                         </td>
                         <td className="px-6 py-4 whitespace-nowrap">
                           <span className="inline-flex px-2 py-1 text-xs font-semibold rounded-full bg-blue-100 text-blue-800 dark:bg-blue-900 dark:text-blue-200">
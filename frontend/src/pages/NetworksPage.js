@@ -16,7 +16,7 @@ const NetworksPage = () => {
     try {
       setLoading(true);
       const response = await api.getNetworks();
-      setNetworks(response.data);
+      setNetworks(response.data.items);
       setError(null);
     } catch (err) {
       console.error('Failed to fetch networks:', err);
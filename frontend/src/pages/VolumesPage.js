@@ -16,7 +16,7 @@ const VolumesPage = () => {
     try {
       setLoading(true);
       const response = await api.getVolumes();
-      setVolumes(response.data);
+      setVolumes(response.data.items);
       setError(null);
     } catch (err) {
       console.error('Failed to fetch volumes:', err);
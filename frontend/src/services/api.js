@@ -114,12 +114,23 @@ const api = {
   getSystemMetrics: () =>
     apiClient.get('/system/metrics'),
 
+  suggestHostPort: (from, protocol = 'tcp', hostIp = '') =>
+    apiClient.get('/system/ports/next', { params: { from, protocol, host_ip: hostIp || undefined } }),
+
   // Containers
-  getContainers: (all = true) => 
-    apiClient.get(`/containers?all=${all}`),
+  // List endpoints return { items, total, count, offset, limit, next_cursor }.
+  // limit=0 requests every item.
+  getContainers: (all = true, params = {}) => 
+    apiClient.get('/containers', { params: { all, limit: 0, ...params } }),
   
   runContainer: (config) =>
     apiClient.post('/containers/run', config),
+
+  bulkContainers: (action, { ids = [], labels = [], dryRun = false, force = false, signal = '' } = {}) =>
+    apiClient.post('/containers/bulk', { action, ids, labels, dry_run: dryRun, force, signal }),
+
+  importRunCommand: (command, envFiles = {}, run = false) =>
+    apiClient.post('/containers/import-run', { command, env_files: envFiles, run }),
   
   startContainer: (id) => 
     apiClient.post(`/containers/${id}/start`),
@@ -139,36 +150,340 @@ const api = {
   getContainerLogs: (id, tail = 100) => 
     apiClient.get(`/containers/${id}/logs?tail=${tail}`),
 
+  getContainerTop: (id, columns = '') =>
+    apiClient.get(`/containers/${id}/top${columns ? `?columns=${encodeURIComponent(columns)}` : ''}`),
+
+  getContainerChanges: (id, sizes = true) =>
+    apiClient.get(`/containers/${id}/changes?sizes=${sizes}`),
+
+  listContainerFiles: (id, path = '/') =>
+    apiClient.get(`/containers/${id}/files?path=${encodeURIComponent(path)}`),
+
+  statContainerFile: (id, path) =>
+    apiClient.get(`/containers/${id}/files/stat?path=${encodeURIComponent(path)}`),
+
+  downloadContainerFile: (id, path, format = '') =>
+    apiClient.get(`/containers/${id}/files/download?path=${encodeURIComponent(path)}${format ? `&format=${format}` : ''}`, {
+      responseType: 'blob',
+      timeout: 0,
+    }),
+
+  uploadContainerFiles: (id, path, files, extract = false) => {
+    const formData = new FormData();
+    files.forEach(file => formData.append('files', file));
+    return apiClient.post(`/containers/${id}/files/upload?path=${encodeURIComponent(path)}&extract=${extract}`, formData, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 0,
+    });
+  },
+
+  deleteContainerFile: (id, path) =>
+    apiClient.delete(`/containers/${id}/files?path=${encodeURIComponent(path)}`),
+
+  renameContainerFile: (id, from, to) =>
+    apiClient.post(`/containers/${id}/files/rename`, { from, to }),
+
+  commitContainer: (id, options) =>
+    apiClient.post(`/containers/${id}/commit`, options),
+
+  exportContainerFilesystem: (id, gzip = false) =>
+    apiClient.post(`/containers/${id}/export`, { gzip }),
+
+  cloneContainer: (id, name, overrides = {}, start = true) =>
+    apiClient.post(`/containers/${id}/clone`, { name, overrides, start }),
+
+  exportContainerConfig: (id, format = 'run') =>
+    apiClient.get(`/containers/${id}/export/${format}`, {
+      responseType: format === 'json' ? 'json' : 'text',
+    }),
+
   // Images
-  getImages: () => 
-    apiClient.get('/images'),
+  getImages: (params = {}) => 
+    apiClient.get('/images', { params: { limit: 0, ...params } }),
   
   searchImages: (query) =>
     apiClient.get(`/images/search?q=${encodeURIComponent(query)}`),
 
-  pullImage: (image) =>
-    apiClient.post('/images/pull', { image }),
+  // Starts a pull job; follow it with jobEventsUrl
+  pullImage: (image, platform) =>
+    apiClient.post('/images/pull', { image, platform: platform || undefined }),
+
+  // Starts a build job from a FormData with an "options" JSON field, an
+  // optional "context" tar, an inline "Dockerfile" and "files" parts
+  buildImage: (formData) =>
+    apiClient.post('/images/build', formData, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 0,
+    }),
+
+  getBuilds: (limit = 50) =>
+    apiClient.get(`/images/builds?limit=${limit}`),
+
+  getBuild: (id) =>
+    apiClient.get(`/images/builds/${id}`),
+
+  // Link for a browser download, so multi-gigabyte tarballs are not buffered
+  saveImagesUrl: (images, gzip = false) =>
+    `${API_BASE_URL}/images/save?${images.map(image => `image=${encodeURIComponent(image)}`).join('&')}&gzip=${gzip}&token=${encodeURIComponent(localStorage.getItem('token') || '')}`,
+
+  loadImages: (file, onUploadProgress) => {
+    const formData = new FormData();
+    formData.append('file', file);
+    return apiClient.post('/images/load', formData, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 0,
+      onUploadProgress,
+    });
+  },
+
+  tagImage: (source, target) =>
+    apiClient.post('/images/tag', { source, target }),
+
+  untagImage: (reference) =>
+    apiClient.post('/images/untag', { reference }),
+
+  // Push and promote start jobs; follow them with jobEventsUrl
+  pushImage: (image) =>
+    apiClient.post('/images/push', { image }),
+
+  promoteImage: (source, target, pull = false) =>
+    apiClient.post('/images/promote', { source, target, pull }),
 
   inspectImage: (id) =>
     apiClient.get(`/images/${id}/inspect`),
+
+  getImageHistory: (id) =>
+    apiClient.get(`/images/${id}/history`),
+
+  // SBOM format is cyclonedx or spdx
+  getImageSBOM: (id, format = 'cyclonedx') =>
+    apiClient.get(`/images/${id}/sbom`, { params: { format }, timeout: 0 }),
+
+  getImageVulnerabilities: (id, severity = '') =>
+    apiClient.get(`/images/${id}/vulnerabilities`, { params: { severity: severity || undefined }, timeout: 0 }),
+
+  getImageUsage: () =>
+    apiClient.get('/images/usage'),
   
-  deleteImage: (id, force = false) => 
-    apiClient.delete(`/images/${id}?force=${force}`),
+  // Images used by containers or child images are refused with a 409
+  // listing them unless override is set
+  deleteImage: (id, force = false, override = false) => 
+    apiClient.delete(`/images/${id}?force=${force}${override ? '&override=true' : ''}`),
 
   // Volumes
-  getVolumes: () => 
-    apiClient.get('/volumes'),
+  getVolumes: (params = {}) => 
+    apiClient.get('/volumes', { params: { limit: 0, ...params } }),
   
   deleteVolume: (name, force = false) => 
     apiClient.delete(`/volumes/${name}?force=${force}`),
 
+  // volume is { name, driver, driver_opts, labels }
+  createVolume: (volume) =>
+    apiClient.post('/volumes', volume),
+
+  getVolume: (name, size = false) =>
+    apiClient.get(`/volumes/${encodeURIComponent(name)}${size ? '?size=true' : ''}`),
+
+  // Streams a .tar.gz of the volume; stop pauses the containers using it
+  volumeBackupUrl: (name, stop = false) =>
+    `${API_BASE_URL}/volumes/${encodeURIComponent(name)}/backup?stop=${stop}&token=${encodeURIComponent(localStorage.getItem('token') || '')}`,
+
+  restoreVolume: (name, file, { replace = false, stop = false } = {}, onUploadProgress) => {
+    const formData = new FormData();
+    formData.append('file', file);
+    return apiClient.post(`/volumes/${encodeURIComponent(name)}/restore?replace=${replace}&stop=${stop}`, formData, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 0,
+      onUploadProgress,
+    });
+  },
+
+  // Stored backups; creating and restoring return jobs. targetId 0 is the backup directory
+  createVolumeBackup: (name, stop = false, targetId = 0) =>
+    apiClient.post(`/volumes/${encodeURIComponent(name)}/backups`, { stop, target_id: targetId }),
+
+  // filters is { volume, target_id, schedule_id }; a volume name alone is also accepted
+  getVolumeBackups: (filters = {}) =>
+    apiClient.get('/volume-backups', { params: typeof filters === 'string' ? { volume: filters } : filters }),
+
+  verifyVolumeBackup: (id) =>
+    apiClient.post(`/volume-backups/${id}/verify`, null, { timeout: 0 }),
+
+  volumeBackupDownloadUrl: (id) =>
+    `${API_BASE_URL}/volume-backups/${id}/download?token=${encodeURIComponent(localStorage.getItem('token') || '')}`,
+
+  // options is { volume, replace, stop }; volume defaults to the backed up one
+  restoreVolumeBackup: (id, options = {}) =>
+    apiClient.post(`/volume-backups/${id}/restore`, options),
+
+  deleteVolumeBackup: (id) =>
+    apiClient.delete(`/volume-backups/${id}`),
+
+  // Backup targets; target is { name, type, path } or { name, type: 's3', endpoint, region, bucket, prefix, access_key, secret_key }
+  getBackupTargets: () =>
+    apiClient.get('/volume-backup-targets'),
+
+  getBackupTarget: (id) =>
+    apiClient.get(`/volume-backup-targets/${id}`),
+
+  createBackupTarget: (target) =>
+    apiClient.post('/volume-backup-targets', target),
+
+  updateBackupTarget: (id, target) =>
+    apiClient.put(`/volume-backup-targets/${id}`, target),
+
+  deleteBackupTarget: (id) =>
+    apiClient.delete(`/volume-backup-targets/${id}`),
+
+  testBackupTarget: (id) =>
+    apiClient.post(`/volume-backup-targets/${id}/test`),
+
+  // Backup schedules; schedule is { name, volumes, labels, schedule, target_id, stop, retention: { keep_last, keep_daily, keep_weekly }, enabled }
+  getBackupSchedules: () =>
+    apiClient.get('/volume-backup-schedules'),
+
+  getBackupSchedule: (id) =>
+    apiClient.get(`/volume-backup-schedules/${id}`),
+
+  createBackupSchedule: (schedule) =>
+    apiClient.post('/volume-backup-schedules', schedule),
+
+  updateBackupSchedule: (id, schedule) =>
+    apiClient.put(`/volume-backup-schedules/${id}`, schedule),
+
+  deleteBackupSchedule: (id) =>
+    apiClient.delete(`/volume-backup-schedules/${id}`),
+
+  // Returns a job whose result is the run
+  runBackupSchedule: (id) =>
+    apiClient.post(`/volume-backup-schedules/${id}/run`),
+
+  getBackupRuns: (id, limit = 50) =>
+    apiClient.get(`/volume-backup-schedules/${id}/runs?limit=${limit}`),
+
   // Networks
-  getNetworks: () => 
-    apiClient.get('/networks'),
+  getNetworks: (params = {}) => 
+    apiClient.get('/networks', { params: { limit: 0, ...params } }),
   
   deleteNetwork: (id) => 
     apiClient.delete(`/networks/${id}`),
 
+  // Registries; credentials are used automatically for matching image hosts
+  getRegistries: () =>
+    apiClient.get('/registries'),
+
+  createRegistry: (registry) =>
+    apiClient.post('/registries', registry),
+
+  updateRegistry: (id, registry) =>
+    apiClient.put(`/registries/${id}`, registry),
+
+  deleteRegistry: (id) =>
+    apiClient.delete(`/registries/${id}`),
+
+  testRegistry: (id) =>
+    apiClient.post(`/registries/${id}/test`),
+
+  testRegistryCredentials: (registry) =>
+    apiClient.post('/registries/test', registry),
+
+  // Background jobs
+  getJobs: (type = '') =>
+    apiClient.get(`/jobs${type ? `?type=${type}` : ''}`),
+
+  getJob: (id) =>
+    apiClient.get(`/jobs/${id}`),
+
+  cancelJob: (id) =>
+    apiClient.post(`/jobs/${id}/cancel`),
+
+  jobEventsUrl: (id) =>
+    `${API_BASE_URL}/jobs/${id}/events?token=${encodeURIComponent(localStorage.getItem('token') || '')}`,
+
+  jobDownloadUrl: (id) =>
+    `${API_BASE_URL}/jobs/${id}/download?token=${encodeURIComponent(localStorage.getItem('token') || '')}`,
+
+  // Disk usage and prune; type is containers, images, volumes, networks or
+  // build-cache, params are until, label, label!, dangling, all and dry_run
+  getDiskUsage: (verbose = false) =>
+    apiClient.get(`/system/df${verbose ? '?verbose=true' : ''}`, { timeout: 0 }),
+
+  prune: (type, params = {}) =>
+    apiClient.post(`/system/prune/${type}`, null, { params, timeout: 0 }),
+
+  // Image update checks; checkImageUpdates starts a job
+  getImageUpdates: (onlyAvailable = false) =>
+    apiClient.get(`/images/updates${onlyAvailable ? '?update_available=true' : ''}`),
+
+  checkImageUpdates: (images = []) =>
+    apiClient.post(`/images/updates/check${images.length ? `?${images.map(image => `image=${encodeURIComponent(image)}`).join('&')}` : ''}`),
+
+  // Notifications
+  getNotifications: (unread = false, limit = 100) =>
+    apiClient.get(`/notifications?limit=${limit}${unread ? '&unread=true' : ''}`),
+
+  markNotificationsRead: (ids = []) =>
+    apiClient.post('/notifications/read', { ids }),
+
+  // Container updates; updateContainer and runContainerUpdates start jobs
+  updateContainer: (id) =>
+    apiClient.post(`/containers/${id}/update`),
+
+  getContainerUpdates: (container = '', limit = 100) =>
+    apiClient.get('/containers/updates', { params: { container: container || undefined, limit } }),
+
+  runContainerUpdates: () =>
+    apiClient.post('/containers/updates/run'),
+
+  // Cleanup policies; type is a prune type or image-tags, schedule is a cron
+  // expression, a macro such as @nightly, or @every <duration>
+  getCleanupPolicies: () =>
+    apiClient.get('/cleanup/policies'),
+
+  getCleanupPolicy: (id) =>
+    apiClient.get(`/cleanup/policies/${id}`),
+
+  createCleanupPolicy: (policy) =>
+    apiClient.post('/cleanup/policies', policy),
+
+  updateCleanupPolicy: (id, policy) =>
+    apiClient.put(`/cleanup/policies/${id}`, policy),
+
+  deleteCleanupPolicy: (id) =>
+    apiClient.delete(`/cleanup/policies/${id}`),
+
+  previewCleanupPolicy: (id) =>
+    apiClient.post(`/cleanup/policies/${id}/preview`, null, { timeout: 0 }),
+
+  previewCleanupPolicyDraft: (policy) =>
+    apiClient.post('/cleanup/policies/preview', policy, { timeout: 0 }),
+
+  runCleanupPolicy: (id) =>
+    apiClient.post(`/cleanup/policies/${id}/run`, null, { timeout: 0 }),
+
+  getCleanupRuns: (id, limit = 50) =>
+    apiClient.get(`/cleanup/policies/${id}/runs?limit=${limit}`),
+
+  // Offline vulnerability database; importVulnerabilities starts a job
+  getVulnerabilityDatabase: () =>
+    apiClient.get('/vulnerabilities'),
+
+  importVulnerabilities: (file, replace = false) => {
+    const formData = new FormData();
+    formData.append('file', file);
+    return apiClient.post(`/vulnerabilities/import${replace ? '?replace=true' : ''}`, formData, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 0,
+    });
+  },
+
+  clearVulnerabilityDatabase: () =>
+    apiClient.delete('/vulnerabilities'),
+
+  // Audit log
+  getAuditLog: (action = '', limit = 100) =>
+    apiClient.get(`/audit?limit=${limit}${action ? `&action=${encodeURIComponent(action)}` : ''}`),
+
   // Health check
   healthCheck: () => 
     apiClient.get('/health'),
@@ -54,8 +54,8 @@ fi
 # Test 4: Container listing
 echo "4. Testing container listing..."
 CONTAINERS=$(curl -s -H "Authorization: Bearer $TOKEN" ${API_URL}/containers)
-if echo "$CONTAINERS" | jq -e '. | length' >/dev/null 2>&1; then
-    CONTAINER_COUNT=$(echo "$CONTAINERS" | jq '. | length')
+if echo "$CONTAINERS" | jq -e '.items | length' >/dev/null 2>&1; then
+    CONTAINER_COUNT=$(echo "$CONTAINERS" | jq '.total')
     echo "✅ Container listing working ($CONTAINER_COUNT containers found)"
 else
     echo "❌ Container listing failed"
@@ -65,8 +65,8 @@ fi
 # Test 5: Image listing
 echo "5. Testing image listing..."
 IMAGES=$(curl -s -H "Authorization: Bearer $TOKEN" ${API_URL}/images)
-if echo "$IMAGES" | jq -e '. | length' >/dev/null 2>&1; then
-    IMAGE_COUNT=$(echo "$IMAGES" | jq '. | length')
+if echo "$IMAGES" | jq -e '.items | length' >/dev/null 2>&1; then
+    IMAGE_COUNT=$(echo "$IMAGES" | jq '.total')
     echo "✅ Image listing working ($IMAGE_COUNT images found)"
 else
     echo "❌ Image listing failed"
@@ -97,18 +97,164 @@ fi
 
 # Test 8: Container operations (dry run)
 echo "8. Testing container run endpoint..."
-RUN_RESPONSE=$(curl -s -w "%{http_code}" -o /dev/null -X POST \
+RUN_RESPONSE=$(curl -s -w "%{http_code}" -o /tmp/dockmaster-run.json -X POST \
   -H "Authorization: Bearer $TOKEN" \
   -H "Content-Type: application/json" \
   -d '{"image":"nginx:alpine","name":"test-dockmaster","ports":{"8888":"80"}}' \
   ${API_URL}/containers/run)
 
-if [ "$RUN_RESPONSE" = "200" ] || [ "$RUN_RESPONSE" = "500" ]; then
+# Failures must come back as a JSON error envelope with a classified code
+if [ "$RUN_RESPONSE" = "200" ] || jq -e '.code and .message and .request_id' /tmp/dockmaster-run.json >/dev/null 2>&1; then
     echo "✅ Container run endpoint accessible (HTTP $RUN_RESPONSE)"
 else
     echo "❌ Container run endpoint failed (HTTP $RUN_RESPONSE)"
 fi
 
+# Waits up to $2 seconds (default 30) for a job to finish and prints it
+wait_for_job() {
+    for _ in $(seq 1 ${2:-30}); do
+        JOB=$(curl -s -H "Authorization: Bearer $TOKEN" ${API_URL}/jobs/$1)
+        case $(echo "$JOB" | jq -r '.status') in
+            pending|running) sleep 1 ;;
+            *) echo "$JOB"; return ;;
+        esac
+    done
+    echo "$JOB"
+}
+
+# Test 9: Registry credentials against a local registry stand-in that requires a login
+echo "9. Testing registry credentials..."
+REGISTRY_PORT=${REGISTRY_PORT:-5055}
+REGISTRY_AUTH_DIR=$(mktemp -d)
+# Pushes from this script log in with their own client config, not the user's
+TEST_DOCKER_CONFIG=$(mktemp -d)
+if docker run --rm --entrypoint htpasswd httpd:2-alpine -Bbn tester secret > ${REGISTRY_AUTH_DIR}/htpasswd 2>/dev/null \
+  && docker run -d --rm --name dockmaster-test-registry -p ${REGISTRY_PORT}:5000 -v ${REGISTRY_AUTH_DIR}:/auth \
+    -e REGISTRY_AUTH=htpasswd -e REGISTRY_AUTH_HTPASSWD_REALM=dockmaster-test -e REGISTRY_AUTH_HTPASSWD_PATH=/auth/htpasswd \
+    registry:2 >/dev/null 2>&1; then
+    sleep 2
+    REGISTRY_STARTED=1
+    REGISTRY=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+      -d "{\"name\":\"test-registry\",\"host\":\"localhost:${REGISTRY_PORT}\",\"username\":\"tester\",\"password\":\"secret\"}" \
+      ${API_URL}/registries)
+    REGISTRY_ID=$(echo "$REGISTRY" | jq -r '.id // empty')
+    LOGIN=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" ${API_URL}/registries/${REGISTRY_ID}/test)
+    WRONG_LOGIN=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+      -d "{\"name\":\"wrong\",\"host\":\"localhost:${REGISTRY_PORT}\",\"username\":\"tester\",\"password\":\"wrong\"}" \
+      ${API_URL}/registries/test)
+
+    # Push a private image with the script's login, then pull it through the stored credential
+    PRIVATE_IMAGE=localhost:${REGISTRY_PORT}/dockmaster-private:1
+    PULL_STATUS=""
+    if docker pull -q alpine:latest >/dev/null 2>&1 \
+      && echo secret | docker --config $TEST_DOCKER_CONFIG login -u tester --password-stdin localhost:${REGISTRY_PORT} >/dev/null 2>&1 \
+      && docker tag alpine:latest $PRIVATE_IMAGE && docker --config $TEST_DOCKER_CONFIG push -q $PRIVATE_IMAGE >/dev/null 2>&1 \
+      && docker rmi $PRIVATE_IMAGE >/dev/null 2>&1; then
+        PULL_JOB=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+          -d "{\"image\":\"$PRIVATE_IMAGE\"}" ${API_URL}/images/pull | jq -r '.id')
+        PULL_STATUS=$(wait_for_job "$PULL_JOB" | jq -r '.status')
+        docker rmi $PRIVATE_IMAGE >/dev/null 2>&1
+    fi
+
+    if [ -n "$REGISTRY_ID" ] && ! echo "$REGISTRY" | jq -e '.password' >/dev/null 2>&1 \
+      && [ "$(echo "$LOGIN" | jq -r '.success')" = "true" ] \
+      && [ "$(echo "$WRONG_LOGIN" | jq -r '.success')" = "false" ] && [ "$(echo "$WRONG_LOGIN" | jq -r '.code')" = "registry_denied" ] \
+      && [ "$PULL_STATUS" = "succeeded" ]; then
+        echo "✅ Registry credentials working"
+    else
+        echo "❌ Registry credentials failed"
+        echo "Response: $REGISTRY $LOGIN $WRONG_LOGIN Pull: $PULL_STATUS"
+    fi
+else
+    echo "⚠️  Skipped registry test (could not start registry:2 with htpasswd auth)"
+fi
+
+# Test 10: Image update checks against the same registry stand-in, using the stored credential
+echo "10. Testing image update checks..."
+check_update() {
+    JOB_ID=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" "${API_URL}/images/updates/check?image=$1" | jq -r '.id')
+    wait_for_job "$JOB_ID" | jq -r '.result.images[0].status // empty'
+}
+UPDATE_IMAGE=localhost:${REGISTRY_PORT}/dockmaster-update-test:1
+if [ -n "$REGISTRY_STARTED" ] && docker pull -q alpine:latest >/dev/null 2>&1 && docker pull -q busybox:latest >/dev/null 2>&1; then
+    # Push alpine, then replace the registry tag with busybox while the local tag stays alpine
+    docker tag alpine:latest $UPDATE_IMAGE && docker --config $TEST_DOCKER_CONFIG push -q $UPDATE_IMAGE >/dev/null 2>&1
+    BEFORE=$(check_update $UPDATE_IMAGE)
+    docker tag busybox:latest $UPDATE_IMAGE && docker --config $TEST_DOCKER_CONFIG push -q $UPDATE_IMAGE >/dev/null 2>&1
+    docker tag alpine:latest $UPDATE_IMAGE
+    AFTER=$(check_update $UPDATE_IMAGE)
+    FLAG=$(curl -s -H "Authorization: Bearer $TOKEN" "${API_URL}/images?limit=0" | jq -r --arg ref "$UPDATE_IMAGE" '[.items[] | select(.RepoTags | index($ref))][0].update_available')
+    if [ "$BEFORE" = "up_to_date" ] && [ "$AFTER" = "update_available" ] && [ "$FLAG" = "true" ]; then
+        echo "✅ Image update checks working"
+    else
+        echo "❌ Image update checks failed"
+        echo "Before: $BEFORE After: $AFTER Flag: $FLAG"
+    fi
+    docker rmi $UPDATE_IMAGE >/dev/null 2>&1
+else
+    echo "⚠️  Skipped image update test (registry stand-in or base images unavailable)"
+fi
+[ -n "$REGISTRY_ID" ] && curl -s -o /dev/null -X DELETE -H "Authorization: Bearer $TOKEN" ${API_URL}/registries/${REGISTRY_ID}
+[ -n "$REGISTRY_STARTED" ] && docker stop dockmaster-test-registry >/dev/null 2>&1
+rm -rf $REGISTRY_AUTH_DIR $TEST_DOCKER_CONFIG
+
+# Test 11: Scheduled volume backups to MinIO, with retention and a multipart upload
+echo "11. Testing scheduled backups to S3..."
+# MinIO joins the backend's network so the backend reaches it by name
+BACKEND_NETWORK=$(docker inspect -f '{{range $name, $_ := .NetworkSettings.Networks}}{{$name}} {{end}}' dockmaster-backend 2>/dev/null | awk '{print $1}')
+MINIO=dockmaster-test-minio
+BACKUP_VOLUME=dockmaster-test-backup
+mc() {
+    docker run --rm --network $BACKEND_NETWORK --entrypoint sh minio/mc -c \
+      "mc alias set test http://${MINIO}:9000 dockmaster dockmaster-secret >/dev/null && mc $*"
+}
+if [ -n "$BACKEND_NETWORK" ] && docker run -d --rm --name $MINIO --network $BACKEND_NETWORK \
+    -e MINIO_ROOT_USER=dockmaster -e MINIO_ROOT_PASSWORD=dockmaster-secret minio/minio server /data >/dev/null 2>&1; then
+    sleep 3
+    mc mb test/backups >/dev/null 2>&1
+    # Random data does not compress, so the backup is larger than the 64 MiB part size
+    docker volume create $BACKUP_VOLUME >/dev/null
+    docker run --rm -v $BACKUP_VOLUME:/data busybox dd if=/dev/urandom of=/data/big bs=1M count=70 >/dev/null 2>&1
+
+    TARGET_ID=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+      -d "{\"name\":\"test-minio\",\"type\":\"s3\",\"endpoint\":\"http://${MINIO}:9000\",\"bucket\":\"backups\",\"prefix\":\"smoke\",\"access_key\":\"dockmaster\",\"secret_key\":\"dockmaster-secret\"}" \
+      ${API_URL}/volume-backup-targets | jq -r '.id // empty')
+    TARGET_TEST=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" ${API_URL}/volume-backup-targets/${TARGET_ID}/test | jq -r '.success')
+    SCHEDULE_ID=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+      -d "{\"name\":\"test-minio\",\"volumes\":[\"$BACKUP_VOLUME\"],\"schedule\":\"@daily\",\"target_id\":${TARGET_ID:-0},\"retention\":{\"keep_last\":2},\"enabled\":false}" \
+      ${API_URL}/volume-backup-schedules | jq -r '.id // empty')
+
+    # Three runs against keep_last 2 leave the two newest backups
+    RUNS=""
+    for _ in 1 2 3; do
+        RUN_JOB=$(curl -s -X POST -H "Authorization: Bearer $TOKEN" ${API_URL}/volume-backup-schedules/${SCHEDULE_ID}/run | jq -r '.id')
+        RUN=$(wait_for_job "$RUN_JOB" 300)
+        RUNS="$RUNS $(echo "$RUN" | jq -r '[.status, .result.status, .result.results[0].verified] | join("/")')"
+    done
+    BACKUPS=$(curl -s -H "Authorization: Bearer $TOKEN" "${API_URL}/volume-backups?schedule_id=${SCHEDULE_ID}")
+    BACKUP_COUNT=$(echo "$BACKUPS" | jq 'length')
+    LARGEST=$(echo "$BACKUPS" | jq '[.[].size] | max // 0' 2>/dev/null)
+    OBJECT_COUNT=$(mc ls --recursive test/backups/smoke/ 2>/dev/null | grep -c "$BACKUP_VOLUME")
+
+    if [ "$TARGET_TEST" = "true" ] && [ "$RUNS" = " succeeded/succeeded/true succeeded/succeeded/true succeeded/succeeded/true" ] \
+      && [ "$BACKUP_COUNT" = "2" ] && [ "$OBJECT_COUNT" = "2" ] && [ "${LARGEST:-0}" -gt $((64 * 1024 * 1024)) ]; then
+        echo "✅ Scheduled S3 backups working"
+    else
+        echo "❌ Scheduled S3 backups failed"
+        echo "Target test: $TARGET_TEST Runs:$RUNS Backups: $BACKUP_COUNT Objects: $OBJECT_COUNT Largest: $LARGEST"
+    fi
+
+    [ -n "$SCHEDULE_ID" ] && curl -s -o /dev/null -X DELETE -H "Authorization: Bearer $TOKEN" ${API_URL}/volume-backup-schedules/${SCHEDULE_ID}
+    for BACKUP_ID in $(echo "$BACKUPS" | jq -r '.[].id' 2>/dev/null); do
+        curl -s -o /dev/null -X DELETE -H "Authorization: Bearer $TOKEN" ${API_URL}/volume-backups/${BACKUP_ID}
+    done
+    [ -n "$TARGET_ID" ] && curl -s -o /dev/null -X DELETE -H "Authorization: Bearer $TOKEN" ${API_URL}/volume-backup-targets/${TARGET_ID}
+    docker volume rm $BACKUP_VOLUME >/dev/null 2>&1
+    docker stop $MINIO >/dev/null 2>&1
+else
+    echo "⚠️  Skipped S3 backup test (backend container or minio/minio unavailable)"
+fi
+
 echo ""
 echo "🎉 Complete functionality test finished!"
 echo ""